@@ -0,0 +1,405 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"log"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-fonts/dejavu/dejavusanscondensed"
+	"github.com/go-fonts/dejavu/dejavusanscondensedbold"
+	"golang.org/x/image/draw"
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/opentype"
+	"golang.org/x/image/math/fixed"
+)
+
+// contactSheetVersion identifies the layout below. Sheets rendered with
+// another version are cached under a different key and so re-rendered.
+const contactSheetVersion = "v1"
+
+const (
+	contactSheetThumbSide      = 240 // Thumbnails are fitted into a square cell of this side
+	contactSheetPadding        = 12
+	contactSheetCaptionHeight  = 36 // Two caption lines: folder and filename
+	contactSheetHeaderHeight   = 40
+	contactSheetDefaultColumns = 6
+	contactSheetMaxColumns     = 12
+	contactSheetMaxImages      = 36 // Images past this are left off the sheet
+	contactSheetWorkers        = 4
+	contactSheetJPEGQuality    = 85
+	// Sheets are rendered within the request, which API Gateway cuts off after 29 seconds
+	contactSheetLoadBudget = 20 * time.Second
+)
+
+var (
+	contactSheetBackground  = color.RGBA{255, 255, 255, 255}
+	contactSheetCellColor   = color.RGBA{240, 240, 240, 255}
+	contactSheetTextColor   = color.RGBA{33, 33, 33, 255}
+	contactSheetMutedColor  = color.RGBA{117, 117, 117, 255}
+	contactSheetErrorColor  = color.RGBA{198, 40, 40, 255}
+	contactSheetHeaderColor = color.RGBA{38, 50, 56, 255}
+)
+
+type ContactSheet struct {
+	Key          string    `json:"key"`
+	PresignedURL string    `json:"presignedUrl"`
+	ExpiresAt    time.Time `json:"expiresAt"`
+	Width        int       `json:"width"`
+	Height       int       `json:"height"`
+	Columns      int       `json:"columns"`
+	Images       int       `json:"images"`
+	// Images that could not be read, drawn as placeholders
+	UnreadableImages []string `json:"unreadableImages,omitempty"`
+}
+
+type ContactSheetMetadata struct {
+	ProductID   string `json:"productId"`
+	Category    string `json:"category"`
+	Folder      string `json:"folder,omitempty"`
+	TotalImages int    `json:"totalImages"`
+	// Set when the product has more images than fit on one sheet
+	Truncated bool      `json:"truncated"`
+	Cached    bool      `json:"cached"`
+	ScannedAt time.Time `json:"scannedAt"`
+}
+
+// One cell of the sheet: a thumbnail, or nil when the image could not be read
+type contactSheetCell struct {
+	Key    string
+	Folder string
+	Thumb  image.Image
+	Err    error
+}
+
+// Parsed fonts are shared; faces are not safe for concurrent use, so each render opens its own
+var (
+	contactSheetFontsOnce sync.Once
+	contactSheetFonts     struct {
+		Regular *opentype.Font
+		Bold    *opentype.Font
+		Err     error
+	}
+)
+
+// Handle contact sheet operation: one image with a captioned thumbnail of every product image
+func handleContactSheet(ctx context.Context, requestID string, queryParams map[string]string) (*CatalogResponse, error) {
+	category := queryParams["category"]
+	productID := queryParams["productId"]
+	folder := queryParams["folder"] // Optional: only images of this folder
+
+	if category == "" || productID == "" {
+		return nil, newBadRequest("MISSING_PARAMETER", "Missing required 'category' and 'productId' parameters for contact sheet")
+	}
+	if _, err := resolveStatsCategories(category); err != nil {
+		return nil, err
+	}
+
+	columns := contactSheetDefaultColumns
+	if value := queryParams["columns"]; value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed < 1 || parsed > contactSheetMaxColumns {
+			return nil, newBadRequest("INVALID_PARAMETER", "Invalid 'columns' parameter: %s. Must be between 1 and %d", value, contactSheetMaxColumns)
+		}
+		columns = parsed
+	}
+
+	log.Printf("RequestID: %s - Building contact sheet for %s/%s, folder: %s", requestID, category, productID, folder)
+
+	productPrefix := categoryDefinitions[category].S3Prefix + productID + "/"
+	listPrefix := productPrefix
+	if folder != "" {
+		listPrefix += folder + "/"
+	}
+
+	objects, err := listDatasetObjects(ctx, listPrefix, 0)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list images of %s/%s: %w", category, productID, err)
+	}
+
+	images := []objectInfo{}
+	for _, object := range objects {
+		if isImageFile(object.Key) {
+			images = append(images, object)
+		}
+	}
+	if len(images) == 0 {
+		return nil, newNotFound("NO_IMAGES", "No images found for product %s/%s", category, productID)
+	}
+	sortContactSheetImages(category, images)
+
+	metadata := ContactSheetMetadata{
+		ProductID:   productID,
+		Category:    category,
+		Folder:      folder,
+		TotalImages: len(images),
+		ScannedAt:   time.Now(),
+	}
+	if len(images) > contactSheetMaxImages {
+		images = images[:contactSheetMaxImages]
+		metadata.Truncated = true
+	}
+	if len(images) < columns {
+		columns = len(images)
+	}
+
+	// The key changes whenever an image is added, removed or replaced, so a cached sheet is never stale
+	key := contactSheetKey(category, productID, folder, columns, images)
+
+	sheet := &ContactSheet{Key: key, Columns: columns, Images: len(images)}
+	if queryParams["refresh"] != "true" {
+		if _, err := datasetStorage.Head(ctx, key); err == nil {
+			metadata.Cached = true
+		} else if !errors.Is(err, errObjectNotFound) {
+			log.Printf("RequestID: %s - Warning: Failed to check cached contact sheet %s: %v", requestID, key, err)
+		}
+	}
+
+	if !metadata.Cached {
+		loadCtx, cancel := context.WithTimeout(ctx, contactSheetLoadBudget)
+		cells := loadContactSheetCells(loadCtx, category, images)
+		loadErr := loadCtx.Err()
+		cancel()
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		if loadErr != nil {
+			// A partial sheet would be cached under the same key, so nothing is stored
+			return nil, &requestError{StatusCode: 503, Code: "CONTACT_SHEET_TIMEOUT", Message: fmt.Sprintf("Images of %s/%s could not be loaded in time; retry, or limit the sheet with 'folder'", category, productID)}
+		}
+
+		canvas, err := renderContactSheet(category, productID, folder, columns, cells)
+		if err != nil {
+			return nil, err
+		}
+
+		var buffer bytes.Buffer
+		if err := jpeg.Encode(&buffer, canvas, &jpeg.Options{Quality: contactSheetJPEGQuality}); err != nil {
+			return nil, fmt.Errorf("failed to encode contact sheet: %w", err)
+		}
+		if err := datasetStorage.Put(ctx, key, buffer.Bytes(), "image/jpeg"); err != nil {
+			return nil, fmt.Errorf("failed to store contact sheet %s: %w", key, err)
+		}
+
+		for _, cell := range cells {
+			if cell.Err != nil {
+				sheet.UnreadableImages = append(sheet.UnreadableImages, cell.Key)
+			}
+		}
+		log.Printf("RequestID: %s - Rendered contact sheet %s (%d images, %d bytes)", requestID, key, len(images), buffer.Len())
+	}
+
+	sheet.Width, sheet.Height = contactSheetSize(columns, len(images))
+
+	presignedURL, err := datasetStorage.PresignGet(ctx, key, appConfig.PresignedURLExpiry)
+	if err != nil {
+		return nil, err
+	}
+	sheet.PresignedURL = presignedURL
+	sheet.ExpiresAt = time.Now().Add(appConfig.PresignedURLExpiry)
+
+	log.Printf("RequestID: %s - Contact sheet for %s/%s ready (cached: %t)", requestID, category, productID, metadata.Cached)
+
+	return &CatalogResponse{
+		Type:     "contactSheet",
+		Data:     sheet,
+		Metadata: metadata,
+	}, nil
+}
+
+// Order images label folder first, then overview, then the rest, by folder and filename
+func sortContactSheetImages(category string, images []objectInfo) {
+	rank := map[string]int{folderRoleLabel: 0, folderRoleOverview: 1, folderRoleOther: 2}
+	sort.SliceStable(images, func(i, j int) bool {
+		_, folderI := splitProductImageKey(category, images[i].Key)
+		_, folderJ := splitProductImageKey(category, images[j].Key)
+		if rankI, rankJ := rank[folderRole(folderI)], rank[folderRole(folderJ)]; rankI != rankJ {
+			return rankI < rankJ
+		}
+		return images[i].Key < images[j].Key
+	})
+}
+
+// Cache key of a sheet, derived from the layout and the keys and ETags of its images
+func contactSheetKey(category, productID, folder string, columns int, images []objectInfo) string {
+	hash := sha256.New()
+	fmt.Fprintf(hash, "%s\n%s\n%d\n", contactSheetVersion, folder, columns)
+	for _, object := range images {
+		fmt.Fprintf(hash, "%s\t%s\t%d\n", object.Key, object.ETag, object.Size)
+	}
+	digest := hex.EncodeToString(hash.Sum(nil))[:16]
+
+	return fmt.Sprintf("%scontactsheets/%s/%s/%s.jpg", appConfig.MetaPrefix, category, productID, digest)
+}
+
+// Download and shrink the images with a bounded number of workers, keeping only thumbnails in memory
+func loadContactSheetCells(ctx context.Context, category string, images []objectInfo) []contactSheetCell {
+	cells := make([]contactSheetCell, len(images))
+
+	var wg sync.WaitGroup
+	work := make(chan int)
+
+	for i := 0; i < contactSheetWorkers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for index := range work {
+				key := images[index].Key
+				_, folder := splitProductImageKey(category, key)
+				cell := contactSheetCell{Key: key, Folder: folder}
+
+				if img, err := loadImage(ctx, datasetStorage, key); err != nil {
+					cell.Err = err
+				} else {
+					cell.Thumb = contactSheetThumbnail(img)
+				}
+				cells[index] = cell
+			}
+		}()
+	}
+
+	for index := range images {
+		if ctx.Err() != nil {
+			break
+		}
+		work <- index
+	}
+	close(work)
+	wg.Wait()
+
+	return cells
+}
+
+// Scale an image to fit the thumbnail cell, keeping its aspect ratio
+func contactSheetThumbnail(img image.Image) image.Image {
+	bounds := img.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	if width == 0 || height == 0 {
+		return image.NewRGBA(image.Rect(0, 0, 1, 1))
+	}
+
+	scale := float64(contactSheetThumbSide) / float64(max(width, height))
+	if scale > 1 {
+		scale = 1 // Never enlarge small images
+	}
+	thumbWidth := max(1, int(float64(width)*scale))
+	thumbHeight := max(1, int(float64(height)*scale))
+
+	thumb := image.NewRGBA(image.Rect(0, 0, thumbWidth, thumbHeight))
+	draw.ApproxBiLinear.Scale(thumb, thumb.Bounds(), img, bounds, draw.Src, nil)
+	return thumb
+}
+
+// Pixel size of a sheet with the given number of columns and images
+func contactSheetSize(columns, images int) (int, int) {
+	rows := (images + columns - 1) / columns
+	cellWidth := contactSheetThumbSide + contactSheetPadding
+	cellHeight := contactSheetThumbSide + contactSheetCaptionHeight + contactSheetPadding
+
+	width := contactSheetPadding + columns*cellWidth
+	height := contactSheetHeaderHeight + contactSheetPadding + rows*cellHeight
+	return width, height
+}
+
+// Draw the header and the grid of captioned thumbnails
+func renderContactSheet(category, productID, folder string, columns int, cells []contactSheetCell) (*image.RGBA, error) {
+	captionFace, headerFace, err := contactSheetFaces()
+	if err != nil {
+		return nil, err
+	}
+
+	width, height := contactSheetSize(columns, len(cells))
+	canvas := image.NewRGBA(image.Rect(0, 0, width, height))
+	draw.Draw(canvas, canvas.Bounds(), image.NewUniform(contactSheetBackground), image.Point{}, draw.Src)
+
+	// Header bar with the product and image count
+	header := image.Rect(0, 0, width, contactSheetHeaderHeight)
+	draw.Draw(canvas, header, image.NewUniform(contactSheetHeaderColor), image.Point{}, draw.Src)
+	title := fmt.Sprintf("%s / %s", category, productID)
+	if folder != "" {
+		title += " / " + folder
+	}
+	title += fmt.Sprintf("  (%d images)", len(cells))
+	drawContactSheetText(canvas, headerFace, contactSheetBackground, title,
+		contactSheetPadding, contactSheetHeaderHeight/2+6, width-2*contactSheetPadding)
+
+	cellWidth := contactSheetThumbSide + contactSheetPadding
+	cellHeight := contactSheetThumbSide + contactSheetCaptionHeight + contactSheetPadding
+
+	for index, cell := range cells {
+		x := contactSheetPadding + (index%columns)*cellWidth
+		y := contactSheetHeaderHeight + contactSheetPadding + (index/columns)*cellHeight
+
+		frame := image.Rect(x, y, x+contactSheetThumbSide, y+contactSheetThumbSide)
+		draw.Draw(canvas, frame, image.NewUniform(contactSheetCellColor), image.Point{}, draw.Src)
+
+		if cell.Thumb != nil {
+			bounds := cell.Thumb.Bounds()
+			offset := image.Pt(x+(contactSheetThumbSide-bounds.Dx())/2, y+(contactSheetThumbSide-bounds.Dy())/2)
+			draw.Draw(canvas, bounds.Sub(bounds.Min).Add(offset), cell.Thumb, bounds.Min, draw.Over)
+		} else {
+			drawContactSheetText(canvas, captionFace, contactSheetErrorColor, "unreadable",
+				x+8, y+contactSheetThumbSide/2, contactSheetThumbSide-16)
+		}
+
+		captionTop := y + contactSheetThumbSide
+		drawContactSheetText(canvas, captionFace, contactSheetMutedColor, cell.Folder,
+			x, captionTop+15, contactSheetThumbSide)
+		drawContactSheetText(canvas, captionFace, contactSheetTextColor, path.Base(cell.Key),
+			x, captionTop+31, contactSheetThumbSide)
+	}
+
+	return canvas, nil
+}
+
+// Draw one line of text at the baseline (x, y), shortened with an ellipsis to fit maxWidth
+func drawContactSheetText(canvas *image.RGBA, face font.Face, textColor color.Color, text string, x, y, maxWidth int) {
+	drawer := &font.Drawer{Dst: canvas, Src: image.NewUniform(textColor), Face: face}
+
+	limit := fixed.I(maxWidth)
+	if drawer.MeasureString(text) > limit {
+		runes := []rune(text)
+		for len(runes) > 0 && drawer.MeasureString(string(runes)+"…") > limit {
+			runes = runes[:len(runes)-1]
+		}
+		text = strings.TrimSpace(string(runes)) + "…"
+	}
+
+	drawer.Dot = fixed.P(x, y)
+	drawer.DrawString(text)
+}
+
+// Caption and header faces. DejaVu covers the Vietnamese folder names used in the dataset.
+func contactSheetFaces() (font.Face, font.Face, error) {
+	contactSheetFontsOnce.Do(func() {
+		contactSheetFonts.Regular, contactSheetFonts.Err = opentype.Parse(dejavusanscondensed.TTF)
+		if contactSheetFonts.Err == nil {
+			contactSheetFonts.Bold, contactSheetFonts.Err = opentype.Parse(dejavusanscondensedbold.TTF)
+		}
+	})
+	if contactSheetFonts.Err != nil {
+		return nil, nil, fmt.Errorf("failed to parse contact sheet font: %w", contactSheetFonts.Err)
+	}
+
+	caption, err := opentype.NewFace(contactSheetFonts.Regular, &opentype.FaceOptions{Size: 12, DPI: 72, Hinting: font.HintingNone})
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to load contact sheet font: %w", err)
+	}
+	header, err := opentype.NewFace(contactSheetFonts.Bold, &opentype.FaceOptions{Size: 16, DPI: 72, Hinting: font.HintingNone})
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to load contact sheet font: %w", err)
+	}
+	return caption, header, nil
+}
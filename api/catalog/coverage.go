@@ -0,0 +1,371 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// Coverage status of a product
+const (
+	coverageVerified      = "verified"
+	coverageNeverVerified = "neverVerified"
+	coverageOrphan        = "orphan" // Results for a product ID with no catalog entry
+)
+
+// Orphans within this edit distance of a catalog product ID are reported as likely typos
+const coverageSuggestionDistance = 2
+
+type ProductCoverage struct {
+	Category       string     `json:"category"`
+	ProductID      string     `json:"productId"`
+	Status         string     `json:"status"`
+	Verifications  int        `json:"verifications"`
+	LastVerifiedAt *time.Time `json:"lastVerifiedAt,omitempty"`
+	// Closest catalog product ID for an orphan, when one is near enough to be a typo
+	SuggestedProductID string `json:"suggestedProductId,omitempty"`
+}
+
+type CoverageMetadata struct {
+	Categories            []string  `json:"categories"`
+	CatalogProducts       int       `json:"catalogProducts"`
+	VerifiedProducts      int       `json:"verifiedProducts"`
+	NeverVerifiedProducts int       `json:"neverVerifiedProducts"`
+	OrphanProducts        int       `json:"orphanProducts"`
+	TotalVerifications    int       `json:"totalVerifications"`
+	CoveragePercentage    float64   `json:"coveragePercentage"`
+	ScannedAt             time.Time `json:"scannedAt"`
+	// False when the deadline stopped reading results, so counts are low and some
+	// products may be reported as never verified
+	Complete bool `json:"complete"`
+}
+
+type CoverageExport struct {
+	DownloadURL string    `json:"downloadUrl"`
+	ExpiresAt   time.Time `json:"expiresAt"`
+	Format      string    `json:"format"`
+	RecordCount int       `json:"recordCount"`
+	FileSize    int64     `json:"fileSize"`
+}
+
+// Key attributes of a verification record
+type verificationKeyItem struct {
+	ProductID       string `dynamodbav:"productId"`
+	ProductCategory string `dynamodbav:"productCategory"`
+	Timestamp       string `dynamodbav:"timestamp"`
+}
+
+// Handle verification coverage report: catalog products cross-referenced with the results table
+func handleVerificationCoverage(ctx context.Context, requestID string, queryParams map[string]string) (*CatalogResponse, error) {
+	if !verificationStatsEnabled() {
+		return nil, verificationStatsDisabledError()
+	}
+
+	categoryIDs, err := resolveStatsCategories(queryParams["category"])
+	if err != nil {
+		return nil, err
+	}
+
+	format := queryParams["format"]
+	if format != "" && format != "json" && format != "csv" {
+		return nil, newBadRequest("INVALID_PARAMETER", "Invalid 'format' parameter: %s. Valid values: json, csv", format)
+	}
+
+	status := queryParams["status"]
+	if status != "" && status != coverageVerified && status != coverageNeverVerified && status != coverageOrphan {
+		return nil, newBadRequest("INVALID_PARAMETER", "Invalid 'status' parameter: %s. Valid values: %s, %s, %s", status, coverageVerified, coverageNeverVerified, coverageOrphan)
+	}
+
+	log.Printf("RequestID: %s - Building verification coverage for categories: %v", requestID, categoryIDs)
+
+	// Without a category filter, results filed under unknown categories are reported as orphans too
+	coverage, metadata, err := buildVerificationCoverage(ctx, requestID, categoryIDs, queryParams["category"] == "")
+	if err != nil {
+		return nil, err
+	}
+
+	if status != "" {
+		filtered := []ProductCoverage{}
+		for _, product := range coverage {
+			if product.Status == status {
+				filtered = append(filtered, product)
+			}
+		}
+		coverage = filtered
+	}
+
+	if format == "csv" {
+		export, err := exportCoverageCSV(ctx, requestID, coverage)
+		if err != nil {
+			return nil, err
+		}
+		return &CatalogResponse{
+			Type:     "verificationCoverage",
+			Data:     export,
+			Metadata: metadata,
+		}, nil
+	}
+
+	log.Printf("RequestID: %s - Verification coverage: %d verified, %d never verified, %d orphans",
+		requestID, metadata.VerifiedProducts, metadata.NeverVerifiedProducts, metadata.OrphanProducts)
+
+	return &CatalogResponse{
+		Type:     "verificationCoverage",
+		Data:     coverage,
+		Metadata: metadata,
+	}, nil
+}
+
+// Cross-reference catalog product IDs with the product IDs found in the results table
+func buildVerificationCoverage(ctx context.Context, requestID string, categoryIDs []string, allCategories bool) ([]ProductCoverage, CoverageMetadata, error) {
+	metadata := CoverageMetadata{Categories: categoryIDs, ScannedAt: time.Now()}
+
+	results, complete, err := scanVerificationKeys(ctx, requestID, categoryIDs, allCategories)
+	if err != nil {
+		return nil, metadata, err
+	}
+	metadata.Complete = complete
+
+	coverage := []ProductCoverage{}
+	catalogIDs := make(map[string][]string)
+
+	for _, categoryID := range categoryIDs {
+		productPrefixes, err := listDatasetPrefixes(ctx, categoryDefinitions[categoryID].S3Prefix)
+		if err != nil {
+			return nil, metadata, fmt.Errorf("failed to list products in %s: %w", categoryID, err)
+		}
+
+		for _, prefix := range productPrefixes {
+			productID := strings.TrimPrefix(strings.TrimSuffix(prefix, "/"), categoryDefinitions[categoryID].S3Prefix)
+			catalogIDs[categoryID] = append(catalogIDs[categoryID], productID)
+
+			product := ProductCoverage{Category: categoryID, ProductID: productID, Status: coverageNeverVerified}
+			if found, ok := results[categoryID][productID]; ok {
+				product.Status = coverageVerified
+				product.Verifications = found.Verifications
+				product.LastVerifiedAt = found.LastVerifiedAt
+				delete(results[categoryID], productID)
+			}
+			coverage = append(coverage, product)
+		}
+	}
+
+	// What is left in the results has no catalog entry
+	for categoryID, products := range results {
+		for _, orphan := range products {
+			orphan.SuggestedProductID = suggestCatalogProduct(orphan.ProductID, catalogIDs[categoryID])
+			coverage = append(coverage, orphan)
+		}
+	}
+
+	sort.Slice(coverage, func(i, j int) bool {
+		if coverage[i].Category != coverage[j].Category {
+			return coverage[i].Category < coverage[j].Category
+		}
+		return coverage[i].ProductID < coverage[j].ProductID
+	})
+
+	for _, product := range coverage {
+		metadata.TotalVerifications += product.Verifications
+		switch product.Status {
+		case coverageVerified:
+			metadata.CatalogProducts++
+			metadata.VerifiedProducts++
+		case coverageNeverVerified:
+			metadata.CatalogProducts++
+			metadata.NeverVerifiedProducts++
+		case coverageOrphan:
+			metadata.OrphanProducts++
+		}
+	}
+	if metadata.CatalogProducts > 0 {
+		metadata.CoveragePercentage = float64(metadata.VerifiedProducts) / float64(metadata.CatalogProducts) * 100
+	}
+
+	return coverage, metadata, nil
+}
+
+// Verification counts per category and product ID. Selected categories are read from the
+// category index; all categories take a parallel scan of the table. Both stop at the deadline,
+// and the counts are then incomplete.
+func scanVerificationKeys(ctx context.Context, requestID string, categoryIDs []string, allCategories bool) (map[string]map[string]ProductCoverage, bool, error) {
+	results := make(map[string]map[string]ProductCoverage)
+	records := 0
+
+	addPage := func(page []map[string]types.AttributeValue) error {
+		var items []verificationKeyItem
+		if err := attributevalue.UnmarshalListOfMaps(page, &items); err != nil {
+			return fmt.Errorf("failed to unmarshal verification results: %w", err)
+		}
+
+		for _, item := range items {
+			if item.ProductID == "" || item.ProductCategory == "" {
+				continue
+			}
+			records++
+
+			if results[item.ProductCategory] == nil {
+				results[item.ProductCategory] = make(map[string]ProductCoverage)
+			}
+			product, ok := results[item.ProductCategory][item.ProductID]
+			if !ok {
+				product = ProductCoverage{Category: item.ProductCategory, ProductID: item.ProductID, Status: coverageOrphan}
+			}
+			product.Verifications++
+			if timestamp, err := parseResultTimestamp(item.Timestamp); err == nil && (product.LastVerifiedAt == nil || timestamp.After(*product.LastVerifiedAt)) {
+				product.LastVerifiedAt = &timestamp
+			}
+			results[item.ProductCategory][item.ProductID] = product
+		}
+		return nil
+	}
+
+	projection := aws.String("productId, productCategory, #ts")
+	names := map[string]string{"#ts": "timestamp"}
+
+	complete := true
+	if allCategories {
+		var err error
+		complete, err = parallelScan(ctx, dynamodb.ScanInput{
+			TableName:                aws.String(appConfig.ResultTable),
+			ProjectionExpression:     projection,
+			ExpressionAttributeNames: names,
+		}, addPage)
+		if err != nil {
+			return nil, false, fmt.Errorf("failed to scan verification results: %w", err)
+		}
+	} else {
+		for _, categoryID := range categoryIDs {
+			categoryComplete, err := queryAllPages(ctx, &dynamodb.QueryInput{
+				TableName:                aws.String(appConfig.ResultTable),
+				IndexName:                aws.String(resultCategoryIndex),
+				KeyConditionExpression:   aws.String("productCategory = :category"),
+				ProjectionExpression:     projection,
+				ExpressionAttributeNames: names,
+				ExpressionAttributeValues: map[string]types.AttributeValue{
+					":category": &types.AttributeValueMemberS{Value: categoryID},
+				},
+			}, addPage)
+			if err != nil {
+				return nil, false, fmt.Errorf("failed to query verification results for %s: %w", categoryID, err)
+			}
+			if !categoryComplete {
+				complete = false
+				break
+			}
+		}
+	}
+
+	log.Printf("RequestID: %s - Read %d verification results for coverage, complete: %t", requestID, records, complete)
+	return results, complete, nil
+}
+
+// Closest catalog product ID to an orphan: the same ID up to case and punctuation,
+// otherwise the nearest one within the suggestion distance
+func suggestCatalogProduct(productID string, catalogIDs []string) string {
+	normalized := normalizeProductID(productID)
+
+	suggestion := ""
+	bestDistance := coverageSuggestionDistance + 1
+	for _, catalogID := range catalogIDs {
+		candidate := normalizeProductID(catalogID)
+		if candidate == normalized {
+			return catalogID
+		}
+		if distance := editDistance(normalized, candidate); distance < bestDistance {
+			suggestion, bestDistance = catalogID, distance
+		}
+	}
+
+	return suggestion
+}
+
+func normalizeProductID(productID string) string {
+	return strings.Map(func(r rune) rune {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			return unicode.ToUpper(r)
+		}
+		return -1
+	}, productID)
+}
+
+// Levenshtein distance between two strings, by rune
+func editDistance(a, b string) int {
+	source, target := []rune(a), []rune(b)
+	previous := make([]int, len(target)+1)
+	current := make([]int, len(target)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+
+	for i := 1; i <= len(source); i++ {
+		current[0] = i
+		for j := 1; j <= len(target); j++ {
+			cost := 1
+			if source[i-1] == target[j-1] {
+				cost = 0
+			}
+			current[j] = min(previous[j]+1, current[j-1]+1, previous[j-1]+cost)
+		}
+		previous, current = current, previous
+	}
+
+	return previous[len(target)]
+}
+
+// Store the coverage report as CSV and return a download link
+func exportCoverageCSV(ctx context.Context, requestID string, coverage []ProductCoverage) (*CoverageExport, error) {
+	var buffer bytes.Buffer
+	writer := csv.NewWriter(&buffer)
+
+	rows := [][]string{{"Category", "Product ID", "Status", "Verifications", "Last Verified At", "Suggested Product ID"}}
+	for _, product := range coverage {
+		lastVerifiedAt := ""
+		if product.LastVerifiedAt != nil {
+			lastVerifiedAt = product.LastVerifiedAt.Format(time.RFC3339)
+		}
+		rows = append(rows, []string{
+			product.Category,
+			product.ProductID,
+			product.Status,
+			strconv.Itoa(product.Verifications),
+			lastVerifiedAt,
+			product.SuggestedProductID,
+		})
+	}
+	if err := writer.WriteAll(rows); err != nil {
+		return nil, fmt.Errorf("failed to write coverage CSV: %w", err)
+	}
+
+	now := time.Now().UTC()
+	key := fmt.Sprintf("%sreports/verification-coverage-%s.csv", appConfig.MetaPrefix, now.Format("20060102-150405"))
+	if err := datasetStorage.Put(ctx, key, buffer.Bytes(), "text/csv; charset=utf-8"); err != nil {
+		return nil, fmt.Errorf("failed to store coverage report %s: %w", key, err)
+	}
+
+	downloadURL, err := datasetStorage.PresignGet(ctx, key, appConfig.PresignedURLExpiry)
+	if err != nil {
+		return nil, err
+	}
+
+	log.Printf("RequestID: %s - Coverage report exported to %s (%d rows)", requestID, key, len(coverage))
+	return &CoverageExport{
+		DownloadURL: downloadURL,
+		ExpiresAt:   now.Add(appConfig.PresignedURLExpiry),
+		Format:      "csv",
+		RecordCount: len(coverage),
+		FileSize:    int64(buffer.Len()),
+	}, nil
+}
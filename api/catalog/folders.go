@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"time"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+type ProductFolder struct {
+	Name         string     `json:"name"`
+	Role         string     `json:"role"`
+	S3Prefix     string     `json:"s3Prefix"`
+	ImageCount   int        `json:"imageCount"`
+	TotalBytes   int64      `json:"totalBytes"`
+	LastModified *time.Time `json:"lastModified,omitempty"`
+}
+
+type FoldersMetadata struct {
+	ProductID    string    `json:"productId"`
+	Category     string    `json:"category"`
+	TotalFolders int       `json:"totalFolders"`
+	ScannedAt    time.Time `json:"scannedAt"`
+}
+
+// Handle product folder browsing operation
+func handleFoldersDiscovery(ctx context.Context, requestID string, queryParams map[string]string) (*CatalogResponse, error) {
+	category := queryParams["category"]
+	productID := queryParams["productId"]
+
+	if category == "" || productID == "" {
+		return nil, newBadRequest("MISSING_PARAMETER", "Missing required 'category' and 'productId' parameters for folders discovery")
+	}
+
+	log.Printf("RequestID: %s - Starting folders discovery for product: %s/%s", requestID, category, productID)
+
+	folders, err := listProductFolders(ctx, requestID, category, productID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list folders for product %s/%s: %w", category, productID, err)
+	}
+
+	response := &CatalogResponse{
+		Type: "folders",
+		Data: folders,
+		Metadata: FoldersMetadata{
+			ProductID:    productID,
+			Category:     category,
+			TotalFolders: len(folders),
+			ScannedAt:    time.Now(),
+		},
+	}
+
+	log.Printf("RequestID: %s - Folders discovery completed for %s/%s: %d folders", requestID, category, productID, len(folders))
+	return response, nil
+}
+
+// List every direct subfolder of a product with its image count and size
+func listProductFolders(ctx context.Context, requestID, category, productID string) ([]ProductFolder, error) {
+	basePrefix := fmt.Sprintf("dataset/%s/%s/", category, productID)
+
+	objects, err := listDatasetObjects(ctx, basePrefix, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	folderIndex := make(map[string]int)
+	var folders []ProductFolder
+
+	for _, obj := range objects {
+		name, rest, found := strings.Cut(strings.TrimPrefix(obj.Key, basePrefix), "/")
+		if !found || name == "" {
+			continue // Files directly under the product prefix
+		}
+
+		index, exists := folderIndex[name]
+		if !exists {
+			index = len(folders)
+			folderIndex[name] = index
+			folders = append(folders, ProductFolder{
+				Name:     name,
+				Role:     folderRole(name),
+				S3Prefix: basePrefix + name + "/",
+			})
+		}
+
+		if rest == "" || !isImageFile(obj.Key) {
+			continue
+		}
+
+		folder := &folders[index]
+		folder.ImageCount++
+		folder.TotalBytes += obj.Size
+		if folder.LastModified == nil || obj.LastModified.After(*folder.LastModified) {
+			lastModified := obj.LastModified
+			folder.LastModified = &lastModified
+		}
+	}
+
+	sort.Slice(folders, func(i, j int) bool { return folders[i].Name < folders[j].Name })
+
+	log.Printf("RequestID: %s - Found %d folders under %s", requestID, len(folders), basePrefix)
+	return folders, nil
+}
+
+// Discover images from a folder outside the known label and overview folders.
+// Only folders that actually exist under the product are accepted; names are
+// compared in NFC so stored NFD names match what clients send.
+func discoverProductImagesFromOtherFolder(ctx context.Context, requestID, category, productID, folder string) (*ImagesData, error) {
+	folders, err := listProductFolders(ctx, requestID, category, productID)
+	if err != nil {
+		return nil, err
+	}
+
+	var discovered *ProductFolder
+	for i := range folders {
+		if norm.NFC.String(folders[i].Name) == folder {
+			discovered = &folders[i]
+			break
+		}
+	}
+	if discovered == nil {
+		return nil, newNotFound("FOLDER_NOT_FOUND", "Folder '%s' was not found for product %s/%s", folder, category, productID)
+	}
+
+	otherImages, err := discoverImagesInFolder(ctx, requestID, discovered.S3Prefix)
+	if err != nil {
+		return nil, err
+	}
+	if otherImages == nil {
+		otherImages = []ImageData{}
+	}
+
+	return &ImagesData{
+		LabelImages:    []ImageData{},
+		OverviewImages: []ImageData{},
+		OtherImages:    otherImages,
+	}, nil
+}
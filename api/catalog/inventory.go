@@ -0,0 +1,559 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/md5"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/parquet-go/parquet-go"
+)
+
+// Changes recorded this long before the inventory creation time are replayed on
+// top of it, covering the delay between the listing and the report delivery.
+// Replaying is idempotent because changes are applied in event order.
+const inventoryChangeOverlap = 24 * time.Hour
+
+// Parallel downloads of recorded change objects
+const inventoryChangeFetchWorkers = 16
+
+// Inventory delivery folders are named by creation time, e.g. "2026-10-17T01-00Z/"
+var inventoryDeliveryPattern = regexp.MustCompile(`^\d{4}-\d{2}-\d{2}T\d{2}-\d{2}Z/$`)
+
+// manifest.json of an S3 Inventory delivery
+type inventoryManifest struct {
+	SourceBucket      string                  `json:"sourceBucket"`
+	DestinationBucket string                  `json:"destinationBucket"`
+	Version           string                  `json:"version"`
+	CreationTimestamp string                  `json:"creationTimestamp"`
+	FileFormat        string                  `json:"fileFormat"`
+	FileSchema        string                  `json:"fileSchema"`
+	Files             []inventoryManifestFile `json:"files"`
+}
+
+type inventoryManifestFile struct {
+	Key         string `json:"key"`
+	Size        int64  `json:"size"`
+	MD5Checksum string `json:"MD5checksum"`
+}
+
+// Object change seen through an S3 event notification since the last inventory
+type inventoryChange struct {
+	Key       string    `json:"key"`
+	Deleted   bool      `json:"deleted"`
+	Size      int64     `json:"size"`
+	ETag      string    `json:"etag"`
+	EventTime time.Time `json:"eventTime"`
+	Sequencer string    `json:"sequencer"`
+}
+
+// In-memory view of the dataset built from an inventory plus recorded changes.
+// A published index is never modified; reconciling builds a new one.
+type inventoryIndex struct {
+	manifestKey   string
+	inventoryTime time.Time
+	loadedAt      time.Time
+	base          map[string]objectInfo
+	changes       map[string]inventoryChange // By change object key
+	objects       []objectInfo               // Sorted by key
+}
+
+var (
+	inventoryMutex sync.Mutex
+	inventoryCache *inventoryIndex
+)
+
+// Inventory scanning is opt-in through CATALOG_INVENTORY_PREFIX
+func inventoryEnabled() bool {
+	return appConfig.InventoryPrefix != ""
+}
+
+func inventoryChangesPrefix() string {
+	return appConfig.MetaPrefix + "inventory/changes/"
+}
+
+// Return the cached inventory index, refreshing it when it is older than the refresh interval
+func currentInventoryIndex(ctx context.Context) (*inventoryIndex, error) {
+	inventoryMutex.Lock()
+	defer inventoryMutex.Unlock()
+
+	if inventoryCache != nil && time.Since(inventoryCache.loadedAt) < appConfig.InventoryRefresh {
+		return inventoryCache, nil
+	}
+
+	manifestKey, err := findLatestInventoryManifest(ctx)
+	if err != nil {
+		if inventoryCache != nil {
+			log.Printf("Warning: Failed to check for a newer inventory, keeping %s: %v", inventoryCache.manifestKey, err)
+			return inventoryCache, nil
+		}
+		return nil, err
+	}
+
+	base := inventoryCache
+	if base == nil || base.manifestKey != manifestKey {
+		base, err = loadInventory(ctx, manifestKey)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	index, err := base.reconcile(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	inventoryCache = index
+	return index, nil
+}
+
+// Find the manifest of the most recent inventory delivery
+func findLatestInventoryManifest(ctx context.Context) (string, error) {
+	deliveries, err := inventoryStorage.ListPrefixes(ctx, appConfig.InventoryPrefix)
+	if err != nil {
+		return "", fmt.Errorf("failed to list inventory deliveries: %w", err)
+	}
+
+	var latest string
+	for _, delivery := range deliveries {
+		if inventoryDeliveryPattern.MatchString(strings.TrimPrefix(delivery, appConfig.InventoryPrefix)) && delivery > latest {
+			latest = delivery
+		}
+	}
+
+	if latest == "" {
+		return "", fmt.Errorf("no inventory delivery found under %s/%s", appConfig.InventoryBucket, appConfig.InventoryPrefix)
+	}
+
+	return latest + "manifest.json", nil
+}
+
+// Load every data file listed in a manifest into a new index
+func loadInventory(ctx context.Context, manifestKey string) (*inventoryIndex, error) {
+	started := time.Now()
+
+	body, err := readObject(ctx, inventoryStorage, manifestKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read inventory manifest %s: %w", manifestKey, err)
+	}
+
+	var manifest inventoryManifest
+	if err := json.Unmarshal(body, &manifest); err != nil {
+		return nil, fmt.Errorf("failed to parse inventory manifest %s: %w", manifestKey, err)
+	}
+
+	creationMillis, err := strconv.ParseInt(manifest.CreationTimestamp, 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid creationTimestamp in inventory manifest: %s", manifest.CreationTimestamp)
+	}
+
+	index := &inventoryIndex{
+		manifestKey:   manifestKey,
+		inventoryTime: time.UnixMilli(creationMillis).UTC(),
+		base:          make(map[string]objectInfo),
+		changes:       make(map[string]inventoryChange),
+	}
+
+	for _, file := range manifest.Files {
+		data, err := readObject(ctx, inventoryStorage, file.Key)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read inventory file %s: %w", file.Key, err)
+		}
+
+		if file.MD5Checksum != "" {
+			sum := md5.Sum(data)
+			if hex.EncodeToString(sum[:]) != file.MD5Checksum {
+				return nil, fmt.Errorf("checksum mismatch for inventory file %s", file.Key)
+			}
+		}
+
+		var objects []objectInfo
+		switch strings.ToUpper(manifest.FileFormat) {
+		case "CSV":
+			objects, err = parseInventoryCSV(data, manifest.FileSchema)
+		case "PARQUET":
+			objects, err = parseInventoryParquet(data)
+		default:
+			err = fmt.Errorf("unsupported inventory format: %s", manifest.FileFormat)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse inventory file %s: %w", file.Key, err)
+		}
+
+		for _, obj := range objects {
+			if strings.HasPrefix(obj.Key, "dataset/") {
+				index.base[obj.Key] = obj
+			}
+		}
+	}
+
+	log.Printf("Loaded inventory %s (%s, %d files): %d dataset objects as of %s in %v",
+		manifestKey, manifest.FileFormat, len(manifest.Files), len(index.base), index.inventoryTime.Format(time.RFC3339), time.Since(started))
+	return index, nil
+}
+
+// Parse a gzipped CSV inventory file using the column order from the manifest
+func parseInventoryCSV(data []byte, fileSchema string) ([]objectInfo, error) {
+	columns := make(map[string]int)
+	for i, column := range strings.Split(fileSchema, ",") {
+		columns[strings.TrimSpace(column)] = i
+	}
+
+	keyColumn, ok := columns["Key"]
+	if !ok {
+		return nil, fmt.Errorf("inventory schema has no Key column: %s", fileSchema)
+	}
+
+	gzipReader, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("failed to open gzip stream: %w", err)
+	}
+	defer gzipReader.Close()
+
+	reader := csv.NewReader(gzipReader)
+	reader.FieldsPerRecord = -1
+
+	field := func(record []string, name string) string {
+		if i, ok := columns[name]; ok && i < len(record) {
+			return record[i]
+		}
+		return ""
+	}
+
+	var objects []objectInfo
+	for {
+		record, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CSV record: %w", err)
+		}
+		if keyColumn >= len(record) {
+			continue
+		}
+
+		// Versioned inventories list every version, keep the current one only
+		if field(record, "IsLatest") == "false" || field(record, "IsDeleteMarker") == "true" {
+			continue
+		}
+
+		// Keys are URL-encoded in CSV inventories
+		key, err := url.QueryUnescape(record[keyColumn])
+		if err != nil {
+			return nil, fmt.Errorf("invalid key encoding %q: %w", record[keyColumn], err)
+		}
+
+		obj := objectInfo{Key: key, ETag: field(record, "ETag")}
+		obj.Size, _ = strconv.ParseInt(field(record, "Size"), 10, 64)
+		obj.LastModified, _ = time.Parse(time.RFC3339, field(record, "LastModifiedDate"))
+
+		objects = append(objects, obj)
+	}
+
+	return objects, nil
+}
+
+// Parse a Parquet inventory file by column name
+func parseInventoryParquet(data []byte) ([]objectInfo, error) {
+	file, err := parquet.OpenFile(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return nil, fmt.Errorf("failed to open parquet file: %w", err)
+	}
+
+	column := func(name string) int {
+		if leaf, ok := file.Schema().Lookup(name); ok {
+			return leaf.ColumnIndex
+		}
+		return -1
+	}
+
+	keyColumn := column("key")
+	if keyColumn < 0 {
+		return nil, fmt.Errorf("parquet inventory has no key column")
+	}
+	sizeColumn := column("size")
+	lastModifiedColumn := column("last_modified_date")
+	etagColumn := column("e_tag")
+	isLatestColumn := column("is_latest")
+	deleteMarkerColumn := column("is_delete_marker")
+
+	// Timestamps are stored as INT64 in the unit of the column's logical type
+	timestampUnit := time.Millisecond
+	if leaf, ok := file.Schema().Lookup("last_modified_date"); ok {
+		if logical := leaf.Node.Type().LogicalType(); logical != nil && logical.Timestamp != nil {
+			switch {
+			case logical.Timestamp.Unit.Micros != nil:
+				timestampUnit = time.Microsecond
+			case logical.Timestamp.Unit.Nanos != nil:
+				timestampUnit = time.Nanosecond
+			}
+		}
+	}
+
+	reader := parquet.NewReader(file)
+	defer reader.Close()
+
+	var objects []objectInfo
+	rows := make([]parquet.Row, 256)
+	for {
+		n, err := reader.ReadRows(rows)
+		for _, row := range rows[:n] {
+			obj := objectInfo{}
+			current := true
+
+			for _, value := range row {
+				if value.IsNull() {
+					continue
+				}
+				switch value.Column() {
+				case keyColumn:
+					obj.Key = string(value.ByteArray())
+				case sizeColumn:
+					obj.Size = value.Int64()
+				case lastModifiedColumn:
+					obj.LastModified = time.Unix(0, value.Int64()*int64(timestampUnit)).UTC()
+				case etagColumn:
+					obj.ETag = string(value.ByteArray())
+				case isLatestColumn:
+					current = current && value.Boolean()
+				case deleteMarkerColumn:
+					current = current && !value.Boolean()
+				}
+			}
+
+			if current && obj.Key != "" {
+				objects = append(objects, obj)
+			}
+		}
+
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read parquet rows: %w", err)
+		}
+	}
+
+	return objects, nil
+}
+
+// Overlay changes recorded since the inventory was taken onto a new index
+func (idx *inventoryIndex) reconcile(ctx context.Context) (*inventoryIndex, error) {
+	since := idx.inventoryTime.Add(-inventoryChangeOverlap)
+
+	newChanges, err := loadInventoryChanges(ctx, since, idx.changes)
+	if err != nil {
+		return nil, err
+	}
+
+	reconciled := idx.withChanges(newChanges, time.Now())
+	log.Printf("Inventory %s reconciled: %d objects after %d recorded changes", reconciled.manifestKey, len(reconciled.objects), len(reconciled.changes))
+	return reconciled, nil
+}
+
+// Build a new index with more recorded changes applied in event order. The
+// base objects are shared, as they are never modified after loading.
+func (idx *inventoryIndex) withChanges(newChanges map[string]inventoryChange, loadedAt time.Time) *inventoryIndex {
+	next := &inventoryIndex{
+		manifestKey:   idx.manifestKey,
+		inventoryTime: idx.inventoryTime,
+		loadedAt:      loadedAt,
+		base:          idx.base,
+		changes:       make(map[string]inventoryChange, len(idx.changes)+len(newChanges)),
+	}
+	for changeKey, change := range idx.changes {
+		next.changes[changeKey] = change
+	}
+	for changeKey, change := range newChanges {
+		next.changes[changeKey] = change
+	}
+
+	ordered := make([]inventoryChange, 0, len(next.changes))
+	for _, change := range next.changes {
+		ordered = append(ordered, change)
+	}
+	sort.Slice(ordered, func(i, j int) bool {
+		if !ordered[i].EventTime.Equal(ordered[j].EventTime) {
+			return ordered[i].EventTime.Before(ordered[j].EventTime)
+		}
+		return ordered[i].Sequencer < ordered[j].Sequencer
+	})
+
+	current := make(map[string]objectInfo, len(next.base))
+	for key, obj := range next.base {
+		current[key] = obj
+	}
+	for _, change := range ordered {
+		if change.Deleted {
+			delete(current, change.Key)
+		} else {
+			current[change.Key] = objectInfo{Key: change.Key, Size: change.Size, LastModified: change.EventTime, ETag: change.ETag}
+		}
+	}
+
+	next.objects = make([]objectInfo, 0, len(current))
+	for _, obj := range current {
+		next.objects = append(next.objects, obj)
+	}
+	sort.Slice(next.objects, func(i, j int) bool { return next.objects[i].Key < next.objects[j].Key })
+
+	return next
+}
+
+// Index range of objects whose key starts with prefix
+func (idx *inventoryIndex) prefixRange(prefix string) (int, int) {
+	start := sort.Search(len(idx.objects), func(i int) bool { return idx.objects[i].Key >= prefix })
+	end := start
+	for end < len(idx.objects) && strings.HasPrefix(idx.objects[end].Key, prefix) {
+		end++
+	}
+	return start, end
+}
+
+func (idx *inventoryIndex) listObjects(prefix string, limit int) []objectInfo {
+	start, end := idx.prefixRange(prefix)
+	if limit > 0 && end-start > limit {
+		end = start + limit
+	}
+
+	objects := make([]objectInfo, end-start)
+	copy(objects, idx.objects[start:end])
+	return objects
+}
+
+func (idx *inventoryIndex) listPrefixes(prefix string) []string {
+	start, end := idx.prefixRange(prefix)
+
+	var prefixes []string
+	for _, obj := range idx.objects[start:end] {
+		child, _, found := strings.Cut(strings.TrimPrefix(obj.Key, prefix), "/")
+		if !found {
+			continue
+		}
+		childPrefix := prefix + child + "/"
+		if len(prefixes) == 0 || prefixes[len(prefixes)-1] != childPrefix {
+			prefixes = append(prefixes, childPrefix)
+		}
+	}
+	return prefixes
+}
+
+// Load change objects recorded since the given time, skipping ones already loaded
+func loadInventoryChanges(ctx context.Context, since time.Time, loaded map[string]inventoryChange) (map[string]inventoryChange, error) {
+	var pending []string
+
+	for day := since.UTC().Truncate(24 * time.Hour); !day.After(time.Now().UTC()); day = day.Add(24 * time.Hour) {
+		objects, err := datasetStorage.ListObjects(ctx, inventoryChangesPrefix()+day.Format("2006-01-02")+"/", 0)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list inventory changes: %w", err)
+		}
+		for _, obj := range objects {
+			if _, done := loaded[obj.Key]; !done {
+				pending = append(pending, obj.Key)
+			}
+		}
+	}
+
+	var (
+		mutex    sync.Mutex
+		wg       sync.WaitGroup
+		firstErr error
+	)
+	changes := make(map[string]inventoryChange, len(pending))
+	work := make(chan string)
+
+	for i := 0; i < inventoryChangeFetchWorkers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for changeKey := range work {
+				var change inventoryChange
+				body, err := readObject(ctx, datasetStorage, changeKey)
+				if err == nil {
+					err = json.Unmarshal(body, &change)
+				}
+
+				mutex.Lock()
+				if err != nil && firstErr == nil {
+					firstErr = fmt.Errorf("failed to read inventory change %s: %w", changeKey, err)
+				} else if err == nil && !change.EventTime.Before(since) {
+					changes[changeKey] = change
+				}
+				mutex.Unlock()
+			}
+		}()
+	}
+
+	for _, changeKey := range pending {
+		work <- changeKey
+	}
+	close(work)
+	wg.Wait()
+
+	if firstErr != nil {
+		return nil, firstErr
+	}
+	return changes, nil
+}
+
+// Record dataset object changes from an S3 event notification for reconciliation
+func recordInventoryChanges(ctx context.Context, event events.S3Event) (int, error) {
+	if !inventoryEnabled() {
+		return 0, nil
+	}
+
+	recorded := 0
+
+	for _, record := range event.Records {
+		// Keys in S3 event notifications are URL-encoded
+		key, err := url.QueryUnescape(record.S3.Object.Key)
+		if err != nil {
+			log.Printf("Warning: Skipping S3 event with undecodable key %q: %v", record.S3.Object.Key, err)
+			continue
+		}
+		if !strings.HasPrefix(key, "dataset/") {
+			continue
+		}
+
+		change := inventoryChange{
+			Key:       key,
+			Deleted:   strings.HasPrefix(record.EventName, "ObjectRemoved:"),
+			Size:      record.S3.Object.Size,
+			ETag:      record.S3.Object.ETag,
+			EventTime: record.EventTime.UTC(),
+			Sequencer: record.S3.Object.Sequencer,
+		}
+
+		body, err := json.Marshal(change)
+		if err != nil {
+			return recorded, fmt.Errorf("failed to serialize inventory change: %w", err)
+		}
+
+		sum := md5.Sum([]byte(key))
+		changeKey := fmt.Sprintf("%s%s/%s-%s-%s.json", inventoryChangesPrefix(),
+			change.EventTime.Format("2006-01-02"), change.EventTime.Format("150405.000000000"), change.Sequencer, hex.EncodeToString(sum[:8]))
+
+		if err := datasetStorage.Put(ctx, changeKey, body, "application/json"); err != nil {
+			return recorded, fmt.Errorf("failed to record inventory change for %s: %w", key, err)
+		}
+		recorded++
+	}
+
+	return recorded, nil
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/parquet-go/parquet-go"
+)
+
+const inventoryTestSchema = "Bucket, Key, VersionId, IsLatest, IsDeleteMarker, Size, LastModifiedDate, ETag"
+
+func gzipInventoryCSV(t *testing.T, lines ...string) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+	writer := gzip.NewWriter(&buf)
+	if _, err := writer.Write([]byte(strings.Join(lines, "\n") + "\n")); err != nil {
+		t.Fatal(err)
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestParseInventoryCSV(t *testing.T) {
+	data := gzipInventoryCSV(t,
+		`"dataset-bucket","dataset/REF/AQR-B380MA%28GY%29/CH%C3%8DNH+DI%E1%BB%86N.jpg","v1","true","false","2048","2026-10-16T08:30:00.000Z","etag-1"`,
+		`"dataset-bucket","dataset/REF/P1/old.jpg","v0","false","false","10","2026-10-01T00:00:00.000Z","etag-old"`,
+		`"dataset-bucket","dataset/REF/P1/removed.jpg","v2","true","true","","2026-10-15T00:00:00.000Z",""`,
+		`"dataset-bucket","dataset/REF/P1/a.jpg","","","","512","2026-10-14T00:00:00.000Z","etag-2"`,
+	)
+
+	objects, err := parseInventoryCSV(data, inventoryTestSchema)
+	if err != nil {
+		t.Fatalf("parseInventoryCSV() error = %v", err)
+	}
+
+	want := []objectInfo{
+		{Key: "dataset/REF/AQR-B380MA(GY)/CHÍNH DIỆN.jpg", Size: 2048, LastModified: time.Date(2026, 10, 16, 8, 30, 0, 0, time.UTC), ETag: "etag-1"},
+		{Key: "dataset/REF/P1/a.jpg", Size: 512, LastModified: time.Date(2026, 10, 14, 0, 0, 0, 0, time.UTC), ETag: "etag-2"},
+	}
+	if len(objects) != len(want) {
+		t.Fatalf("parseInventoryCSV() returned %d objects, want %d: %+v", len(objects), len(want), objects)
+	}
+	for i := range want {
+		if objects[i].Key != want[i].Key || objects[i].Size != want[i].Size || objects[i].ETag != want[i].ETag || !objects[i].LastModified.Equal(want[i].LastModified) {
+			t.Errorf("object %d = %+v, want %+v", i, objects[i], want[i])
+		}
+	}
+}
+
+func TestParseInventoryCSVErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		data   []byte
+		schema string
+	}{
+		{"no key column", gzipInventoryCSV(t, `"dataset-bucket","10"`), "Bucket, Size"},
+		{"not gzipped", []byte(`"dataset-bucket","dataset/a.jpg"`), "Bucket, Key"},
+		{"bad key encoding", gzipInventoryCSV(t, `"dataset-bucket","dataset/%zz.jpg"`), "Bucket, Key"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := parseInventoryCSV(tt.data, tt.schema); err == nil {
+				t.Errorf("parseInventoryCSV() error = nil, want an error")
+			}
+		})
+	}
+}
+
+// Row layout of a Parquet S3 Inventory report
+type parquetInventoryRow struct {
+	Bucket           string `parquet:"bucket"`
+	Key              string `parquet:"key"`
+	Size             int64  `parquet:"size"`
+	LastModifiedDate int64  `parquet:"last_modified_date,timestamp(microsecond)"`
+	ETag             string `parquet:"e_tag"`
+	IsLatest         bool   `parquet:"is_latest"`
+	IsDeleteMarker   bool   `parquet:"is_delete_marker"`
+}
+
+func TestLoadInventoryParquet(t *testing.T) {
+	ctx := context.Background()
+
+	storage, err := newLocalStorage(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	saved := inventoryStorage
+	inventoryStorage = storage
+	t.Cleanup(func() { inventoryStorage = saved })
+
+	modified := time.Date(2026, 10, 16, 8, 30, 0, 0, time.UTC)
+	var data bytes.Buffer
+	if err := parquet.Write(&data, []parquetInventoryRow{
+		{Bucket: "dataset-bucket", Key: "dataset/REF/P1/a.jpg", Size: 100, LastModifiedDate: modified.UnixMicro(), ETag: "etag-a", IsLatest: true},
+		{Bucket: "dataset-bucket", Key: "dataset/REF/P1/b.jpg", Size: 50, LastModifiedDate: modified.UnixMicro(), ETag: "etag-b-old"},
+		{Bucket: "dataset-bucket", Key: "dataset/REF/P1/c.jpg", LastModifiedDate: modified.UnixMicro(), IsLatest: true, IsDeleteMarker: true},
+		{Bucket: "dataset-bucket", Key: "catalog-meta/snapshot.json", Size: 10, LastModifiedDate: modified.UnixMicro(), ETag: "etag-meta", IsLatest: true},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	delivery := "inventory/dataset-bucket/catalog/2026-10-17T01-00Z/"
+	dataKey := "inventory/dataset-bucket/catalog/data/part-0.parquet"
+	if err := storage.Put(ctx, dataKey, data.Bytes(), "application/octet-stream"); err != nil {
+		t.Fatal(err)
+	}
+
+	putManifest := func(checksum string) {
+		manifest, err := json.Marshal(inventoryManifest{
+			SourceBucket:      "dataset-bucket",
+			CreationTimestamp: "1792198800000",
+			FileFormat:        "Parquet",
+			Files:             []inventoryManifestFile{{Key: dataKey, Size: int64(data.Len()), MD5Checksum: checksum}},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := storage.Put(ctx, delivery+"manifest.json", manifest, "application/json"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	sum := md5.Sum(data.Bytes())
+	putManifest(hex.EncodeToString(sum[:]))
+
+	index, err := loadInventory(ctx, delivery+"manifest.json")
+	if err != nil {
+		t.Fatalf("loadInventory() error = %v", err)
+	}
+
+	if want := time.UnixMilli(1792198800000).UTC(); !index.inventoryTime.Equal(want) {
+		t.Errorf("inventoryTime = %v, want %v", index.inventoryTime, want)
+	}
+	want := map[string]objectInfo{
+		"dataset/REF/P1/a.jpg": {Key: "dataset/REF/P1/a.jpg", Size: 100, LastModified: modified, ETag: "etag-a"},
+	}
+	if !reflect.DeepEqual(index.base, want) {
+		t.Errorf("base = %+v, want %+v", index.base, want)
+	}
+
+	putManifest("00000000000000000000000000000000")
+	if _, err := loadInventory(ctx, delivery+"manifest.json"); err == nil || !strings.Contains(err.Error(), "checksum mismatch") {
+		t.Errorf("loadInventory() with a wrong checksum error = %v, want a checksum mismatch", err)
+	}
+}
+
+func TestInventoryIndexWithChanges(t *testing.T) {
+	t0 := time.Date(2026, 10, 17, 1, 0, 0, 0, time.UTC)
+	base := &inventoryIndex{
+		manifestKey:   "inventory/2026-10-17T01-00Z/manifest.json",
+		inventoryTime: t0,
+		base: map[string]objectInfo{
+			"dataset/REF/P1/a.jpg": {Key: "dataset/REF/P1/a.jpg", Size: 1, ETag: "a1"},
+			"dataset/REF/P1/b.jpg": {Key: "dataset/REF/P1/b.jpg", Size: 1, ETag: "b1"},
+		},
+		changes: map[string]inventoryChange{
+			"c/0": {Key: "dataset/REF/P1/b.jpg", Deleted: true, EventTime: t0.Add(-time.Hour), Sequencer: "0A"},
+		},
+	}
+
+	later := t0.Add(time.Minute)
+	next := base.withChanges(map[string]inventoryChange{
+		// Same event time: the sequencer orders the delete after the put
+		"c/2": {Key: "dataset/REF/P1/c.jpg", Deleted: true, EventTime: later, Sequencer: "0C"},
+		"c/1": {Key: "dataset/REF/P1/c.jpg", Size: 3, ETag: "c1", EventTime: later, Sequencer: "0B"},
+		// The recreate comes after the delete by event time, whatever the sequencer
+		"c/4": {Key: "dataset/REF/P1/a.jpg", Size: 2, ETag: "a2", EventTime: later.Add(time.Minute), Sequencer: "01"},
+		"c/3": {Key: "dataset/REF/P1/a.jpg", Deleted: true, EventTime: later, Sequencer: "0D"},
+		"c/5": {Key: "dataset/REF/P1/d.jpg", Size: 4, ETag: "d1", EventTime: later, Sequencer: "0E"},
+	}, later)
+
+	var got []string
+	for _, obj := range next.objects {
+		got = append(got, obj.Key+"="+obj.ETag)
+	}
+	want := []string{"dataset/REF/P1/a.jpg=a2", "dataset/REF/P1/d.jpg=d1"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("objects = %v, want %v", got, want)
+	}
+	if len(next.changes) != 6 || !next.loadedAt.Equal(later) {
+		t.Errorf("next index has %d changes loaded at %v, want 6 at %v", len(next.changes), next.loadedAt, later)
+	}
+
+	// The index the changes were applied to is left as it was
+	if len(base.changes) != 1 || base.objects != nil || len(base.base) != 2 {
+		t.Errorf("base index modified: %d changes, %d objects, %d base objects", len(base.changes), len(base.objects), len(base.base))
+	}
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+)
+
+// Payload of scheduled invocations, e.g. an EventBridge rule with constant input {"job":"statsSnapshot"}
+type JobEvent struct {
+	Job string `json:"job"`
+}
+
+type JobResult struct {
+	Job         string      `json:"job"`
+	StartedAt   time.Time   `json:"startedAt"`
+	CompletedAt time.Time   `json:"completedAt"`
+	Result      interface{} `json:"result"`
+}
+
+// Run a background catalog job by name
+func runCatalogJob(ctx context.Context, event JobEvent) (*JobResult, error) {
+	jobID := fmt.Sprintf("job-%s-%d", event.Job, time.Now().Unix())
+	log.Printf("RequestID: %s - Starting catalog job: %s", jobID, event.Job)
+
+	jobResult := &JobResult{
+		Job:       event.Job,
+		StartedAt: time.Now(),
+	}
+
+	var result interface{}
+	var err error
+
+	switch event.Job {
+	case "statsSnapshot":
+		result, err = persistStatsSnapshot(ctx, jobID)
+	case "qualityScoring":
+		result, err = runQualityScoring(ctx, jobID)
+	case "similarityIndex":
+		result, err = runSimilarityIndexing(ctx, jobID)
+	case "labelCodes":
+		result, err = runLabelCodeDecoding(ctx, jobID)
+	default:
+		return nil, fmt.Errorf("unknown catalog job: %s", event.Job)
+	}
+
+	if err != nil {
+		log.Printf("RequestID: %s - Catalog job %s failed: %v", jobID, event.Job, err)
+		return nil, err
+	}
+
+	jobResult.Result = result
+	jobResult.CompletedAt = time.Now()
+
+	log.Printf("RequestID: %s - Catalog job %s completed in %v", jobID, event.Job, jobResult.CompletedAt.Sub(jobResult.StartedAt))
+	return jobResult, nil
+}
@@ -0,0 +1,457 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// Journal actions
+const (
+	journalActionUpload  = "upload"
+	journalActionCopy    = "copy"
+	journalActionDelete  = "delete"
+	journalActionRestore = "restore"
+)
+
+// Journal entry sources
+const (
+	journalSourceS3Event = "s3Event"
+	journalSourceAPI     = "api"
+)
+
+// Fixed-width timestamp prefix of entry IDs, so IDs sort chronologically
+const journalEntryTimeLayout = "2006-01-02T15:04:05.000000000Z"
+
+const (
+	defaultJournalLimit   = 100
+	maxJournalLimit       = 500
+	defaultJournalWindow  = 7 * 24 * time.Hour
+	maxJournalDayScanDays = 31 // Time-only queries read one day-index partition per day
+	maxJournalActorBytes  = 256
+)
+
+// One change to catalog objects. Keys lists every object involved, e.g. the
+// source and destination of a rename; ObjectKey is the object the ETags refer to.
+type JournalEntry struct {
+	ProductKey string            `json:"-" dynamodbav:"productKey"`
+	EntryID    string            `json:"entryId" dynamodbav:"entryId"`
+	Day        string            `json:"-" dynamodbav:"day"`
+	Timestamp  time.Time         `json:"timestamp" dynamodbav:"timestamp"`
+	Category   string            `json:"category" dynamodbav:"category"`
+	ProductID  string            `json:"productId,omitempty" dynamodbav:"productId,omitempty"`
+	Actor      string            `json:"actor" dynamodbav:"actor"`
+	Action     string            `json:"action" dynamodbav:"action"`
+	Source     string            `json:"source" dynamodbav:"source"`
+	ObjectKey  string            `json:"objectKey" dynamodbav:"objectKey"`
+	Keys       []string          `json:"keys" dynamodbav:"keys"`
+	BeforeETag string            `json:"beforeEtag,omitempty" dynamodbav:"beforeEtag,omitempty"`
+	AfterETag  string            `json:"afterEtag,omitempty" dynamodbav:"afterEtag,omitempty"`
+	Details    map[string]string `json:"details,omitempty" dynamodbav:"details,omitempty"`
+}
+
+type JournalMetadata struct {
+	TotalEntries int       `json:"totalEntries"`
+	From         time.Time `json:"from"`
+	To           time.Time `json:"to"`
+	Truncated    bool      `json:"truncated"`
+	ScannedAt    time.Time `json:"scannedAt"`
+}
+
+var dynamoClient *dynamodb.Client
+
+func journalEnabled() bool {
+	return appConfig.JournalTable != ""
+}
+
+// Split a dataset key into category and product ID, e.g. "dataset/REF/P1/TEM NL/a.jpg"
+func parseDatasetKey(key string) (string, string) {
+	parts := strings.SplitN(strings.TrimPrefix(key, "dataset/"), "/", 3)
+	if len(parts) < 3 {
+		return parts[0], "" // Objects directly under a category have no product
+	}
+	return parts[0], parts[1]
+}
+
+// Identify the caller of an API request: an authorizer principal when present,
+// otherwise the IAM caller or the API key used
+func requestActor(request events.APIGatewayProxyRequest) string {
+	if claims, ok := request.RequestContext.Authorizer["claims"].(map[string]interface{}); ok {
+		for _, claim := range []string{"email", "cognito:username", "sub"} {
+			if value, ok := claims[claim].(string); ok && value != "" {
+				return value
+			}
+		}
+	}
+	if principalID, ok := request.RequestContext.Authorizer["principalId"].(string); ok && principalID != "" {
+		return principalID
+	}
+
+	identity := request.RequestContext.Identity
+	switch {
+	case identity.UserArn != "":
+		return identity.UserArn
+	case identity.APIKeyID != "":
+		return "apiKey:" + identity.APIKeyID
+	case identity.SourceIP != "":
+		return "ip:" + identity.SourceIP
+	default:
+		return "unknown"
+	}
+}
+
+// Append an entry to the journal. Missing IDs are derived from the entry, so a
+// redelivered event produces the same ID and is written only once.
+func recordJournalEntry(ctx context.Context, entry JournalEntry) error {
+	if !journalEnabled() {
+		return nil
+	}
+
+	if entry.Timestamp.IsZero() {
+		entry.Timestamp = time.Now()
+	}
+	entry.Timestamp = entry.Timestamp.UTC()
+	if entry.Category == "" && entry.ProductID == "" {
+		entry.Category, entry.ProductID = parseDatasetKey(entry.ObjectKey)
+	}
+	if entry.Actor == "" {
+		entry.Actor = "unknown"
+	}
+	if len(entry.Keys) == 0 {
+		entry.Keys = []string{entry.ObjectKey}
+	}
+
+	entry.ProductKey = entry.Category + "#" + entry.ProductID
+	entry.Day = entry.Timestamp.Format("2006-01-02")
+	if entry.EntryID == "" {
+		sum := sha256.Sum256([]byte(strings.Join(append([]string{entry.Action, entry.Details["sequencer"]}, entry.Keys...), "\n")))
+		entry.EntryID = entry.Timestamp.Format(journalEntryTimeLayout) + "#" + hex.EncodeToString(sum[:6])
+	}
+
+	// S3 events carry no previous ETag, take it from the last entry for the object
+	if entry.BeforeETag == "" && entry.ObjectKey != "" {
+		before, err := previousObjectETag(ctx, entry.ObjectKey, entry.EntryID)
+		if err != nil {
+			log.Printf("Warning: Failed to look up previous ETag of %s: %v", entry.ObjectKey, err)
+		}
+		entry.BeforeETag = before
+	}
+
+	item, err := attributevalue.MarshalMap(entry)
+	if err != nil {
+		return fmt.Errorf("failed to serialize journal entry: %w", err)
+	}
+
+	_, err = dynamoClient.PutItem(ctx, &dynamodb.PutItemInput{
+		TableName:           aws.String(appConfig.JournalTable),
+		Item:                item,
+		ConditionExpression: aws.String("attribute_not_exists(entryId)"),
+	})
+	if err != nil {
+		var conditionFailed *types.ConditionalCheckFailedException
+		if errors.As(err, &conditionFailed) {
+			return nil // Already journaled
+		}
+		return fmt.Errorf("failed to write journal entry for %s: %w", entry.ObjectKey, err)
+	}
+
+	return nil
+}
+
+// After-ETag of the latest journal entry for an object before the given entry
+func previousObjectETag(ctx context.Context, objectKey, beforeEntryID string) (string, error) {
+	result, err := dynamoClient.Query(ctx, &dynamodb.QueryInput{
+		TableName:              aws.String(appConfig.JournalTable),
+		IndexName:              aws.String("object-index"),
+		KeyConditionExpression: aws.String("objectKey = :key AND entryId < :entryId"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":key":     &types.AttributeValueMemberS{Value: objectKey},
+			":entryId": &types.AttributeValueMemberS{Value: beforeEntryID},
+		},
+		ScanIndexForward: aws.Bool(false),
+		Limit:            aws.Int32(1),
+	})
+	if err != nil {
+		return "", err
+	}
+	if len(result.Items) == 0 {
+		return "", nil
+	}
+
+	var previous JournalEntry
+	if err := attributevalue.UnmarshalMap(result.Items[0], &previous); err != nil {
+		return "", err
+	}
+	return previous.AfterETag, nil
+}
+
+// Journal dataset changes delivered as S3 event notifications
+func journalS3Events(ctx context.Context, event events.S3Event) (int, error) {
+	if !journalEnabled() {
+		return 0, nil
+	}
+
+	journaled := 0
+
+	for _, record := range event.Records {
+		key, err := url.QueryUnescape(record.S3.Object.Key)
+		if err != nil || !strings.HasPrefix(key, "dataset/") {
+			continue
+		}
+
+		entry := JournalEntry{
+			Timestamp: record.EventTime,
+			Actor:     record.PrincipalID.PrincipalID,
+			Source:    journalSourceS3Event,
+			ObjectKey: key,
+			Details: map[string]string{
+				"eventName": record.EventName,
+				"sequencer": record.S3.Object.Sequencer,
+			},
+		}
+		if sourceIP := record.RequestParameters.SourceIPAddress; sourceIP != "" {
+			entry.Details["sourceIp"] = sourceIP
+		}
+		if versionID := record.S3.Object.VersionID; versionID != "" {
+			entry.Details["versionId"] = versionID
+		}
+
+		switch {
+		case strings.HasPrefix(record.EventName, "ObjectRemoved:"):
+			entry.Action = journalActionDelete
+		case record.EventName == "ObjectCreated:Copy":
+			entry.Action = journalActionCopy
+			entry.AfterETag = record.S3.Object.ETag
+		default:
+			entry.Action = journalActionUpload
+			entry.AfterETag = record.S3.Object.ETag
+		}
+
+		if err := recordJournalEntry(ctx, entry); err != nil {
+			return journaled, err
+		}
+		journaled++
+	}
+
+	return journaled, nil
+}
+
+// Handle journal query operation, filtered by product, actor and time range
+func handleJournalQuery(ctx context.Context, requestID string, queryParams map[string]string) (*CatalogResponse, error) {
+	if !journalEnabled() {
+		return nil, &requestError{StatusCode: 503, Code: "JOURNAL_DISABLED", Message: "The change journal is not configured (CATALOG_JOURNAL_TABLE)"}
+	}
+
+	category := queryParams["category"]
+	productID := queryParams["productId"]
+	actor := queryParams["actor"]
+
+	if productID != "" && category == "" {
+		return nil, newBadRequest("MISSING_PARAMETER", "Missing required 'category' parameter when filtering by 'productId'")
+	}
+	if len(actor) > maxJournalActorBytes {
+		return nil, newBadRequest("INVALID_ACTOR", "'actor' must be at most %d bytes", maxJournalActorBytes)
+	}
+
+	to := time.Now().UTC()
+	if value := queryParams["to"]; value != "" {
+		parsed, err := parseJournalTime(value, true)
+		if err != nil {
+			return nil, newBadRequest("INVALID_PARAMETER", "Invalid 'to' parameter: %s. Use RFC 3339 or YYYY-MM-DD", value)
+		}
+		to = parsed
+	}
+
+	from := to.Add(-defaultJournalWindow)
+	if value := queryParams["from"]; value != "" {
+		parsed, err := parseJournalTime(value, false)
+		if err != nil {
+			return nil, newBadRequest("INVALID_PARAMETER", "Invalid 'from' parameter: %s. Use RFC 3339 or YYYY-MM-DD", value)
+		}
+		from = parsed
+	}
+	if from.After(to) {
+		return nil, newBadRequest("INVALID_PARAMETER", "'from' must not be after 'to'")
+	}
+
+	limit := defaultJournalLimit
+	if value := queryParams["limit"]; value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed < 1 || parsed > maxJournalLimit {
+			return nil, newBadRequest("INVALID_PARAMETER", "Invalid 'limit' parameter: %s. Must be between 1 and %d", value, maxJournalLimit)
+		}
+		limit = parsed
+	}
+
+	log.Printf("RequestID: %s - Querying journal: product=%s/%s, actor=%s, from=%s, to=%s",
+		requestID, category, productID, actor, from.Format(time.RFC3339), to.Format(time.RFC3339))
+
+	var entries []JournalEntry
+	var truncated bool
+	var err error
+
+	// Filters not served by the partition key are applied by DynamoDB, so the limit counts matching entries
+	filters := journalFilters{}
+	if actor != "" {
+		filters["actor"] = actor
+	}
+	if category != "" && productID == "" {
+		filters["category"] = category
+	}
+
+	switch {
+	case productID != "":
+		entries, truncated, err = queryJournalPartition(ctx, "", "productKey", category+"#"+productID, from, to, filters, limit)
+	case actor != "":
+		delete(filters, "actor")
+		entries, truncated, err = queryJournalPartition(ctx, "actor-index", "actor", actor, from, to, filters, limit)
+	default:
+		if to.Sub(from) > maxJournalDayScanDays*24*time.Hour {
+			return nil, newBadRequest("INVALID_PARAMETER", "Time range must be at most %d days unless filtering by 'productId' or 'actor'", maxJournalDayScanDays)
+		}
+		entries, truncated, err = queryJournalByDay(ctx, from, to, filters, limit)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if entries == nil {
+		entries = []JournalEntry{}
+	}
+
+	response := &CatalogResponse{
+		Type: "journal",
+		Data: entries,
+		Metadata: JournalMetadata{
+			TotalEntries: len(entries),
+			From:         from,
+			To:           to,
+			Truncated:    truncated,
+			ScannedAt:    time.Now(),
+		},
+	}
+
+	log.Printf("RequestID: %s - Journal query returned %d entries (truncated: %v)", requestID, len(entries), truncated)
+	return response, nil
+}
+
+// Accept RFC 3339 timestamps or whole days; a day as the upper bound covers the whole day
+func parseJournalTime(value string, endOfDay bool) (time.Time, error) {
+	if parsed, err := time.Parse(time.RFC3339, value); err == nil {
+		return parsed.UTC(), nil
+	}
+
+	day, err := time.Parse("2006-01-02", value)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if endOfDay {
+		return day.Add(24*time.Hour - time.Nanosecond), nil
+	}
+	return day, nil
+}
+
+// Entry ID bounds covering [from, to]; '~' sorts after the '#' separator and hex suffix
+func journalEntryRange(from, to time.Time) (string, string) {
+	return from.UTC().Format(journalEntryTimeLayout), to.UTC().Format(journalEntryTimeLayout) + "~"
+}
+
+// Attribute values entries must equal, besides the partition key
+type journalFilters map[string]string
+
+// Query one partition of the table or an index, newest first. Pages are read until limit
+// entries pass the filters or the partition ends.
+func queryJournalPartition(ctx context.Context, indexName, keyName, keyValue string, from, to time.Time, filters journalFilters, limit int) ([]JournalEntry, bool, error) {
+	lower, upper := journalEntryRange(from, to)
+
+	input := &dynamodb.QueryInput{
+		TableName:              aws.String(appConfig.JournalTable),
+		KeyConditionExpression: aws.String("#pk = :pk AND entryId BETWEEN :lower AND :upper"),
+		ExpressionAttributeNames: map[string]string{
+			"#pk": keyName,
+		},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":pk":    &types.AttributeValueMemberS{Value: keyValue},
+			":lower": &types.AttributeValueMemberS{Value: lower},
+			":upper": &types.AttributeValueMemberS{Value: upper},
+		},
+		ScanIndexForward: aws.Bool(false),
+	}
+	if indexName != "" {
+		input.IndexName = aws.String(indexName)
+	}
+	if len(filters) > 0 {
+		names := make([]string, 0, len(filters))
+		for name := range filters {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		conditions := make([]string, len(names))
+		for i, name := range names {
+			conditions[i] = fmt.Sprintf("#%s = :%s", name, name)
+			input.ExpressionAttributeNames["#"+name] = name
+			input.ExpressionAttributeValues[":"+name] = &types.AttributeValueMemberS{Value: filters[name]}
+		}
+		input.FilterExpression = aws.String(strings.Join(conditions, " AND "))
+	}
+
+	var entries []JournalEntry
+	paginator := dynamodb.NewQueryPaginator(dynamoClient, input)
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, false, fmt.Errorf("failed to query journal: %w", err)
+		}
+
+		var pageEntries []JournalEntry
+		if err := attributevalue.UnmarshalListOfMaps(page.Items, &pageEntries); err != nil {
+			return nil, false, fmt.Errorf("failed to parse journal entries: %w", err)
+		}
+
+		for _, entry := range pageEntries {
+			if len(entries) == limit {
+				return entries, true, nil
+			}
+			entries = append(entries, entry)
+		}
+	}
+
+	return entries, false, nil
+}
+
+// Query the day index for each day in the range, newest day first
+func queryJournalByDay(ctx context.Context, from, to time.Time, filters journalFilters, limit int) ([]JournalEntry, bool, error) {
+	var entries []JournalEntry
+
+	for day := to.UTC().Truncate(24 * time.Hour); !day.Before(from.UTC().Truncate(24 * time.Hour)); day = day.Add(-24 * time.Hour) {
+		dayEntries, truncated, err := queryJournalPartition(ctx, "day-index", "day", day.Format("2006-01-02"), from, to, filters, limit-len(entries))
+		if err != nil {
+			return nil, false, err
+		}
+		entries = append(entries, dayEntries...)
+
+		if truncated {
+			return entries, true, nil
+		}
+		if len(entries) == limit {
+			// Full; earlier days may still hold entries
+			return entries, day.After(from), nil
+		}
+	}
+
+	return entries, false, nil
+}
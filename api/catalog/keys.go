@@ -0,0 +1,282 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// Maximum length of a single key component, of a full S3 key and of a version ID, in bytes
+const (
+	maxKeyComponentBytes = 255
+	maxObjectKeyBytes    = 1024
+	maxVersionIDBytes    = 1024
+)
+
+// requestError is returned for invalid client input and mapped to a 4xx response
+type requestError struct {
+	StatusCode int
+	Code       string
+	Message    string
+}
+
+func (e *requestError) Error() string {
+	return e.Message
+}
+
+func newBadRequest(code, format string, args ...interface{}) error {
+	return &requestError{StatusCode: 400, Code: code, Message: fmt.Sprintf(format, args...)}
+}
+
+func newNotFound(code, format string, args ...interface{}) error {
+	return &requestError{StatusCode: 404, Code: code, Message: fmt.Sprintf(format, args...)}
+}
+
+// Canonicalise a user-supplied key component (product ID, folder name) to NFC
+// and reject anything that could change the prefix it is concatenated into
+func canonicalKeyComponent(param, value string) (string, error) {
+	if !utf8.ValidString(value) {
+		return "", newBadRequest("INVALID_"+parameterCode(param), "'%s' is not valid UTF-8", param)
+	}
+
+	canonical := norm.NFC.String(value)
+	if err := checkKeyComponent(param, canonical); err != nil {
+		return "", err
+	}
+	return canonical, nil
+}
+
+// Reject a key component that could change the prefix it is concatenated into,
+// whatever its normalisation form
+func checkKeyComponent(param, value string) error {
+	if value == "" {
+		return newBadRequest("INVALID_"+parameterCode(param), "'%s' must not be empty", param)
+	}
+	if len(value) > maxKeyComponentBytes {
+		return newBadRequest("INVALID_"+parameterCode(param), "'%s' must be at most %d bytes", param, maxKeyComponentBytes)
+	}
+	if value != strings.TrimSpace(value) {
+		return newBadRequest("INVALID_"+parameterCode(param), "'%s' must not start or end with whitespace", param)
+	}
+	if value == "." || value == ".." {
+		return newBadRequest("INVALID_"+parameterCode(param), "'%s' must not be a relative path segment", param)
+	}
+	if strings.ContainsAny(value, "/\\") {
+		return newBadRequest("INVALID_"+parameterCode(param), "'%s' must not contain path separators", param)
+	}
+	for _, r := range value {
+		if unicode.IsControl(r) || unicode.Is(unicode.Cf, r) || r == utf8.RuneError {
+			return newBadRequest("INVALID_"+parameterCode(param), "'%s' contains a control or formatting character (U+%04X)", param, r)
+		}
+	}
+
+	return nil
+}
+
+// Canonicalise a full dataset object key segment by segment
+func canonicalObjectKey(param, key string) (string, error) {
+	canonical, err := canonicalKeySegments(param, key)
+	if err != nil {
+		return "", err
+	}
+	if !strings.HasPrefix(canonical, "dataset/") {
+		return "", newBadRequest("INVALID_"+parameterCode(param), "'%s' must be a key under dataset/", param)
+	}
+	return canonical, nil
+}
+
+// Canonicalise an object key segment by segment, wherever it lives in the bucket
+func canonicalKeySegments(param, key string) (string, error) {
+	if len(key) > maxObjectKeyBytes {
+		return "", newBadRequest("INVALID_"+parameterCode(param), "'%s' must be at most %d bytes", param, maxObjectKeyBytes)
+	}
+
+	segments := strings.Split(key, "/")
+	for i, segment := range segments {
+		canonical, err := canonicalKeyComponent(param, segment)
+		if err != nil {
+			return "", err
+		}
+		segments[i] = canonical
+	}
+
+	return strings.Join(segments, "/"), nil
+}
+
+// Version IDs are opaque, so only reject what cannot be a version ID
+func validateVersionID(versionID string) error {
+	if !utf8.ValidString(versionID) || len(versionID) > maxVersionIDBytes {
+		return newBadRequest("INVALID_VERSION_ID", "'versionId' must be valid UTF-8 of at most %d bytes", maxVersionIDBytes)
+	}
+	for _, r := range versionID {
+		if unicode.IsControl(r) {
+			return newBadRequest("INVALID_VERSION_ID", "'versionId' contains a control character (U+%04X)", r)
+		}
+	}
+	return nil
+}
+
+// Validate and canonicalise the key components in the query parameters.
+// Handlers receive the canonical values, so every prefix built from them is safe.
+func validateCatalogParams(queryParams map[string]string) (map[string]string, error) {
+	canonical := make(map[string]string, len(queryParams))
+	for name, value := range queryParams {
+		canonical[name] = value
+	}
+
+	if category, ok := queryParams["category"]; ok && category != "" {
+		if _, exists := categoryDefinitions[category]; !exists {
+			return nil, newBadRequest("INVALID_CATEGORY", "Invalid 'category' parameter: %s. Valid values: %s", category, strings.Join(sortedCategoryIDs(), ", "))
+		}
+	}
+
+	for _, param := range []string{"productId", "folder"} {
+		value, ok := queryParams[param]
+		if !ok || value == "" {
+			continue
+		}
+
+		value, err := canonicalKeyComponent(param, value)
+		if err != nil {
+			return nil, err
+		}
+		canonical[param] = value
+	}
+
+	if key := queryParams["key"]; key != "" {
+		key, err := canonicalObjectKey("key", key)
+		if err != nil {
+			return nil, err
+		}
+		canonical["key"] = key
+	}
+
+	// Uploaded images live in the validation bucket, not necessarily under dataset/
+	if imageKey := queryParams["imageKey"]; imageKey != "" {
+		imageKey, err := canonicalKeySegments("imageKey", imageKey)
+		if err != nil {
+			return nil, err
+		}
+		canonical["imageKey"] = imageKey
+	}
+
+	if versionID := queryParams["versionId"]; versionID != "" {
+		if err := validateVersionID(versionID); err != nil {
+			return nil, err
+		}
+	}
+
+	return canonical, nil
+}
+
+// Parameters that name stored objects, in the order their lookups depend on each other
+var storedKeyParams = []string{"productId", "folder", "key", "imageKey"}
+
+// S3 keys are byte-exact and objects uploaded from macOS may be named in NFD. Where a client
+// sent a key parameter in another normalisation form than NFC, use the form it sent if only
+// that form is stored. Costs one lookup per such parameter; NFC input is never looked up.
+func resolveStoredKeyForms(ctx context.Context, requestID string, raw, canonical map[string]string) map[string]string {
+	for _, param := range storedKeyParams {
+		value := raw[param]
+		if value == "" || value == canonical[param] || !validRawKeyParam(param, value) {
+			continue
+		}
+
+		exists := func(params map[string]string) bool {
+			found, err := storedKeyParamExists(ctx, param, params)
+			if err != nil {
+				log.Printf("RequestID: %s - Warning: Failed to look up '%s' as stored: %v", requestID, param, err)
+			}
+			return found
+		}
+		if exists(canonical) {
+			continue
+		}
+
+		rawForm := make(map[string]string, len(canonical))
+		for name, v := range canonical {
+			rawForm[name] = v
+		}
+		rawForm[param] = value
+		if exists(rawForm) {
+			log.Printf("RequestID: %s - Using '%s' as sent, it is stored in another normalisation form than NFC", requestID, param)
+			canonical = rawForm
+		}
+	}
+	return canonical
+}
+
+// Whether a raw key parameter passes the same checks as its canonical form
+func validRawKeyParam(param, value string) bool {
+	switch param {
+	case "productId", "folder":
+		return checkKeyComponent(param, value) == nil
+	default:
+		if len(value) > maxObjectKeyBytes {
+			return false
+		}
+		for _, segment := range strings.Split(value, "/") {
+			if checkKeyComponent(param, segment) != nil {
+				return false
+			}
+		}
+		return true
+	}
+}
+
+// Whether the object or prefix a key parameter names exists. A product or folder without
+// its category (or product) names nothing and reports false.
+func storedKeyParamExists(ctx context.Context, param string, params map[string]string) (bool, error) {
+	prefixExists := func(prefix string) (bool, error) {
+		objects, err := listDatasetObjects(ctx, prefix, 1)
+		return len(objects) > 0, err
+	}
+	objectExists := func(storage objectStorage, key string) (bool, error) {
+		_, err := storage.Head(ctx, key)
+		if errors.Is(err, errObjectNotFound) {
+			return false, nil
+		}
+		return err == nil, err
+	}
+
+	productPrefix := ""
+	if params["category"] != "" && params["productId"] != "" {
+		productPrefix = fmt.Sprintf("dataset/%s/%s/", params["category"], params["productId"])
+	}
+
+	switch param {
+	case "productId":
+		if productPrefix == "" {
+			return false, nil
+		}
+		return prefixExists(productPrefix)
+	case "folder":
+		if productPrefix == "" {
+			return false, nil
+		}
+		return prefixExists(productPrefix + params["folder"] + "/")
+	case "key":
+		return objectExists(datasetStorage, params["key"])
+	case "imageKey":
+		return objectExists(validationStorage, params["imageKey"])
+	}
+	return false, nil
+}
+
+// Error code fragment for a parameter name, e.g. "productId" -> "PRODUCT_ID"
+func parameterCode(param string) string {
+	var builder strings.Builder
+	for i, r := range param {
+		if unicode.IsUpper(r) && i > 0 {
+			builder.WriteByte('_')
+		}
+		builder.WriteRune(unicode.ToUpper(r))
+	}
+	return builder.String()
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// Inputs an attacker would try, and stored names the catalog must accept
+var keyComponentSeeds = []string{
+	"P1",
+	"AQR-B380MA(GY)",
+	"CHÍNH DIỆN",
+	norm.NFD.String("CHÍNH DIỆN"),
+	"HÌNH WEB",
+	"",
+	".",
+	"..",
+	"../..",
+	"a/b",
+	`a\b`,
+	"a\x00b",
+	"\x00",
+	"a\nb",
+	"a\rb",
+	"\x7f",
+	"\u202e",
+	"\ufeffP1",
+	" P1",
+	"P1 ",
+	"\xff",
+	"%2e%2e",
+	"..%2f",
+	strings.Repeat("a", maxKeyComponentBytes+1),
+}
+
+var objectKeySeeds = []string{
+	"dataset/REF/P1/TEM NL/a.jpg",
+	"dataset/REF/" + norm.NFD.String("CHÍNH DIỆN") + "/a.jpg",
+	"dataset/../secrets",
+	"dataset/REF/../../x",
+	"dataset/REF/./P1",
+	"dataset//REF",
+	"/dataset/REF",
+	"dataset/REF/P1\x00.jpg",
+	"dataset/REF/P1\n/a.jpg",
+	`dataset\REF`,
+	"other/REF/P1",
+	"dataset/" + strings.Repeat("a/", maxObjectKeyBytes/2),
+}
+
+// A rejection must be a 400 request error, never an internal error
+func assertBadRequest(t *testing.T, err error) {
+	t.Helper()
+	var reqErr *requestError
+	if !errors.As(err, &reqErr) || reqErr.StatusCode != 400 {
+		t.Fatalf("rejection is not a 400 request error: %v", err)
+	}
+}
+
+// What every accepted segment must satisfy
+func assertSafeSegment(t *testing.T, segment string) {
+	t.Helper()
+	if segment == "" || segment == "." || segment == ".." {
+		t.Fatalf("accepted segment %q", segment)
+	}
+	if strings.ContainsAny(segment, "/\\") {
+		t.Fatalf("accepted segment %q with a path separator", segment)
+	}
+	if !utf8.ValidString(segment) {
+		t.Fatalf("accepted segment %q that is not UTF-8", segment)
+	}
+	for _, r := range segment {
+		if unicode.IsControl(r) || unicode.Is(unicode.Cf, r) {
+			t.Fatalf("accepted segment %q with control or formatting character U+%04X", segment, r)
+		}
+	}
+}
+
+// Whether a value has something that must be rejected in any normalisation form
+func hasForbiddenRune(value string) bool {
+	for _, r := range norm.NFC.String(value) {
+		if r == 0 || unicode.IsControl(r) || unicode.Is(unicode.Cf, r) {
+			return true
+		}
+	}
+	return false
+}
+
+func FuzzCanonicalKeyComponent(f *testing.F) {
+	for _, seed := range keyComponentSeeds {
+		f.Add(seed)
+	}
+
+	f.Fuzz(func(t *testing.T, value string) {
+		canonical, err := canonicalKeyComponent("productId", value)
+		if err != nil {
+			assertBadRequest(t, err)
+			return
+		}
+
+		assertSafeSegment(t, canonical)
+		if hasForbiddenRune(value) || norm.NFC.String(value) == ".." {
+			t.Fatalf("accepted %q", value)
+		}
+		if !norm.NFC.IsNormalString(canonical) {
+			t.Fatalf("result %q is not NFC", canonical)
+		}
+		if len(canonical) > maxKeyComponentBytes {
+			t.Fatalf("result %q is longer than %d bytes", canonical, maxKeyComponentBytes)
+		}
+
+		again, err := canonicalKeyComponent("productId", canonical)
+		if err != nil || again != canonical {
+			t.Fatalf("not idempotent: %q -> %q -> %q (%v)", value, canonical, again, err)
+		}
+	})
+}
+
+func FuzzCanonicalObjectKey(f *testing.F) {
+	for _, seed := range objectKeySeeds {
+		f.Add(seed)
+	}
+	for _, seed := range keyComponentSeeds {
+		f.Add("dataset/REF/" + seed + "/a.jpg")
+	}
+
+	f.Fuzz(func(t *testing.T, key string) {
+		canonical, err := canonicalObjectKey("key", key)
+		if err != nil {
+			assertBadRequest(t, err)
+			return
+		}
+
+		if !strings.HasPrefix(canonical, "dataset/") {
+			t.Fatalf("accepted %q outside dataset/", canonical)
+		}
+		if hasForbiddenRune(key) {
+			t.Fatalf("accepted %q", key)
+		}
+		for _, segment := range strings.Split(canonical, "/") {
+			assertSafeSegment(t, segment)
+		}
+
+		again, err := canonicalObjectKey("key", canonical)
+		if err != nil || again != canonical {
+			t.Fatalf("not idempotent: %q -> %q -> %q (%v)", key, canonical, again, err)
+		}
+	})
+}
+
+func FuzzValidateCatalogParams(f *testing.F) {
+	for _, seed := range keyComponentSeeds {
+		f.Add(seed, "TEM NL", "dataset/REF/P1/TEM NL/a.jpg", "uploads/a.jpg")
+		f.Add("P1", seed, "dataset/REF/P1/"+seed, "uploads/"+seed)
+	}
+	for _, seed := range objectKeySeeds {
+		f.Add("P1", "", seed, seed)
+	}
+
+	f.Fuzz(func(t *testing.T, productID, folder, key, imageKey string) {
+		params := map[string]string{
+			"type":      "images",
+			"category":  "REF",
+			"productId": productID,
+			"folder":    folder,
+			"key":       key,
+			"imageKey":  imageKey,
+		}
+		canonical, err := validateCatalogParams(params)
+		if err != nil {
+			assertBadRequest(t, err)
+			return
+		}
+
+		for _, param := range storedKeyParams {
+			value := canonical[param]
+			if value == "" {
+				continue
+			}
+			if hasForbiddenRune(params[param]) {
+				t.Fatalf("accepted %s %q", param, params[param])
+			}
+			for _, segment := range strings.Split(value, "/") {
+				assertSafeSegment(t, segment)
+			}
+		}
+
+		again, err := validateCatalogParams(canonical)
+		if err != nil {
+			t.Fatalf("canonical parameters rejected: %v", err)
+		}
+		for name, value := range canonical {
+			if again[name] != value {
+				t.Fatalf("not idempotent: %s %q -> %q", name, value, again[name])
+			}
+		}
+	})
+}
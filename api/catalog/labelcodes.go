@@ -0,0 +1,509 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"github.com/makiuchi-d/gozxing"
+	multiqrcode "github.com/makiuchi-d/gozxing/multi/qrcode"
+	"github.com/makiuchi-d/gozxing/oned"
+	"github.com/makiuchi-d/gozxing/qrcode"
+	"golang.org/x/image/draw"
+)
+
+// labelCodesVersion identifies the decoder set below. Results decoded with
+// another version are decoded again by the next run.
+const labelCodesVersion = "v1"
+
+const (
+	labelCodesMaxSide       = 2048 // Larger images are scaled down before decoding
+	labelCodesWorkers       = 2    // Decoding is CPU-bound and the function has about one vCPU
+	labelCodesIndexCacheTTL = 15 * time.Minute
+)
+
+// Outcome of comparing an uploaded label's codes with the reference labels
+const (
+	labelCodeMatch         = "match"
+	labelCodeMismatch      = "mismatch"
+	labelCodeNoUploadCodes = "noCodesInUpload"
+	labelCodeNoReference   = "noReferenceCodes"
+)
+
+type LabelCode struct {
+	Format  string `json:"format"`
+	Payload string `json:"payload"`
+}
+
+// Decoded codes of one image, sorted by format then payload
+type LabelCodes struct {
+	Codes     []LabelCode `json:"codes"`
+	ETag      string      `json:"etag"`
+	Version   string      `json:"version"`
+	DecodedAt time.Time   `json:"decodedAt"`
+}
+
+// Sidecar index of decoded codes for the label images of one category, keyed by object key
+type LabelCodeIndex struct {
+	Category    string                `json:"category"`
+	Version     string                `json:"version"`
+	GeneratedAt time.Time             `json:"generatedAt"`
+	Images      map[string]LabelCodes `json:"images"`
+}
+
+type LabelCodeReference struct {
+	Key   string      `json:"key"`
+	Codes []LabelCode `json:"codes"`
+}
+
+type LabelCodeCheck struct {
+	Status string `json:"status"`
+	// Payloads found both on the uploaded label and on a reference label
+	MatchedPayloads []string `json:"matchedPayloads"`
+	// Whether an uploaded payload mentions the product ID, ignoring case and punctuation
+	MentionsProductID bool                 `json:"mentionsProductId"`
+	UploadedCodes     []LabelCode          `json:"uploadedCodes"`
+	ReferenceCodes    []LabelCodeReference `json:"referenceCodes"`
+}
+
+type LabelCodeCheckMetadata struct {
+	ImageKey       string    `json:"imageKey"`
+	ProductID      string    `json:"productId"`
+	Category       string    `json:"category"`
+	DecoderVersion string    `json:"decoderVersion"`
+	CheckedAt      time.Time `json:"checkedAt"`
+}
+
+type LabelCodeIndexCategory struct {
+	Category       string `json:"category"`
+	TotalImages    int    `json:"totalImages"`
+	DecodedImages  int    `json:"decodedImages"`
+	ImagesWithCode int    `json:"imagesWithCode"`
+	ReusedImages   int    `json:"reusedImages"`
+	FailedImages   int    `json:"failedImages"`
+	PendingImages  int    `json:"pendingImages"`
+}
+
+// Output of a decoding run
+type LabelCodeIndexReport struct {
+	Version     string                   `json:"version"`
+	GeneratedAt time.Time                `json:"generatedAt"`
+	Complete    bool                     `json:"complete"`
+	Categories  []LabelCodeIndexCategory `json:"categories"`
+}
+
+type cachedLabelCodeIndex struct {
+	index    *LabelCodeIndex
+	loadedAt time.Time
+}
+
+var (
+	labelCodeCacheMutex sync.Mutex
+	labelCodeIndexCache = make(map[string]cachedLabelCodeIndex)
+)
+
+func labelCodeIndexKey(category string) string {
+	return appConfig.MetaPrefix + "labelcodes/" + category + ".json"
+}
+
+// Sidecar of an uploaded image, kept with the catalog's own objects
+func uploadedLabelCodesKey(imageKey string) string {
+	return appConfig.MetaPrefix + "labelcodes/uploads/" + imageKey + ".json"
+}
+
+// Handle the deterministic label code check of an uploaded label against a product's reference labels
+func handleLabelCodeCheck(ctx context.Context, requestID string, queryParams map[string]string) (*CatalogResponse, error) {
+	imageKey := queryParams["imageKey"]
+	category := queryParams["category"]
+	productID := queryParams["productId"]
+
+	if imageKey == "" || category == "" || productID == "" {
+		return nil, newBadRequest("MISSING_PARAMETER", "Missing required 'imageKey', 'category' and 'productId' parameters for label code check")
+	}
+
+	log.Printf("RequestID: %s - Checking label codes of %s against %s/%s", requestID, imageKey, category, productID)
+
+	uploaded, err := uploadedLabelCodes(ctx, requestID, imageKey)
+	if err != nil {
+		return nil, err
+	}
+
+	index, err := cachedLabelCodeIndexFor(ctx, category)
+	if err != nil {
+		return nil, err
+	}
+
+	references := []LabelCodeReference{}
+	productPrefix := categoryDefinitions[category].S3Prefix + productID + "/"
+	for key, codes := range index.Images {
+		if strings.HasPrefix(key, productPrefix) && codes.Version == labelCodesVersion && len(codes.Codes) > 0 {
+			references = append(references, LabelCodeReference{Key: key, Codes: codes.Codes})
+		}
+	}
+	sort.Slice(references, func(i, j int) bool {
+		return references[i].Key < references[j].Key
+	})
+
+	check := compareLabelCodes(uploaded.Codes, references, productID)
+
+	log.Printf("RequestID: %s - Label code check for %s: %s (%d uploaded codes, %d reference images)",
+		requestID, imageKey, check.Status, len(check.UploadedCodes), len(references))
+
+	return &CatalogResponse{
+		Type: "labelCodeCheck",
+		Data: check,
+		Metadata: LabelCodeCheckMetadata{
+			ImageKey:       imageKey,
+			ProductID:      productID,
+			Category:       category,
+			DecoderVersion: labelCodesVersion,
+			CheckedAt:      time.Now(),
+		},
+	}, nil
+}
+
+// Compare uploaded codes with the reference codes. The result depends only on the payloads.
+func compareLabelCodes(uploaded []LabelCode, references []LabelCodeReference, productID string) LabelCodeCheck {
+	check := LabelCodeCheck{
+		MatchedPayloads: []string{},
+		UploadedCodes:   uploaded,
+		ReferenceCodes:  references,
+	}
+	if check.UploadedCodes == nil {
+		check.UploadedCodes = []LabelCode{}
+	}
+
+	referencePayloads := make(map[string]bool)
+	for _, reference := range references {
+		for _, code := range reference.Codes {
+			referencePayloads[normalizePayload(code.Payload)] = true
+		}
+	}
+
+	normalizedProductID := normalizeProductID(productID)
+	matched := make(map[string]bool)
+	for _, code := range uploaded {
+		if referencePayloads[normalizePayload(code.Payload)] && !matched[code.Payload] {
+			matched[code.Payload] = true
+			check.MatchedPayloads = append(check.MatchedPayloads, code.Payload)
+		}
+		if normalizedProductID != "" && strings.Contains(normalizeProductID(code.Payload), normalizedProductID) {
+			check.MentionsProductID = true
+		}
+	}
+	sort.Strings(check.MatchedPayloads)
+
+	switch {
+	case len(uploaded) == 0:
+		check.Status = labelCodeNoUploadCodes
+	case len(references) == 0:
+		check.Status = labelCodeNoReference
+	case len(check.MatchedPayloads) > 0:
+		check.Status = labelCodeMatch
+	default:
+		check.Status = labelCodeMismatch
+	}
+	return check
+}
+
+// Payloads compare equal regardless of surrounding and repeated whitespace
+func normalizePayload(payload string) string {
+	return strings.Join(strings.FieldsFunc(payload, unicode.IsSpace), " ")
+}
+
+// Decoded codes of an uploaded image, reusing its sidecar while the object is unchanged
+func uploadedLabelCodes(ctx context.Context, requestID, imageKey string) (*LabelCodes, error) {
+	info, err := validationStorage.Head(ctx, imageKey)
+	if errors.Is(err, errObjectNotFound) {
+		return nil, newNotFound("IMAGE_NOT_FOUND", "Image %s was not found in the validation bucket", imageKey)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	sidecarKey := uploadedLabelCodesKey(imageKey)
+	if body, err := readObject(ctx, datasetStorage, sidecarKey); err == nil {
+		var cached LabelCodes
+		if json.Unmarshal(body, &cached) == nil && cached.ETag == info.ETag && cached.Version == labelCodesVersion {
+			return &cached, nil
+		}
+	}
+
+	img, err := loadImage(ctx, validationStorage, imageKey)
+	if err != nil {
+		return nil, newBadRequest("INVALID_IMAGE", "Image %s could not be read: %v", imageKey, err)
+	}
+
+	codes := &LabelCodes{
+		Codes:     decodeLabelCodes(img),
+		ETag:      info.ETag,
+		Version:   labelCodesVersion,
+		DecodedAt: time.Now().UTC(),
+	}
+
+	body, err := json.Marshal(codes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to serialize label codes: %w", err)
+	}
+	if err := datasetStorage.Put(ctx, sidecarKey, body, "application/json"); err != nil {
+		log.Printf("RequestID: %s - Warning: Failed to store label codes of %s: %v", requestID, imageKey, err)
+	}
+
+	return codes, nil
+}
+
+// Decode QR codes and common 1D barcodes from an image
+func decodeLabelCodes(img image.Image) []LabelCode {
+	bounds := img.Bounds()
+	if longSide := max(bounds.Dx(), bounds.Dy()); longSide > labelCodesMaxSide {
+		scaled := image.NewGray(image.Rect(0, 0, bounds.Dx()*labelCodesMaxSide/longSide, bounds.Dy()*labelCodesMaxSide/longSide))
+		draw.ApproxBiLinear.Scale(scaled, scaled.Bounds(), img, bounds, draw.Src, nil)
+		img = scaled
+	}
+
+	bitmap, err := gozxing.NewBinaryBitmapFromImage(img)
+	if err != nil {
+		return []LabelCode{}
+	}
+	hints := map[gozxing.DecodeHintType]interface{}{
+		gozxing.DecodeHintType_TRY_HARDER: true,
+	}
+
+	seen := make(map[LabelCode]bool)
+	codes := []LabelCode{}
+	add := func(result *gozxing.Result) {
+		code := LabelCode{Format: result.GetBarcodeFormat().String(), Payload: result.GetText()}
+		if code.Payload != "" && !seen[code] {
+			seen[code] = true
+			codes = append(codes, code)
+		}
+	}
+
+	// Labels may carry several QR codes; fall back to the single reader, which handles some the detector misses
+	if results, err := multiqrcode.NewQRCodeMultiReader().DecodeMultiple(bitmap, hints); err == nil && len(results) > 0 {
+		for _, result := range results {
+			add(result)
+		}
+	} else if result, err := qrcode.NewQRCodeReader().Decode(bitmap, hints); err == nil {
+		add(result)
+	}
+
+	// Each 1D reader finds at most one barcode
+	for _, reader := range []gozxing.Reader{
+		oned.NewMultiFormatUPCEANReader(hints),
+		oned.NewCode128Reader(),
+		oned.NewCode39Reader(),
+		oned.NewITFReader(),
+	} {
+		if result, err := reader.Decode(bitmap, hints); err == nil {
+			add(result)
+		}
+	}
+
+	sort.Slice(codes, func(i, j int) bool {
+		if codes[i].Format != codes[j].Format {
+			return codes[i].Format < codes[j].Format
+		}
+		return codes[i].Payload < codes[j].Payload
+	})
+	return codes
+}
+
+// Read a category's label code index from storage, empty when none has been written
+func readLabelCodeIndex(ctx context.Context, category string) (*LabelCodeIndex, error) {
+	body, err := readObject(ctx, datasetStorage, labelCodeIndexKey(category))
+	if errors.Is(err, errObjectNotFound) {
+		return &LabelCodeIndex{Category: category, Images: map[string]LabelCodes{}}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read label code index for %s: %w", category, err)
+	}
+
+	var index LabelCodeIndex
+	if err := json.Unmarshal(body, &index); err != nil {
+		return nil, fmt.Errorf("failed to parse label code index for %s: %w", category, err)
+	}
+	if index.Images == nil {
+		index.Images = map[string]LabelCodes{}
+	}
+	return &index, nil
+}
+
+// Cached label code index for serving API requests
+func cachedLabelCodeIndexFor(ctx context.Context, category string) (*LabelCodeIndex, error) {
+	labelCodeCacheMutex.Lock()
+	defer labelCodeCacheMutex.Unlock()
+
+	if cached, ok := labelCodeIndexCache[category]; ok && time.Since(cached.loadedAt) < labelCodesIndexCacheTTL {
+		return cached.index, nil
+	}
+
+	index, err := readLabelCodeIndex(ctx, category)
+	if err != nil {
+		return nil, err
+	}
+	labelCodeIndexCache[category] = cachedLabelCodeIndex{index: index, loadedAt: time.Now()}
+	return index, nil
+}
+
+// Decode codes from label images that changed since the last run and store the
+// sidecar indexes. Work stops before the invocation deadline; the next run
+// continues where this one left off.
+func runLabelCodeDecoding(ctx context.Context, requestID string) (*LabelCodeIndexReport, error) {
+	decodingCtx := ctx
+	if deadline, ok := ctx.Deadline(); ok {
+		var cancel context.CancelFunc
+		decodingCtx, cancel = context.WithDeadline(ctx, deadline.Add(-qualityDeadlineMargin))
+		defer cancel()
+	}
+
+	report := &LabelCodeIndexReport{
+		Version:  labelCodesVersion,
+		Complete: true,
+	}
+
+	for _, category := range sortedCategoryIDs() {
+		categoryReport, err := decodeCategoryLabelCodes(decodingCtx, ctx, requestID, category)
+		if err != nil {
+			return nil, err
+		}
+		if categoryReport.PendingImages > 0 {
+			report.Complete = false
+		}
+		report.Categories = append(report.Categories, *categoryReport)
+	}
+
+	report.GeneratedAt = time.Now().UTC()
+	return report, nil
+}
+
+// Decode the label images of one category. decodingCtx bounds the decoding
+// work, while ctx is used to load and store the index.
+func decodeCategoryLabelCodes(decodingCtx, ctx context.Context, requestID, category string) (*LabelCodeIndexCategory, error) {
+	objects, err := listDatasetObjects(ctx, categoryDefinitions[category].S3Prefix, 0)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list images in category %s: %w", category, err)
+	}
+
+	previous, err := readLabelCodeIndex(ctx, category)
+	if err != nil {
+		return nil, err
+	}
+
+	// Objects no longer listed drop out of the new index
+	index := &LabelCodeIndex{
+		Category: category,
+		Version:  labelCodesVersion,
+		Images:   make(map[string]LabelCodes),
+	}
+	categoryReport := &LabelCodeIndexCategory{Category: category}
+
+	var pending []objectInfo
+	for _, obj := range objects {
+		_, folder := splitProductImageKey(category, obj.Key)
+		if !isImageFile(obj.Key) || folderRole(folder) != folderRoleLabel {
+			continue
+		}
+		categoryReport.TotalImages++
+
+		if codes, ok := previous.Images[obj.Key]; ok && codes.ETag == obj.ETag && codes.Version == labelCodesVersion {
+			index.Images[obj.Key] = codes
+			categoryReport.ReusedImages++
+			continue
+		}
+		pending = append(pending, obj)
+	}
+
+	var mutex sync.Mutex
+	var wg sync.WaitGroup
+	work := make(chan objectInfo)
+
+	for i := 0; i < labelCodesWorkers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for obj := range work {
+				img, err := loadImage(decodingCtx, datasetStorage, obj.Key)
+				var codes []LabelCode
+				if err == nil {
+					codes = decodeLabelCodes(img)
+				}
+
+				mutex.Lock()
+				if err != nil {
+					if decodingCtx.Err() != nil {
+						if previousCodes, ok := previous.Images[obj.Key]; ok {
+							index.Images[obj.Key] = previousCodes
+						}
+						categoryReport.PendingImages++
+					} else {
+						log.Printf("RequestID: %s - Warning: Failed to decode %s: %v", requestID, obj.Key, err)
+						categoryReport.FailedImages++
+					}
+				} else {
+					index.Images[obj.Key] = LabelCodes{
+						Codes:     codes,
+						ETag:      obj.ETag,
+						Version:   labelCodesVersion,
+						DecodedAt: time.Now().UTC(),
+					}
+					categoryReport.DecodedImages++
+				}
+				mutex.Unlock()
+			}
+		}()
+	}
+
+	for _, obj := range pending {
+		if obj.Size > qualityImageMaxBytes {
+			mutex.Lock()
+			categoryReport.FailedImages++
+			mutex.Unlock()
+			continue
+		}
+		if decodingCtx.Err() != nil {
+			mutex.Lock()
+			if codes, ok := previous.Images[obj.Key]; ok {
+				index.Images[obj.Key] = codes
+			}
+			categoryReport.PendingImages++
+			mutex.Unlock()
+			continue
+		}
+		work <- obj
+	}
+	close(work)
+	wg.Wait()
+
+	for _, codes := range index.Images {
+		if len(codes.Codes) > 0 {
+			categoryReport.ImagesWithCode++
+		}
+	}
+
+	index.GeneratedAt = time.Now().UTC()
+	body, err := json.Marshal(index)
+	if err != nil {
+		return nil, fmt.Errorf("failed to serialize label code index for %s: %w", category, err)
+	}
+	if err := datasetStorage.Put(ctx, labelCodeIndexKey(category), body, "application/json"); err != nil {
+		return nil, fmt.Errorf("failed to store label code index for %s: %w", category, err)
+	}
+
+	labelCodeCacheMutex.Lock()
+	labelCodeIndexCache[category] = cachedLabelCodeIndex{index: index, loadedAt: time.Now()}
+	labelCodeCacheMutex.Unlock()
+
+	log.Printf("RequestID: %s - Label code decoding for %s: %d images, %d decoded, %d with codes, %d reused, %d failed, %d pending",
+		requestID, category, categoryReport.TotalImages, categoryReport.DecodedImages, categoryReport.ImagesWithCode,
+		categoryReport.ReusedImages, categoryReport.FailedImages, categoryReport.PendingImages)
+	return categoryReport, nil
+}
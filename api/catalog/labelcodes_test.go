@@ -0,0 +1,103 @@
+package main
+
+import (
+	"image"
+	"image/draw"
+	"reflect"
+	"testing"
+
+	"github.com/makiuchi-d/gozxing"
+	"github.com/makiuchi-d/gozxing/oned"
+	"github.com/makiuchi-d/gozxing/qrcode"
+)
+
+// Draw a generated code onto img with its top-left corner at (left, top)
+func drawBitMatrix(t *testing.T, img *image.Gray, matrix *gozxing.BitMatrix, left, top int) {
+	t.Helper()
+	for y := 0; y < matrix.GetHeight(); y++ {
+		for x := 0; x < matrix.GetWidth(); x++ {
+			if matrix.Get(x, y) {
+				img.Pix[img.PixOffset(left+x, top+y)] = 0
+			}
+		}
+	}
+}
+
+// White label of the given size carrying a QR code and a Code 128 barcode side by side
+func labelImage(t *testing.T, width, height int, qrPayload, barcodePayload string) *image.Gray {
+	t.Helper()
+
+	img := image.NewGray(image.Rect(0, 0, width, height))
+	draw.Draw(img, img.Bounds(), image.White, image.Point{}, draw.Src)
+
+	side := height / 2
+	if qrPayload != "" {
+		matrix, err := qrcode.NewQRCodeWriter().Encode(qrPayload, gozxing.BarcodeFormat_QR_CODE, side, side, nil)
+		if err != nil {
+			t.Fatalf("failed to encode QR code: %v", err)
+		}
+		drawBitMatrix(t, img, matrix, 0, 0)
+	}
+	if barcodePayload != "" {
+		matrix, err := oned.NewCode128Writer().Encode(barcodePayload, gozxing.BarcodeFormat_CODE_128, width/2, side/2, nil)
+		if err != nil {
+			t.Fatalf("failed to encode Code 128 barcode: %v", err)
+		}
+		drawBitMatrix(t, img, matrix, width/2, height/2)
+	}
+	return img
+}
+
+func TestDecodeLabelCodes(t *testing.T) {
+	tests := []struct {
+		name          string
+		width, height int
+		qr, barcode   string
+		want          []LabelCode
+	}{
+		{
+			name:    "QR code and Code 128",
+			width:   1200,
+			height:  600,
+			qr:      "https://aqua.example/p/AQR-B360MA(SLB)",
+			barcode: "AQRB360MASLB01",
+			want: []LabelCode{
+				{Format: "CODE_128", Payload: "AQRB360MASLB01"},
+				{Format: "QR_CODE", Payload: "https://aqua.example/p/AQR-B360MA(SLB)"},
+			},
+		},
+		{
+			name:   "Vietnamese QR payload",
+			width:  800,
+			height: 800,
+			qr:     "Tủ lạnh AQR-B360MA",
+			want:   []LabelCode{{Format: "QR_CODE", Payload: "Tủ lạnh AQR-B360MA"}},
+		},
+		{
+			name:    "scaled down before decoding",
+			width:   4000,
+			height:  2400,
+			qr:      "SERIAL-0001",
+			barcode: "0123456789",
+			want: []LabelCode{
+				{Format: "CODE_128", Payload: "0123456789"},
+				{Format: "QR_CODE", Payload: "SERIAL-0001"},
+			},
+		},
+		{
+			name:   "blank label",
+			width:  600,
+			height: 400,
+			want:   []LabelCode{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := decodeLabelCodes(labelImage(t, tt.width, tt.height, tt.qr, tt.barcode))
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("decodeLabelCodes() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
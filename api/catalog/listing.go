@@ -0,0 +1,41 @@
+package main
+
+import (
+	"context"
+	"time"
+)
+
+// Object listed from the dataset, independent of where the listing came from
+type objectInfo struct {
+	Key          string
+	Size         int64
+	LastModified time.Time
+	ETag         string
+}
+
+// List objects under a dataset prefix, recursively. A limit of 0 lists everything.
+// Served from the inventory index when inventory scanning is enabled.
+func listDatasetObjects(ctx context.Context, prefix string, limit int) ([]objectInfo, error) {
+	if inventoryEnabled() {
+		index, err := currentInventoryIndex(ctx)
+		if err != nil {
+			return nil, err
+		}
+		return index.listObjects(prefix, limit), nil
+	}
+
+	return datasetStorage.ListObjects(ctx, prefix, limit)
+}
+
+// List the direct child prefixes of a dataset prefix, e.g. products of a category
+func listDatasetPrefixes(ctx context.Context, prefix string) ([]string, error) {
+	if inventoryEnabled() {
+		index, err := currentInventoryIndex(ctx)
+		if err != nil {
+			return nil, err
+		}
+		return index.listPrefixes(prefix), nil
+	}
+
+	return datasetStorage.ListPrefixes(ctx, prefix)
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+// Run the catalog as a plain HTTP server for offline development. API requests
+// on any path are passed to the Lambda handler, /objects/ serves local presigned
+// URLs and POST /jobs/<name> runs a catalog job.
+func serveLocal(addr string) error {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/objects/", handleLocalObject)
+	mux.HandleFunc("/jobs/", handleLocalJob)
+	mux.HandleFunc("/", handleLocalAPI)
+
+	log.Printf("Catalog API listening on %s (storage: %s)", addr, appConfig.StorageBackend)
+	return http.ListenAndServe(addr, mux)
+}
+
+// Translate an HTTP request into an API Gateway proxy request
+func handleLocalAPI(w http.ResponseWriter, r *http.Request) {
+	queryParams := make(map[string]string)
+	for name, values := range r.URL.Query() {
+		queryParams[name] = values[0]
+	}
+
+	headers := make(map[string]string)
+	for name := range r.Header {
+		headers[name] = r.Header.Get(name)
+	}
+
+	request := events.APIGatewayProxyRequest{
+		HTTPMethod:            r.Method,
+		Path:                  r.URL.Path,
+		Headers:               headers,
+		QueryStringParameters: queryParams,
+		RequestContext: events.APIGatewayProxyRequestContext{
+			RequestID: fmt.Sprintf("local-%d", time.Now().UnixNano()),
+		},
+	}
+
+	response, err := handler(r.Context(), request)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	for name, value := range response.Headers {
+		w.Header().Set(name, value)
+	}
+	w.WriteHeader(response.StatusCode)
+	w.Write([]byte(response.Body))
+}
+
+// Serve an object through a URL produced by localStorage.PresignGet
+func handleLocalObject(w http.ResponseWriter, r *http.Request) {
+	storage, ok := datasetStorage.(*localStorage)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	key := strings.TrimPrefix(r.URL.Path, "/objects/")
+	expires := r.URL.Query().Get("expires")
+	signature := r.URL.Query().Get("signature")
+
+	expiresAt, err := strconv.ParseInt(expires, 10, 64)
+	if err != nil || time.Now().Unix() > expiresAt ||
+		subtle.ConstantTimeCompare([]byte(signature), []byte(localObjectSignature(key, expires))) != 1 {
+		http.Error(w, "invalid or expired signature", http.StatusForbidden)
+		return
+	}
+
+	filePath, err := storage.path(key)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	http.ServeFile(w, r, filePath)
+}
+
+// Run a catalog job, the local equivalent of a scheduled invocation
+func handleLocalJob(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	result, err := runCatalogJob(r.Context(), JobEvent{Job: strings.TrimPrefix(r.URL.Path, "/jobs/")})
+	if err != nil {
+		status := http.StatusInternalServerError
+		var reqErr *requestError
+		if errors.As(err, &reqErr) {
+			status = reqErr.StatusCode
+		}
+		http.Error(w, err.Error(), status)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
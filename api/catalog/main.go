@@ -2,12 +2,15 @@ package main
 
 import (
 	"context"
+	"crypto/rand"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 
 	//"net/url"
 	"os"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
@@ -16,6 +19,7 @@ import (
 	"github.com/aws/aws-lambda-go/lambda"
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
 	//"github.com/aws/aws-sdk-go-v2/service/s3/types"
 )
@@ -26,6 +30,21 @@ type Config struct {
 	Region              string
 	PresignedURLExpiry  time.Duration
 	LogLevel            string
+	MaxReferenceLabelImages    int
+	MaxReferenceOverviewImages int
+	MetaPrefix                 string
+	InventoryBucket            string
+	InventoryPrefix            string
+	InventoryRefresh           time.Duration
+	StorageBackend             string
+	S3Endpoint                 string
+	LocalRoot                  string
+	LocalPublicURL             string
+	LocalSigningKey            []byte
+	HTTPAddr                   string
+	JournalTable               string
+	ResultTable                string
+	ValidationBucket           string
 }
 
 // Response structures
@@ -54,20 +73,31 @@ type Product struct {
 	LabelFolders      []string  `json:"labelFolders"`
 	OverviewFolders   []string  `json:"overviewFolders"`
 	LastModified      time.Time `json:"lastModified"`
+	BaseModel         string    `json:"baseModel"`
+	Variant           string    `json:"variant,omitempty"`
+	// Only set when requested with withVerificationStats=true
+	VerificationStats *VerificationStats `json:"verificationStats,omitempty"`
 }
 
 type ImageData struct {
-	Key          string    `json:"key"`
-	Filename     string    `json:"filename"`
-	Size         int64     `json:"size"`
-	LastModified time.Time `json:"lastModified"`
-	PresignedURL string    `json:"presignedUrl"`
-	ContentType  string    `json:"contentType"`
+	Key          string        `json:"key"`
+	Filename     string        `json:"filename"`
+	Size         int64         `json:"size"`
+	LastModified time.Time     `json:"lastModified"`
+	PresignedURL string        `json:"presignedUrl"`
+	ContentType  string        `json:"contentType"`
+	ETag         string        `json:"etag,omitempty"`
+	Quality      *ImageQuality `json:"quality,omitempty"`
 }
 
 type ImagesData struct {
 	LabelImages    []ImageData `json:"labelImages"`
 	OverviewImages []ImageData `json:"overviewImages"`
+	// Images from folders other than TEM NL, CHÍNH DIỆN and HÌNH WEB
+	OtherImages []ImageData `json:"otherImages,omitempty"`
+	// Set when a role was filled from another colour variant of the product
+	LabelSourceProductID    string `json:"labelSourceProductId,omitempty"`
+	OverviewSourceProductID string `json:"overviewSourceProductId,omitempty"`
 }
 
 type CategoriesMetadata struct {
@@ -136,6 +166,16 @@ var categoryDefinitions = map[string]Category{
 	},
 }
 
+// Category IDs in a stable order
+func sortedCategoryIDs() []string {
+	categoryIDs := make([]string, 0, len(categoryDefinitions))
+	for categoryID := range categoryDefinitions {
+		categoryIDs = append(categoryIDs, categoryID)
+	}
+	sort.Strings(categoryIDs)
+	return categoryIDs
+}
+
 // Initialize AWS services and configuration
 func init() {
 	log.SetFlags(log.LstdFlags | log.Lshortfile)
@@ -146,10 +186,49 @@ func init() {
 		Region:             os.Getenv("AWS_REGION"),
 		PresignedURLExpiry: 15 * time.Minute, // Default 15 minutes
 		LogLevel:           os.Getenv("LOG_LEVEL"),
+		MaxReferenceLabelImages:    2, // Matches the validation service defaults
+		MaxReferenceOverviewImages: 2,
+		MetaPrefix:                 os.Getenv("CATALOG_META_PREFIX"),
+		InventoryBucket:            os.Getenv("CATALOG_INVENTORY_BUCKET"),
+		InventoryPrefix:            os.Getenv("CATALOG_INVENTORY_PREFIX"),
+		InventoryRefresh:           15 * time.Minute,
+		StorageBackend:             os.Getenv("STORAGE_BACKEND"),
+		S3Endpoint:                 os.Getenv("S3_ENDPOINT_URL"),
+		LocalRoot:                  os.Getenv("CATALOG_LOCAL_ROOT"),
+		LocalPublicURL:             os.Getenv("CATALOG_PUBLIC_URL"),
+		LocalSigningKey:            []byte(os.Getenv("CATALOG_LOCAL_SIGNING_KEY")),
+		HTTPAddr:                   os.Getenv("CATALOG_HTTP_ADDR"),
+		JournalTable:               os.Getenv("CATALOG_JOURNAL_TABLE"),
+		ResultTable:                os.Getenv("AWS_RESULT_TABLE"),
+		ValidationBucket:           os.Getenv("AWS_IMPUT_IMG_VALIDATION_BUCKET"),
+	}
+
+	if appConfig.StorageBackend == "" {
+		appConfig.StorageBackend = storageBackendS3
+	}
+
+	// The bucket name is only informational for local storage
+	if appConfig.DatasetBucket == "" {
+		if appConfig.StorageBackend != storageBackendLocal {
+			log.Fatal("AWS_DATASET_BUCKET environment variable is required")
+		}
+		appConfig.DatasetBucket = "local"
 	}
 
-	if appConfig.DatasetBucket == "" {
-		log.Fatal("AWS_DATASET_BUCKET environment variable is required")
+	if appConfig.HTTPAddr != "" && appConfig.LocalPublicURL == "" {
+		host := appConfig.HTTPAddr
+		if strings.HasPrefix(host, ":") {
+			host = "localhost" + host
+		}
+		appConfig.LocalPublicURL = "http://" + host
+	}
+
+	// Local presigned URLs only need to outlive the process unless a key is configured
+	if len(appConfig.LocalSigningKey) == 0 {
+		appConfig.LocalSigningKey = make([]byte, 32)
+		if _, err := rand.Read(appConfig.LocalSigningKey); err != nil {
+			log.Fatalf("Failed to generate local signing key: %v", err)
+		}
 	}
 
 	if expiry := os.Getenv("PRESIGNED_URL_EXPIRY"); expiry != "" {
@@ -158,21 +237,116 @@ func init() {
 		}
 	}
 
-	log.Printf("Initializing Catalog API with config: bucket=%s, region=%s, expiry=%v", 
-		appConfig.DatasetBucket, appConfig.Region, appConfig.PresignedURLExpiry)
+	// Catalog-owned objects (snapshots, indexes) live outside dataset/
+	if appConfig.MetaPrefix == "" {
+		appConfig.MetaPrefix = "catalog-meta/"
+	} else if !strings.HasSuffix(appConfig.MetaPrefix, "/") {
+		appConfig.MetaPrefix += "/"
+	}
 
-	// Initialize AWS configuration
-	ctx := context.Background()
-	cfg, err := config.LoadDefaultConfig(ctx, config.WithRegion(appConfig.Region))
-	if err != nil {
-		log.Fatalf("Failed to load AWS configuration: %v", err)
+	if maxLabel := os.Getenv("MAX_REFERENCE_LABEL_IMAGES"); maxLabel != "" {
+		if count, err := strconv.Atoi(maxLabel); err == nil && count > 0 {
+			appConfig.MaxReferenceLabelImages = count
+		}
 	}
 
-	// Initialize S3 clients
-	s3Client = s3.NewFromConfig(cfg)
-	presignClient = s3.NewPresignClient(s3Client)
-	
-	log.Println("AWS S3 clients initialized successfully")
+	if maxOverview := os.Getenv("MAX_REFERENCE_OVERVIEW_IMAGES"); maxOverview != "" {
+		if count, err := strconv.Atoi(maxOverview); err == nil && count > 0 {
+			appConfig.MaxReferenceOverviewImages = count
+		}
+	}
+
+	// Uploaded images are usually kept in the dataset bucket
+	if appConfig.ValidationBucket == "" {
+		appConfig.ValidationBucket = appConfig.DatasetBucket
+	}
+
+	// S3 Inventory reports, e.g. "inventory/<source-bucket>/<config-id>/"
+	if appConfig.InventoryBucket == "" {
+		appConfig.InventoryBucket = appConfig.DatasetBucket
+	}
+	if appConfig.InventoryPrefix != "" && !strings.HasSuffix(appConfig.InventoryPrefix, "/") {
+		appConfig.InventoryPrefix += "/"
+	}
+	if refresh := os.Getenv("CATALOG_INVENTORY_REFRESH_MINUTES"); refresh != "" {
+		if minutes, err := strconv.Atoi(refresh); err == nil && minutes >= 0 {
+			appConfig.InventoryRefresh = time.Duration(minutes) * time.Minute
+		}
+	}
+
+	loadVariantConfig()
+
+	log.Printf("Initializing Catalog API with config: bucket=%s, region=%s, expiry=%v, storage=%s", 
+		appConfig.DatasetBucket, appConfig.Region, appConfig.PresignedURLExpiry, appConfig.StorageBackend)
+
+	if appConfig.StorageBackend == storageBackendS3 || journalEnabled() || verificationStatsEnabled() {
+		// Initialize AWS configuration
+		ctx := context.Background()
+		cfg, err := config.LoadDefaultConfig(ctx, config.WithRegion(appConfig.Region))
+		if err != nil {
+			log.Fatalf("Failed to load AWS configuration: %v", err)
+		}
+
+		if appConfig.StorageBackend == storageBackendS3 {
+			// Initialize S3 clients, pointed at an S3-compatible endpoint such as MinIO when configured
+			s3Client = s3.NewFromConfig(cfg, func(o *s3.Options) {
+				if appConfig.S3Endpoint != "" {
+					o.BaseEndpoint = aws.String(appConfig.S3Endpoint)
+					o.UsePathStyle = os.Getenv("S3_FORCE_PATH_STYLE") != "false"
+				}
+			})
+			presignClient = s3.NewPresignClient(s3Client)
+
+			log.Println("AWS S3 clients initialized successfully")
+		}
+
+		if journalEnabled() || verificationStatsEnabled() {
+			dynamoClient = dynamodb.NewFromConfig(cfg)
+		}
+		if journalEnabled() {
+			log.Printf("Change journal enabled: table=%s", appConfig.JournalTable)
+		}
+	}
+
+	var err error
+	if datasetStorage, err = newObjectStorage(appConfig.DatasetBucket); err != nil {
+		log.Fatalf("Failed to initialize dataset storage: %v", err)
+	}
+	if inventoryStorage, err = newObjectStorage(appConfig.InventoryBucket); err != nil {
+		log.Fatalf("Failed to initialize inventory storage: %v", err)
+	}
+	if validationStorage, err = newObjectStorage(appConfig.ValidationBucket); err != nil {
+		log.Fatalf("Failed to initialize validation storage: %v", err)
+	}
+}
+
+// Lambda entry point, routing scheduled jobs, S3 notifications and API Gateway requests
+func dispatch(ctx context.Context, payload json.RawMessage) (interface{}, error) {
+	var jobEvent JobEvent
+	if err := json.Unmarshal(payload, &jobEvent); err == nil && jobEvent.Job != "" {
+		return runCatalogJob(ctx, jobEvent)
+	}
+
+	var s3Event events.S3Event
+	if err := json.Unmarshal(payload, &s3Event); err == nil && len(s3Event.Records) > 0 && s3Event.Records[0].EventSource == "aws:s3" {
+		recorded, err := recordInventoryChanges(ctx, s3Event)
+		if err != nil {
+			return nil, err
+		}
+		journaled, err := journalS3Events(ctx, s3Event)
+		if err != nil {
+			return nil, err
+		}
+		log.Printf("Recorded %d dataset changes (%d journaled) from %d S3 event records", recorded, journaled, len(s3Event.Records))
+		return map[string]int{"recorded": recorded, "journaled": journaled}, nil
+	}
+
+	var request events.APIGatewayProxyRequest
+	if err := json.Unmarshal(payload, &request); err != nil {
+		return nil, fmt.Errorf("unsupported event payload: %w", err)
+	}
+
+	return handler(ctx, request)
 }
 
 // Main Lambda handler function
@@ -189,30 +363,70 @@ func handler(ctx context.Context, request events.APIGatewayProxyRequest) (events
 	operationType := queryParams["type"]
 	if operationType == "" {
 		log.Printf("RequestID: %s - Missing 'type' parameter", requestID)
-		return createErrorResponse(400, "MISSING_TYPE", "Missing required 'type' query parameter. Valid values: categories, products, images")
+		return createErrorResponse(400, "MISSING_TYPE", "Missing required 'type' query parameter. Valid values: categories, products, productGroups, folders, images, referenceSet, stats, statsHistory, qualityAudit, journal, versions, imageVersion, restoreVersion, verificationCoverage, similarProducts, labelCodeCheck, contactSheet")
 	}
 
 	log.Printf("RequestID: %s - Processing operation type: %s", requestID, operationType)
 
+	// Validate and canonicalise user-supplied key components before any S3 access
+	rawParams := queryParams
+	queryParams, err := validateCatalogParams(rawParams)
+	if err != nil {
+		log.Printf("RequestID: %s - Invalid parameters: %v", requestID, err)
+		return createRequestErrorResponse(err)
+	}
+	queryParams = resolveStoredKeyForms(ctx, requestID, rawParams, queryParams)
+
 	// Route to appropriate handler based on operation type
 	var response interface{}
-	var err error
 
 	switch operationType {
 	case "categories":
 		response, err = handleCategoriesDiscovery(ctx, requestID)
 	case "products":
 		response, err = handleProductsDiscovery(ctx, requestID, queryParams)
+	case "productGroups":
+		response, err = handleProductGroupsDiscovery(ctx, requestID, queryParams)
+	case "folders":
+		response, err = handleFoldersDiscovery(ctx, requestID, queryParams)
 	case "images":
 		response, err = handleImagesDiscovery(ctx, requestID, queryParams)
+	case "referenceSet":
+		response, err = handleReferenceSetSelection(ctx, requestID, queryParams)
+	case "stats":
+		response, err = handleStatsDiscovery(ctx, requestID, queryParams)
+	case "statsHistory":
+		response, err = handleStatsHistory(ctx, requestID, queryParams)
+	case "qualityAudit":
+		response, err = handleQualityAudit(ctx, requestID, queryParams)
+	case "journal":
+		response, err = handleJournalQuery(ctx, requestID, queryParams)
+	case "verificationCoverage":
+		response, err = handleVerificationCoverage(ctx, requestID, queryParams)
+	case "similarProducts":
+		response, err = handleSimilarProducts(ctx, requestID, queryParams)
+	case "labelCodeCheck":
+		response, err = handleLabelCodeCheck(ctx, requestID, queryParams)
+	case "contactSheet":
+		response, err = handleContactSheet(ctx, requestID, queryParams)
+	case "versions":
+		response, err = handleVersionsDiscovery(ctx, requestID, queryParams)
+	case "imageVersion":
+		response, err = handleImageVersion(ctx, requestID, queryParams)
+	case "restoreVersion":
+		// Restoring changes the dataset, so it is never reachable with a GET
+		if request.HTTPMethod != "POST" {
+			return createErrorResponse(405, "METHOD_NOT_ALLOWED", "The 'restoreVersion' operation requires POST")
+		}
+		response, err = handleVersionRestore(ctx, requestID, queryParams, requestActor(request))
 	default:
 		log.Printf("RequestID: %s - Invalid operation type: %s", requestID, operationType)
-		return createErrorResponse(400, "INVALID_TYPE", fmt.Sprintf("Invalid 'type' parameter: %s. Valid values: categories, products, images", operationType))
+		return createErrorResponse(400, "INVALID_TYPE", fmt.Sprintf("Invalid 'type' parameter: %s. Valid values: categories, products, productGroups, folders, images, referenceSet, stats, statsHistory, qualityAudit, journal, versions, imageVersion, restoreVersion, verificationCoverage, similarProducts, labelCodeCheck, contactSheet", operationType))
 	}
 
 	if err != nil {
 		log.Printf("RequestID: %s - Operation failed: %v", requestID, err)
-		return createErrorResponse(500, "OPERATION_FAILED", "Internal server error during catalog operation")
+		return createRequestErrorResponse(err)
 	}
 
 	// Serialize response to JSON
@@ -229,7 +443,7 @@ func handler(ctx context.Context, request events.APIGatewayProxyRequest) (events
 		Headers: map[string]string{
 			"Content-Type":                 "application/json",
 			"Access-Control-Allow-Origin":  "*",
-			"Access-Control-Allow-Methods": "GET, OPTIONS",
+			"Access-Control-Allow-Methods": "GET, POST, OPTIONS",
 			"Access-Control-Allow-Headers": "Content-Type, x-api-key",
 		},
 		Body: string(responseBody),
@@ -280,16 +494,13 @@ func handleCategoriesDiscovery(ctx context.Context, requestID string) (*CatalogR
 func handleProductsDiscovery(ctx context.Context, requestID string, queryParams map[string]string) (*CatalogResponse, error) {
 	category := queryParams["category"]
 	if category == "" {
-		return nil, fmt.Errorf("missing required 'category' parameter for products discovery")
+		return nil, newBadRequest("MISSING_PARAMETER", "Missing required 'category' parameter for products discovery")
 	}
 
 	log.Printf("RequestID: %s - Starting products discovery for category: %s", requestID, category)
 
 	// Validate category
-	categoryDef, exists := categoryDefinitions[category]
-	if !exists {
-		return nil, fmt.Errorf("invalid category: %s", category)
-	}
+	categoryDef := categoryDefinitions[category]
 
 	// Discover products using S3 prefix listing
 	products, err := discoverProductsInCategory(ctx, requestID, categoryDef.S3Prefix, category)
@@ -297,6 +508,13 @@ func handleProductsDiscovery(ctx context.Context, requestID string, queryParams
 		return nil, fmt.Errorf("failed to discover products in category %s: %w", category, err)
 	}
 
+	// Optionally join each product with its results from the verification table
+	if queryParams["withVerificationStats"] == "true" {
+		if err := attachVerificationStats(ctx, requestID, category, products); err != nil {
+			return nil, err
+		}
+	}
+
 	response := &CatalogResponse{
 		Type: "products",
 		Data: products,
@@ -318,16 +536,11 @@ func handleImagesDiscovery(ctx context.Context, requestID string, queryParams ma
 	folder := queryParams["folder"] // Optional parameter to specify which folder to fetch
 
 	if category == "" || productID == "" {
-		return nil, fmt.Errorf("missing required 'category' and 'productId' parameters for images discovery")
+		return nil, newBadRequest("MISSING_PARAMETER", "Missing required 'category' and 'productId' parameters for images discovery")
 	}
 
 	log.Printf("RequestID: %s - Starting images discovery for product: %s/%s, folder: %s", requestID, category, productID, folder)
 
-	// Validate category
-	if _, exists := categoryDefinitions[category]; !exists {
-		return nil, fmt.Errorf("invalid category: %s", category)
-	}
-
 	var imagesData *ImagesData
 	var err error
 
@@ -343,7 +556,14 @@ func handleImagesDiscovery(ctx context.Context, requestID string, queryParams ma
 		return nil, fmt.Errorf("failed to discover images for product %s/%s: %w", category, productID, err)
 	}
 
-	totalImages := len(imagesData.LabelImages) + len(imagesData.OverviewImages)
+	// Optionally borrow images from another colour variant of the same model
+	if folder == "" && queryParams["variantFallback"] == "true" {
+		if err := applyVariantFallback(ctx, requestID, category, productID, imagesData); err != nil {
+			log.Printf("RequestID: %s - Warning: Variant fallback failed for %s/%s: %v", requestID, category, productID, err)
+		}
+	}
+
+	totalImages := len(imagesData.LabelImages) + len(imagesData.OverviewImages) + len(imagesData.OtherImages)
 
 	response := &CatalogResponse{
 		Type: "images",
@@ -356,8 +576,8 @@ func handleImagesDiscovery(ctx context.Context, requestID string, queryParams ma
 		},
 	}
 
-	log.Printf("RequestID: %s - Images discovery completed for %s/%s: %d total images (%d label, %d overview)", 
-		requestID, category, productID, totalImages, len(imagesData.LabelImages), len(imagesData.OverviewImages))
+	log.Printf("RequestID: %s - Images discovery completed for %s/%s: %d total images (%d label, %d overview, %d other)",
+		requestID, category, productID, totalImages, len(imagesData.LabelImages), len(imagesData.OverviewImages), len(imagesData.OtherImages))
 	return response, nil
 }
 
@@ -365,18 +585,12 @@ func handleImagesDiscovery(ctx context.Context, requestID string, queryParams ma
 func countProductsInCategory(ctx context.Context, requestID, categoryPrefix string) (int, error) {
 	log.Printf("RequestID: %s - Counting products in prefix: %s", requestID, categoryPrefix)
 
-	input := &s3.ListObjectsV2Input{
-		Bucket:    aws.String(appConfig.DatasetBucket),
-		Prefix:    aws.String(categoryPrefix),
-		Delimiter: aws.String("/"),
-	}
-
-	result, err := s3Client.ListObjectsV2(ctx, input)
+	productPrefixes, err := listDatasetPrefixes(ctx, categoryPrefix)
 	if err != nil {
 		return 0, fmt.Errorf("failed to list objects for counting: %w", err)
 	}
 
-	count := len(result.CommonPrefixes)
+	count := len(productPrefixes)
 	log.Printf("RequestID: %s - Found %d products in category prefix %s", requestID, count, categoryPrefix)
 	return count, nil
 }
@@ -385,24 +599,18 @@ func countProductsInCategory(ctx context.Context, requestID, categoryPrefix stri
 func discoverProductsInCategory(ctx context.Context, requestID, categoryPrefix, category string) ([]Product, error) {
 	log.Printf("RequestID: %s - Discovering products in category prefix: %s", requestID, categoryPrefix)
 
-	input := &s3.ListObjectsV2Input{
-		Bucket:    aws.String(appConfig.DatasetBucket),
-		Prefix:    aws.String(categoryPrefix),
-		Delimiter: aws.String("/"),
-	}
-
-	result, err := s3Client.ListObjectsV2(ctx, input)
+	productPrefixes, err := listDatasetPrefixes(ctx, categoryPrefix)
 	if err != nil {
 		return nil, fmt.Errorf("failed to list category objects: %w", err)
 	}
 
 	var products []Product
 
-	for _, prefix := range result.CommonPrefixes {
+	for _, prefix := range productPrefixes {
 		// Extract product ID from prefix path
-		parts := strings.Split(strings.TrimSuffix(*prefix.Prefix, "/"), "/")
+		parts := strings.Split(strings.TrimSuffix(prefix, "/"), "/")
 		if len(parts) < 3 {
-			log.Printf("RequestID: %s - Warning: Invalid prefix structure: %s", requestID, *prefix.Prefix)
+			log.Printf("RequestID: %s - Warning: Invalid prefix structure: %s", requestID, prefix)
 			continue
 		}
 
@@ -410,21 +618,25 @@ func discoverProductsInCategory(ctx context.Context, requestID, categoryPrefix,
 		log.Printf("RequestID: %s - Processing product: %s", requestID, productID)
 
 		// Check for required folders (label and overview)
-		hasLabel, labelFolders := checkLabelFolders(ctx, requestID, *prefix.Prefix)
-		hasOverview, overviewFolders := checkOverviewFolders(ctx, requestID, *prefix.Prefix)
+		hasLabel, labelFolders := checkLabelFolders(ctx, requestID, prefix)
+		hasOverview, overviewFolders := checkOverviewFolders(ctx, requestID, prefix)
 
 		// Get last modified time for the product
-		lastModified := getProductLastModified(ctx, requestID, *prefix.Prefix)
+		lastModified := getProductLastModified(ctx, requestID, prefix)
+
+		baseModel, variant := parseProductVariant(productID)
 
 		product := Product{
 			ID:                productID,
 			Category:          category,
-			S3Prefix:          *prefix.Prefix,
+			S3Prefix:          prefix,
 			HasLabelFolder:    hasLabel,
 			HasOverviewFolder: hasOverview,
 			LabelFolders:      labelFolders,
 			OverviewFolders:   overviewFolders,
 			LastModified:      lastModified,
+			BaseModel:         baseModel,
+			Variant:           variant,
 		}
 
 		products = append(products, product)
@@ -438,19 +650,13 @@ func discoverProductsInCategory(ctx context.Context, requestID, categoryPrefix,
 func checkLabelFolders(ctx context.Context, requestID, productPrefix string) (bool, []string) {
 	labelPrefix := productPrefix + "TEM NL/"
 	
-	input := &s3.ListObjectsV2Input{
-		Bucket:  aws.String(appConfig.DatasetBucket),
-		Prefix:  aws.String(labelPrefix),
-		MaxKeys: aws.Int32(1),
-	}
-
-	result, err := s3Client.ListObjectsV2(ctx, input)
+	objects, err := listDatasetObjects(ctx, labelPrefix, 1)
 	if err != nil {
 		log.Printf("RequestID: %s - Warning: Failed to check label folder %s: %v", requestID, labelPrefix, err)
 		return false, []string{}
 	}
 
-	hasImages := len(result.Contents) > 0
+	hasImages := len(objects) > 0
 	folders := []string{}
 	if hasImages {
 		folders = append(folders, "TEM NL")
@@ -468,19 +674,13 @@ func checkOverviewFolders(ctx context.Context, requestID, productPrefix string)
 	for _, folderName := range overviewFolderNames {
 		overviewPrefix := productPrefix + folderName + "/"
 		
-		input := &s3.ListObjectsV2Input{
-			Bucket:  aws.String(appConfig.DatasetBucket),
-			Prefix:  aws.String(overviewPrefix),
-			MaxKeys: aws.Int32(1),
-		}
-
-		result, err := s3Client.ListObjectsV2(ctx, input)
+		objects, err := listDatasetObjects(ctx, overviewPrefix, 1)
 		if err != nil {
 			log.Printf("RequestID: %s - Warning: Failed to check overview folder %s: %v", requestID, overviewPrefix, err)
 			continue
 		}
 
-		if len(result.Contents) > 0 {
+		if len(objects) > 0 {
 			foundFolders = append(foundFolders, folderName)
 			hasAnyImages = true
 		}
@@ -491,22 +691,16 @@ func checkOverviewFolders(ctx context.Context, requestID, productPrefix string)
 
 // Get the last modified time for a product by checking its most recent image
 func getProductLastModified(ctx context.Context, requestID, productPrefix string) time.Time {
-	input := &s3.ListObjectsV2Input{
-		Bucket:  aws.String(appConfig.DatasetBucket),
-		Prefix:  aws.String(productPrefix),
-		MaxKeys: aws.Int32(10), // Check a few files to find the most recent
-	}
-
-	result, err := s3Client.ListObjectsV2(ctx, input)
+	objects, err := listDatasetObjects(ctx, productPrefix, 10) // Check a few files to find the most recent
 	if err != nil {
 		log.Printf("RequestID: %s - Warning: Failed to get last modified for product %s: %v", requestID, productPrefix, err)
 		return time.Now()
 	}
 
 	var lastModified time.Time
-	for _, obj := range result.Contents {
-		if obj.LastModified != nil && obj.LastModified.After(lastModified) {
-			lastModified = *obj.LastModified
+	for _, obj := range objects {
+		if obj.LastModified.After(lastModified) {
+			lastModified = obj.LastModified
 		}
 	}
 
@@ -587,7 +781,8 @@ func discoverProductImagesFromFolder(ctx context.Context, requestID, category, p
 			imagesData.OverviewImages = overviewImages
 		}
 	default:
-		return nil, fmt.Errorf("unsupported folder: %s. Supported folders: TEM NL, CHÍNH DIỆN, HÌNH WEB", folder)
+		// Any other folder discovered under the product, e.g. "PHỤ KIỆN" or "BAO BÌ"
+		return discoverProductImagesFromOtherFolder(ctx, requestID, category, productID, folder)
 	}
 
 	return imagesData, nil
@@ -597,50 +792,45 @@ func discoverProductImagesFromFolder(ctx context.Context, requestID, category, p
 func discoverImagesInFolder(ctx context.Context, requestID, folderPrefix string) ([]ImageData, error) {
 	log.Printf("RequestID: %s - Discovering images in folder: %s", requestID, folderPrefix)
 
-	input := &s3.ListObjectsV2Input{
-		Bucket: aws.String(appConfig.DatasetBucket),
-		Prefix: aws.String(folderPrefix),
+	objects, err := listDatasetObjects(ctx, folderPrefix, 0)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list images in folder %s: %w", folderPrefix, err)
 	}
 
 	var allImages []ImageData
-	paginator := s3.NewListObjectsV2Paginator(s3Client, input)
 
-	for paginator.HasMorePages() {
-		result, err := paginator.NextPage(ctx)
+	for _, obj := range objects {
+		// Filter for image files only
+		if !isImageFile(obj.Key) {
+			continue
+		}
+
+		// Generate presigned URL for the image
+		presignedURL, err := generatePresignedURL(ctx, requestID, obj.Key)
 		if err != nil {
-			return nil, fmt.Errorf("failed to list images in folder %s: %w", folderPrefix, err)
+			log.Printf("RequestID: %s - Warning: Failed to generate presigned URL for %s: %v", requestID, obj.Key, err)
+			presignedURL = "" // Continue without presigned URL
 		}
 
-		for _, obj := range result.Contents {
-			// Filter for image files only
-			if !isImageFile(*obj.Key) {
-				continue
-			}
-
-			// Generate presigned URL for the image
-			presignedURL, err := generatePresignedURL(ctx, requestID, *obj.Key)
-			if err != nil {
-				log.Printf("RequestID: %s - Warning: Failed to generate presigned URL for %s: %v", requestID, *obj.Key, err)
-				presignedURL = "" // Continue without presigned URL
-			}
-
-			// Extract filename from key
-			parts := strings.Split(*obj.Key, "/")
-			filename := parts[len(parts)-1]
-
-			imageData := ImageData{
-				Key:          *obj.Key,
-				Filename:     filename,
-				Size:         *obj.Size,
-				LastModified: *obj.LastModified,
-				PresignedURL: presignedURL,
-				ContentType:  getContentType(*obj.Key),
-			}
-
-			allImages = append(allImages, imageData)
+		// Extract filename from key
+		parts := strings.Split(obj.Key, "/")
+		filename := parts[len(parts)-1]
+
+		imageData := ImageData{
+			Key:          obj.Key,
+			Filename:     filename,
+			Size:         obj.Size,
+			LastModified: obj.LastModified,
+			PresignedURL: presignedURL,
+			ContentType:  getContentType(obj.Key),
+			ETag:         obj.ETag,
 		}
+
+		allImages = append(allImages, imageData)
 	}
 
+	attachImageQuality(ctx, requestID, allImages)
+
 	log.Printf("RequestID: %s - Found %d images in folder %s", requestID, len(allImages), folderPrefix)
 	return allImages, nil
 }
@@ -649,18 +839,7 @@ func discoverImagesInFolder(ctx context.Context, requestID, folderPrefix string)
 func generatePresignedURL(ctx context.Context, requestID, key string) (string, error) {
 	log.Printf("RequestID: %s - Generating presigned URL for key: %s", requestID, key)
 
-	request, err := presignClient.PresignGetObject(ctx, &s3.GetObjectInput{
-		Bucket: aws.String(appConfig.DatasetBucket),
-		Key:    aws.String(key),
-	}, func(opts *s3.PresignOptions) {
-		opts.Expires = appConfig.PresignedURLExpiry
-	})
-
-	if err != nil {
-		return "", fmt.Errorf("failed to generate presigned URL: %w", err)
-	}
-
-	return request.URL, nil
+	return datasetStorage.PresignGet(ctx, key, appConfig.PresignedURLExpiry)
 }
 
 // Check if file is an image based on extension
@@ -691,6 +870,16 @@ func getContentType(key string) string {
 	return "image/jpeg" // Default fallback
 }
 
+// Create error response for a failed operation, 4xx for client errors and 500 otherwise
+func createRequestErrorResponse(err error) (events.APIGatewayProxyResponse, error) {
+	var reqErr *requestError
+	if errors.As(err, &reqErr) {
+		return createErrorResponse(reqErr.StatusCode, reqErr.Code, reqErr.Message)
+	}
+
+	return createErrorResponse(500, "OPERATION_FAILED", "Internal server error during catalog operation")
+}
+
 // Create standardized error response
 func createErrorResponse(statusCode int, errorCode, message string) (events.APIGatewayProxyResponse, error) {
 	errorResp := ErrorResponse{}
@@ -709,7 +898,7 @@ func createErrorResponse(statusCode int, errorCode, message string) (events.APIG
 		Headers: map[string]string{
 			"Content-Type":                 "application/json",
 			"Access-Control-Allow-Origin":  "*",
-			"Access-Control-Allow-Methods": "GET, OPTIONS",
+			"Access-Control-Allow-Methods": "GET, POST, OPTIONS",
 			"Access-Control-Allow-Headers": "Content-Type, x-api-key",
 		},
 		Body: string(body),
@@ -718,6 +907,10 @@ func createErrorResponse(statusCode int, errorCode, message string) (events.APIG
 
 // Main function to start Lambda
 func main() {
+	if appConfig.HTTPAddr != "" {
+		log.Fatal(serveLocal(appConfig.HTTPAddr))
+	}
+
 	log.Println("Starting Aqua Catalog API Lambda function")
-	lambda.Start(handler)
+	lambda.Start(dispatch)
 }
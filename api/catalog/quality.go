@@ -0,0 +1,595 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	"io"
+	"log"
+	"math"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	_ "golang.org/x/image/webp" // Register the WebP decoder alongside JPEG and PNG
+)
+
+// qualityMetricsVersion identifies the metric and flag rules below. Scores
+// computed with another version are recomputed by the next scoring run.
+const qualityMetricsVersion = "v1"
+
+// Flag thresholds for reference images
+const (
+	minQualityShortSide   = 600   // Pixels on the shorter side
+	minQualitySharpness   = 100.0 // Laplacian variance at the analysis resolution
+	maxQualityAspectRatio = 2.5   // Longer side over shorter side
+	minQualityMeanLuma    = 40.0
+	maxQualityMeanLuma    = 240.0 // White product backgrounds sit well below this
+)
+
+// Quality flags
+const (
+	qualityFlagLowResolution = "lowResolution"
+	qualityFlagBlurry        = "blurry"
+	qualityFlagUnderexposed  = "underexposed"
+	qualityFlagOverexposed   = "overexposed"
+	qualityFlagAspectRatio   = "unusualAspectRatio"
+)
+
+const (
+	qualityAnalysisMaxSide = 1024             // Images are downsampled to this before measuring
+	qualityHistogramBins   = 16               // Luminance histogram buckets
+	qualityDeadlineMargin  = 8 * time.Second  // Time kept to store results before the invocation deadline
+	qualityIndexCacheTTL   = 15 * time.Minute // How long API instances reuse a loaded index
+)
+
+// Limits of loadImage, shared by every job and operation that decodes images. An image
+// costs up to its file size plus 4 bytes per pixel once decoded (8 for 16-bit PNGs), so
+// with the scoring workers below one invocation stays well inside the function's memory.
+const (
+	qualityMaxDecodePixels = 25 * 1000 * 1000        // Larger images are skipped rather than decoded
+	qualityImageMaxBytes   = int64(25 * 1024 * 1024) // Larger objects are not downloaded
+	qualityScoringWorkers  = 4                       // Parallel image downloads and decodes
+)
+
+type ImageQuality struct {
+	Width         int       `json:"width"`
+	Height        int       `json:"height"`
+	AspectRatio   float64   `json:"aspectRatio"`
+	Sharpness     float64   `json:"sharpness"`
+	MeanLuminance float64   `json:"meanLuminance"`
+	Histogram     []float64 `json:"histogram"`
+	Score         float64   `json:"score"`
+	Flags         []string  `json:"flags,omitempty"`
+	ETag          string    `json:"etag"`
+	Version       string    `json:"version"`
+	ScoredAt      time.Time `json:"scoredAt"`
+}
+
+// Sidecar index of quality metrics for one category, keyed by object key
+type QualityIndex struct {
+	Category    string                  `json:"category"`
+	Version     string                  `json:"version"`
+	GeneratedAt time.Time               `json:"generatedAt"`
+	Images      map[string]ImageQuality `json:"images"`
+}
+
+type QualityFlaggedImage struct {
+	Key       string   `json:"key"`
+	ProductID string   `json:"productId"`
+	Folder    string   `json:"folder"`
+	Score     float64  `json:"score"`
+	Flags     []string `json:"flags"`
+}
+
+type QualityAuditCategory struct {
+	Category          string                `json:"category"`
+	TotalImages       int                   `json:"totalImages"`
+	ScoredImages      int                   `json:"scoredImages"`
+	ReusedImages      int                   `json:"reusedImages"`
+	FailedImages      int                   `json:"failedImages"`
+	PendingImages     int                   `json:"pendingImages"`
+	FlaggedReferences []QualityFlaggedImage `json:"flaggedReferences"`
+}
+
+// Output of a scoring run, also stored for the qualityAudit operation
+type QualityAuditReport struct {
+	Version     string                 `json:"version"`
+	GeneratedAt time.Time              `json:"generatedAt"`
+	Complete    bool                   `json:"complete"`
+	Categories  []QualityAuditCategory `json:"categories"`
+}
+
+type QualityAuditMetadata struct {
+	Complete       bool      `json:"complete"`
+	TotalFlagged   int       `json:"totalFlagged"`
+	ReportTime     time.Time `json:"reportTime"`
+	MetricsVersion string    `json:"metricsVersion"`
+}
+
+type cachedQualityIndex struct {
+	index    *QualityIndex
+	loadedAt time.Time
+}
+
+var (
+	qualityCacheMutex sync.Mutex
+	qualityIndexCache = make(map[string]cachedQualityIndex)
+)
+
+func qualityIndexKey(category string) string {
+	return appConfig.MetaPrefix + "quality/" + category + ".json"
+}
+
+func qualityAuditKey() string {
+	return appConfig.MetaPrefix + "quality/audit.json"
+}
+
+// Handle the latest quality audit report, optionally for one category
+func handleQualityAudit(ctx context.Context, requestID string, queryParams map[string]string) (*CatalogResponse, error) {
+	body, err := readObject(ctx, datasetStorage, qualityAuditKey())
+	if errors.Is(err, errObjectNotFound) {
+		return nil, newNotFound("AUDIT_NOT_FOUND", "No quality audit has been run yet")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read quality audit: %w", err)
+	}
+
+	var report QualityAuditReport
+	if err := json.Unmarshal(body, &report); err != nil {
+		return nil, fmt.Errorf("failed to parse quality audit: %w", err)
+	}
+
+	if category := queryParams["category"]; category != "" {
+		var filtered []QualityAuditCategory
+		for _, categoryAudit := range report.Categories {
+			if categoryAudit.Category == category {
+				filtered = append(filtered, categoryAudit)
+			}
+		}
+		report.Categories = filtered
+	}
+
+	totalFlagged := 0
+	for _, categoryAudit := range report.Categories {
+		totalFlagged += len(categoryAudit.FlaggedReferences)
+	}
+
+	log.Printf("RequestID: %s - Quality audit from %s: %d flagged references", requestID, report.GeneratedAt.Format(time.RFC3339), totalFlagged)
+
+	return &CatalogResponse{
+		Type: "qualityAudit",
+		Data: report.Categories,
+		Metadata: QualityAuditMetadata{
+			Complete:       report.Complete,
+			TotalFlagged:   totalFlagged,
+			ReportTime:     report.GeneratedAt,
+			MetricsVersion: report.Version,
+		},
+	}, nil
+}
+
+// Read a category's quality index from storage, empty when none has been written
+func readQualityIndex(ctx context.Context, category string) (*QualityIndex, error) {
+	body, err := readObject(ctx, datasetStorage, qualityIndexKey(category))
+	if errors.Is(err, errObjectNotFound) {
+		return &QualityIndex{Category: category, Images: map[string]ImageQuality{}}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read quality index for %s: %w", category, err)
+	}
+
+	var index QualityIndex
+	if err := json.Unmarshal(body, &index); err != nil {
+		return nil, fmt.Errorf("failed to parse quality index for %s: %w", category, err)
+	}
+	if index.Images == nil {
+		index.Images = map[string]ImageQuality{}
+	}
+	return &index, nil
+}
+
+// Cached quality index for serving API requests
+func cachedQualityIndexFor(ctx context.Context, category string) (*QualityIndex, error) {
+	qualityCacheMutex.Lock()
+	defer qualityCacheMutex.Unlock()
+
+	if cached, ok := qualityIndexCache[category]; ok && time.Since(cached.loadedAt) < qualityIndexCacheTTL {
+		return cached.index, nil
+	}
+
+	index, err := readQualityIndex(ctx, category)
+	if err != nil {
+		return nil, err
+	}
+	qualityIndexCache[category] = cachedQualityIndex{index: index, loadedAt: time.Now()}
+	return index, nil
+}
+
+// Attach stored quality metrics to images whose content has not changed since scoring
+func attachImageQuality(ctx context.Context, requestID string, images []ImageData) {
+	indexes := make(map[string]*QualityIndex)
+
+	for i := range images {
+		parts := strings.SplitN(images[i].Key, "/", 3)
+		if len(parts) < 3 || parts[0] != "dataset" {
+			continue
+		}
+		category := parts[1]
+
+		index, loaded := indexes[category]
+		if !loaded {
+			var err error
+			index, err = cachedQualityIndexFor(ctx, category)
+			if err != nil {
+				log.Printf("RequestID: %s - Warning: Failed to load quality index for %s: %v", requestID, category, err)
+			}
+			indexes[category] = index
+		}
+		if index == nil {
+			continue
+		}
+
+		if quality, ok := index.Images[images[i].Key]; ok && quality.ETag == images[i].ETag {
+			images[i].Quality = &quality
+		}
+	}
+}
+
+// Score every catalog image that changed since the last run and store the
+// sidecar indexes and audit report. Work stops before the invocation deadline;
+// the next run continues where this one left off.
+func runQualityScoring(ctx context.Context, requestID string) (*QualityAuditReport, error) {
+	scoringCtx := ctx
+	if deadline, ok := ctx.Deadline(); ok {
+		var cancel context.CancelFunc
+		scoringCtx, cancel = context.WithDeadline(ctx, deadline.Add(-qualityDeadlineMargin))
+		defer cancel()
+	}
+
+	report := &QualityAuditReport{
+		Version:  qualityMetricsVersion,
+		Complete: true,
+	}
+
+	for _, category := range sortedCategoryIDs() {
+		categoryAudit, err := scoreCategoryImages(scoringCtx, ctx, requestID, category)
+		if err != nil {
+			return nil, err
+		}
+		if categoryAudit.PendingImages > 0 {
+			report.Complete = false
+		}
+		report.Categories = append(report.Categories, *categoryAudit)
+	}
+
+	report.GeneratedAt = time.Now().UTC()
+
+	body, err := json.Marshal(report)
+	if err != nil {
+		return nil, fmt.Errorf("failed to serialize quality audit: %w", err)
+	}
+	if err := datasetStorage.Put(ctx, qualityAuditKey(), body, "application/json"); err != nil {
+		return nil, fmt.Errorf("failed to store quality audit: %w", err)
+	}
+
+	return report, nil
+}
+
+// Score the images of one category. scoringCtx bounds the scoring work, while
+// ctx is used to load and store the index.
+func scoreCategoryImages(scoringCtx, ctx context.Context, requestID, category string) (*QualityAuditCategory, error) {
+	objects, err := listDatasetObjects(ctx, categoryDefinitions[category].S3Prefix, 0)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list images in category %s: %w", category, err)
+	}
+
+	previous, err := readQualityIndex(ctx, category)
+	if err != nil {
+		return nil, err
+	}
+
+	index := &QualityIndex{
+		Category: category,
+		Version:  qualityMetricsVersion,
+		Images:   make(map[string]ImageQuality),
+	}
+	audit := &QualityAuditCategory{Category: category}
+
+	var pending []objectInfo
+	for _, obj := range objects {
+		if !isImageFile(obj.Key) {
+			continue
+		}
+		audit.TotalImages++
+
+		if quality, ok := previous.Images[obj.Key]; ok {
+			if quality.ETag == obj.ETag && quality.Version == qualityMetricsVersion {
+				index.Images[obj.Key] = quality
+				audit.ReusedImages++
+				continue
+			}
+			// Keep the stale entry until it is rescored; readers ignore it because the ETag differs
+			index.Images[obj.Key] = quality
+		}
+		pending = append(pending, obj)
+	}
+
+	var mutex sync.Mutex
+	var wg sync.WaitGroup
+	work := make(chan objectInfo)
+
+	for i := 0; i < qualityScoringWorkers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for obj := range work {
+				quality, err := scoreImageObject(scoringCtx, obj)
+
+				mutex.Lock()
+				if err != nil {
+					if scoringCtx.Err() != nil {
+						audit.PendingImages++
+					} else {
+						log.Printf("RequestID: %s - Warning: Failed to score %s: %v", requestID, obj.Key, err)
+						audit.FailedImages++
+					}
+				} else {
+					index.Images[obj.Key] = *quality
+					audit.ScoredImages++
+				}
+				mutex.Unlock()
+			}
+		}()
+	}
+
+	for _, obj := range pending {
+		if scoringCtx.Err() != nil {
+			mutex.Lock()
+			audit.PendingImages++
+			mutex.Unlock()
+			continue
+		}
+		work <- obj
+	}
+	close(work)
+	wg.Wait()
+
+	index.GeneratedAt = time.Now().UTC()
+	body, err := json.Marshal(index)
+	if err != nil {
+		return nil, fmt.Errorf("failed to serialize quality index for %s: %w", category, err)
+	}
+	if err := datasetStorage.Put(ctx, qualityIndexKey(category), body, "application/json"); err != nil {
+		return nil, fmt.Errorf("failed to store quality index for %s: %w", category, err)
+	}
+
+	qualityCacheMutex.Lock()
+	qualityIndexCache[category] = cachedQualityIndex{index: index, loadedAt: time.Now()}
+	qualityCacheMutex.Unlock()
+
+	audit.FlaggedReferences = flaggedReferenceImages(category, index)
+
+	log.Printf("RequestID: %s - Quality scoring for %s: %d images, %d scored, %d reused, %d failed, %d pending, %d flagged references",
+		requestID, category, audit.TotalImages, audit.ScoredImages, audit.ReusedImages, audit.FailedImages, audit.PendingImages, len(audit.FlaggedReferences))
+	return audit, nil
+}
+
+// Flagged images in label and overview folders, lowest score first
+func flaggedReferenceImages(category string, index *QualityIndex) []QualityFlaggedImage {
+	categoryPrefix := categoryDefinitions[category].S3Prefix
+	flagged := []QualityFlaggedImage{}
+
+	for key, quality := range index.Images {
+		if len(quality.Flags) == 0 || quality.Version != qualityMetricsVersion {
+			continue
+		}
+
+		parts := strings.Split(strings.TrimPrefix(key, categoryPrefix), "/")
+		if len(parts) < 3 || folderRole(parts[1]) == folderRoleOther {
+			continue
+		}
+
+		flagged = append(flagged, QualityFlaggedImage{
+			Key:       key,
+			ProductID: parts[0],
+			Folder:    parts[1],
+			Score:     quality.Score,
+			Flags:     quality.Flags,
+		})
+	}
+
+	sort.Slice(flagged, func(i, j int) bool {
+		if flagged[i].Score != flagged[j].Score {
+			return flagged[i].Score < flagged[j].Score
+		}
+		return flagged[i].Key < flagged[j].Key
+	})
+	return flagged
+}
+
+// Download, decode and measure one image
+func scoreImageObject(ctx context.Context, obj objectInfo) (*ImageQuality, error) {
+	if obj.Size > qualityImageMaxBytes {
+		return nil, fmt.Errorf("image is %d bytes, above the %d byte limit", obj.Size, qualityImageMaxBytes)
+	}
+
+	img, err := loadImage(ctx, datasetStorage, obj.Key)
+	if err != nil {
+		return nil, err
+	}
+
+	quality := computeImageQuality(img)
+	quality.ETag = obj.ETag
+	quality.Version = qualityMetricsVersion
+	quality.ScoredAt = time.Now().UTC()
+	return &quality, nil
+}
+
+// Download and decode an image, refusing ones too large to decode safely
+func loadImage(ctx context.Context, storage objectStorage, key string) (image.Image, error) {
+	body, err := storage.GetRange(ctx, key, 0, -1)
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+
+	// Read one byte past the limit so an oversized object is caught without reading it all
+	data, err := io.ReadAll(io.LimitReader(body, qualityImageMaxBytes+1))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", path.Base(key), err)
+	}
+	if int64(len(data)) > qualityImageMaxBytes {
+		return nil, fmt.Errorf("image is above the %d byte limit", qualityImageMaxBytes)
+	}
+
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode %s header: %w", path.Base(key), err)
+	}
+	if cfg.Width*cfg.Height > qualityMaxDecodePixels {
+		return nil, fmt.Errorf("image is %dx%d, above the %d pixel limit", cfg.Width, cfg.Height, qualityMaxDecodePixels)
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode %s: %w", path.Base(key), err)
+	}
+	return img, nil
+}
+
+// Measure resolution, sharpness, exposure and aspect ratio of a decoded image
+func computeImageQuality(img image.Image) ImageQuality {
+	bounds := img.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+
+	quality := ImageQuality{Width: width, Height: height}
+	if width == 0 || height == 0 {
+		quality.Flags = []string{qualityFlagLowResolution}
+		return quality
+	}
+
+	shortSide, longSide := width, height
+	if shortSide > longSide {
+		shortSide, longSide = longSide, shortSide
+	}
+	quality.AspectRatio = roundTo(float64(longSide)/float64(shortSide), 3)
+
+	luma, lumaWidth, lumaHeight := downsampledLuminance(img, qualityAnalysisMaxSide)
+
+	// Exposure: mean luminance and a normalised histogram
+	histogram := make([]float64, qualityHistogramBins)
+	sum := 0.0
+	for _, value := range luma {
+		sum += value
+		histogram[int(value)*qualityHistogramBins/256]++
+	}
+	for i := range histogram {
+		histogram[i] = roundTo(histogram[i]/float64(len(luma)), 4)
+	}
+	quality.Histogram = histogram
+	quality.MeanLuminance = roundTo(sum/float64(len(luma)), 2)
+
+	// Sharpness: variance of the 4-neighbour Laplacian
+	quality.Sharpness = roundTo(laplacianVariance(luma, lumaWidth, lumaHeight), 2)
+
+	if shortSide < minQualityShortSide {
+		quality.Flags = append(quality.Flags, qualityFlagLowResolution)
+	}
+	if quality.Sharpness < minQualitySharpness {
+		quality.Flags = append(quality.Flags, qualityFlagBlurry)
+	}
+	if quality.MeanLuminance < minQualityMeanLuma {
+		quality.Flags = append(quality.Flags, qualityFlagUnderexposed)
+	}
+	if quality.MeanLuminance > maxQualityMeanLuma {
+		quality.Flags = append(quality.Flags, qualityFlagOverexposed)
+	}
+	if quality.AspectRatio > maxQualityAspectRatio {
+		quality.Flags = append(quality.Flags, qualityFlagAspectRatio)
+	}
+
+	// Weighted 0-100 score, sharpness and resolution dominating
+	resolutionScore := math.Min(1, float64(shortSide)/1000)
+	sharpnessScore := math.Min(1, quality.Sharpness/(3*minQualitySharpness))
+	exposureScore := 1.0
+	if quality.MeanLuminance < minQualityMeanLuma || quality.MeanLuminance > maxQualityMeanLuma {
+		exposureScore = 0.3
+	}
+	aspectScore := 1.0
+	if quality.AspectRatio > maxQualityAspectRatio {
+		aspectScore = 0.5
+	}
+	quality.Score = roundTo(100*(0.35*resolutionScore+0.4*sharpnessScore+0.15*exposureScore+0.1*aspectScore), 1)
+
+	return quality
+}
+
+// Luminance (0-255) averaged over square blocks so the longer side is at most maxSide
+func downsampledLuminance(img image.Image, maxSide int) ([]float64, int, int) {
+	bounds := img.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+
+	factor := 1
+	for (width+factor-1)/factor > maxSide || (height+factor-1)/factor > maxSide {
+		factor++
+	}
+	outWidth, outHeight := (width+factor-1)/factor, (height+factor-1)/factor
+
+	sums := make([]float64, outWidth*outHeight)
+	counts := make([]int, outWidth*outHeight)
+
+	ycbcr, isYCbCr := img.(*image.YCbCr)
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		row := ((y - bounds.Min.Y) / factor) * outWidth
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			var value float64
+			if isYCbCr {
+				// JPEG fast path: the Y plane is already luminance
+				value = float64(ycbcr.Y[ycbcr.YOffset(x, y)])
+			} else {
+				r, g, b, _ := img.At(x, y).RGBA()
+				value = (0.299*float64(r) + 0.587*float64(g) + 0.114*float64(b)) / 257
+			}
+			cell := row + (x-bounds.Min.X)/factor
+			sums[cell] += value
+			counts[cell]++
+		}
+	}
+
+	for i := range sums {
+		sums[i] /= float64(counts[i])
+	}
+	return sums, outWidth, outHeight
+}
+
+func laplacianVariance(luma []float64, width, height int) float64 {
+	if width < 3 || height < 3 {
+		return 0
+	}
+
+	var sum, sumSquares float64
+	n := 0
+	for y := 1; y < height-1; y++ {
+		for x := 1; x < width-1; x++ {
+			i := y*width + x
+			value := luma[i-width] + luma[i+width] + luma[i-1] + luma[i+1] - 4*luma[i]
+			sum += value
+			sumSquares += value * value
+			n++
+		}
+	}
+
+	mean := sum / float64(n)
+	return sumSquares/float64(n) - mean*mean
+}
+
+func roundTo(value float64, decimals int) float64 {
+	scale := math.Pow(10, float64(decimals))
+	return math.Round(value*scale) / scale
+}
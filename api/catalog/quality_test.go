@@ -0,0 +1,130 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"reflect"
+	"testing"
+)
+
+func flatImage(width, height int, luma uint8) image.Image {
+	img := image.NewGray(image.Rect(0, 0, width, height))
+	for i := range img.Pix {
+		img.Pix[i] = luma
+	}
+	return img
+}
+
+func checkerboardImage(width, height int) image.Image {
+	img := image.NewGray(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			if (x+y)%2 == 0 {
+				img.SetGray(x, y, color.Gray{Y: 255})
+			}
+		}
+	}
+	return img
+}
+
+func TestComputeImageQualitySharpness(t *testing.T) {
+	flat := computeImageQuality(flatImage(800, 800, 128))
+	if flat.Sharpness > 0.01 {
+		t.Errorf("flat image sharpness = %v, want near zero", flat.Sharpness)
+	}
+	if want := []string{qualityFlagBlurry}; !reflect.DeepEqual(flat.Flags, want) {
+		t.Errorf("flat image flags = %v, want %v", flat.Flags, want)
+	}
+	if flat.MeanLuminance != 128 || flat.Histogram[128*qualityHistogramBins/256] != 1 {
+		t.Errorf("flat image mean = %v, histogram = %v, want all pixels at 128", flat.MeanLuminance, flat.Histogram)
+	}
+
+	checkerboard := computeImageQuality(checkerboardImage(800, 800))
+	if checkerboard.Sharpness < 100*minQualitySharpness {
+		t.Errorf("checkerboard sharpness = %v, want well above %v", checkerboard.Sharpness, minQualitySharpness)
+	}
+	if len(checkerboard.Flags) != 0 {
+		t.Errorf("checkerboard flags = %v, want none", checkerboard.Flags)
+	}
+	if checkerboard.Score <= flat.Score {
+		t.Errorf("checkerboard score %v is not above the flat image's %v", checkerboard.Score, flat.Score)
+	}
+}
+
+func TestComputeImageQualityFlags(t *testing.T) {
+	tests := []struct {
+		name        string
+		img         image.Image
+		wantAspect  float64
+		wantFlagged map[string]bool
+	}{
+		{
+			name:        "aspect ratio at the limit",
+			img:         checkerboardImage(1000, 400),
+			wantAspect:  2.5,
+			wantFlagged: map[string]bool{qualityFlagAspectRatio: false},
+		},
+		{
+			name:        "aspect ratio over the limit",
+			img:         checkerboardImage(1000, 399),
+			wantAspect:  2.506,
+			wantFlagged: map[string]bool{qualityFlagAspectRatio: true},
+		},
+		{
+			name:        "portrait over the limit",
+			img:         checkerboardImage(400, 1020),
+			wantAspect:  2.55,
+			wantFlagged: map[string]bool{qualityFlagAspectRatio: true},
+		},
+		{
+			name:        "short side under the minimum",
+			img:         checkerboardImage(599, 800),
+			wantAspect:  1.336,
+			wantFlagged: map[string]bool{qualityFlagLowResolution: true, qualityFlagAspectRatio: false},
+		},
+		{
+			name:        "dark",
+			img:         flatImage(800, 800, 20),
+			wantAspect:  1,
+			wantFlagged: map[string]bool{qualityFlagUnderexposed: true, qualityFlagOverexposed: false},
+		},
+		{
+			name:        "bright",
+			img:         flatImage(800, 800, 250),
+			wantAspect:  1,
+			wantFlagged: map[string]bool{qualityFlagUnderexposed: false, qualityFlagOverexposed: true},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			quality := computeImageQuality(tt.img)
+			if quality.AspectRatio != tt.wantAspect {
+				t.Errorf("AspectRatio = %v, want %v", quality.AspectRatio, tt.wantAspect)
+			}
+			flags := map[string]bool{}
+			for _, flag := range quality.Flags {
+				flags[flag] = true
+			}
+			for flag, want := range tt.wantFlagged {
+				if flags[flag] != want {
+					t.Errorf("flag %s = %t, want %t (flags: %v)", flag, flags[flag], want, quality.Flags)
+				}
+			}
+		})
+	}
+
+	// The aspect penalty halves the tenth of the score given to the aspect ratio
+	atLimit := computeImageQuality(checkerboardImage(1000, 400))
+	overLimit := computeImageQuality(checkerboardImage(1000, 399))
+	if diff := atLimit.Score - overLimit.Score; diff < 4.9 || diff > 5.1 {
+		t.Errorf("aspect penalty = %v points (%v vs %v), want 5", diff, atLimit.Score, overLimit.Score)
+	}
+}
+
+func TestComputeImageQualityEmpty(t *testing.T) {
+	quality := computeImageQuality(image.NewGray(image.Rect(0, 0, 0, 0)))
+	if want := []string{qualityFlagLowResolution}; !reflect.DeepEqual(quality.Flags, want) || quality.Score != 0 {
+		t.Errorf("computeImageQuality(empty) = %+v, want only %v and a zero score", quality, want)
+	}
+}
@@ -0,0 +1,274 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"image"
+	_ "image/jpeg" // Register decoders used by image.DecodeConfig
+	_ "image/png"
+	"io"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// referenceSelectionVersion identifies the ranking rules below. Bump it
+// whenever the ordering changes so consumers can tell selections apart.
+const referenceSelectionVersion = "v1"
+
+// Bytes fetched from the start of an image to read its dimensions
+const imageHeaderProbeBytes = 256 * 1024
+
+// Folder preference for reference images, lower rank wins
+var referenceFolderRanks = map[string]int{
+	"TEM NL":     0,
+	"CHÍNH DIỆN": 0,
+	"HÌNH WEB":   1,
+}
+
+type ReferenceImage struct {
+	ImageData
+	Folder string `json:"folder"`
+	Width  int    `json:"width"`
+	Height int    `json:"height"`
+	Rank   int    `json:"rank"`
+}
+
+type ReferenceSet struct {
+	ProductID        string           `json:"productId"`
+	Category         string           `json:"category"`
+	SelectionVersion string           `json:"selectionVersion"`
+	SelectionHash    string           `json:"selectionHash"`
+	LabelImages      []ReferenceImage `json:"labelImages"`
+	OverviewImages   []ReferenceImage `json:"overviewImages"`
+	// Set when a role was selected from another colour variant of the product
+	LabelSourceProductID    string `json:"labelSourceProductId,omitempty"`
+	OverviewSourceProductID string `json:"overviewSourceProductId,omitempty"`
+}
+
+type ReferenceSetMetadata struct {
+	ProductID               string    `json:"productId"`
+	Category                string    `json:"category"`
+	RequestedLabelImages    int       `json:"requestedLabelImages"`
+	RequestedOverviewImages int       `json:"requestedOverviewImages"`
+	CandidateLabelImages    int       `json:"candidateLabelImages"`
+	CandidateOverviewImages int       `json:"candidateOverviewImages"`
+	ScannedAt               time.Time `json:"scannedAt"`
+}
+
+// Handle deterministic reference set selection operation
+func handleReferenceSetSelection(ctx context.Context, requestID string, queryParams map[string]string) (*CatalogResponse, error) {
+	category := queryParams["category"]
+	productID := queryParams["productId"]
+
+	if category == "" || productID == "" {
+		return nil, newBadRequest("MISSING_PARAMETER", "Missing required 'category' and 'productId' parameters for reference set selection")
+	}
+
+	// 'count' applies to both roles, the role-specific parameters override it
+	labelCount := appConfig.MaxReferenceLabelImages
+	overviewCount := appConfig.MaxReferenceOverviewImages
+	if count, err := parseReferenceCount(queryParams["count"]); err != nil {
+		return nil, err
+	} else if count > 0 {
+		labelCount, overviewCount = count, count
+	}
+	if count, err := parseReferenceCount(queryParams["labelCount"]); err != nil {
+		return nil, err
+	} else if count > 0 {
+		labelCount = count
+	}
+	if count, err := parseReferenceCount(queryParams["overviewCount"]); err != nil {
+		return nil, err
+	} else if count > 0 {
+		overviewCount = count
+	}
+
+	log.Printf("RequestID: %s - Selecting reference set for %s/%s: label=%d, overview=%d",
+		requestID, category, productID, labelCount, overviewCount)
+
+	basePrefix := fmt.Sprintf("dataset/%s/%s/", category, productID)
+
+	labelCandidates, err := collectReferenceCandidates(ctx, requestID, basePrefix, []string{"TEM NL"})
+	if err != nil {
+		return nil, fmt.Errorf("failed to collect label candidates: %w", err)
+	}
+
+	overviewCandidates, err := collectReferenceCandidates(ctx, requestID, basePrefix, []string{"CHÍNH DIỆN", "HÌNH WEB"})
+	if err != nil {
+		return nil, fmt.Errorf("failed to collect overview candidates: %w", err)
+	}
+
+	var labelSource, overviewSource string
+	if queryParams["variantFallback"] == "true" && (len(labelCandidates) == 0 || len(overviewCandidates) == 0) {
+		siblings, err := findSiblingVariants(ctx, category, productID)
+		if err != nil {
+			log.Printf("RequestID: %s - Warning: Failed to find variants of %s: %v", requestID, productID, err)
+		}
+
+		for _, sibling := range siblings {
+			siblingPrefix := fmt.Sprintf("dataset/%s/%s/", category, sibling)
+			if len(labelCandidates) == 0 {
+				if labelCandidates, err = collectReferenceCandidates(ctx, requestID, siblingPrefix, []string{"TEM NL"}); err == nil && len(labelCandidates) > 0 {
+					labelSource = sibling
+				}
+			}
+			if len(overviewCandidates) == 0 {
+				if overviewCandidates, err = collectReferenceCandidates(ctx, requestID, siblingPrefix, []string{"CHÍNH DIỆN", "HÌNH WEB"}); err == nil && len(overviewCandidates) > 0 {
+					overviewSource = sibling
+				}
+			}
+		}
+	}
+
+	referenceSet := &ReferenceSet{
+		ProductID:               productID,
+		Category:                category,
+		SelectionVersion:        referenceSelectionVersion,
+		LabelImages:             selectReferenceImages(labelCandidates, labelCount),
+		OverviewImages:          selectReferenceImages(overviewCandidates, overviewCount),
+		LabelSourceProductID:    labelSource,
+		OverviewSourceProductID: overviewSource,
+	}
+	referenceSet.SelectionHash = computeSelectionHash(referenceSet)
+
+	response := &CatalogResponse{
+		Type: "referenceSet",
+		Data: referenceSet,
+		Metadata: ReferenceSetMetadata{
+			ProductID:               productID,
+			Category:                category,
+			RequestedLabelImages:    labelCount,
+			RequestedOverviewImages: overviewCount,
+			CandidateLabelImages:    len(labelCandidates),
+			CandidateOverviewImages: len(overviewCandidates),
+			ScannedAt:               time.Now(),
+		},
+	}
+
+	log.Printf("RequestID: %s - Reference set selected for %s/%s: %d label, %d overview, hash=%s",
+		requestID, category, productID, len(referenceSet.LabelImages), len(referenceSet.OverviewImages), referenceSet.SelectionHash)
+	return response, nil
+}
+
+// Parse an optional positive image count parameter
+func parseReferenceCount(value string) (int, error) {
+	if value == "" {
+		return 0, nil
+	}
+
+	count, err := strconv.Atoi(value)
+	if err != nil || count < 1 || count > 20 {
+		return 0, newBadRequest("INVALID_PARAMETER", "Invalid image count: %s. Must be between 1 and 20", value)
+	}
+
+	return count, nil
+}
+
+// Collect reference candidates from the given folders, including their dimensions
+func collectReferenceCandidates(ctx context.Context, requestID, basePrefix string, folders []string) ([]ReferenceImage, error) {
+	var candidates []ReferenceImage
+
+	for _, folder := range folders {
+		images, err := discoverImagesInFolder(ctx, requestID, basePrefix+folder+"/")
+		if err != nil {
+			return nil, err
+		}
+
+		for _, img := range images {
+			width, height, err := probeImageDimensions(ctx, img.Key)
+			if err != nil {
+				log.Printf("RequestID: %s - Warning: Failed to read dimensions for %s: %v", requestID, img.Key, err)
+			}
+
+			candidates = append(candidates, ReferenceImage{
+				ImageData: img,
+				Folder:    folder,
+				Width:     width,
+				Height:    height,
+			})
+		}
+	}
+
+	return candidates, nil
+}
+
+// Read image dimensions from the object header without downloading the full image
+func probeImageDimensions(ctx context.Context, key string) (int, int, error) {
+	body, err := datasetStorage.GetRange(ctx, key, 0, imageHeaderProbeBytes)
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to fetch image header: %w", err)
+	}
+	defer body.Close()
+
+	header, err := io.ReadAll(body)
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to read image header: %w", err)
+	}
+
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(header))
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to decode image header: %w", err)
+	}
+
+	return cfg.Width, cfg.Height, nil
+}
+
+// Rank candidates (primary folder, resolution, recency, key) and keep the first n
+func selectReferenceImages(candidates []ReferenceImage, n int) []ReferenceImage {
+	ranked := make([]ReferenceImage, len(candidates))
+	copy(ranked, candidates)
+
+	sort.SliceStable(ranked, func(i, j int) bool {
+		a, b := ranked[i], ranked[j]
+		if referenceFolderRanks[a.Folder] != referenceFolderRanks[b.Folder] {
+			return referenceFolderRanks[a.Folder] < referenceFolderRanks[b.Folder]
+		}
+		if a.Width*a.Height != b.Width*b.Height {
+			return a.Width*a.Height > b.Width*b.Height
+		}
+		if a.Size != b.Size {
+			return a.Size > b.Size
+		}
+		if !a.LastModified.Equal(b.LastModified) {
+			return a.LastModified.After(b.LastModified)
+		}
+		return a.Key < b.Key
+	})
+
+	if len(ranked) > n {
+		ranked = ranked[:n]
+	}
+
+	for i := range ranked {
+		ranked[i].Rank = i + 1
+	}
+
+	return ranked
+}
+
+// Compute a stable hash over the selection rules and the selected objects
+func computeSelectionHash(referenceSet *ReferenceSet) string {
+	var builder strings.Builder
+	builder.WriteString(referenceSet.SelectionVersion + "\n")
+
+	for _, role := range []struct {
+		name   string
+		images []ReferenceImage
+	}{
+		{"label", referenceSet.LabelImages},
+		{"overview", referenceSet.OverviewImages},
+	} {
+		for _, img := range role.images {
+			fmt.Fprintf(&builder, "%s\t%s\t%s\n", role.name, img.Key, img.ETag)
+		}
+	}
+
+	sum := sha256.Sum256([]byte(builder.String()))
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
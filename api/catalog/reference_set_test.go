@@ -0,0 +1,140 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func referenceCandidate(key, folder string, width, height int, size int64, modified string) ReferenceImage {
+	lastModified, err := time.Parse(time.RFC3339, modified)
+	if err != nil {
+		panic(err)
+	}
+	return ReferenceImage{
+		ImageData: ImageData{Key: key, Size: size, LastModified: lastModified, ETag: "etag-" + key},
+		Folder:    folder,
+		Width:     width,
+		Height:    height,
+	}
+}
+
+func TestSelectReferenceImagesOrdering(t *testing.T) {
+	// In each case first ranks above second
+	tests := []struct {
+		name          string
+		first, second ReferenceImage
+	}{
+		{
+			name:   "primary folder before HÌNH WEB",
+			first:  referenceCandidate("b.jpg", "CHÍNH DIỆN", 100, 100, 10, "2026-01-01T00:00:00Z"),
+			second: referenceCandidate("a.jpg", "HÌNH WEB", 4000, 3000, 9000, "2026-10-01T00:00:00Z"),
+		},
+		{
+			name:   "TEM NL and CHÍNH DIỆN rank alike, then resolution",
+			first:  referenceCandidate("b.jpg", "CHÍNH DIỆN", 2000, 1500, 10, "2026-01-01T00:00:00Z"),
+			second: referenceCandidate("a.jpg", "TEM NL", 1000, 1000, 9000, "2026-10-01T00:00:00Z"),
+		},
+		{
+			name:   "same resolution, larger file",
+			first:  referenceCandidate("b.jpg", "TEM NL", 1500, 1000, 900, "2026-01-01T00:00:00Z"),
+			second: referenceCandidate("a.jpg", "TEM NL", 1000, 1500, 800, "2026-10-01T00:00:00Z"),
+		},
+		{
+			name:   "same size, more recent",
+			first:  referenceCandidate("b.jpg", "TEM NL", 1000, 1000, 800, "2026-10-01T00:00:00Z"),
+			second: referenceCandidate("a.jpg", "TEM NL", 1000, 1000, 800, "2026-01-01T00:00:00Z"),
+		},
+		{
+			name:   "everything equal, key order",
+			first:  referenceCandidate("a.jpg", "TEM NL", 1000, 1000, 800, "2026-10-01T00:00:00Z"),
+			second: referenceCandidate("b.jpg", "TEM NL", 1000, 1000, 800, "2026-10-01T00:00:00Z"),
+		},
+		{
+			name:   "unreadable dimensions rank below known ones",
+			first:  referenceCandidate("b.jpg", "TEM NL", 10, 10, 1, "2026-01-01T00:00:00Z"),
+			second: referenceCandidate("a.jpg", "TEM NL", 0, 0, 9000, "2026-10-01T00:00:00Z"),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, candidates := range [][]ReferenceImage{{tt.first, tt.second}, {tt.second, tt.first}} {
+				selected := selectReferenceImages(candidates, 2)
+				if len(selected) != 2 || selected[0].Key != tt.first.Key || selected[1].Key != tt.second.Key {
+					t.Fatalf("selectReferenceImages() = %v, want %s then %s", referenceKeys(selected), tt.first.Key, tt.second.Key)
+				}
+				if selected[0].Rank != 1 || selected[1].Rank != 2 {
+					t.Errorf("ranks = %d, %d, want 1, 2", selected[0].Rank, selected[1].Rank)
+				}
+			}
+		})
+	}
+}
+
+func TestSelectReferenceImagesLimit(t *testing.T) {
+	candidates := []ReferenceImage{
+		referenceCandidate("web.jpg", "HÌNH WEB", 4000, 3000, 9000, "2026-10-01T00:00:00Z"),
+		referenceCandidate("small.jpg", "TEM NL", 800, 600, 100, "2026-10-01T00:00:00Z"),
+		referenceCandidate("large.jpg", "CHÍNH DIỆN", 1600, 1200, 100, "2026-10-01T00:00:00Z"),
+	}
+
+	if got, want := referenceKeys(selectReferenceImages(candidates, 2)), []string{"large.jpg", "small.jpg"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("selectReferenceImages(n=2) = %v, want %v", got, want)
+	}
+	if got := selectReferenceImages(candidates, 5); len(got) != 3 {
+		t.Errorf("selectReferenceImages(n=5) returned %d images, want 3", len(got))
+	}
+	if candidates[0].Key != "web.jpg" || candidates[0].Rank != 0 {
+		t.Errorf("selectReferenceImages() modified its input: %+v", candidates[0])
+	}
+}
+
+func TestComputeSelectionHash(t *testing.T) {
+	build := func() *ReferenceSet {
+		return &ReferenceSet{
+			SelectionVersion: referenceSelectionVersion,
+			LabelImages:      []ReferenceImage{referenceCandidate("dataset/REF/P1/TEM NL/1.jpg", "TEM NL", 1000, 1000, 10, "2026-10-01T00:00:00Z")},
+			OverviewImages:   []ReferenceImage{referenceCandidate("dataset/REF/P1/CHÍNH DIỆN/1.jpg", "CHÍNH DIỆN", 1000, 1000, 10, "2026-10-01T00:00:00Z")},
+		}
+	}
+
+	// Pinned so a change to the hashed fields or their layout is noticed; bump
+	// referenceSelectionVersion along with this value when that is intended
+	const want = "sha256:d47baf4674d30bb3d65ab48d59c867e909506c94035ab10c5fa79c9dc930c215"
+	if got := computeSelectionHash(build()); got != want {
+		t.Errorf("computeSelectionHash() = %s, want %s", got, want)
+	}
+
+	unchanged := build()
+	unchanged.LabelImages[0].PresignedURL = "https://example.com/signed"
+	unchanged.LabelImages[0].LastModified = time.Now()
+	unchanged.LabelImages[0].Rank = 7
+	if computeSelectionHash(unchanged) != computeSelectionHash(build()) {
+		t.Errorf("computeSelectionHash() changed with presigned URL, modification time or rank")
+	}
+
+	changes := map[string]func(*ReferenceSet){
+		"etag":    func(set *ReferenceSet) { set.LabelImages[0].ETag = "other" },
+		"version": func(set *ReferenceSet) { set.SelectionVersion = "v0" },
+		"roles swapped": func(set *ReferenceSet) {
+			set.LabelImages, set.OverviewImages = set.OverviewImages, set.LabelImages
+		},
+		"image dropped": func(set *ReferenceSet) { set.OverviewImages = nil },
+	}
+	for name, change := range changes {
+		set := build()
+		change(set)
+		if computeSelectionHash(set) == computeSelectionHash(build()) {
+			t.Errorf("computeSelectionHash() unchanged after %s", name)
+		}
+	}
+}
+
+func referenceKeys(images []ReferenceImage) []string {
+	keys := make([]string, len(images))
+	for i, img := range images {
+		keys[i] = img.Key
+	}
+	return keys
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+const (
+	// Segments read concurrently by a full-table scan
+	scanSegments = 8
+	// Time kept back from the Lambda deadline to build and send the response
+	deadlineMargin = 5 * time.Second
+)
+
+// Context that expires deadlineMargin before the Lambda deadline, if ctx has one
+func withDeadlineMargin(ctx context.Context) (context.Context, context.CancelFunc) {
+	if deadline, ok := ctx.Deadline(); ok {
+		return context.WithDeadline(ctx, deadline.Add(-deadlineMargin))
+	}
+	return context.WithCancel(ctx)
+}
+
+// Whether err only means the work context ran out of time while the request itself is still live
+func stoppedAtDeadline(ctx, workCtx context.Context, err error) bool {
+	return ctx.Err() == nil && workCtx.Err() != nil &&
+		(errors.Is(err, context.DeadlineExceeded) || errors.Is(err, context.Canceled))
+}
+
+// Scan the whole table with parallel segments, following LastEvaluatedKey in each.
+// Pages are passed to handlePage one at a time. Segments stop at the deadline,
+// and the scan is then reported as incomplete rather than failed.
+func parallelScan(ctx context.Context, input dynamodb.ScanInput, handlePage func([]map[string]types.AttributeValue) error) (bool, error) {
+	workCtx, cancel := withDeadlineMargin(ctx)
+	defer cancel()
+
+	var (
+		mutex    sync.Mutex
+		wg       sync.WaitGroup
+		firstErr error
+	)
+	complete := true
+
+	for segment := 0; segment < scanSegments; segment++ {
+		wg.Add(1)
+		go func(segment int) {
+			defer wg.Done()
+
+			segmentInput := input
+			segmentInput.Segment = aws.Int32(int32(segment))
+			segmentInput.TotalSegments = aws.Int32(scanSegments)
+
+			for {
+				output, err := dynamoClient.Scan(workCtx, &segmentInput)
+				if err == nil {
+					mutex.Lock()
+					if firstErr == nil {
+						err = handlePage(output.Items)
+					}
+					mutex.Unlock()
+				}
+				if err != nil {
+					mutex.Lock()
+					if stoppedAtDeadline(ctx, workCtx, err) {
+						complete = false
+					} else if firstErr == nil {
+						firstErr = fmt.Errorf("scan of segment %d failed: %w", segment, err)
+						cancel()
+					}
+					mutex.Unlock()
+					return
+				}
+
+				if output.LastEvaluatedKey == nil {
+					return
+				}
+				segmentInput.ExclusiveStartKey = output.LastEvaluatedKey
+			}
+		}(segment)
+	}
+	wg.Wait()
+
+	if firstErr != nil {
+		return false, firstErr
+	}
+	return complete, nil
+}
+
+// Read every page of a query, stopping early at the deadline like parallelScan
+func queryAllPages(ctx context.Context, input *dynamodb.QueryInput, handlePage func([]map[string]types.AttributeValue) error) (bool, error) {
+	workCtx, cancel := withDeadlineMargin(ctx)
+	defer cancel()
+
+	paginator := dynamodb.NewQueryPaginator(dynamoClient, input)
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(workCtx)
+		if err != nil {
+			if stoppedAtDeadline(ctx, workCtx, err) {
+				return false, nil
+			}
+			return false, err
+		}
+		if err := handlePage(page.Items); err != nil {
+			return false, err
+		}
+	}
+
+	return true, nil
+}
@@ -0,0 +1,590 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"log"
+	"math"
+	"math/bits"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// similarityVersion identifies the signature algorithm below. Signatures
+// computed with another version are recomputed by the next indexing run.
+const similarityVersion = "v1"
+
+const (
+	similarityHashSide       = 32   // Luminance grid the DCT is taken over
+	similarityHashBits       = 8    // Low-frequency coefficients kept per axis, 64 bits in all
+	similarityHistogramBins  = 4    // Buckets per RGB channel, 64 colour bins in all
+	similaritySampleSide     = 512  // Pixels are sampled on a stride so at most this many are read per axis
+	similarityWhiteThreshold = 240  // Near-white background pixels are left out of the colour histogram
+	similarityHashWeight     = 0.6  // Share of the perceptual hash in the combined score
+	similarityDefaultLimit   = 5    // Products returned when no limit is given
+	similarityMaxLimit       = 50   // Largest accepted limit
+	similarityMismatchMargin = 0.05 // How far the selected product may trail the best match before a warning
+	similarityIndexWorkers   = 4    // Parallel downloads and decodes, bounded like qualityScoring
+	similarityIndexCacheTTL  = 15 * time.Minute
+)
+
+// Compact visual signature of one image
+type ImageSignature struct {
+	PHash string `json:"phash"` // 64-bit DCT perceptual hash, hex encoded
+	// Normalised RGB histogram, each bin scaled to 0-255 (base64 in JSON)
+	Histogram []byte    `json:"histogram"`
+	ETag      string    `json:"etag"`
+	Version   string    `json:"version"`
+	IndexedAt time.Time `json:"indexedAt"`
+}
+
+// Sidecar index of image signatures for one category, keyed by object key
+type SimilarityIndex struct {
+	Category    string                    `json:"category"`
+	Version     string                    `json:"version"`
+	GeneratedAt time.Time                 `json:"generatedAt"`
+	Images      map[string]ImageSignature `json:"images"`
+}
+
+type SimilarProduct struct {
+	Category        string  `json:"category"`
+	ProductID       string  `json:"productId"`
+	Score           float64 `json:"score"`
+	HashSimilarity  float64 `json:"hashSimilarity"`
+	ColorSimilarity float64 `json:"colorSimilarity"`
+	BestMatchKey    string  `json:"bestMatchKey"`
+	BestMatchFolder string  `json:"bestMatchFolder"`
+}
+
+type SimilarityMetadata struct {
+	ImageKey          string   `json:"imageKey"`
+	Categories        []string `json:"categories"`
+	ComparedImages    int      `json:"comparedImages"`
+	ComparedProducts  int      `json:"comparedProducts"`
+	SelectedProductID string   `json:"selectedProductId,omitempty"`
+	// Rank (1-based) and score of the selected product among all compared products
+	SelectedRank  int      `json:"selectedRank,omitempty"`
+	SelectedScore *float64 `json:"selectedScore,omitempty"`
+	// Set when another product matches clearly better than the selected one
+	MismatchSuspected  bool      `json:"mismatchSuspected"`
+	SuggestedProductID string    `json:"suggestedProductId,omitempty"`
+	AlgorithmVersion   string    `json:"algorithmVersion"`
+	ScannedAt          time.Time `json:"scannedAt"`
+}
+
+type SimilarityIndexCategory struct {
+	Category      string `json:"category"`
+	TotalImages   int    `json:"totalImages"`
+	IndexedImages int    `json:"indexedImages"`
+	ReusedImages  int    `json:"reusedImages"`
+	FailedImages  int    `json:"failedImages"`
+	PendingImages int    `json:"pendingImages"`
+}
+
+// Output of an indexing run
+type SimilarityIndexReport struct {
+	Version     string                    `json:"version"`
+	GeneratedAt time.Time                 `json:"generatedAt"`
+	Complete    bool                      `json:"complete"`
+	Categories  []SimilarityIndexCategory `json:"categories"`
+}
+
+type cachedSimilarityIndex struct {
+	index    *SimilarityIndex
+	loadedAt time.Time
+}
+
+var (
+	similarityCacheMutex sync.Mutex
+	similarityIndexCache = make(map[string]cachedSimilarityIndex)
+)
+
+// Cosine table for the DCT, computed once
+var similarityDCTCosines = func() [][]float64 {
+	cosines := make([][]float64, similarityHashBits)
+	for u := range cosines {
+		cosines[u] = make([]float64, similarityHashSide)
+		for x := range cosines[u] {
+			cosines[u][x] = math.Cos(float64(2*x+1) * float64(u) * math.Pi / float64(2*similarityHashSide))
+		}
+	}
+	return cosines
+}()
+
+func similarityIndexKey(category string) string {
+	return appConfig.MetaPrefix + "similarity/" + category + ".json"
+}
+
+// Handle ranking catalog products by visual similarity to an uploaded image
+func handleSimilarProducts(ctx context.Context, requestID string, queryParams map[string]string) (*CatalogResponse, error) {
+	imageKey := queryParams["imageKey"]
+	if imageKey == "" {
+		return nil, newBadRequest("MISSING_PARAMETER", "Missing required 'imageKey' parameter for similarity search")
+	}
+
+	categoryIDs, err := resolveStatsCategories(queryParams["category"])
+	if err != nil {
+		return nil, err
+	}
+
+	limit := similarityDefaultLimit
+	if value := queryParams["limit"]; value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed < 1 || parsed > similarityMaxLimit {
+			return nil, newBadRequest("INVALID_PARAMETER", "Invalid 'limit' parameter: %s. Must be between 1 and %d", value, similarityMaxLimit)
+		}
+		limit = parsed
+	}
+
+	selectedProductID := queryParams["productId"]
+
+	log.Printf("RequestID: %s - Finding products similar to %s in %v", requestID, imageKey, categoryIDs)
+
+	img, err := loadImage(ctx, validationStorage, imageKey)
+	if errors.Is(err, errObjectNotFound) {
+		return nil, newNotFound("IMAGE_NOT_FOUND", "Image %s was not found in the validation bucket", imageKey)
+	}
+	if err != nil {
+		return nil, newBadRequest("INVALID_IMAGE", "Image %s could not be read: %v", imageKey, err)
+	}
+	signature := computeImageSignature(img)
+
+	var products []SimilarProduct
+	comparedImages := 0
+	indexed := false
+
+	for _, category := range categoryIDs {
+		index, err := cachedSimilarityIndexFor(ctx, category)
+		if err != nil {
+			return nil, err
+		}
+		if len(index.Images) > 0 {
+			indexed = true
+		}
+
+		best := make(map[string]SimilarProduct)
+		for key, candidate := range index.Images {
+			if candidate.Version != similarityVersion {
+				continue
+			}
+			productID, folder := splitProductImageKey(category, key)
+			if productID == "" {
+				continue
+			}
+			comparedImages++
+
+			hashSimilarity, colorSimilarity, score := compareSignatures(signature, candidate)
+			score = roundTo(score, 4)
+			// A product scores as its closest image
+			if current, ok := best[productID]; !ok || score > current.Score || (score == current.Score && key < current.BestMatchKey) {
+				best[productID] = SimilarProduct{
+					Category:        category,
+					ProductID:       productID,
+					Score:           score,
+					HashSimilarity:  roundTo(hashSimilarity, 4),
+					ColorSimilarity: roundTo(colorSimilarity, 4),
+					BestMatchKey:    key,
+					BestMatchFolder: folder,
+				}
+			}
+		}
+		for _, product := range best {
+			products = append(products, product)
+		}
+	}
+
+	if !indexed {
+		return nil, newNotFound("SIMILARITY_INDEX_NOT_FOUND", "No similarity index has been built yet; run the similarityIndex job")
+	}
+
+	sort.Slice(products, func(i, j int) bool {
+		if products[i].Score != products[j].Score {
+			return products[i].Score > products[j].Score
+		}
+		if products[i].Category != products[j].Category {
+			return products[i].Category < products[j].Category
+		}
+		return products[i].ProductID < products[j].ProductID
+	})
+
+	metadata := SimilarityMetadata{
+		ImageKey:          imageKey,
+		Categories:        categoryIDs,
+		ComparedImages:    comparedImages,
+		ComparedProducts:  len(products),
+		SelectedProductID: selectedProductID,
+		AlgorithmVersion:  similarityVersion,
+		ScannedAt:         time.Now(),
+	}
+
+	if selectedProductID != "" && len(products) > 0 {
+		for rank, product := range products {
+			if product.ProductID == selectedProductID && (queryParams["category"] == "" || product.Category == queryParams["category"]) {
+				score := product.Score
+				metadata.SelectedRank = rank + 1
+				metadata.SelectedScore = &score
+				break
+			}
+		}
+
+		top := products[0]
+		if top.ProductID != selectedProductID && (metadata.SelectedScore == nil || top.Score-*metadata.SelectedScore >= similarityMismatchMargin) {
+			metadata.MismatchSuspected = true
+			metadata.SuggestedProductID = top.ProductID
+		}
+	}
+
+	if len(products) > limit {
+		products = products[:limit]
+	}
+	if products == nil {
+		products = []SimilarProduct{}
+	}
+
+	log.Printf("RequestID: %s - Compared %s with %d images of %d products, mismatch suspected: %t",
+		requestID, imageKey, comparedImages, metadata.ComparedProducts, metadata.MismatchSuspected)
+
+	return &CatalogResponse{
+		Type:     "similarProducts",
+		Data:     products,
+		Metadata: metadata,
+	}, nil
+}
+
+// Product ID and folder of an image key in a category, e.g. "dataset/REF/P1/TEM NL/a.jpg"
+func splitProductImageKey(category, key string) (string, string) {
+	parts := strings.Split(strings.TrimPrefix(key, categoryDefinitions[category].S3Prefix), "/")
+	if len(parts) < 3 {
+		return "", ""
+	}
+	return parts[0], parts[1]
+}
+
+// Similarity of two signatures: hash and colour similarity in [0, 1] and their weighted score
+func compareSignatures(a, b ImageSignature) (float64, float64, float64) {
+	hashA, errA := strconv.ParseUint(a.PHash, 16, 64)
+	hashB, errB := strconv.ParseUint(b.PHash, 16, 64)
+	hashSimilarity := 0.0
+	if errA == nil && errB == nil {
+		hashSimilarity = 1 - float64(bits.OnesCount64(hashA^hashB))/64
+	}
+
+	// Histogram intersection, over the smaller total as rounding leaves bins summing to about 255
+	colorSimilarity := 0.0
+	if len(a.Histogram) == len(b.Histogram) {
+		intersection, totalA, totalB := 0, 0, 0
+		for i := range a.Histogram {
+			intersection += int(min(a.Histogram[i], b.Histogram[i]))
+			totalA += int(a.Histogram[i])
+			totalB += int(b.Histogram[i])
+		}
+		if total := min(totalA, totalB); total > 0 {
+			colorSimilarity = float64(intersection) / float64(total)
+		}
+	}
+
+	score := similarityHashWeight*hashSimilarity + (1-similarityHashWeight)*colorSimilarity
+	return hashSimilarity, colorSimilarity, score
+}
+
+// Perceptual hash and colour histogram of a decoded image
+func computeImageSignature(img image.Image) ImageSignature {
+	bounds := img.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+
+	stepX := max(1, width/similaritySampleSide)
+	stepY := max(1, height/similaritySampleSide)
+
+	// Luminance averaged into a square grid, and colour counts over the same samples
+	grid := make([]float64, similarityHashSide*similarityHashSide)
+	gridCounts := make([]int, len(grid))
+	binCount := similarityHistogramBins * similarityHistogramBins * similarityHistogramBins
+	colorCounts := make([]float64, binCount)
+	allCounts := make([]float64, binCount)
+	colored := 0
+
+	ycbcr, isYCbCr := img.(*image.YCbCr)
+	for y := bounds.Min.Y; y < bounds.Max.Y; y += stepY {
+		gridY := (y - bounds.Min.Y) * similarityHashSide / height
+		for x := bounds.Min.X; x < bounds.Max.X; x += stepX {
+			var r, g, b uint8
+			if isYCbCr {
+				yi, ci := ycbcr.YOffset(x, y), ycbcr.COffset(x, y)
+				r, g, b = color.YCbCrToRGB(ycbcr.Y[yi], ycbcr.Cb[ci], ycbcr.Cr[ci])
+			} else {
+				r16, g16, b16, _ := img.At(x, y).RGBA()
+				r, g, b = uint8(r16>>8), uint8(g16>>8), uint8(b16>>8)
+			}
+
+			cell := gridY*similarityHashSide + (x-bounds.Min.X)*similarityHashSide/width
+			grid[cell] += 0.299*float64(r) + 0.587*float64(g) + 0.114*float64(b)
+			gridCounts[cell]++
+
+			bin := (int(r)*similarityHistogramBins/256)*similarityHistogramBins*similarityHistogramBins +
+				(int(g)*similarityHistogramBins/256)*similarityHistogramBins +
+				int(b)*similarityHistogramBins/256
+			allCounts[bin]++
+			if r < similarityWhiteThreshold || g < similarityWhiteThreshold || b < similarityWhiteThreshold {
+				colorCounts[bin]++
+				colored++
+			}
+		}
+	}
+
+	for i := range grid {
+		if gridCounts[i] > 0 {
+			grid[i] /= float64(gridCounts[i])
+		}
+	}
+
+	// A plain white image keeps its white bin rather than an empty histogram
+	if colored == 0 {
+		colorCounts = allCounts
+	}
+	total := 0.0
+	for _, count := range colorCounts {
+		total += count
+	}
+	histogram := make([]byte, binCount)
+	if total > 0 {
+		for i, count := range colorCounts {
+			histogram[i] = byte(math.Round(count / total * 255))
+		}
+	}
+
+	return ImageSignature{
+		PHash:     fmt.Sprintf("%016x", perceptualHash(grid)),
+		Histogram: histogram,
+	}
+}
+
+// 64-bit pHash: low-frequency DCT coefficients of the luminance grid compared with their median
+func perceptualHash(grid []float64) uint64 {
+	n := similarityHashSide
+	k := similarityHashBits
+
+	// Separable 2D DCT-II, keeping only the k x k lowest frequencies
+	rows := make([]float64, n*k)
+	for y := 0; y < n; y++ {
+		for u := 0; u < k; u++ {
+			sum := 0.0
+			for x := 0; x < n; x++ {
+				sum += grid[y*n+x] * similarityDCTCosines[u][x]
+			}
+			rows[y*k+u] = sum
+		}
+	}
+	coefficients := make([]float64, k*k)
+	for v := 0; v < k; v++ {
+		for u := 0; u < k; u++ {
+			sum := 0.0
+			for y := 0; y < n; y++ {
+				sum += rows[y*k+u] * similarityDCTCosines[v][y]
+			}
+			coefficients[v*k+u] = sum
+		}
+	}
+
+	// The DC term only reflects overall brightness, so it is left out of the median
+	sorted := append([]float64(nil), coefficients[1:]...)
+	sort.Float64s(sorted)
+	median := (sorted[len(sorted)/2-1] + sorted[len(sorted)/2]) / 2
+
+	var hash uint64
+	for i, coefficient := range coefficients {
+		if coefficient > median {
+			hash |= 1 << uint(i)
+		}
+	}
+	return hash
+}
+
+// Read a category's similarity index from storage, empty when none has been written
+func readSimilarityIndex(ctx context.Context, category string) (*SimilarityIndex, error) {
+	body, err := readObject(ctx, datasetStorage, similarityIndexKey(category))
+	if errors.Is(err, errObjectNotFound) {
+		return &SimilarityIndex{Category: category, Images: map[string]ImageSignature{}}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read similarity index for %s: %w", category, err)
+	}
+
+	var index SimilarityIndex
+	if err := json.Unmarshal(body, &index); err != nil {
+		return nil, fmt.Errorf("failed to parse similarity index for %s: %w", category, err)
+	}
+	if index.Images == nil {
+		index.Images = map[string]ImageSignature{}
+	}
+	return &index, nil
+}
+
+// Cached similarity index for serving API requests
+func cachedSimilarityIndexFor(ctx context.Context, category string) (*SimilarityIndex, error) {
+	similarityCacheMutex.Lock()
+	defer similarityCacheMutex.Unlock()
+
+	if cached, ok := similarityIndexCache[category]; ok && time.Since(cached.loadedAt) < similarityIndexCacheTTL {
+		return cached.index, nil
+	}
+
+	index, err := readSimilarityIndex(ctx, category)
+	if err != nil {
+		return nil, err
+	}
+	similarityIndexCache[category] = cachedSimilarityIndex{index: index, loadedAt: time.Now()}
+	return index, nil
+}
+
+// Compute signatures for label and overview images that changed since the last
+// run and store the sidecar indexes. Work stops before the invocation deadline;
+// the next run continues where this one left off.
+func runSimilarityIndexing(ctx context.Context, requestID string) (*SimilarityIndexReport, error) {
+	indexingCtx := ctx
+	if deadline, ok := ctx.Deadline(); ok {
+		var cancel context.CancelFunc
+		indexingCtx, cancel = context.WithDeadline(ctx, deadline.Add(-qualityDeadlineMargin))
+		defer cancel()
+	}
+
+	report := &SimilarityIndexReport{
+		Version:  similarityVersion,
+		Complete: true,
+	}
+
+	for _, category := range sortedCategoryIDs() {
+		categoryReport, err := indexCategorySignatures(indexingCtx, ctx, requestID, category)
+		if err != nil {
+			return nil, err
+		}
+		if categoryReport.PendingImages > 0 {
+			report.Complete = false
+		}
+		report.Categories = append(report.Categories, *categoryReport)
+	}
+
+	report.GeneratedAt = time.Now().UTC()
+	return report, nil
+}
+
+// Index the reference images of one category. indexingCtx bounds the signature
+// work, while ctx is used to load and store the index.
+func indexCategorySignatures(indexingCtx, ctx context.Context, requestID, category string) (*SimilarityIndexCategory, error) {
+	objects, err := listDatasetObjects(ctx, categoryDefinitions[category].S3Prefix, 0)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list images in category %s: %w", category, err)
+	}
+
+	previous, err := readSimilarityIndex(ctx, category)
+	if err != nil {
+		return nil, err
+	}
+
+	// Objects no longer listed drop out of the new index
+	index := &SimilarityIndex{
+		Category: category,
+		Version:  similarityVersion,
+		Images:   make(map[string]ImageSignature),
+	}
+	categoryReport := &SimilarityIndexCategory{Category: category}
+
+	var pending []objectInfo
+	for _, obj := range objects {
+		_, folder := splitProductImageKey(category, obj.Key)
+		if !isImageFile(obj.Key) || folder == "" || folderRole(folder) == folderRoleOther {
+			continue
+		}
+		categoryReport.TotalImages++
+
+		if signature, ok := previous.Images[obj.Key]; ok && signature.ETag == obj.ETag && signature.Version == similarityVersion {
+			index.Images[obj.Key] = signature
+			categoryReport.ReusedImages++
+			continue
+		}
+		pending = append(pending, obj)
+	}
+
+	var mutex sync.Mutex
+	var wg sync.WaitGroup
+	work := make(chan objectInfo)
+
+	for i := 0; i < similarityIndexWorkers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for obj := range work {
+				var signature ImageSignature
+				img, err := loadImage(indexingCtx, datasetStorage, obj.Key)
+				if err == nil {
+					signature = computeImageSignature(img)
+					signature.ETag = obj.ETag
+					signature.Version = similarityVersion
+					signature.IndexedAt = time.Now().UTC()
+				}
+
+				mutex.Lock()
+				if err != nil {
+					if indexingCtx.Err() != nil {
+						if signature, ok := previous.Images[obj.Key]; ok {
+							index.Images[obj.Key] = signature
+						}
+						categoryReport.PendingImages++
+					} else {
+						log.Printf("RequestID: %s - Warning: Failed to index %s: %v", requestID, obj.Key, err)
+						categoryReport.FailedImages++
+					}
+				} else {
+					index.Images[obj.Key] = signature
+					categoryReport.IndexedImages++
+				}
+				mutex.Unlock()
+			}
+		}()
+	}
+
+	for _, obj := range pending {
+		if obj.Size > qualityImageMaxBytes {
+			mutex.Lock()
+			categoryReport.FailedImages++
+			mutex.Unlock()
+			continue
+		}
+		if indexingCtx.Err() != nil {
+			// Keep the previous signature, if any, until the next run replaces it
+			mutex.Lock()
+			if signature, ok := previous.Images[obj.Key]; ok {
+				index.Images[obj.Key] = signature
+			}
+			categoryReport.PendingImages++
+			mutex.Unlock()
+			continue
+		}
+		work <- obj
+	}
+	close(work)
+	wg.Wait()
+
+	index.GeneratedAt = time.Now().UTC()
+	body, err := json.Marshal(index)
+	if err != nil {
+		return nil, fmt.Errorf("failed to serialize similarity index for %s: %w", category, err)
+	}
+	if err := datasetStorage.Put(ctx, similarityIndexKey(category), body, "application/json"); err != nil {
+		return nil, fmt.Errorf("failed to store similarity index for %s: %w", category, err)
+	}
+
+	similarityCacheMutex.Lock()
+	similarityIndexCache[category] = cachedSimilarityIndex{index: index, loadedAt: time.Now()}
+	similarityCacheMutex.Unlock()
+
+	log.Printf("RequestID: %s - Similarity indexing for %s: %d images, %d indexed, %d reused, %d failed, %d pending",
+		requestID, category, categoryReport.TotalImages, categoryReport.IndexedImages, categoryReport.ReusedImages, categoryReport.FailedImages, categoryReport.PendingImages)
+	return categoryReport, nil
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"math/bits"
+	"testing"
+)
+
+// Product-like test image: a white background with a coloured body, a dark panel and a gradient
+func similarityTestImage(width, height int) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			fx, fy := float64(x)/float64(width), float64(y)/float64(height)
+			c := color.RGBA{R: 255, G: 255, B: 255, A: 255}
+			switch {
+			case fx > 0.2 && fx < 0.8 && fy > 0.1 && fy < 0.35:
+				c = color.RGBA{R: 30, G: 30, B: 40, A: 255}
+			case fx > 0.2 && fx < 0.8 && fy > 0.1 && fy < 0.9:
+				c = color.RGBA{R: uint8(60 + 150*fy), G: 90, B: uint8(200 - 120*fx), A: 255}
+			}
+			img.SetRGBA(x, y, c)
+		}
+	}
+	return img
+}
+
+// Apply f to every pixel of a copy of img
+func mapPixels(img *image.RGBA, f func(color.RGBA) color.RGBA) *image.RGBA {
+	out := image.NewRGBA(img.Bounds())
+	for y := img.Bounds().Min.Y; y < img.Bounds().Max.Y; y++ {
+		for x := img.Bounds().Min.X; x < img.Bounds().Max.X; x++ {
+			out.SetRGBA(x, y, f(img.RGBAAt(x, y)))
+		}
+	}
+	return out
+}
+
+func TestCompareSignaturesIdentical(t *testing.T) {
+	signature := computeImageSignature(similarityTestImage(400, 300))
+	same := computeImageSignature(similarityTestImage(400, 300))
+
+	if signature.PHash != same.PHash {
+		t.Fatalf("PHash = %s and %s for the same image", signature.PHash, same.PHash)
+	}
+	hash, colour, score := compareSignatures(signature, same)
+	if hash != 1 || colour != 1 || score != 1 {
+		t.Errorf("compareSignatures(identical) = (%v, %v, %v), want 1", hash, colour, score)
+	}
+}
+
+func TestCompareSignaturesTransforms(t *testing.T) {
+	original := similarityTestImage(400, 300)
+	signature := computeImageSignature(original)
+
+	tests := []struct {
+		name     string
+		img      image.Image
+		minScore float64
+		maxScore float64
+	}{
+		{
+			name:     "resized",
+			img:      similarityTestImage(1200, 900),
+			minScore: 0.9,
+			maxScore: 1,
+		},
+		{
+			name: "darker",
+			img: mapPixels(original, func(c color.RGBA) color.RGBA {
+				return color.RGBA{R: c.R - c.R/10, G: c.G - c.G/10, B: c.B - c.B/10, A: 255}
+			}),
+			minScore: 0.7,
+			maxScore: 1,
+		},
+		{
+			name: "inverted",
+			img: mapPixels(original, func(c color.RGBA) color.RGBA {
+				return color.RGBA{R: 255 - c.R, G: 255 - c.G, B: 255 - c.B, A: 255}
+			}),
+			minScore: 0,
+			maxScore: 0.3,
+		},
+		{
+			name: "upside down",
+			img: func() image.Image {
+				out := image.NewRGBA(original.Bounds())
+				for y := 0; y < 300; y++ {
+					for x := 0; x < 400; x++ {
+						out.SetRGBA(x, 299-y, original.RGBAAt(x, y))
+					}
+				}
+				return out
+			}(),
+			minScore: 0,
+			maxScore: 0.85,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hash, colour, score := compareSignatures(signature, computeImageSignature(tt.img))
+			if score < tt.minScore || score > tt.maxScore {
+				t.Errorf("compareSignatures() = (%v, %v, %v), want a score in [%v, %v]", hash, colour, score, tt.minScore, tt.maxScore)
+			}
+		})
+	}
+}
+
+func TestPerceptualHash(t *testing.T) {
+	grid := make([]float64, similarityHashSide*similarityHashSide)
+	for y := 0; y < similarityHashSide; y++ {
+		for x := 0; x < similarityHashSide; x++ {
+			grid[y*similarityHashSide+x] = float64((x*7 + y*13) % 256)
+		}
+	}
+
+	// Brightness only moves the DC term, which is left out of the median
+	brighter := make([]float64, len(grid))
+	inverted := make([]float64, len(grid))
+	for i, value := range grid {
+		brighter[i] = value + 40
+		inverted[i] = 255 - value
+	}
+
+	hash := perceptualHash(grid)
+	if got := perceptualHash(brighter); got != hash {
+		t.Errorf("perceptualHash(brighter) = %016x, want %016x", got, hash)
+	}
+
+	// Inverting negates every AC coefficient, so all bits but the DC bit flip
+	flipped := hash ^ perceptualHash(inverted)
+	if ones := bits.OnesCount64(flipped); ones < 60 {
+		t.Errorf("perceptualHash(inverted) differs in %d bits, want at least 60", ones)
+	}
+}
+
+func TestCompareSignaturesInvalid(t *testing.T) {
+	valid := computeImageSignature(similarityTestImage(100, 100))
+
+	hash, colour, _ := compareSignatures(valid, ImageSignature{PHash: "not hex", Histogram: valid.Histogram})
+	if hash != 0 || colour != 1 {
+		t.Errorf("compareSignatures(bad hash) = (%v, %v), want hash 0 and colour 1", hash, colour)
+	}
+
+	hash, colour, _ = compareSignatures(valid, ImageSignature{PHash: valid.PHash, Histogram: valid.Histogram[:8]})
+	if hash != 1 || colour != 0 {
+		t.Errorf("compareSignatures(short histogram) = (%v, %v), want hash 1 and colour 0", hash, colour)
+	}
+}
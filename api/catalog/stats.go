@@ -0,0 +1,288 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Folder roles used when counting images
+const (
+	folderRoleLabel    = "label"
+	folderRoleOverview = "overview"
+	folderRoleOther    = "other"
+)
+
+type CategoryStatistics struct {
+	Category               string         `json:"category"`
+	TotalProducts          int            `json:"totalProducts"`
+	CompleteProducts       int            `json:"completeProducts"`
+	CompletenessPercentage float64        `json:"completenessPercentage"`
+	TotalImages            int            `json:"totalImages"`
+	ImagesByFolderRole     map[string]int `json:"imagesByFolderRole"`
+	TotalBytes             int64          `json:"totalBytes"`
+	NewestUpload           *time.Time     `json:"newestUpload,omitempty"`
+}
+
+type StatsMetadata struct {
+	TotalCategories int       `json:"totalCategories"`
+	ScannedAt       time.Time `json:"scannedAt"`
+	Bucket          string    `json:"bucket"`
+}
+
+type StatsSnapshot struct {
+	SnapshotID string               `json:"snapshotId"`
+	CreatedAt  time.Time            `json:"createdAt"`
+	Categories []CategoryStatistics `json:"categories"`
+}
+
+type StatsHistoryMetadata struct {
+	TotalSnapshots int       `json:"totalSnapshots"`
+	ScannedAt      time.Time `json:"scannedAt"`
+}
+
+// Classify a product subfolder into a folder role
+func folderRole(folder string) string {
+	switch folder {
+	case "TEM NL":
+		return folderRoleLabel
+	case "CHÍNH DIỆN", "HÌNH WEB":
+		return folderRoleOverview
+	default:
+		return folderRoleOther
+	}
+}
+
+// Handle per-category dataset statistics operation
+func handleStatsDiscovery(ctx context.Context, requestID string, queryParams map[string]string) (*CatalogResponse, error) {
+	categoryIDs, err := resolveStatsCategories(queryParams["category"])
+	if err != nil {
+		return nil, err
+	}
+
+	log.Printf("RequestID: %s - Starting statistics scan for categories: %v", requestID, categoryIDs)
+
+	statistics, err := collectCategoryStatistics(ctx, requestID, categoryIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	response := &CatalogResponse{
+		Type: "stats",
+		Data: statistics,
+		Metadata: StatsMetadata{
+			TotalCategories: len(statistics),
+			ScannedAt:       time.Now(),
+			Bucket:          appConfig.DatasetBucket,
+		},
+	}
+
+	log.Printf("RequestID: %s - Statistics scan completed for %d categories", requestID, len(statistics))
+	return response, nil
+}
+
+// Handle stored statistics snapshots view, oldest first for charting
+func handleStatsHistory(ctx context.Context, requestID string, queryParams map[string]string) (*CatalogResponse, error) {
+	weeks := 26
+	if value := queryParams["weeks"]; value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed < 1 || parsed > 520 {
+			return nil, newBadRequest("INVALID_PARAMETER", "Invalid 'weeks' parameter: %s. Must be between 1 and 520", value)
+		}
+		weeks = parsed
+	}
+
+	log.Printf("RequestID: %s - Loading up to %d statistics snapshots", requestID, weeks)
+
+	snapshots, err := loadStatsSnapshots(ctx, requestID, weeks)
+	if err != nil {
+		return nil, err
+	}
+
+	response := &CatalogResponse{
+		Type: "statsHistory",
+		Data: snapshots,
+		Metadata: StatsHistoryMetadata{
+			TotalSnapshots: len(snapshots),
+			ScannedAt:      time.Now(),
+		},
+	}
+
+	log.Printf("RequestID: %s - Loaded %d statistics snapshots", requestID, len(snapshots))
+	return response, nil
+}
+
+// Resolve the categories to scan, all categories when none is given
+func resolveStatsCategories(category string) ([]string, error) {
+	if category != "" {
+		if _, exists := categoryDefinitions[category]; !exists {
+			return nil, newBadRequest("INVALID_CATEGORY", "Invalid 'category' parameter: %s", category)
+		}
+		return []string{category}, nil
+	}
+
+	return sortedCategoryIDs(), nil
+}
+
+// Collect statistics for each category in order
+func collectCategoryStatistics(ctx context.Context, requestID string, categoryIDs []string) ([]CategoryStatistics, error) {
+	statistics := make([]CategoryStatistics, 0, len(categoryIDs))
+	for _, categoryID := range categoryIDs {
+		stats, err := computeCategoryStatistics(ctx, requestID, categoryID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to compute statistics for category %s: %w", categoryID, err)
+		}
+		statistics = append(statistics, stats)
+	}
+	return statistics, nil
+}
+
+// Walk every object in a category and aggregate counts, bytes and completeness
+func computeCategoryStatistics(ctx context.Context, requestID, categoryID string) (CategoryStatistics, error) {
+	categoryPrefix := categoryDefinitions[categoryID].S3Prefix
+
+	stats := CategoryStatistics{
+		Category: categoryID,
+		ImagesByFolderRole: map[string]int{
+			folderRoleLabel:    0,
+			folderRoleOverview: 0,
+			folderRoleOther:    0,
+		},
+	}
+
+	// Products are the category's child prefixes, as in the product listing; files
+	// directly under the category prefix are not products. Folder roles seen per product
+	// are used for completeness.
+	productPrefixes, err := listDatasetPrefixes(ctx, categoryPrefix)
+	if err != nil {
+		return stats, err
+	}
+	productRoles := make(map[string]map[string]bool, len(productPrefixes))
+	for _, prefix := range productPrefixes {
+		productRoles[strings.TrimPrefix(strings.TrimSuffix(prefix, "/"), categoryPrefix)] = make(map[string]bool)
+	}
+
+	objects, err := listDatasetObjects(ctx, categoryPrefix, 0)
+	if err != nil {
+		return stats, err
+	}
+
+	for _, obj := range objects {
+		parts := strings.Split(strings.TrimPrefix(obj.Key, categoryPrefix), "/")
+		productID := parts[0]
+		if len(parts) < 3 || productRoles[productID] == nil || !isImageFile(obj.Key) {
+			continue
+		}
+
+		role := folderRole(parts[1])
+		productRoles[productID][role] = true
+
+		stats.TotalImages++
+		stats.ImagesByFolderRole[role]++
+		stats.TotalBytes += obj.Size
+
+		if stats.NewestUpload == nil || obj.LastModified.After(*stats.NewestUpload) {
+			newest := obj.LastModified
+			stats.NewestUpload = &newest
+		}
+	}
+
+	stats.TotalProducts = len(productRoles)
+	for _, roles := range productRoles {
+		if roles[folderRoleLabel] && roles[folderRoleOverview] {
+			stats.CompleteProducts++
+		}
+	}
+	if stats.TotalProducts > 0 {
+		stats.CompletenessPercentage = float64(stats.CompleteProducts) / float64(stats.TotalProducts) * 100
+	}
+
+	log.Printf("RequestID: %s - Category %s statistics: %d products (%d complete), %d images, %d bytes",
+		requestID, categoryID, stats.TotalProducts, stats.CompleteProducts, stats.TotalImages, stats.TotalBytes)
+	return stats, nil
+}
+
+// Snapshot identifier for the ISO week containing t, e.g. "2026-W42"
+func statsSnapshotID(t time.Time) string {
+	year, week := t.ISOWeek()
+	return fmt.Sprintf("%d-W%02d", year, week)
+}
+
+func statsSnapshotPrefix() string {
+	return appConfig.MetaPrefix + "stats/snapshots/"
+}
+
+// Compute statistics for all categories and store them as this week's snapshot
+func persistStatsSnapshot(ctx context.Context, requestID string) (*StatsSnapshot, error) {
+	categoryIDs, _ := resolveStatsCategories("")
+
+	statistics, err := collectCategoryStatistics(ctx, requestID, categoryIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now().UTC()
+	snapshot := &StatsSnapshot{
+		SnapshotID: statsSnapshotID(now),
+		CreatedAt:  now,
+		Categories: statistics,
+	}
+
+	body, err := json.Marshal(snapshot)
+	if err != nil {
+		return nil, fmt.Errorf("failed to serialize statistics snapshot: %w", err)
+	}
+
+	// One object per ISO week, so a re-run within the week replaces it
+	key := statsSnapshotPrefix() + snapshot.SnapshotID + ".json"
+	if err := datasetStorage.Put(ctx, key, body, "application/json"); err != nil {
+		return nil, fmt.Errorf("failed to store statistics snapshot %s: %w", key, err)
+	}
+
+	log.Printf("RequestID: %s - Statistics snapshot stored: %s", requestID, key)
+	return snapshot, nil
+}
+
+// Load the most recent snapshots, returned oldest first
+func loadStatsSnapshots(ctx context.Context, requestID string, limit int) ([]StatsSnapshot, error) {
+	objects, err := datasetStorage.ListObjects(ctx, statsSnapshotPrefix(), 0)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list statistics snapshots: %w", err)
+	}
+
+	var keys []string
+	for _, obj := range objects {
+		if strings.HasSuffix(obj.Key, ".json") {
+			keys = append(keys, obj.Key)
+		}
+	}
+
+	// Snapshot IDs sort chronologically
+	sort.Strings(keys)
+	if len(keys) > limit {
+		keys = keys[len(keys)-limit:]
+	}
+
+	snapshots := make([]StatsSnapshot, 0, len(keys))
+	for _, key := range keys {
+		body, err := readObject(ctx, datasetStorage, key)
+		if err != nil {
+			log.Printf("RequestID: %s - Warning: Failed to read statistics snapshot %s: %v", requestID, key, err)
+			continue
+		}
+
+		var snapshot StatsSnapshot
+		if err := json.Unmarshal(body, &snapshot); err != nil {
+			log.Printf("RequestID: %s - Warning: Failed to parse statistics snapshot %s: %v", requestID, key, err)
+			continue
+		}
+		snapshots = append(snapshots, snapshot)
+	}
+
+	return snapshots, nil
+}
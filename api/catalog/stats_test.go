@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestComputeCategoryStatistics(t *testing.T) {
+	ctx := context.Background()
+
+	storage, err := newLocalStorage(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	saved := datasetStorage
+	datasetStorage = storage
+	t.Cleanup(func() { datasetStorage = saved })
+
+	prefix := categoryDefinitions["REF"].S3Prefix
+	for key, size := range map[string]int{
+		"P1/TEM NL/label.jpg":      100,
+		"P1/CHÍNH DIỆN/front.png":  200,
+		"P2/HÌNH WEB/web.JPG":      50,
+		"P2/HÌNH WEB/notes.txt":    10,
+		"P3/readme.txt":            5,
+		"P4/OTHER/side.webp":       25,
+		"readme.txt":               5,
+		"cover.jpg":                40,
+		"P5/TEM NL/nested/old.jpg": 30,
+	} {
+		if err := storage.Put(ctx, prefix+key, []byte(strings.Repeat("x", size)), "application/octet-stream"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	stats, err := computeCategoryStatistics(ctx, "test", "REF")
+	if err != nil {
+		t.Fatalf("computeCategoryStatistics() returned error: %v", err)
+	}
+
+	// Files directly under the category prefix are not products; P3 has no images but is one
+	if stats.TotalProducts != 5 || stats.CompleteProducts != 1 || stats.CompletenessPercentage != 20 {
+		t.Errorf("products = %d total, %d complete (%v%%), want 5, 1 (20%%)",
+			stats.TotalProducts, stats.CompleteProducts, stats.CompletenessPercentage)
+	}
+	if stats.TotalImages != 5 || stats.TotalBytes != 405 {
+		t.Errorf("images = %d of %d bytes, want 5 of 405 bytes", stats.TotalImages, stats.TotalBytes)
+	}
+	wantRoles := map[string]int{folderRoleLabel: 2, folderRoleOverview: 2, folderRoleOther: 1}
+	if !reflect.DeepEqual(stats.ImagesByFolderRole, wantRoles) {
+		t.Errorf("ImagesByFolderRole = %v, want %v", stats.ImagesByFolderRole, wantRoles)
+	}
+	if stats.NewestUpload == nil {
+		t.Errorf("NewestUpload is not set")
+	}
+
+	empty, err := computeCategoryStatistics(ctx, "test", "WM")
+	if err != nil {
+		t.Fatalf("computeCategoryStatistics(empty) returned error: %v", err)
+	}
+	if empty.TotalProducts != 0 || empty.TotalImages != 0 || empty.CompletenessPercentage != 0 || empty.NewestUpload != nil {
+		t.Errorf("computeCategoryStatistics(empty) = %+v, want no products or images", empty)
+	}
+}
@@ -0,0 +1,324 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// Storage backends selected with STORAGE_BACKEND
+const (
+	storageBackendS3    = "s3"    // AWS S3, or any S3-compatible endpoint when S3_ENDPOINT_URL is set
+	storageBackendLocal = "local" // Directory on disk, for offline development
+)
+
+var errObjectNotFound = errors.New("object not found")
+
+// Object storage operations used by the catalog. Keys always use "/" separators.
+type objectStorage interface {
+	// Direct child prefixes of prefix, each ending in "/"
+	ListPrefixes(ctx context.Context, prefix string) ([]string, error)
+	// Objects under prefix in key order, recursively. A limit of 0 lists everything.
+	ListObjects(ctx context.Context, prefix string, limit int) ([]objectInfo, error)
+	// Object metadata, errObjectNotFound when missing
+	Head(ctx context.Context, key string) (*objectInfo, error)
+	// Time-limited URL for downloading the object
+	PresignGet(ctx context.Context, key string, expiry time.Duration) (string, error)
+	// Read length bytes from offset; a negative length reads to the end
+	GetRange(ctx context.Context, key string, offset, length int64) (io.ReadCloser, error)
+	Put(ctx context.Context, key string, body []byte, contentType string) error
+}
+
+// Object version as kept by a versioning-enabled bucket
+type objectVersion struct {
+	objectInfo
+	VersionID      string
+	IsLatest       bool
+	IsDeleteMarker bool
+}
+
+// Implemented by backends that keep object versions (S3 with versioning enabled)
+type versionedStorage interface {
+	// Versions and delete markers under prefix, by key then newest first
+	ListVersions(ctx context.Context, prefix string) ([]objectVersion, error)
+	PresignGetVersion(ctx context.Context, key, versionID string, expiry time.Duration) (string, error)
+	// Copy a previous version over the key, making it current; returns the new version
+	RestoreVersion(ctx context.Context, key, versionID string) (*objectVersion, error)
+}
+
+// Backends for the dataset bucket, the inventory report bucket and the bucket of uploaded images
+var (
+	datasetStorage    objectStorage
+	inventoryStorage  objectStorage
+	validationStorage objectStorage
+)
+
+// Create the configured storage backend for a bucket
+func newObjectStorage(bucket string) (objectStorage, error) {
+	switch appConfig.StorageBackend {
+	case storageBackendS3:
+		return &s3Storage{client: s3Client, presign: presignClient, bucket: bucket}, nil
+	case storageBackendLocal:
+		return newLocalStorage(appConfig.LocalRoot)
+	default:
+		return nil, fmt.Errorf("unsupported storage backend: %s", appConfig.StorageBackend)
+	}
+}
+
+// Read a whole object into memory
+func readObject(ctx context.Context, storage objectStorage, key string) ([]byte, error) {
+	body, err := storage.GetRange(ctx, key, 0, -1)
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+
+	return io.ReadAll(body)
+}
+
+// S3 and S3-compatible (MinIO, Ceph, ...) storage
+type s3Storage struct {
+	client  *s3.Client
+	presign *s3.PresignClient
+	bucket  string
+}
+
+func (s *s3Storage) ListPrefixes(ctx context.Context, prefix string) ([]string, error) {
+	input := &s3.ListObjectsV2Input{
+		Bucket:    aws.String(s.bucket),
+		Prefix:    aws.String(prefix),
+		Delimiter: aws.String("/"),
+	}
+
+	var prefixes []string
+	paginator := s3.NewListObjectsV2Paginator(s.client, input)
+
+	for paginator.HasMorePages() {
+		result, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list prefixes in %s: %w", prefix, err)
+		}
+
+		for _, commonPrefix := range result.CommonPrefixes {
+			prefixes = append(prefixes, aws.ToString(commonPrefix.Prefix))
+		}
+	}
+
+	return prefixes, nil
+}
+
+func (s *s3Storage) ListObjects(ctx context.Context, prefix string, limit int) ([]objectInfo, error) {
+	input := &s3.ListObjectsV2Input{
+		Bucket: aws.String(s.bucket),
+		Prefix: aws.String(prefix),
+	}
+	if limit > 0 && limit < 1000 {
+		input.MaxKeys = aws.Int32(int32(limit))
+	}
+
+	var objects []objectInfo
+	paginator := s3.NewListObjectsV2Paginator(s.client, input)
+
+	for paginator.HasMorePages() {
+		result, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list objects in %s: %w", prefix, err)
+		}
+
+		for _, obj := range result.Contents {
+			objects = append(objects, objectInfo{
+				Key:          aws.ToString(obj.Key),
+				Size:         aws.ToInt64(obj.Size),
+				LastModified: aws.ToTime(obj.LastModified),
+				ETag:         strings.Trim(aws.ToString(obj.ETag), "\""),
+			})
+			if limit > 0 && len(objects) >= limit {
+				return objects, nil
+			}
+		}
+	}
+
+	return objects, nil
+}
+
+func (s *s3Storage) Head(ctx context.Context, key string) (*objectInfo, error) {
+	result, err := s.client.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		var notFound *types.NotFound
+		if errors.As(err, &notFound) {
+			return nil, errObjectNotFound
+		}
+		return nil, fmt.Errorf("failed to head object %s: %w", key, err)
+	}
+
+	return &objectInfo{
+		Key:          key,
+		Size:         aws.ToInt64(result.ContentLength),
+		LastModified: aws.ToTime(result.LastModified),
+		ETag:         strings.Trim(aws.ToString(result.ETag), "\""),
+	}, nil
+}
+
+func (s *s3Storage) PresignGet(ctx context.Context, key string, expiry time.Duration) (string, error) {
+	request, err := s.presign.PresignGetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(key),
+	}, func(opts *s3.PresignOptions) {
+		opts.Expires = expiry
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to generate presigned URL: %w", err)
+	}
+
+	return request.URL, nil
+}
+
+func (s *s3Storage) GetRange(ctx context.Context, key string, offset, length int64) (io.ReadCloser, error) {
+	input := &s3.GetObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(key),
+	}
+	if length >= 0 {
+		input.Range = aws.String(fmt.Sprintf("bytes=%d-%d", offset, offset+length-1))
+	} else if offset > 0 {
+		input.Range = aws.String(fmt.Sprintf("bytes=%d-", offset))
+	}
+
+	result, err := s.client.GetObject(ctx, input)
+	if err != nil {
+		var noSuchKey *types.NoSuchKey
+		if errors.As(err, &noSuchKey) {
+			return nil, errObjectNotFound
+		}
+		return nil, fmt.Errorf("failed to get object %s: %w", key, err)
+	}
+
+	return result.Body, nil
+}
+
+func (s *s3Storage) ListVersions(ctx context.Context, prefix string) ([]objectVersion, error) {
+	input := &s3.ListObjectVersionsInput{
+		Bucket: aws.String(s.bucket),
+		Prefix: aws.String(prefix),
+	}
+
+	var versions []objectVersion
+	for {
+		result, err := s.client.ListObjectVersions(ctx, input)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list object versions in %s: %w", prefix, err)
+		}
+
+		for _, version := range result.Versions {
+			versions = append(versions, objectVersion{
+				objectInfo: objectInfo{
+					Key:          aws.ToString(version.Key),
+					Size:         aws.ToInt64(version.Size),
+					LastModified: aws.ToTime(version.LastModified),
+					ETag:         strings.Trim(aws.ToString(version.ETag), "\""),
+				},
+				VersionID: aws.ToString(version.VersionId),
+				IsLatest:  aws.ToBool(version.IsLatest),
+			})
+		}
+		for _, marker := range result.DeleteMarkers {
+			versions = append(versions, objectVersion{
+				objectInfo: objectInfo{
+					Key:          aws.ToString(marker.Key),
+					LastModified: aws.ToTime(marker.LastModified),
+				},
+				VersionID:      aws.ToString(marker.VersionId),
+				IsLatest:       aws.ToBool(marker.IsLatest),
+				IsDeleteMarker: true,
+			})
+		}
+
+		if !aws.ToBool(result.IsTruncated) {
+			break
+		}
+		input.KeyMarker = result.NextKeyMarker
+		input.VersionIdMarker = result.NextVersionIdMarker
+	}
+
+	// Versions and delete markers come back in separate lists
+	sort.SliceStable(versions, func(i, j int) bool {
+		if versions[i].Key != versions[j].Key {
+			return versions[i].Key < versions[j].Key
+		}
+		return versions[i].LastModified.After(versions[j].LastModified)
+	})
+
+	return versions, nil
+}
+
+func (s *s3Storage) PresignGetVersion(ctx context.Context, key, versionID string, expiry time.Duration) (string, error) {
+	request, err := s.presign.PresignGetObject(ctx, &s3.GetObjectInput{
+		Bucket:    aws.String(s.bucket),
+		Key:       aws.String(key),
+		VersionId: aws.String(versionID),
+	}, func(opts *s3.PresignOptions) {
+		opts.Expires = expiry
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to generate presigned URL: %w", err)
+	}
+
+	return request.URL, nil
+}
+
+func (s *s3Storage) RestoreVersion(ctx context.Context, key, versionID string) (*objectVersion, error) {
+	// CopySource is "bucket/key?versionId=id" with the bucket and key URL-encoded
+	segments := strings.Split(key, "/")
+	for i, segment := range segments {
+		segments[i] = url.PathEscape(segment)
+	}
+	copySource := s.bucket + "/" + strings.Join(segments, "/") + "?versionId=" + url.QueryEscape(versionID)
+
+	result, err := s.client.CopyObject(ctx, &s3.CopyObjectInput{
+		Bucket:     aws.String(s.bucket),
+		Key:        aws.String(key),
+		CopySource: aws.String(copySource),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to restore version %s of %s: %w", versionID, key, err)
+	}
+
+	restored := &objectVersion{
+		objectInfo: objectInfo{Key: key},
+		VersionID:  aws.ToString(result.VersionId),
+		IsLatest:   true,
+	}
+	if result.CopyObjectResult != nil {
+		restored.ETag = strings.Trim(aws.ToString(result.CopyObjectResult.ETag), "\"")
+		restored.LastModified = aws.ToTime(result.CopyObjectResult.LastModified)
+	}
+
+	return restored, nil
+}
+
+func (s *s3Storage) Put(ctx context.Context, key string, body []byte, contentType string) error {
+	_, err := s.client.PutObject(ctx, &s3.PutObjectInput{
+		Bucket:      aws.String(s.bucket),
+		Key:         aws.String(key),
+		Body:        bytes.NewReader(body),
+		ContentType: aws.String(contentType),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to put object %s: %w", key, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,230 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Storage backed by a local directory, where object keys are paths relative to
+// the root. Presigned URLs point at the local server's /objects/ route.
+type localStorage struct {
+	root string
+}
+
+func newLocalStorage(root string) (*localStorage, error) {
+	if root == "" {
+		return nil, fmt.Errorf("CATALOG_LOCAL_ROOT is required for the local storage backend")
+	}
+
+	absRoot, err := filepath.Abs(root)
+	if err != nil {
+		return nil, fmt.Errorf("invalid local storage root %s: %w", root, err)
+	}
+	info, err := os.Stat(absRoot)
+	if err != nil || !info.IsDir() {
+		return nil, fmt.Errorf("local storage root %s is not a directory", absRoot)
+	}
+
+	return &localStorage{root: absRoot}, nil
+}
+
+// Map a key to a file path inside the root
+func (s *localStorage) path(key string) (string, error) {
+	cleaned := path.Clean("/" + key)
+	if cleaned == "/" || strings.Contains(key, "\\") || cleaned != "/"+strings.TrimSuffix(key, "/") {
+		return "", fmt.Errorf("invalid object key: %s", key)
+	}
+	return filepath.Join(s.root, filepath.FromSlash(cleaned)), nil
+}
+
+// Directory to walk for a prefix: the part up to its last "/"
+func (s *localStorage) prefixDir(prefix string) (string, error) {
+	dir := prefix[:strings.LastIndex(prefix, "/")+1]
+	if dir == "" {
+		return s.root, nil
+	}
+	return s.path(dir)
+}
+
+func (s *localStorage) objectInfo(key string, info fs.FileInfo) objectInfo {
+	return objectInfo{
+		Key:          key,
+		Size:         info.Size(),
+		LastModified: info.ModTime().UTC(),
+		// Cheap ETag that changes whenever the file is rewritten
+		ETag: fmt.Sprintf("%x-%x", info.ModTime().UnixNano(), info.Size()),
+	}
+}
+
+func (s *localStorage) ListPrefixes(ctx context.Context, prefix string) ([]string, error) {
+	dir, err := s.prefixDir(prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	entries, err := os.ReadDir(dir)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to list prefixes in %s: %w", prefix, err)
+	}
+
+	base := prefix[:strings.LastIndex(prefix, "/")+1]
+	var prefixes []string
+	for _, entry := range entries {
+		childPrefix := base + entry.Name() + "/"
+		if entry.IsDir() && strings.HasPrefix(childPrefix, prefix) {
+			prefixes = append(prefixes, childPrefix)
+		}
+	}
+
+	sort.Strings(prefixes)
+	return prefixes, nil
+}
+
+func (s *localStorage) ListObjects(ctx context.Context, prefix string, limit int) ([]objectInfo, error) {
+	dir, err := s.prefixDir(prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	var objects []objectInfo
+	err = filepath.WalkDir(dir, func(filePath string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				return nil
+			}
+			return err
+		}
+		if entry.IsDir() || strings.HasPrefix(entry.Name(), ".") {
+			return nil
+		}
+
+		rel, err := filepath.Rel(s.root, filePath)
+		if err != nil {
+			return err
+		}
+		key := filepath.ToSlash(rel)
+		if !strings.HasPrefix(key, prefix) {
+			return nil
+		}
+
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		objects = append(objects, s.objectInfo(key, info))
+		return ctx.Err()
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list objects in %s: %w", prefix, err)
+	}
+
+	// Match S3 ordering, which is by full key rather than per directory
+	sort.Slice(objects, func(i, j int) bool { return objects[i].Key < objects[j].Key })
+	if limit > 0 && len(objects) > limit {
+		objects = objects[:limit]
+	}
+
+	return objects, nil
+}
+
+func (s *localStorage) Head(ctx context.Context, key string) (*objectInfo, error) {
+	filePath, err := s.path(key)
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := os.Stat(filePath)
+	if errors.Is(err, fs.ErrNotExist) || (err == nil && info.IsDir()) {
+		return nil, errObjectNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to stat %s: %w", key, err)
+	}
+
+	obj := s.objectInfo(key, info)
+	return &obj, nil
+}
+
+func (s *localStorage) PresignGet(ctx context.Context, key string, expiry time.Duration) (string, error) {
+	expires := strconv.FormatInt(time.Now().Add(expiry).Unix(), 10)
+	escapedKey := (&url.URL{Path: key}).EscapedPath()
+
+	return fmt.Sprintf("%s/objects/%s?expires=%s&signature=%s",
+		strings.TrimSuffix(appConfig.LocalPublicURL, "/"), escapedKey, expires, localObjectSignature(key, expires)), nil
+}
+
+func (s *localStorage) GetRange(ctx context.Context, key string, offset, length int64) (io.ReadCloser, error) {
+	filePath, err := s.path(key)
+	if err != nil {
+		return nil, err
+	}
+
+	file, err := os.Open(filePath)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, errObjectNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to open %s: %w", key, err)
+	}
+
+	if offset > 0 {
+		if _, err := file.Seek(offset, io.SeekStart); err != nil {
+			file.Close()
+			return nil, fmt.Errorf("failed to seek %s: %w", key, err)
+		}
+	}
+	if length < 0 {
+		return file, nil
+	}
+
+	return struct {
+		io.Reader
+		io.Closer
+	}{io.LimitReader(file, length), file}, nil
+}
+
+func (s *localStorage) Put(ctx context.Context, key string, body []byte, contentType string) error {
+	filePath, err := s.path(key)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(filePath), 0o755); err != nil {
+		return fmt.Errorf("failed to create directory for %s: %w", key, err)
+	}
+
+	// Write then rename so readers never see a partial object
+	tmpPath := filepath.Join(filepath.Dir(filePath), "."+filepath.Base(filePath)+".tmp")
+	if err := os.WriteFile(tmpPath, body, 0o644); err != nil {
+		return fmt.Errorf("failed to write %s: %w", key, err)
+	}
+	if err := os.Rename(tmpPath, filePath); err != nil {
+		return fmt.Errorf("failed to write %s: %w", key, err)
+	}
+
+	return nil
+}
+
+// Signature of a local presigned URL
+func localObjectSignature(key, expires string) string {
+	mac := hmac.New(sha256.New, appConfig.LocalSigningKey)
+	mac.Write([]byte(key + "\n" + expires))
+	return hex.EncodeToString(mac.Sum(nil))
+}
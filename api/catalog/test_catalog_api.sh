@@ -60,6 +60,58 @@ curl -X GET \
 echo ""
 echo "✓ Images discovery test completed"
 
+# Test 4: Malicious key components must be rejected with HTTP 400
+echo ""
+echo "🔍 Test 4: Rejecting malicious productId and folder values"
+echo "-------------------------------------------"
+MALICIOUS_VALUES=(
+  "../../"
+  ".."
+  "."
+  "AQR/../../validation"
+  "AQR%2F..%2F..%2F"
+  "..%2F..%2Fsecrets"
+  "AQR%5C..%5C"
+  "%00"
+  "AQR%0D%0AX-Injected:%201"
+  "%E2%80%AEgpj.exe"
+  "%C0%AF"
+  "%20AQR"
+  "$(head -c 300 /dev/zero | tr '\0' 'A')"
+)
+FAILURES=0
+for value in "${MALICIOUS_VALUES[@]}"; do
+  for param in productId folder; do
+    if [ "$param" = "productId" ]; then
+      query="type=images&category=$PRODUCT_CATEGORY&productId=$value"
+    else
+      query="type=images&category=$PRODUCT_CATEGORY&productId=$PRODUCT_ID&folder=$value"
+    fi
+    status=$(curl -s -o /dev/null -w "%{http_code}" \
+      "${API_GATEWAY_ENDPOINT}?${query}" \
+      -H "x-api-key: $API_KEY")
+    if [ "$status" != "400" ]; then
+      echo "✗ $param=${value:0:40} returned HTTP $status, expected 400"
+      FAILURES=$((FAILURES + 1))
+    fi
+  done
+done
+status=$(curl -s -o /dev/null -w "%{http_code}" \
+  "${API_GATEWAY_ENDPOINT}?type=products&category=..%2FREF" \
+  -H "x-api-key: $API_KEY")
+if [ "$status" != "400" ]; then
+  echo "✗ category=../REF returned HTTP $status, expected 400"
+  FAILURES=$((FAILURES + 1))
+fi
+
+if [ "$FAILURES" -gt 0 ]; then
+  echo "✗ $FAILURES malicious inputs were not rejected"
+  exit 1
+fi
+
+echo ""
+echo "✓ Malicious input test completed"
+
 echo ""
 echo "==========================================="
 echo "All Catalog API tests completed!"
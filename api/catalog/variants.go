@@ -0,0 +1,255 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Default colour-variant pattern: "AQR-B360MA(SLB)" -> base "AQR-B360MA", variant "SLB"
+const defaultProductVariantPattern = `^(?P<base>.+?)\s*\((?P<variant>[^()]+)\)$`
+
+var (
+	productVariantPattern *regexp.Regexp
+	variantFallbackOrder  []string
+)
+
+type ProductVariant struct {
+	Variant string  `json:"variant"`
+	Product Product `json:"product"`
+}
+
+type ProductGroup struct {
+	BaseModel string           `json:"baseModel"`
+	Category  string           `json:"category"`
+	Variants  []ProductVariant `json:"variants"`
+}
+
+type ProductGroupsMetadata struct {
+	Category      string    `json:"category"`
+	TotalGroups   int       `json:"totalGroups"`
+	TotalProducts int       `json:"totalProducts"`
+	VariantRegex  string    `json:"variantPattern"`
+	ScannedAt     time.Time `json:"scannedAt"`
+}
+
+// Load the product ID variant pattern and fallback preference from the environment
+func loadVariantConfig() {
+	pattern := os.Getenv("PRODUCT_VARIANT_PATTERN")
+	if pattern == "" {
+		pattern = defaultProductVariantPattern
+	}
+
+	compiled, err := regexp.Compile(pattern)
+	if err == nil && (compiled.SubexpIndex("base") < 0 || compiled.SubexpIndex("variant") < 0) {
+		err = fmt.Errorf("pattern must define named groups 'base' and 'variant'")
+	}
+	if err != nil {
+		log.Printf("Warning: Invalid PRODUCT_VARIANT_PATTERN %q, using default: %v", pattern, err)
+		compiled = regexp.MustCompile(defaultProductVariantPattern)
+	}
+	productVariantPattern = compiled
+
+	variantFallbackOrder = nil
+	for _, variant := range strings.Split(os.Getenv("PRODUCT_VARIANT_FALLBACK_ORDER"), ",") {
+		if variant = strings.TrimSpace(variant); variant != "" {
+			variantFallbackOrder = append(variantFallbackOrder, variant)
+		}
+	}
+}
+
+// Split a product ID into its base model and colour variant
+func parseProductVariant(productID string) (string, string) {
+	match := productVariantPattern.FindStringSubmatch(productID)
+	if match == nil {
+		return productID, ""
+	}
+
+	return strings.TrimSpace(match[productVariantPattern.SubexpIndex("base")]),
+		strings.TrimSpace(match[productVariantPattern.SubexpIndex("variant")])
+}
+
+// Handle grouped products view, listing colour variants under each base model
+func handleProductGroupsDiscovery(ctx context.Context, requestID string, queryParams map[string]string) (*CatalogResponse, error) {
+	category := queryParams["category"]
+	if category == "" {
+		return nil, newBadRequest("MISSING_PARAMETER", "Missing required 'category' parameter for product groups discovery")
+	}
+
+	categoryDef := categoryDefinitions[category]
+
+	log.Printf("RequestID: %s - Starting product groups discovery for category: %s", requestID, category)
+
+	products, err := discoverProductsInCategory(ctx, requestID, categoryDef.S3Prefix, category)
+	if err != nil {
+		return nil, fmt.Errorf("failed to discover products in category %s: %w", category, err)
+	}
+
+	groups := groupProductsByBaseModel(category, products)
+
+	response := &CatalogResponse{
+		Type: "productGroups",
+		Data: groups,
+		Metadata: ProductGroupsMetadata{
+			Category:      category,
+			TotalGroups:   len(groups),
+			TotalProducts: len(products),
+			VariantRegex:  productVariantPattern.String(),
+			ScannedAt:     time.Now(),
+		},
+	}
+
+	log.Printf("RequestID: %s - Product groups discovery completed for category %s: %d groups, %d products",
+		requestID, category, len(groups), len(products))
+	return response, nil
+}
+
+// Group products by base model, ordering groups and variants by name
+func groupProductsByBaseModel(category string, products []Product) []ProductGroup {
+	groupIndex := make(map[string]int)
+	var groups []ProductGroup
+
+	for _, product := range products {
+		baseModel, variant := parseProductVariant(product.ID)
+
+		index, exists := groupIndex[baseModel]
+		if !exists {
+			index = len(groups)
+			groupIndex[baseModel] = index
+			groups = append(groups, ProductGroup{BaseModel: baseModel, Category: category})
+		}
+
+		groups[index].Variants = append(groups[index].Variants, ProductVariant{Variant: variant, Product: product})
+	}
+
+	sort.Slice(groups, func(i, j int) bool { return groups[i].BaseModel < groups[j].BaseModel })
+	for _, group := range groups {
+		sort.Slice(group.Variants, func(i, j int) bool { return group.Variants[i].Variant < group.Variants[j].Variant })
+	}
+
+	return groups
+}
+
+// List the product IDs directly under a category prefix
+func listProductIDsInCategory(ctx context.Context, categoryPrefix string) ([]string, error) {
+	prefixes, err := listDatasetPrefixes(ctx, categoryPrefix)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list products in %s: %w", categoryPrefix, err)
+	}
+
+	var productIDs []string
+	for _, prefix := range prefixes {
+		productID := strings.TrimSuffix(strings.TrimPrefix(prefix, categoryPrefix), "/")
+		if productID != "" {
+			productIDs = append(productIDs, productID)
+		}
+	}
+
+	return productIDs, nil
+}
+
+// Find sibling variants of a product in fallback order: the bare base model,
+// then PRODUCT_VARIANT_FALLBACK_ORDER, then the remaining variants by name
+func findSiblingVariants(ctx context.Context, category, productID string) ([]string, error) {
+	baseModel, ownVariant := parseProductVariant(productID)
+
+	productIDs, err := listProductIDsInCategory(ctx, categoryDefinitions[category].S3Prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	preference := make(map[string]int)
+	for i, variant := range variantFallbackOrder {
+		preference[variant] = i + 1
+	}
+
+	siblingVariants := make(map[string]string)
+	var siblings []string
+	for _, candidate := range productIDs {
+		candidateBase, candidateVariant := parseProductVariant(candidate)
+		if candidate == productID || candidateBase != baseModel || candidateVariant == ownVariant {
+			continue
+		}
+		siblingVariants[candidate] = candidateVariant
+		siblings = append(siblings, candidate)
+	}
+
+	rank := func(productID string) int {
+		variant := siblingVariants[productID]
+		if variant == "" {
+			return 0
+		}
+		if position, ok := preference[variant]; ok {
+			return position
+		}
+		return len(variantFallbackOrder) + 1
+	}
+
+	sort.Slice(siblings, func(i, j int) bool {
+		if rank(siblings[i]) != rank(siblings[j]) {
+			return rank(siblings[i]) < rank(siblings[j])
+		}
+		return siblings[i] < siblings[j]
+	})
+
+	return siblings, nil
+}
+
+// Borrow images for the given folders from the first sibling variant that has any
+func discoverVariantFallbackImages(ctx context.Context, requestID, category, productID string, folders []string) ([]ImageData, string, error) {
+	siblings, err := findSiblingVariants(ctx, category, productID)
+	if err != nil {
+		return nil, "", err
+	}
+
+	for _, sibling := range siblings {
+		var images []ImageData
+		for _, folder := range folders {
+			folderImages, err := discoverImagesInFolder(ctx, requestID, fmt.Sprintf("dataset/%s/%s/%s/", category, sibling, folder))
+			if err != nil {
+				log.Printf("RequestID: %s - Warning: Failed to check fallback variant %s/%s: %v", requestID, sibling, folder, err)
+				continue
+			}
+			images = append(images, folderImages...)
+		}
+
+		if len(images) > 0 {
+			log.Printf("RequestID: %s - Using %d images of variant %s as fallback for %s", requestID, len(images), sibling, productID)
+			return images, sibling, nil
+		}
+	}
+
+	return nil, "", nil
+}
+
+// Fill empty image roles of a product from its sibling variants
+func applyVariantFallback(ctx context.Context, requestID, category, productID string, imagesData *ImagesData) error {
+	if len(imagesData.LabelImages) == 0 {
+		images, source, err := discoverVariantFallbackImages(ctx, requestID, category, productID, []string{"TEM NL"})
+		if err != nil {
+			return err
+		}
+		if source != "" {
+			imagesData.LabelImages = images
+			imagesData.LabelSourceProductID = source
+		}
+	}
+
+	if len(imagesData.OverviewImages) == 0 {
+		images, source, err := discoverVariantFallbackImages(ctx, requestID, category, productID, []string{"CHÍNH DIỆN", "HÌNH WEB"})
+		if err != nil {
+			return err
+		}
+		if source != "" {
+			imagesData.OverviewImages = images
+			imagesData.OverviewSourceProductID = source
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseProductVariant(t *testing.T) {
+	tests := []struct {
+		productID   string
+		wantBase    string
+		wantVariant string
+	}{
+		{"AQR-B360MA(SLB)", "AQR-B360MA", "SLB"},
+		{"AQR-B360MA (SLB)", "AQR-B360MA", "SLB"},
+		{"AQR-B360MA( SLB )", "AQR-B360MA", "SLB"},
+		{"AQR-B360MA(GB)", "AQR-B360MA", "GB"},
+		{"AQR-B360MA(dark silver)", "AQR-B360MA", "dark silver"},
+		{"aqr-b360ma(slb)", "aqr-b360ma", "slb"},
+		{"Aqr-B360Ma(Slb)", "Aqr-B360Ma", "Slb"},
+		// Only the last parenthesised group is the variant
+		{"AQR-(B)360MA(SLB)", "AQR-(B)360MA", "SLB"},
+		// No variant
+		{"AQR-B360MA", "AQR-B360MA", ""},
+		{"AQR-B360MA()", "AQR-B360MA()", ""},
+		{"AQR-B360MA(SLB", "AQR-B360MA(SLB", ""},
+		{"AQR-B360MA(SLB) X", "AQR-B360MA(SLB) X", ""},
+		{"(SLB)", "(SLB)", ""},
+		{"AQR-B360MA((SLB))", "AQR-B360MA((SLB))", ""},
+		{"", "", ""},
+	}
+
+	for _, tt := range tests {
+		base, variant := parseProductVariant(tt.productID)
+		if base != tt.wantBase || variant != tt.wantVariant {
+			t.Errorf("parseProductVariant(%q) = (%q, %q), want (%q, %q)", tt.productID, base, variant, tt.wantBase, tt.wantVariant)
+		}
+	}
+}
+
+func TestParseProductVariantCustomPattern(t *testing.T) {
+	// Registered first so it runs after t.Setenv has restored the environment
+	t.Cleanup(loadVariantConfig)
+
+	t.Setenv("PRODUCT_VARIANT_PATTERN", `^(?P<base>.+)-(?P<variant>[A-Z]{2,3})$`)
+	loadVariantConfig()
+
+	tests := []struct {
+		productID   string
+		wantBase    string
+		wantVariant string
+	}{
+		{"AQR-B360MA-SLB", "AQR-B360MA", "SLB"},
+		{"AQR-B360MA", "AQR-B360MA", ""},
+		{"AQR-B360MA(SLB)", "AQR-B360MA(SLB)", ""},
+	}
+	for _, tt := range tests {
+		base, variant := parseProductVariant(tt.productID)
+		if base != tt.wantBase || variant != tt.wantVariant {
+			t.Errorf("parseProductVariant(%q) = (%q, %q), want (%q, %q)", tt.productID, base, variant, tt.wantBase, tt.wantVariant)
+		}
+	}
+
+	// A pattern without both named groups falls back to the default
+	t.Setenv("PRODUCT_VARIANT_PATTERN", `^(.+)-(\w+)$`)
+	loadVariantConfig()
+	if productVariantPattern.String() != defaultProductVariantPattern {
+		t.Errorf("productVariantPattern = %q, want the default", productVariantPattern.String())
+	}
+}
+
+func TestGroupProductsByBaseModel(t *testing.T) {
+	products := []Product{
+		{ID: "AQR-B360MA(SLB)"},
+		{ID: "AQR-T220FA"},
+		{ID: "AQR-B360MA"},
+		{ID: "aqr-b360ma(slb)"},
+		{ID: "AQR-B360MA (GB)"},
+		{ID: "AQR-T220FA(PS)"},
+	}
+
+	groups := groupProductsByBaseModel("REF", products)
+
+	type variant struct{ variant, productID string }
+	got := map[string][]variant{}
+	var order []string
+	for _, group := range groups {
+		if group.Category != "REF" {
+			t.Errorf("group %s has category %q, want REF", group.BaseModel, group.Category)
+		}
+		order = append(order, group.BaseModel)
+		for _, v := range group.Variants {
+			got[group.BaseModel] = append(got[group.BaseModel], variant{v.Variant, v.Product.ID})
+		}
+	}
+
+	// Groups and variants are ordered by name; case is significant
+	if want := []string{"AQR-B360MA", "AQR-T220FA", "aqr-b360ma"}; !reflect.DeepEqual(order, want) {
+		t.Errorf("groups = %v, want %v", order, want)
+	}
+	want := map[string][]variant{
+		"AQR-B360MA": {{"", "AQR-B360MA"}, {"GB", "AQR-B360MA (GB)"}, {"SLB", "AQR-B360MA(SLB)"}},
+		"AQR-T220FA": {{"", "AQR-T220FA"}, {"PS", "AQR-T220FA(PS)"}},
+		"aqr-b360ma": {{"slb", "aqr-b360ma(slb)"}},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("variants = %v, want %v", got, want)
+	}
+
+	if groups := groupProductsByBaseModel("REF", nil); len(groups) != 0 {
+		t.Errorf("groupProductsByBaseModel(nil) = %v, want no groups", groups)
+	}
+}
@@ -0,0 +1,324 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// Verification results table indexes, sorted by timestamp
+const (
+	resultCategoryIndex = "category-index"
+	resultProductIndex  = "product-index"
+)
+
+const (
+	verificationStatsWindow  = 30 * 24 * time.Hour
+	verificationStatsWorkers = 8 // Concurrent product index queries for results before the window
+	// Results of other categories skipped, one per page, when looking for a product's latest result
+	verificationLatestMaxPages = 5
+	// Result timestamps are written by the validate function as naive UTC, e.g. "2025-06-18T08:01:13.680325"
+	resultTimestampLayout = "2006-01-02T15:04:05"
+)
+
+// Verification outcomes, classified the same way as the history API
+const (
+	verificationCorrect   = "CORRECT"
+	verificationIncorrect = "INCORRECT"
+	verificationUncertain = "UNCERTAIN"
+)
+
+// Confidence thresholds of the outcomes, copied from the history API's
+// determineVerificationResult (history/main.go), which documents them.
+// TestVerificationThresholdsMatch fails when the copies differ.
+const (
+	correctConfidenceThreshold   = 0.85
+	incorrectConfidenceThreshold = 0.60
+)
+
+type VerificationStats struct {
+	LastVerifiedAt   *time.Time `json:"lastVerifiedAt,omitempty"`
+	LastResult       string     `json:"lastResult,omitempty"`
+	LastConfidence   float64    `json:"lastConfidence,omitempty"`
+	Verifications30d int        `json:"verifications30d"`
+	Correct30d       int        `json:"correct30d"`
+	Incorrect30d     int        `json:"incorrect30d"`
+	Uncertain30d     int        `json:"uncertain30d"`
+	// Share of CORRECT results over the window, absent when there were none
+	SuccessRate30d *float64 `json:"successRate30d,omitempty"`
+}
+
+// Subset of a verification record needed for the statistics
+type verificationResultItem struct {
+	Timestamp       string `dynamodbav:"timestamp"`
+	ProductID       string `dynamodbav:"productId"`
+	ProductCategory string `dynamodbav:"productCategory"`
+	BedrockResponse struct {
+		Content []struct {
+			Text interface{} `dynamodbav:"text"`
+		} `dynamodbav:"content"`
+	} `dynamodbav:"bedrockResponse"`
+}
+
+type verificationOutcome struct {
+	Result     string
+	Confidence float64
+}
+
+func verificationStatsEnabled() bool {
+	return appConfig.ResultTable != ""
+}
+
+// Error returned by operations that read the results table when it is not configured
+func verificationStatsDisabledError() error {
+	return &requestError{StatusCode: 503, Code: "VERIFICATION_STATS_DISABLED", Message: "Verification statistics are not configured (AWS_RESULT_TABLE is not set)"}
+}
+
+// Annotate products with their verification statistics. One newest-first query of the
+// category covers the window; products without a result in it then get their latest
+// older result from the product index, with a bounded number of concurrent queries.
+func attachVerificationStats(ctx context.Context, requestID, category string, products []Product) error {
+	if !verificationStatsEnabled() {
+		return verificationStatsDisabledError()
+	}
+	if len(products) == 0 {
+		return nil
+	}
+
+	since := time.Now().UTC().Add(-verificationStatsWindow).Format(resultTimestampLayout)
+
+	stats := make(map[string]*VerificationStats, len(products))
+	for _, product := range products {
+		stats[product.ID] = &VerificationStats{}
+	}
+
+	input := &dynamodb.QueryInput{
+		TableName:              aws.String(appConfig.ResultTable),
+		IndexName:              aws.String(resultCategoryIndex),
+		KeyConditionExpression: aws.String("productCategory = :category AND #ts >= :since"),
+		ProjectionExpression:   aws.String("#ts, productId, productCategory, bedrockResponse.content"),
+		ExpressionAttributeNames: map[string]string{
+			"#ts": "timestamp",
+		},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":category": &types.AttributeValueMemberS{Value: category},
+			":since":    &types.AttributeValueMemberS{Value: since},
+		},
+		ScanIndexForward: aws.Bool(false),
+	}
+
+	records := 0
+	paginator := dynamodb.NewQueryPaginator(dynamoClient, input)
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to query verification stats for %s: %w", category, err)
+		}
+
+		var items []verificationResultItem
+		if err := attributevalue.UnmarshalListOfMaps(page.Items, &items); err != nil {
+			return fmt.Errorf("failed to unmarshal verification results: %w", err)
+		}
+		for _, item := range items {
+			records++
+			if product, ok := stats[item.ProductID]; ok {
+				addVerificationResult(product, item, true)
+			}
+		}
+	}
+
+	var pending []string
+	for productID, product := range stats {
+		if product.LastResult == "" {
+			pending = append(pending, productID)
+		}
+	}
+	if err := attachLatestVerifications(ctx, category, since, pending, stats); err != nil {
+		return err
+	}
+
+	for i := range products {
+		product := stats[products[i].ID]
+		if product.Verifications30d > 0 {
+			rate := float64(product.Correct30d) / float64(product.Verifications30d)
+			product.SuccessRate30d = &rate
+		}
+		products[i].VerificationStats = product
+	}
+
+	log.Printf("RequestID: %s - Attached verification stats to %d products in %s from %d results, %d looked up before the window", requestID, len(products), category, records, len(pending))
+	return nil
+}
+
+// Look up the latest result before the window of each product, querying the product index
+// with a bounded number of workers
+func attachLatestVerifications(ctx context.Context, category, before string, productIDs []string, stats map[string]*VerificationStats) error {
+	var (
+		mutex    sync.Mutex
+		wg       sync.WaitGroup
+		firstErr error
+	)
+	work := make(chan string)
+
+	for i := 0; i < verificationStatsWorkers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for productID := range work {
+				latest, err := queryLatestVerification(ctx, category, productID, before)
+
+				mutex.Lock()
+				if err != nil && firstErr == nil {
+					firstErr = fmt.Errorf("failed to query latest verification of %s: %w", productID, err)
+				} else if latest != nil {
+					addVerificationResult(stats[productID], *latest, false)
+				}
+				mutex.Unlock()
+			}
+		}()
+	}
+
+	for _, productID := range productIDs {
+		if ctx.Err() != nil {
+			break
+		}
+		work <- productID
+	}
+	close(work)
+	wg.Wait()
+
+	if firstErr != nil {
+		return firstErr
+	}
+	return ctx.Err()
+}
+
+// Latest result of a product before the given time, nil when there is none. Product IDs
+// are only unique within a category, so a few results of other categories are skipped
+// before giving up.
+func queryLatestVerification(ctx context.Context, category, productID, before string) (*verificationResultItem, error) {
+	input := &dynamodb.QueryInput{
+		TableName:              aws.String(appConfig.ResultTable),
+		IndexName:              aws.String(resultProductIndex),
+		KeyConditionExpression: aws.String("productId = :productId AND #ts < :before"),
+		ProjectionExpression:   aws.String("#ts, productId, productCategory, bedrockResponse.content"),
+		ExpressionAttributeNames: map[string]string{
+			"#ts": "timestamp",
+		},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":productId": &types.AttributeValueMemberS{Value: productID},
+			":before":    &types.AttributeValueMemberS{Value: before},
+		},
+		ScanIndexForward: aws.Bool(false),
+		Limit:            aws.Int32(1),
+	}
+
+	paginator := dynamodb.NewQueryPaginator(dynamoClient, input)
+	for pages := 0; paginator.HasMorePages() && pages < verificationLatestMaxPages; pages++ {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		var items []verificationResultItem
+		if err := attributevalue.UnmarshalListOfMaps(page.Items, &items); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal verification result: %w", err)
+		}
+		for _, item := range items {
+			if item.ProductCategory == "" || item.ProductCategory == category {
+				return &item, nil
+			}
+		}
+	}
+	return nil, nil
+}
+
+// Fold one result, read newest first, into a product's statistics. Results outside the
+// window only count as the latest result.
+func addVerificationResult(stats *VerificationStats, result verificationResultItem, inWindow bool) {
+	outcome := classifyVerificationResult(result)
+
+	if stats.LastResult == "" {
+		if timestamp, err := parseResultTimestamp(result.Timestamp); err == nil {
+			stats.LastVerifiedAt = &timestamp
+		}
+		stats.LastResult = outcome.Result
+		stats.LastConfidence = outcome.Confidence
+	}
+
+	if !inWindow {
+		return
+	}
+	stats.Verifications30d++
+	switch outcome.Result {
+	case verificationCorrect:
+		stats.Correct30d++
+	case verificationIncorrect:
+		stats.Incorrect30d++
+	default:
+		stats.Uncertain30d++
+	}
+}
+
+func parseResultTimestamp(timestamp string) (time.Time, error) {
+	return time.Parse(time.RFC3339Nano, strings.TrimSuffix(timestamp, "Z")+"Z")
+}
+
+// Classify a stored model response with the thresholds used by the history API
+func classifyVerificationResult(result verificationResultItem) verificationOutcome {
+	if len(result.BedrockResponse.Content) == 0 {
+		return verificationOutcome{Result: verificationUncertain}
+	}
+
+	var fields map[string]interface{}
+	switch text := result.BedrockResponse.Content[0].Text.(type) {
+	case map[string]interface{}:
+		fields = text
+	case string:
+		text = strings.TrimPrefix(strings.TrimSpace(text), "```json")
+		text = strings.TrimSuffix(strings.TrimSpace(strings.TrimPrefix(text, "```")), "```")
+		if err := json.Unmarshal([]byte(text), &fields); err != nil {
+			return verificationOutcome{Result: verificationUncertain}
+		}
+	default:
+		return verificationOutcome{Result: verificationUncertain}
+	}
+
+	labelMatch := fields["matchLabelToReference"] == "yes"
+	overviewMatch := fields["matchOverviewToReference"] == "yes"
+	confidence := (confidenceValue(fields["matchLabelToReference_confidence"]) + confidenceValue(fields["matchOverviewToReference_confidence"])) / 2
+
+	outcome := verificationOutcome{Confidence: confidence}
+	switch {
+	case labelMatch && overviewMatch && confidence >= correctConfidenceThreshold:
+		outcome.Result = verificationCorrect
+	case confidence < incorrectConfidenceThreshold:
+		outcome.Result = verificationIncorrect
+	default:
+		outcome.Result = verificationUncertain
+	}
+	return outcome
+}
+
+// Confidences are stored as numbers or, in older records, as strings
+func confidenceValue(value interface{}) float64 {
+	switch v := value.(type) {
+	case float64:
+		return v
+	case string:
+		if f, err := strconv.ParseFloat(v, 64); err == nil {
+			return f
+		}
+	}
+	return 0
+}
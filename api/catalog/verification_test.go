@@ -0,0 +1,107 @@
+package main
+
+import (
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+)
+
+// Float constants declared at the top level of a Go source file
+func sourceFloatConstants(t *testing.T, path string) map[string]float64 {
+	t.Helper()
+	file, err := parser.ParseFile(token.NewFileSet(), path, nil, 0)
+	if err != nil {
+		t.Fatalf("failed to parse %s: %v", path, err)
+	}
+
+	constants := map[string]float64{}
+	for _, decl := range file.Decls {
+		gen, ok := decl.(*ast.GenDecl)
+		if !ok || gen.Tok != token.CONST {
+			continue
+		}
+		for _, spec := range gen.Specs {
+			valueSpec := spec.(*ast.ValueSpec)
+			for i, name := range valueSpec.Names {
+				if i >= len(valueSpec.Values) {
+					continue
+				}
+				if literal, ok := valueSpec.Values[i].(*ast.BasicLit); ok && literal.Kind == token.FLOAT {
+					value, _ := strconv.ParseFloat(literal.Value, 64)
+					constants[name.Name] = value
+				}
+			}
+		}
+	}
+	return constants
+}
+
+// The catalog badge, the history view and the transaction view must classify alike
+func TestVerificationThresholdsMatch(t *testing.T) {
+	want := map[string]float64{
+		"correctConfidenceThreshold":   correctConfidenceThreshold,
+		"incorrectConfidenceThreshold": incorrectConfidenceThreshold,
+	}
+
+	for _, path := range []string{"../history/main.go", "../transaction/main.go"} {
+		path = filepath.FromSlash(path)
+		if _, err := os.Stat(path); err != nil {
+			t.Skipf("%s is not available: %v", path, err)
+		}
+		constants := sourceFloatConstants(t, path)
+		for name, value := range want {
+			got, ok := constants[name]
+			if !ok {
+				t.Errorf("%s does not declare %s", path, name)
+			} else if got != value {
+				t.Errorf("%s: %s = %g, the catalog API uses %g", path, name, got, value)
+			}
+		}
+	}
+}
+
+func TestClassifyVerificationResult(t *testing.T) {
+	result := func(text interface{}) verificationResultItem {
+		var item verificationResultItem
+		item.BedrockResponse.Content = append(item.BedrockResponse.Content, struct {
+			Text interface{} `dynamodbav:"text"`
+		}{Text: text})
+		return item
+	}
+	fields := func(label, overview string, labelConfidence, overviewConfidence interface{}) map[string]interface{} {
+		return map[string]interface{}{
+			"matchLabelToReference":               label,
+			"matchOverviewToReference":            overview,
+			"matchLabelToReference_confidence":    labelConfidence,
+			"matchOverviewToReference_confidence": overviewConfidence,
+		}
+	}
+
+	tests := []struct {
+		name   string
+		item   verificationResultItem
+		result string
+		conf   float64
+	}{
+		{"both match at the threshold", result(fields("yes", "yes", 0.85, 0.85)), verificationCorrect, 0.85},
+		{"both match below the threshold", result(fields("yes", "yes", 0.9, 0.79)), verificationUncertain, 0.845},
+		{"one image does not match", result(fields("yes", "no", 0.95, 0.95)), verificationUncertain, 0.95},
+		{"at the incorrect threshold", result(fields("no", "no", 0.6, 0.6)), verificationUncertain, 0.6},
+		{"below the incorrect threshold", result(fields("yes", "yes", 0.5, 0.6)), verificationIncorrect, 0.55},
+		{"string confidences", result(fields("yes", "yes", "0.9", "1")), verificationCorrect, 0.95},
+		{"fenced JSON text", result("```json\n{\"matchLabelToReference\":\"yes\",\"matchOverviewToReference\":\"yes\",\"matchLabelToReference_confidence\":1,\"matchOverviewToReference_confidence\":0.9}\n```"), verificationCorrect, 0.95},
+		{"unparsable text", result("not json"), verificationUncertain, 0},
+		{"no content", verificationResultItem{}, verificationUncertain, 0},
+	}
+
+	for _, tt := range tests {
+		outcome := classifyVerificationResult(tt.item)
+		if outcome.Result != tt.result || outcome.Confidence < tt.conf-1e-9 || outcome.Confidence > tt.conf+1e-9 {
+			t.Errorf("%s: classifyVerificationResult = %+v, want %s at %g", tt.name, outcome, tt.result, tt.conf)
+		}
+	}
+}
@@ -0,0 +1,274 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+)
+
+type ImageVersion struct {
+	Key            string    `json:"key"`
+	Filename       string    `json:"filename"`
+	Folder         string    `json:"folder"`
+	VersionID      string    `json:"versionId"`
+	IsLatest       bool      `json:"isLatest"`
+	IsDeleteMarker bool      `json:"isDeleteMarker"`
+	Size           int64     `json:"size"`
+	LastModified   time.Time `json:"lastModified"`
+	ETag           string    `json:"etag,omitempty"`
+	PresignedURL   string    `json:"presignedUrl,omitempty"`
+	// Reference to store when pinning a verification to this version
+	PinnedKey string `json:"pinnedKey,omitempty"`
+}
+
+type VersionsMetadata struct {
+	ProductID     string    `json:"productId"`
+	Category      string    `json:"category"`
+	Folder        string    `json:"folder,omitempty"`
+	TotalObjects  int       `json:"totalObjects"`
+	TotalVersions int       `json:"totalVersions"`
+	ScannedAt     time.Time `json:"scannedAt"`
+}
+
+type VersionRestoreMetadata struct {
+	RestoredVersionID string    `json:"restoredVersionId"`
+	PreviousETag      string    `json:"previousEtag,omitempty"`
+	Actor             string    `json:"actor"`
+	RestoredAt        time.Time `json:"restoredAt"`
+}
+
+// Versioned view of the dataset storage, or a 501 when the backend keeps no versions
+func datasetVersions() (versionedStorage, error) {
+	versioned, ok := datasetStorage.(versionedStorage)
+	if !ok {
+		return nil, &requestError{StatusCode: 501, Code: "VERSIONING_UNSUPPORTED", Message: fmt.Sprintf("Storage backend '%s' does not keep object versions", appConfig.StorageBackend)}
+	}
+	return versioned, nil
+}
+
+// Reference to one version of an object, as resolved by the transaction API
+func pinnedObjectKey(key, versionID string) string {
+	return key + "?versionId=" + url.QueryEscape(versionID)
+}
+
+// Handle image versions listing for a product, optionally limited to one folder
+func handleVersionsDiscovery(ctx context.Context, requestID string, queryParams map[string]string) (*CatalogResponse, error) {
+	category := queryParams["category"]
+	productID := queryParams["productId"]
+	folder := queryParams["folder"]
+
+	if category == "" || productID == "" {
+		return nil, newBadRequest("MISSING_PARAMETER", "Missing required 'category' and 'productId' parameters for versions discovery")
+	}
+
+	versioned, err := datasetVersions()
+	if err != nil {
+		return nil, err
+	}
+
+	basePrefix := fmt.Sprintf("dataset/%s/%s/", category, productID)
+	prefix := basePrefix
+	if folder != "" {
+		prefix += folder + "/"
+	}
+
+	log.Printf("RequestID: %s - Listing image versions under %s", requestID, prefix)
+
+	versions, err := versioned.ListVersions(ctx, prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	imageVersions := []ImageVersion{}
+	objects := make(map[string]bool)
+	for _, version := range versions {
+		if !isImageFile(version.Key) {
+			continue
+		}
+		objects[version.Key] = true
+
+		imageVersion := newImageVersion(basePrefix, version)
+		if !version.IsDeleteMarker {
+			presignedURL, err := versioned.PresignGetVersion(ctx, version.Key, version.VersionID, appConfig.PresignedURLExpiry)
+			if err != nil {
+				log.Printf("RequestID: %s - Warning: Failed to presign version %s of %s: %v", requestID, version.VersionID, version.Key, err)
+			}
+			imageVersion.PresignedURL = presignedURL
+		}
+		imageVersions = append(imageVersions, imageVersion)
+	}
+
+	response := &CatalogResponse{
+		Type: "versions",
+		Data: imageVersions,
+		Metadata: VersionsMetadata{
+			ProductID:     productID,
+			Category:      category,
+			Folder:        folder,
+			TotalObjects:  len(objects),
+			TotalVersions: len(imageVersions),
+			ScannedAt:     time.Now(),
+		},
+	}
+
+	log.Printf("RequestID: %s - Found %d versions of %d images under %s", requestID, len(imageVersions), len(objects), prefix)
+	return response, nil
+}
+
+// Handle presigning one specific version of an image
+func handleImageVersion(ctx context.Context, requestID string, queryParams map[string]string) (*CatalogResponse, error) {
+	key := queryParams["key"]
+	versionID := queryParams["versionId"]
+
+	if key == "" || versionID == "" {
+		return nil, newBadRequest("MISSING_PARAMETER", "Missing required 'key' and 'versionId' parameters for image version lookup")
+	}
+
+	versioned, err := datasetVersions()
+	if err != nil {
+		return nil, err
+	}
+
+	version, err := findObjectVersion(ctx, versioned, key, versionID)
+	if err != nil {
+		return nil, err
+	}
+	if version.IsDeleteMarker {
+		return nil, newNotFound("VERSION_IS_DELETE_MARKER", "Version %s of %s is a delete marker", versionID, key)
+	}
+
+	imageVersion := newImageVersion(datasetProductPrefix(key), *version)
+	imageVersion.PresignedURL, err = versioned.PresignGetVersion(ctx, key, versionID, appConfig.PresignedURLExpiry)
+	if err != nil {
+		return nil, err
+	}
+
+	log.Printf("RequestID: %s - Presigned version %s of %s", requestID, versionID, key)
+	return &CatalogResponse{
+		Type: "imageVersion",
+		Data: imageVersion,
+	}, nil
+}
+
+// Handle restoring a previous version of an image as the current object
+func handleVersionRestore(ctx context.Context, requestID string, queryParams map[string]string, actor string) (*CatalogResponse, error) {
+	key := queryParams["key"]
+	versionID := queryParams["versionId"]
+
+	if key == "" || versionID == "" {
+		return nil, newBadRequest("MISSING_PARAMETER", "Missing required 'key' and 'versionId' parameters for version restore")
+	}
+
+	versioned, err := datasetVersions()
+	if err != nil {
+		return nil, err
+	}
+
+	version, err := findObjectVersion(ctx, versioned, key, versionID)
+	if err != nil {
+		return nil, err
+	}
+	if version.IsDeleteMarker {
+		return nil, newBadRequest("VERSION_IS_DELETE_MARKER", "Version %s of %s is a delete marker and cannot be restored", versionID, key)
+	}
+	if version.IsLatest {
+		return nil, &requestError{StatusCode: 409, Code: "VERSION_ALREADY_CURRENT", Message: fmt.Sprintf("Version %s is already the current version of %s", versionID, key)}
+	}
+
+	// The current object may be a delete marker, in which case there is no previous ETag
+	var previousETag string
+	if current, err := datasetStorage.Head(ctx, key); err == nil {
+		previousETag = current.ETag
+	}
+
+	log.Printf("RequestID: %s - Restoring version %s of %s for %s", requestID, versionID, key, actor)
+
+	restored, err := versioned.RestoreVersion(ctx, key, versionID)
+	if err != nil {
+		return nil, err
+	}
+	restored.Size = version.Size
+
+	err = recordJournalEntry(ctx, JournalEntry{
+		Timestamp:  restored.LastModified,
+		Actor:      actor,
+		Action:     journalActionRestore,
+		Source:     journalSourceAPI,
+		ObjectKey:  key,
+		BeforeETag: previousETag,
+		AfterETag:  restored.ETag,
+		Details: map[string]string{
+			"requestId":         requestID,
+			"restoredVersionId": versionID,
+			"newVersionId":      restored.VersionID,
+		},
+	})
+	if err != nil {
+		// The restore itself succeeded; a missing entry must not turn it into an error
+		log.Printf("RequestID: %s - Warning: Failed to journal restore of %s: %v", requestID, key, err)
+	}
+
+	restoredAt := restored.LastModified
+	if restoredAt.IsZero() {
+		restoredAt = time.Now()
+	}
+
+	return &CatalogResponse{
+		Type: "restoreVersion",
+		Data: newImageVersion(datasetProductPrefix(key), *restored),
+		Metadata: VersionRestoreMetadata{
+			RestoredVersionID: versionID,
+			PreviousETag:      previousETag,
+			Actor:             actor,
+			RestoredAt:        restoredAt,
+		},
+	}, nil
+}
+
+// Find one version of an exact key
+func findObjectVersion(ctx context.Context, versioned versionedStorage, key, versionID string) (*objectVersion, error) {
+	versions, err := versioned.ListVersions(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, version := range versions {
+		// The listing is by prefix, so longer keys sharing it are skipped
+		if version.Key == key && version.VersionID == versionID {
+			return &version, nil
+		}
+	}
+
+	return nil, newNotFound("VERSION_NOT_FOUND", "Version %s of %s was not found", versionID, key)
+}
+
+// Product prefix of a dataset key, e.g. "dataset/REF/P1/" for "dataset/REF/P1/TEM NL/a.jpg"
+func datasetProductPrefix(key string) string {
+	category, productID := parseDatasetKey(key)
+	if productID == "" {
+		return fmt.Sprintf("dataset/%s/", category)
+	}
+	return fmt.Sprintf("dataset/%s/%s/", category, productID)
+}
+
+func newImageVersion(productPrefix string, version objectVersion) ImageVersion {
+	imageVersion := ImageVersion{
+		Key:            version.Key,
+		Filename:       path.Base(version.Key),
+		Folder:         strings.TrimPrefix(path.Dir(strings.TrimPrefix(version.Key, productPrefix)), "."),
+		VersionID:      version.VersionID,
+		IsLatest:       version.IsLatest,
+		IsDeleteMarker: version.IsDeleteMarker,
+		Size:           version.Size,
+		LastModified:   version.LastModified,
+		ETag:           version.ETag,
+	}
+	if !version.IsDeleteMarker && version.VersionID != "" {
+		imageVersion.PinnedKey = pinnedObjectKey(version.Key, version.VersionID)
+	}
+	return imageVersion
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	s3types "github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// Everything an export worker does besides encoding: reading records, keeping the job
+// record, the multipart upload and the carry-over object, and watching the deadline. The
+// worker decides when to write headers, cut parts and carry bytes over; the backend only
+// carries those decisions out.
+type exportBackend interface {
+	// One page of the records matching query after cursor, and the cursor of the next page
+	ReadPage(ctx context.Context, requestID string, query historyQuery, pageSize int, cursor string) ([]VerificationRecord, string, error)
+	// Whether the worker should hand the job on instead of reading another page
+	ShouldYield(ctx context.Context) bool
+
+	// Take a PENDING job by advancing its step, then load it. Fails with a condition
+	// failure when another worker has claimed this step.
+	ClaimJob(ctx context.Context, job *ExportJob) error
+	// Write the job back, provided no other worker has claimed it since
+	SaveJob(ctx context.Context, job *ExportJob) error
+
+	// Start the multipart upload of the export file and return its upload ID
+	CreateUpload(ctx context.Context, job *ExportJob, contentType string) (string, error)
+	// Upload part number of the export file and return its ETag
+	UploadPart(ctx context.Context, job *ExportJob, number int32, data []byte) (string, error)
+	// Assemble the export file from job.Parts
+	CompleteUpload(ctx context.Context, job *ExportJob) error
+	AbortUpload(ctx context.Context, job *ExportJob) error
+
+	// Bytes produced since the last part, kept between workers
+	PutCarry(ctx context.Context, job *ExportJob, data []byte) error
+	GetCarry(ctx context.Context, job *ExportJob) ([]byte, error)
+	DeleteCarry(ctx context.Context, job *ExportJob) error
+}
+
+var exportIO exportBackend = awsExportBackend{}
+
+// Export backend on the history table, the export job table and the export bucket
+type awsExportBackend struct{}
+
+func (awsExportBackend) ReadPage(ctx context.Context, requestID string, query historyQuery, pageSize int, cursor string) ([]VerificationRecord, string, error) {
+	return queryHistoryPage(ctx, requestID, query, pageSize, cursor)
+}
+
+func (awsExportBackend) ShouldYield(ctx context.Context) bool {
+	deadline, ok := ctx.Deadline()
+	return ok && time.Until(deadline) < exportYieldMargin
+}
+
+func (awsExportBackend) ClaimJob(ctx context.Context, job *ExportJob) error {
+	now := time.Now().UTC().Format(time.RFC3339)
+	output, err := dynamoClient.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName:           aws.String(appConfig.ExportJobsTable),
+		Key:                 map[string]types.AttributeValue{"jobId": &types.AttributeValueMemberS{Value: job.JobID}},
+		UpdateExpression:    aws.String("SET #status = :running, #step = #step + :one, updatedAt = :now"),
+		ConditionExpression: aws.String("#status = :pending AND #step = :step"),
+		ExpressionAttributeNames: map[string]string{
+			"#status": "status",
+			"#step":   "step",
+		},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":running": &types.AttributeValueMemberS{Value: exportRunning},
+			":pending": &types.AttributeValueMemberS{Value: exportPending},
+			":one":     &types.AttributeValueMemberN{Value: "1"},
+			":step":    &types.AttributeValueMemberN{Value: fmt.Sprint(job.Step)},
+			":now":     &types.AttributeValueMemberS{Value: now},
+		},
+		ReturnValues: types.ReturnValueAllNew,
+	})
+	if err != nil {
+		return err
+	}
+	return attributevalue.UnmarshalMap(output.Attributes, job)
+}
+
+func (awsExportBackend) SaveJob(ctx context.Context, job *ExportJob) error {
+	job.UpdatedAt = time.Now().UTC().Format(time.RFC3339)
+	item, err := attributevalue.MarshalMap(job)
+	if err != nil {
+		return fmt.Errorf("failed to marshal export job: %w", err)
+	}
+	_, err = dynamoClient.PutItem(ctx, &dynamodb.PutItemInput{
+		TableName:                 aws.String(appConfig.ExportJobsTable),
+		Item:                      item,
+		ConditionExpression:       aws.String("#step = :step"),
+		ExpressionAttributeNames:  map[string]string{"#step": "step"},
+		ExpressionAttributeValues: map[string]types.AttributeValue{":step": &types.AttributeValueMemberN{Value: fmt.Sprint(job.Step)}},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to save export job %s: %w", job.JobID, err)
+	}
+	return nil
+}
+
+func (awsExportBackend) CreateUpload(ctx context.Context, job *ExportJob, contentType string) (string, error) {
+	upload, err := s3Client.CreateMultipartUpload(ctx, &s3.CreateMultipartUploadInput{
+		Bucket:             aws.String(appConfig.ExportBucket),
+		Key:                aws.String(job.ObjectKey),
+		ContentType:        aws.String(contentType),
+		ContentDisposition: aws.String(fmt.Sprintf(`attachment; filename="%s"`, exportFilename(job))),
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to start upload of %s: %w", job.ObjectKey, err)
+	}
+	return aws.ToString(upload.UploadId), nil
+}
+
+func (awsExportBackend) UploadPart(ctx context.Context, job *ExportJob, number int32, data []byte) (string, error) {
+	output, err := s3Client.UploadPart(ctx, &s3.UploadPartInput{
+		Bucket:     aws.String(appConfig.ExportBucket),
+		Key:        aws.String(job.ObjectKey),
+		UploadId:   aws.String(job.UploadID),
+		PartNumber: aws.Int32(number),
+		Body:       bytes.NewReader(data),
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to upload part %d of %s: %w", number, job.ObjectKey, err)
+	}
+	return aws.ToString(output.ETag), nil
+}
+
+func (awsExportBackend) CompleteUpload(ctx context.Context, job *ExportJob) error {
+	parts := make([]s3types.CompletedPart, 0, len(job.Parts))
+	for _, part := range job.Parts {
+		parts = append(parts, s3types.CompletedPart{PartNumber: aws.Int32(part.Number), ETag: aws.String(part.ETag)})
+	}
+	_, err := s3Client.CompleteMultipartUpload(ctx, &s3.CompleteMultipartUploadInput{
+		Bucket:          aws.String(appConfig.ExportBucket),
+		Key:             aws.String(job.ObjectKey),
+		UploadId:        aws.String(job.UploadID),
+		MultipartUpload: &s3types.CompletedMultipartUpload{Parts: parts},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to complete upload of %s: %w", job.ObjectKey, err)
+	}
+	return nil
+}
+
+func (awsExportBackend) AbortUpload(ctx context.Context, job *ExportJob) error {
+	_, err := s3Client.AbortMultipartUpload(ctx, &s3.AbortMultipartUploadInput{
+		Bucket:   aws.String(appConfig.ExportBucket),
+		Key:      aws.String(job.ObjectKey),
+		UploadId: aws.String(job.UploadID),
+	})
+	return err
+}
+
+func (awsExportBackend) PutCarry(ctx context.Context, job *ExportJob, data []byte) error {
+	_, err := s3Client.PutObject(ctx, &s3.PutObjectInput{
+		Bucket: aws.String(appConfig.ExportBucket),
+		Key:    aws.String(exportCarryKey(job)),
+		Body:   bytes.NewReader(data),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to save export carry-over: %w", err)
+	}
+	return nil
+}
+
+func (awsExportBackend) GetCarry(ctx context.Context, job *ExportJob) ([]byte, error) {
+	output, err := s3Client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(appConfig.ExportBucket),
+		Key:    aws.String(exportCarryKey(job)),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read export carry-over: %w", err)
+	}
+	defer output.Body.Close()
+
+	data, err := io.ReadAll(output.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read export carry-over: %w", err)
+	}
+	return data, nil
+}
+
+func (awsExportBackend) DeleteCarry(ctx context.Context, job *ExportJob) error {
+	_, err := s3Client.DeleteObject(ctx, &s3.DeleteObjectInput{
+		Bucket: aws.String(appConfig.ExportBucket),
+		Key:    aws.String(exportCarryKey(job)),
+	})
+	return err
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"log"
+	"strconv"
+	"strings"
+)
+
+// Column of a CSV export, selected by name through the 'columns' parameter. Names follow
+// the 'q' fields where both exist.
+type csvColumn struct {
+	Name   string
+	Header string
+	value  func(*csvRow) string
+}
+
+// A record with its parsed results; Parsed is false when the model response could not be
+// parsed, and the result columns are then left empty
+type csvRow struct {
+	Record     VerificationRecord
+	Results    VerificationResults
+	Parsed     bool
+	Confidence float64
+	Result     string
+}
+
+// All columns, in the order 'columns=all' writes them
+var csvColumns = []*csvColumn{
+	{Name: "id", Header: "ID",
+		value: func(r *csvRow) string { return r.Record.ID }},
+	{Name: "timestamp", Header: "Timestamp",
+		value: func(r *csvRow) string { return r.Record.Timestamp }},
+	{Name: "productId", Header: "Product ID",
+		value: func(r *csvRow) string { return r.Record.ProductID }},
+	{Name: "category", Header: "Category",
+		value: func(r *csvRow) string { return r.Record.ProductCategory }},
+	{Name: "result", Header: "Verification Result",
+		value: func(r *csvRow) string { return r.Result }},
+	{Name: "confidence", Header: "Overall Confidence",
+		value: func(r *csvRow) string { return r.confidence(r.Confidence) }},
+	{Name: "labelMatch", Header: "Label Match",
+		value: func(r *csvRow) string { return r.Results.MatchLabelToReference }},
+	{Name: "labelConfidence", Header: "Label Confidence",
+		value: func(r *csvRow) string { return r.confidence(r.Results.MatchLabelConfidence) }},
+	{Name: "overviewMatch", Header: "Overview Match",
+		value: func(r *csvRow) string { return r.Results.MatchOverviewToReference }},
+	{Name: "overviewConfidence", Header: "Overview Confidence",
+		value: func(r *csvRow) string { return r.confidence(r.Results.MatchOverviewConfidence) }},
+	{Name: "model", Header: "AI Model",
+		value: func(r *csvRow) string { return r.Record.BedrockResponse.Model }},
+	{Name: "parseFailed", Header: "Parse Failed",
+		value: func(r *csvRow) string { return strconv.FormatBool(!r.Parsed) }},
+	{Name: "labelExplanation", Header: "Label Explanation",
+		value: func(r *csvRow) string { return r.Results.LabelExplanation }},
+	{Name: "overviewExplanation", Header: "Overview Explanation",
+		value: func(r *csvRow) string { return r.Results.OverviewExplanation }},
+	{Name: "inputTokens", Header: "Input Tokens",
+		value: func(r *csvRow) string { return strconv.Itoa(r.Record.BedrockResponse.Usage.InputTokens) }},
+	{Name: "outputTokens", Header: "Output Tokens",
+		value: func(r *csvRow) string { return strconv.Itoa(r.Record.BedrockResponse.Usage.OutputTokens) }},
+	{Name: "labelImageKey", Header: "Label Image Key",
+		value: func(r *csvRow) string { return r.Record.UploadedLabelImageKey }},
+	{Name: "overviewImageKey", Header: "Overview Image Key",
+		value: func(r *csvRow) string { return r.Record.UploadedOverviewImageKey }},
+	{Name: "referenceImageKey", Header: "Reference Image Key",
+		value: func(r *csvRow) string { return r.Record.UploadedReferenceImageKey }},
+}
+
+// Columns without a 'columns' parameter: those CSV exports always had, then the parse flag
+const csvDefaultColumns = "id,timestamp,productId,category,result,confidence,labelMatch,labelConfidence,overviewMatch,overviewConfidence,model,parseFailed"
+
+// UTF-8 byte order mark, which makes Excel read the file as UTF-8
+const utf8BOM = "\xef\xbb\xbf"
+
+func (r *csvRow) confidence(value float64) string {
+	if !r.Parsed {
+		return ""
+	}
+	return strconv.FormatFloat(value, 'f', 3, 64)
+}
+
+func lookupCSVColumn(name string) *csvColumn {
+	for _, column := range csvColumns {
+		if strings.EqualFold(column.Name, name) {
+			return column
+		}
+	}
+	return nil
+}
+
+func csvColumnNames() string {
+	names := make([]string, len(csvColumns))
+	for i, column := range csvColumns {
+		names[i] = column.Name
+	}
+	return strings.Join(names, ", ")
+}
+
+// RFC 4180 CSV: fields are quoted where needed and records end in CRLF. Every record is
+// written, with parseFailed set on those whose model response could not be parsed.
+type csvEncoder struct {
+	columns []*csvColumn
+	bom     bool
+}
+
+// Encoder for the 'columns' and 'bom' export options. columns is a comma-separated list
+// of column names, or "default" or "all".
+func newCSVEncoder(options map[string]string) (csvEncoder, error) {
+	var encoder csvEncoder
+
+	switch bom := options["bom"]; bom {
+	case "", "false":
+	case "true":
+		encoder.bom = true
+	default:
+		return encoder, newBadRequest("INVALID_EXPORT_OPTION", "Invalid 'bom' parameter: %s. Valid values: true, false", bom)
+	}
+
+	names := options["columns"]
+	switch strings.ToLower(names) {
+	case "", "default":
+		names = csvDefaultColumns
+	case "all":
+		encoder.columns = csvColumns
+		return encoder, nil
+	}
+
+	seen := map[*csvColumn]bool{}
+	for _, name := range strings.Split(names, ",") {
+		column := lookupCSVColumn(strings.TrimSpace(name))
+		if column == nil {
+			return encoder, newBadRequest("INVALID_COLUMNS", "Unknown CSV column '%s'. Valid columns: %s, or 'default' or 'all'", strings.TrimSpace(name), csvColumnNames())
+		}
+		if seen[column] {
+			return encoder, newBadRequest("INVALID_COLUMNS", "CSV column '%s' is listed more than once", column.Name)
+		}
+		seen[column] = true
+		encoder.columns = append(encoder.columns, column)
+	}
+	return encoder, nil
+}
+
+func (csvEncoder) ContentType() string {
+	return "text/csv; charset=utf-8"
+}
+
+func (e csvEncoder) Header(*ExportJob) []byte {
+	fields := make([]string, len(e.columns))
+	for i, column := range e.columns {
+		fields[i] = column.Header
+	}
+	line, err := csvLine(fields)
+	if err != nil {
+		log.Printf("Warning: Failed to encode CSV header: %v", err)
+		return nil
+	}
+	if e.bom {
+		return append([]byte(utf8BOM), line...)
+	}
+	return line
+}
+
+func (e csvEncoder) Record(requestID string, record VerificationRecord, written int) ([]byte, bool) {
+	row := &csvRow{Record: record}
+	if results, err := parseVerificationResults(requestID, record.BedrockResponse); err == nil {
+		row.Results = results
+		row.Parsed = true
+		row.Confidence = (results.MatchLabelConfidence + results.MatchOverviewConfidence) / 2
+		row.Result = determineVerificationResult(results, row.Confidence)
+	}
+
+	fields := make([]string, len(e.columns))
+	for i, column := range e.columns {
+		fields[i] = column.value(row)
+	}
+	line, err := csvLine(fields)
+	if err != nil {
+		log.Printf("RequestID: %s - Warning: Failed to encode record %s as CSV: %v", requestID, record.ID, err)
+		return nil, false
+	}
+	return line, true
+}
+
+func (csvEncoder) Footer(*ExportJob, int) []byte {
+	return nil
+}
+
+func csvLine(fields []string) ([]byte, error) {
+	var b bytes.Buffer
+	writer := csv.NewWriter(&b)
+	writer.UseCRLF = true
+	if err := writer.Write(fields); err != nil {
+		return nil, err
+	}
+	writer.Flush()
+	if err := writer.Error(); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
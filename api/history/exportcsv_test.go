@@ -0,0 +1,128 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func mustNewCSVEncoder(t *testing.T, options map[string]string) csvEncoder {
+	t.Helper()
+	encoder, err := newCSVEncoder(options)
+	if err != nil {
+		t.Fatalf("newCSVEncoder(%v) returned error: %v", options, err)
+	}
+	return encoder
+}
+
+func TestCSVEncoderRecordQuoting(t *testing.T) {
+	encoder := mustNewCSVEncoder(t, map[string]string{"columns": "id,productId,labelExplanation,overviewExplanation"})
+
+	record := xlsxTestRecord("id-1", "2025-06-18T12:00:00", "REF", 0.95)
+	record.ProductID = "AQR-B360MA(SLB), 2024"
+	text := record.BedrockResponse.Content[0].Text.(map[string]interface{})
+	text["label_explanation"] = `Nhãn "Inverter" khớp`
+	text["overview_explanation"] = "Dòng 1\nDòng 2"
+
+	line, ok := encoder.Record("test", record, 0)
+	if !ok {
+		t.Fatalf("Record() returned false")
+	}
+	// With CRLF line endings, newlines inside quoted fields become CRLF as well
+	want := "id-1,\"AQR-B360MA(SLB), 2024\",\"Nhãn \"\"Inverter\"\" khớp\",\"Dòng 1\r\nDòng 2\"\r\n"
+	if string(line) != want {
+		t.Errorf("Record() = %q, want %q", line, want)
+	}
+}
+
+func TestCSVEncoderRecordParseFailed(t *testing.T) {
+	encoder := mustNewCSVEncoder(t, map[string]string{})
+
+	parsed, ok := encoder.Record("test", xlsxTestRecord("id-1", "2025-06-18T12:00:00", "REF", 0.95), 0)
+	if !ok {
+		t.Fatalf("Record() returned false")
+	}
+	want := "id-1,2025-06-18T12:00:00,AQR-M466XA(GB),REF,CORRECT,0.925,yes,0.950,yes,0.900,claude-sonnet-4-20250514,false\r\n"
+	if string(parsed) != want {
+		t.Errorf("Record(parsed) = %q, want %q", parsed, want)
+	}
+
+	// Records whose response cannot be parsed are still written, with empty result columns
+	failed, ok := encoder.Record("test", VerificationRecord{ID: "id-2", Timestamp: "2025-06-18T13:00:00", ProductID: "P2", ProductCategory: "WM"}, 1)
+	if !ok {
+		t.Fatalf("Record() returned false for an unparsable record")
+	}
+	want = "id-2,2025-06-18T13:00:00,P2,WM,,,,,,,,true\r\n"
+	if string(failed) != want {
+		t.Errorf("Record(unparsable) = %q, want %q", failed, want)
+	}
+}
+
+func TestCSVEncoderHeader(t *testing.T) {
+	tests := []struct {
+		name    string
+		options map[string]string
+		want    string
+	}{
+		{
+			name:    "default columns",
+			options: map[string]string{},
+			want:    "ID,Timestamp,Product ID,Category,Verification Result,Overall Confidence,Label Match,Label Confidence,Overview Match,Overview Confidence,AI Model,Parse Failed\r\n",
+		},
+		{
+			name:    "selected columns keep their order",
+			options: map[string]string{"columns": "inputTokens, ID ,outputTokens"},
+			want:    "Input Tokens,ID,Output Tokens\r\n",
+		},
+		{
+			name:    "byte order mark",
+			options: map[string]string{"columns": "id", "bom": "true"},
+			want:    utf8BOM + "ID\r\n",
+		},
+		{
+			name:    "no byte order mark",
+			options: map[string]string{"columns": "id", "bom": "false"},
+			want:    "ID\r\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := string(mustNewCSVEncoder(t, tt.options).Header(nil)); got != tt.want {
+				t.Errorf("Header() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+
+	all := mustNewCSVEncoder(t, map[string]string{"columns": "ALL"})
+	if fields := strings.Split(strings.TrimSuffix(string(all.Header(nil)), "\r\n"), ","); len(fields) != len(csvColumns) {
+		t.Errorf("columns=all wrote %d headers, want %d", len(fields), len(csvColumns))
+	}
+}
+
+func TestNewCSVEncoderErrors(t *testing.T) {
+	tests := []struct {
+		name     string
+		options  map[string]string
+		wantCode string
+		message  string
+	}{
+		{"unknown column", map[string]string{"columns": "id,colour"}, "INVALID_COLUMNS", "Unknown CSV column 'colour'"},
+		{"empty column", map[string]string{"columns": "id,,model"}, "INVALID_COLUMNS", "Unknown CSV column ''"},
+		{"repeated column", map[string]string{"columns": "id,model,ID"}, "INVALID_COLUMNS", "'id' is listed more than once"},
+		{"invalid bom", map[string]string{"bom": "yes"}, "INVALID_EXPORT_OPTION", "Invalid 'bom' parameter: yes"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := newCSVEncoder(tt.options)
+			var reqErr *requestError
+			if !errors.As(err, &reqErr) || reqErr.StatusCode != 400 || reqErr.Code != tt.wantCode {
+				t.Fatalf("newCSVEncoder(%v) error = %v, want a 400 %s", tt.options, err, tt.wantCode)
+			}
+			if !strings.Contains(reqErr.Message, tt.message) {
+				t.Errorf("error = %q, want it to contain %q", reqErr.Message, tt.message)
+			}
+		})
+	}
+}
@@ -0,0 +1,471 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+const (
+	// Dataset files live under "v<major>/". Adding a field bumps the minor version only,
+	// since Parquet and JSON readers match columns by name; renaming, retyping or removing
+	// one starts a new major version and prefix.
+	lakeSchemaMajor   = 1
+	lakeSchemaVersion = "1.0"
+	// Records a worker holds in memory before it writes them out as files
+	maxDatasetBuffered = 20000
+	// Hive's name for the partition of a missing value
+	hiveDefaultPartition = "__HIVE_DEFAULT_PARTITION__"
+)
+
+// One verification as a data lake row, with the parsed results and token usage flattened
+// into columns. Result columns are null when the model response could not be parsed.
+// Fields are only ever appended; the lake tag gives the schema version that added each.
+// The partition columns, category and date, are in the file path rather than the rows.
+type lakeRecord struct {
+	SchemaVersion       string    `json:"schema_version" parquet:"schema_version" lake:"1.0"`
+	ID                  string    `json:"id" parquet:"id" lake:"1.0"`
+	Timestamp           time.Time `json:"timestamp" parquet:"timestamp" lake:"1.0"`
+	ProductID           string    `json:"product_id" parquet:"product_id" lake:"1.0"`
+	ProductCategory     string    `json:"product_category" parquet:"product_category" lake:"1.0"`
+	LabelImageKey       string    `json:"label_image_key" parquet:"label_image_key" lake:"1.0"`
+	OverviewImageKey    string    `json:"overview_image_key" parquet:"overview_image_key" lake:"1.0"`
+	ReferenceImageKey   string    `json:"reference_image_key" parquet:"reference_image_key" lake:"1.0"`
+	ResultsParsed       bool      `json:"results_parsed" parquet:"results_parsed" lake:"1.0"`
+	VerificationResult  *string   `json:"verification_result" parquet:"verification_result" lake:"1.0"`
+	OverallConfidence   *float64  `json:"overall_confidence" parquet:"overall_confidence" lake:"1.0"`
+	LabelMatch          *string   `json:"label_match" parquet:"label_match" lake:"1.0"`
+	LabelConfidence     *float64  `json:"label_confidence" parquet:"label_confidence" lake:"1.0"`
+	LabelExplanation    *string   `json:"label_explanation" parquet:"label_explanation" lake:"1.0"`
+	OverviewMatch       *string   `json:"overview_match" parquet:"overview_match" lake:"1.0"`
+	OverviewConfidence  *float64  `json:"overview_confidence" parquet:"overview_confidence" lake:"1.0"`
+	OverviewExplanation *string   `json:"overview_explanation" parquet:"overview_explanation" lake:"1.0"`
+	AIModel             string    `json:"ai_model" parquet:"ai_model" lake:"1.0"`
+	StopReason          string    `json:"stop_reason" parquet:"stop_reason" lake:"1.0"`
+	InputTokens         int64     `json:"input_tokens" parquet:"input_tokens" lake:"1.0"`
+	OutputTokens        int64     `json:"output_tokens" parquet:"output_tokens" lake:"1.0"`
+}
+
+// Formats written as a Hive-partitioned dataset of many files instead of a single file.
+// Encode turns the rows of one partition into one file.
+type datasetEncoder interface {
+	ContentType() string
+	Extension() string
+	Encode(rows []lakeRecord) ([]byte, error)
+}
+
+// Dataset export formats by the 'format' parameter
+var datasetEncoders = map[string]datasetEncoder{
+	"ndjson":  ndjsonEncoder{},
+	"parquet": parquetEncoder{},
+}
+
+type ndjsonEncoder struct{}
+
+func (ndjsonEncoder) ContentType() string {
+	return "application/x-ndjson"
+}
+
+func (ndjsonEncoder) Extension() string {
+	return "ndjson"
+}
+
+func (ndjsonEncoder) Encode(rows []lakeRecord) ([]byte, error) {
+	var out bytes.Buffer
+	encoder := json.NewEncoder(&out)
+	encoder.SetEscapeHTML(false)
+	for _, row := range rows {
+		if err := encoder.Encode(row); err != nil {
+			return nil, fmt.Errorf("failed to encode record %s: %w", row.ID, err)
+		}
+	}
+	return out.Bytes(), nil
+}
+
+type parquetEncoder struct{}
+
+func (parquetEncoder) ContentType() string {
+	return "application/vnd.apache.parquet"
+}
+
+func (parquetEncoder) Extension() string {
+	return "parquet"
+}
+
+func (parquetEncoder) Encode(rows []lakeRecord) ([]byte, error) {
+	return writeParquet(rows)
+}
+
+func isDatasetFormat(format string) bool {
+	_, ok := datasetEncoders[format]
+	return ok
+}
+
+// Flatten a record. Returns false for a record without a valid timestamp, which has no
+// date partition.
+func lakeRow(requestID string, record VerificationRecord) (lakeRecord, bool) {
+	ts, err := parseResultTimestamp(record.Timestamp)
+	if err != nil {
+		return lakeRecord{}, false
+	}
+
+	row := lakeRecord{
+		SchemaVersion:     lakeSchemaVersion,
+		ID:                record.ID,
+		Timestamp:         ts.UTC(),
+		ProductID:         record.ProductID,
+		ProductCategory:   record.ProductCategory,
+		LabelImageKey:     record.UploadedLabelImageKey,
+		OverviewImageKey:  record.UploadedOverviewImageKey,
+		ReferenceImageKey: record.UploadedReferenceImageKey,
+		AIModel:           record.BedrockResponse.Model,
+		StopReason:        record.BedrockResponse.StopReason,
+		InputTokens:       int64(record.BedrockResponse.Usage.InputTokens),
+		OutputTokens:      int64(record.BedrockResponse.Usage.OutputTokens),
+	}
+
+	results, err := parseVerificationResults(requestID, record.BedrockResponse)
+	if err != nil {
+		return row, true
+	}
+	overallConfidence := (results.MatchLabelConfidence + results.MatchOverviewConfidence) / 2
+	verificationResult := determineVerificationResult(results, overallConfidence)
+
+	row.ResultsParsed = true
+	row.VerificationResult = &verificationResult
+	row.OverallConfidence = &overallConfidence
+	row.LabelMatch = &results.MatchLabelToReference
+	row.LabelConfidence = &results.MatchLabelConfidence
+	row.LabelExplanation = &results.LabelExplanation
+	row.OverviewMatch = &results.MatchOverviewToReference
+	row.OverviewConfidence = &results.MatchOverviewConfidence
+	row.OverviewExplanation = &results.OverviewExplanation
+	return row, true
+}
+
+// Hive-style partition directory of a row, e.g. "category=REF/date=2026-10-01"
+func lakePartition(row lakeRecord) string {
+	return "category=" + hivePartitionValue(row.ProductCategory) + "/date=" + row.Timestamp.Format("2006-01-02")
+}
+
+// Escape a partition value the way Hive does: path separators, '=' and other reserved
+// characters become %XX, and an empty value is the default partition
+func hivePartitionValue(value string) string {
+	if value == "" {
+		return hiveDefaultPartition
+	}
+	var b strings.Builder
+	for _, r := range value {
+		if r < 0x20 || r == 0x7f || strings.ContainsRune("\"#%'*/:=?\\{[]^", r) {
+			fmt.Fprintf(&b, "%%%02X", r)
+			continue
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// Write records into partition files until the export is done or the deadline is near.
+// Files are written and the job checkpointed together, so records are counted once they
+// are in S3; files a failed worker wrote past the checkpoint are deleted by the next one.
+func exportDataset(ctx context.Context, requestID string, job *ExportJob) error {
+	encoder, ok := datasetEncoders[job.Format]
+	if !ok {
+		return fmt.Errorf("unsupported dataset format: %s", job.Format)
+	}
+	query, err := parseHistoryQuery(job.Filters)
+	if err != nil {
+		return err
+	}
+
+	if job.Step > 1 {
+		if err := deleteUncommittedFiles(ctx, requestID, job); err != nil {
+			return err
+		}
+	}
+
+	partitions := map[string][]lakeRecord{}
+	buffered := 0
+	cursor := job.Cursor
+
+	for {
+		if exportIO.ShouldYield(ctx) {
+			if err := commitDatasetFiles(ctx, job, encoder, partitions, buffered, cursor); err != nil {
+				return err
+			}
+			return yieldExportJob(ctx, requestID, job, &bytes.Buffer{})
+		}
+
+		pageSize := exportPageSize
+		if remaining := maxExportRecords - job.RecordCount - buffered; remaining < pageSize {
+			pageSize = remaining
+		}
+		records, next, err := exportIO.ReadPage(ctx, requestID, query, pageSize, cursor)
+		if err != nil {
+			return err
+		}
+		for _, record := range records {
+			if row, ok := lakeRow(requestID, record); ok {
+				partition := lakePartition(row)
+				partitions[partition] = append(partitions[partition], row)
+				buffered++
+			}
+		}
+		cursor = next
+
+		done := next == "" || job.RecordCount+buffered >= maxExportRecords
+		if done || buffered >= maxDatasetBuffered {
+			if err := commitDatasetFiles(ctx, job, encoder, partitions, buffered, cursor); err != nil {
+				return err
+			}
+			partitions = map[string][]lakeRecord{}
+			buffered = 0
+		}
+		if done {
+			job.Complete = next == ""
+			return completeDatasetJob(ctx, requestID, job, encoder)
+		}
+	}
+}
+
+// Write one file per partition of the buffered rows, then checkpoint the job past them.
+// Files are named after the record count they start at, so a retry from the same
+// checkpoint overwrites them.
+func commitDatasetFiles(ctx context.Context, job *ExportJob, encoder datasetEncoder, partitions map[string][]lakeRecord, buffered int, cursor string) error {
+	names := make([]string, 0, len(partitions))
+	for partition := range partitions {
+		names = append(names, partition)
+	}
+	sort.Strings(names)
+
+	for _, partition := range names {
+		data, err := encoder.Encode(partitions[partition])
+		if err != nil {
+			return err
+		}
+		key := fmt.Sprintf("%s%s/part-%06d-%s.%s", job.ObjectKey, partition, job.RecordCount, job.JobID[:8], encoder.Extension())
+		_, err = s3Client.PutObject(ctx, &s3.PutObjectInput{
+			Bucket:      aws.String(appConfig.ExportBucket),
+			Key:         aws.String(key),
+			Body:        bytes.NewReader(data),
+			ContentType: aws.String(encoder.ContentType()),
+		})
+		if err != nil {
+			return fmt.Errorf("failed to write dataset file %s: %w", key, err)
+		}
+		job.FilesWritten++
+		job.BytesWritten += int64(len(data))
+	}
+
+	job.RecordCount += buffered
+	job.Cursor = cursor
+	return exportIO.SaveJob(ctx, job)
+}
+
+// Remove files written after the last checkpoint by a worker that did not finish its step
+func deleteUncommittedFiles(ctx context.Context, requestID string, job *ExportJob) error {
+	files, err := listDatasetFiles(ctx, job)
+	if err != nil {
+		return err
+	}
+	for _, file := range files {
+		if file.offset < job.RecordCount {
+			continue
+		}
+		_, err := s3Client.DeleteObject(ctx, &s3.DeleteObjectInput{
+			Bucket: aws.String(appConfig.ExportBucket),
+			Key:    aws.String(file.Key),
+		})
+		if err != nil {
+			return fmt.Errorf("failed to delete uncommitted dataset file %s: %w", file.Key, err)
+		}
+		log.Printf("RequestID: %s - Deleted uncommitted dataset file %s", requestID, file.Key)
+	}
+	return nil
+}
+
+type datasetFile struct {
+	Key       string `json:"key"`
+	Partition string `json:"partition"`
+	Size      int64  `json:"size"`
+	offset    int
+}
+
+// Data files under the dataset prefix. Files starting with '_' are metadata, which Athena
+// and Spark also skip.
+func listDatasetFiles(ctx context.Context, job *ExportJob) ([]datasetFile, error) {
+	files := []datasetFile{}
+	paginator := s3.NewListObjectsV2Paginator(s3Client, &s3.ListObjectsV2Input{
+		Bucket: aws.String(appConfig.ExportBucket),
+		Prefix: aws.String(job.ObjectKey),
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list dataset files of %s: %w", job.ObjectKey, err)
+		}
+		for _, object := range page.Contents {
+			key := aws.ToString(object.Key)
+			relative := strings.TrimPrefix(key, job.ObjectKey)
+			slash := strings.LastIndex(relative, "/")
+			name := relative[slash+1:]
+			if slash < 0 || !strings.HasPrefix(name, "part-") {
+				continue
+			}
+			offset, _ := strconv.Atoi(strings.SplitN(strings.TrimPrefix(name, "part-"), "-", 2)[0])
+			files = append(files, datasetFile{Key: key, Partition: relative[:slash], Size: aws.ToInt64(object.Size), offset: offset})
+		}
+	}
+	return files, nil
+}
+
+// Listing of a finished dataset, written as _manifest.json and offered as the download
+type datasetManifest struct {
+	JobID         string            `json:"jobId"`
+	Format        string            `json:"format"`
+	SchemaVersion string            `json:"schemaVersion"`
+	Location      string            `json:"location"`
+	CreatedAt     string            `json:"createdAt"`
+	Filters       map[string]string `json:"filters"`
+	RecordCount   int               `json:"recordCount"`
+	Complete      bool              `json:"complete"`
+	PartitionKeys []string          `json:"partitionKeys"`
+	Partitions    int               `json:"partitions"`
+	Files         []datasetFile     `json:"files"`
+}
+
+// Column of _schema.json, in Athena/Hive type names
+type lakeField struct {
+	Name     string `json:"name"`
+	Type     string `json:"type"`
+	Nullable bool   `json:"nullable"`
+	Since    string `json:"since"`
+}
+
+type lakeSchema struct {
+	Version       string      `json:"version"`
+	Format        string      `json:"format"`
+	PartitionKeys []lakeField `json:"partitionKeys"`
+	Fields        []lakeField `json:"fields"`
+}
+
+func completeDatasetJob(ctx context.Context, requestID string, job *ExportJob, encoder datasetEncoder) error {
+	files, err := listDatasetFiles(ctx, job)
+	if err != nil {
+		return err
+	}
+	partitions := map[string]bool{}
+	for _, file := range files {
+		partitions[file.Partition] = true
+	}
+
+	manifest := datasetManifest{
+		JobID:         job.JobID,
+		Format:        job.Format,
+		SchemaVersion: lakeSchemaVersion,
+		Location:      datasetLocation(job),
+		CreatedAt:     job.CreatedAt,
+		Filters:       job.Filters,
+		RecordCount:   job.RecordCount,
+		Complete:      job.Complete,
+		PartitionKeys: []string{"category", "date"},
+		Partitions:    len(partitions),
+		Files:         files,
+	}
+	schema := lakeSchema{
+		Version: lakeSchemaVersion,
+		Format:  job.Format,
+		PartitionKeys: []lakeField{
+			{Name: "category", Type: "string", Since: "1.0"},
+			{Name: "date", Type: "string", Since: "1.0"},
+		},
+		Fields: lakeSchemaFields(),
+	}
+
+	for name, document := range map[string]interface{}{"_manifest.json": manifest, "_schema.json": schema} {
+		data, err := json.MarshalIndent(document, "", "  ")
+		if err != nil {
+			return fmt.Errorf("failed to marshal dataset %s: %w", name, err)
+		}
+		_, err = s3Client.PutObject(ctx, &s3.PutObjectInput{
+			Bucket:      aws.String(appConfig.ExportBucket),
+			Key:         aws.String(job.ObjectKey + name),
+			Body:        bytes.NewReader(data),
+			ContentType: aws.String("application/json"),
+		})
+		if err != nil {
+			return fmt.Errorf("failed to write dataset %s: %w", name, err)
+		}
+	}
+
+	job.Status = exportCompleted
+	job.Cursor = ""
+	if err := exportIO.SaveJob(ctx, job); err != nil {
+		return err
+	}
+	log.Printf("RequestID: %s - Dataset export completed: %d records in %d %s files over %d partitions, complete: %t",
+		requestID, job.RecordCount, len(files), encoder.Extension(), len(partitions), job.Complete)
+	return nil
+}
+
+// Columns of lakeRecord as they appear in _schema.json
+func lakeSchemaFields() []lakeField {
+	recordType := reflect.TypeOf(lakeRecord{})
+	fields := make([]lakeField, 0, recordType.NumField())
+	for i := 0; i < recordType.NumField(); i++ {
+		field := recordType.Field(i)
+		fieldType := field.Type
+		nullable := fieldType.Kind() == reflect.Ptr
+		if nullable {
+			fieldType = fieldType.Elem()
+		}
+
+		var typeName string
+		switch {
+		case fieldType == reflect.TypeOf(time.Time{}):
+			typeName = "timestamp"
+		case fieldType.Kind() == reflect.String:
+			typeName = "string"
+		case fieldType.Kind() == reflect.Float64:
+			typeName = "double"
+		case fieldType.Kind() == reflect.Int64:
+			typeName = "bigint"
+		case fieldType.Kind() == reflect.Bool:
+			typeName = "boolean"
+		default:
+			typeName = fieldType.String()
+		}
+
+		fields = append(fields, lakeField{
+			Name:     strings.Split(field.Tag.Get("json"), ",")[0],
+			Type:     typeName,
+			Nullable: nullable,
+			Since:    field.Tag.Get("lake"),
+		})
+	}
+	return fields
+}
+
+func datasetLocation(job *ExportJob) string {
+	return fmt.Sprintf("s3://%s/%s", appConfig.ExportBucket, job.ObjectKey)
+}
+
+// The manifest is what view=export offers for download once a dataset is complete
+func datasetManifestKey(job *ExportJob) string {
+	return job.ObjectKey + "_manifest.json"
+}
+
+// Download name of a dataset manifest, e.g. "history-export-20250618-102600-parquet-manifest.json"
+func datasetManifestFilename(job *ExportJob) string {
+	return strings.TrimSuffix(exportFilename(job), "."+job.Format) + "-" + job.Format + "-manifest.json"
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLakeRowTimestamp(t *testing.T) {
+	tests := []struct {
+		timestamp string
+		want      time.Time
+		ok        bool
+	}{
+		// Stored results are naive UTC, with or without microseconds
+		{"2025-06-18T08:01:13.680325", time.Date(2025, 6, 18, 8, 1, 13, 680325000, time.UTC), true},
+		{"2025-06-18T08:01:13", time.Date(2025, 6, 18, 8, 1, 13, 0, time.UTC), true},
+		{"2025-06-18T08:01:13Z", time.Date(2025, 6, 18, 8, 1, 13, 0, time.UTC), true},
+		{"2025-06-18T15:01:13.5+07:00", time.Date(2025, 6, 18, 8, 1, 13, 500000000, time.UTC), true},
+		{"", time.Time{}, false},
+		{"2025-06-18", time.Time{}, false},
+		{"18/06/2025 08:01", time.Time{}, false},
+	}
+
+	for _, tt := range tests {
+		row, ok := lakeRow("test", VerificationRecord{ID: "abc", Timestamp: tt.timestamp})
+		if ok != tt.ok {
+			t.Errorf("lakeRow(%q) ok = %t, want %t", tt.timestamp, ok, tt.ok)
+			continue
+		}
+		if ok && (!row.Timestamp.Equal(tt.want) || row.Timestamp.Location() != time.UTC) {
+			t.Errorf("lakeRow(%q) timestamp = %v, want %v", tt.timestamp, row.Timestamp, tt.want)
+		}
+	}
+}
+
+func TestLakePartition(t *testing.T) {
+	tests := []struct {
+		category string
+		want     string
+	}{
+		{"REF", "category=REF/date=2025-06-18"},
+		{"", "category=" + hiveDefaultPartition + "/date=2025-06-18"},
+		{"A/B=C", "category=A%2FB%3DC/date=2025-06-18"},
+		{"MÁY GIẶT", "category=MÁY GIẶT/date=2025-06-18"},
+		{"a\nb%", "category=a%0Ab%25/date=2025-06-18"},
+	}
+
+	for _, tt := range tests {
+		row := lakeRecord{ProductCategory: tt.category, Timestamp: time.Date(2025, 6, 18, 23, 59, 59, 0, time.UTC)}
+		if got := lakePartition(row); got != tt.want {
+			t.Errorf("lakePartition(%q) = %q, want %q", tt.category, got, tt.want)
+		}
+	}
+}
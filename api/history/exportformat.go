@@ -0,0 +1,210 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Writes an export file record by record, so a job can upload it in parts and resume
+// after any record. written is the number of records already in the file.
+type exportEncoder interface {
+	ContentType() string
+	Header(job *ExportJob) []byte
+	// Encoded record, or false for a record the format cannot represent
+	Record(requestID string, record VerificationRecord, written int) ([]byte, bool)
+	Footer(job *ExportJob, written int) []byte
+}
+
+// Formats that can only be written once every record is known. Records are collected
+// as compact lines, carried between invocations, and rendered into the file at the end.
+type documentEncoder interface {
+	exportEncoder
+	MaxRecords() int
+	Render(requestID string, job *ExportJob, rows []byte) ([]byte, error)
+}
+
+// Export formats by the 'format' parameter. CSV is configured per job by newExportEncoder.
+var exportEncoders = map[string]exportEncoder{
+	"csv":  csvEncoder{},
+	"json": jsonEncoder{},
+	"pdf":  pdfEncoder{},
+	"xlsx": xlsxEncoder{},
+}
+
+// Query parameters that configure an export rather than filter it
+var exportOptionParams = []string{"columns", "bom"}
+
+// Encoder of a job's format with its export options applied
+func newExportEncoder(job *ExportJob) (exportEncoder, error) {
+	if err := validateExportOptions(job.Format, job.Options); err != nil {
+		return nil, err
+	}
+	if job.Format == "csv" {
+		return newCSVEncoder(job.Options)
+	}
+	encoder, ok := exportEncoders[job.Format]
+	if !ok {
+		return nil, fmt.Errorf("unsupported export format: %s", job.Format)
+	}
+	return encoder, nil
+}
+
+// Export options only apply to CSV
+func validateExportOptions(format string, options map[string]string) error {
+	if format != "csv" {
+		if len(options) > 0 {
+			return newBadRequest("INVALID_EXPORT_OPTION", "The 'columns' and 'bom' parameters only apply to csv exports")
+		}
+		return nil
+	}
+	_, err := newCSVEncoder(options)
+	return err
+}
+
+func exportFormatNames() string {
+	return "csv, json, ndjson, parquet, pdf, xlsx"
+}
+
+// A JSON object with the records as history items. totalRecords comes last because it is
+// only known once every record has been written.
+type jsonEncoder struct{}
+
+func (jsonEncoder) ContentType() string {
+	return "application/json"
+}
+
+func (jsonEncoder) Header(job *ExportJob) []byte {
+	exportedAt, _ := json.Marshal(job.CreatedAt)
+	return []byte(fmt.Sprintf("{\n  \"exportedAt\": %s,\n  \"records\": [", exportedAt))
+}
+
+func (jsonEncoder) Record(requestID string, record VerificationRecord, written int) ([]byte, bool) {
+	item, err := convertToHistoryItem(requestID, record)
+	if err != nil {
+		return nil, false
+	}
+	data, err := json.MarshalIndent(item, "    ", "  ")
+	if err != nil {
+		return nil, false
+	}
+
+	var b strings.Builder
+	if written > 0 {
+		b.WriteString(",")
+	}
+	b.WriteString("\n    ")
+	b.Write(data)
+	return []byte(b.String()), true
+}
+
+func (jsonEncoder) Footer(job *ExportJob, written int) []byte {
+	closing := "]"
+	if written > 0 {
+		closing = "\n  ]"
+	}
+	return []byte(fmt.Sprintf("%s,\n  \"totalRecords\": %d\n}\n", closing, written))
+}
+
+// Download name of an export, e.g. "history-export-20250618-102600.csv"
+func exportFilename(job *ExportJob) string {
+	created, err := time.Parse(time.RFC3339, job.CreatedAt)
+	if err != nil {
+		created = time.Now()
+	}
+	return fmt.Sprintf("history-export-%s.%s", created.UTC().Format("20060102-150405"), job.Format)
+}
+
+// A copy of the record holding only what documents show, with the parsed results in place
+// of the model response and explanations cut to explanationLimit characters
+func compactExportRecord(requestID string, record VerificationRecord, explanationLimit int) ([]byte, bool) {
+	results, err := parseVerificationResults(requestID, record.BedrockResponse)
+	if err != nil {
+		return nil, false
+	}
+
+	compact := VerificationRecord{
+		ID:              record.ID,
+		Timestamp:       record.Timestamp,
+		ProductID:       record.ProductID,
+		ProductCategory: record.ProductCategory,
+		BedrockResponse: BedrockResponse{
+			Model: record.BedrockResponse.Model,
+			Usage: record.BedrockResponse.Usage,
+			Content: []ContentItem{{Type: "text", Text: map[string]interface{}{
+				"matchLabelToReference":               results.MatchLabelToReference,
+				"matchLabelToReference_confidence":    results.MatchLabelConfidence,
+				"label_explanation":                   truncateRunes(results.LabelExplanation, explanationLimit),
+				"matchOverviewToReference":            results.MatchOverviewToReference,
+				"matchOverviewToReference_confidence": results.MatchOverviewConfidence,
+				"overview_explanation":                truncateRunes(results.OverviewExplanation, explanationLimit),
+			}}},
+		},
+	}
+	line, err := json.Marshal(compact)
+	if err != nil {
+		return nil, false
+	}
+	return append(line, '\n'), true
+}
+
+// Records collected by compactExportRecord, in export order
+func readCompactRecords(rows []byte) ([]VerificationRecord, error) {
+	records := []VerificationRecord{}
+	scanner := bufio.NewScanner(bytes.NewReader(rows))
+	scanner.Buffer(make([]byte, 64<<10), 1<<20)
+	for scanner.Scan() {
+		var record VerificationRecord
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			return nil, fmt.Errorf("failed to read collected export record: %w", err)
+		}
+		records = append(records, record)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read collected export records: %w", err)
+	}
+	return records, nil
+}
+
+// "2025-06-01 – 2025-06-30" from the date filters of an export, or the whole history
+func exportPeriod(job *ExportJob) string {
+	from, to := job.Filters["dateFrom"], job.Filters["dateTo"]
+	switch {
+	case from == "" && to == "":
+		return "all verifications"
+	case from == "":
+		return "until " + to
+	case to == "":
+		return "from " + from
+	default:
+		return fmt.Sprintf("%s – %s", from, to)
+	}
+}
+
+// The other filters of an export as "name: value" pairs, empty when there are none
+func exportFilterSummary(job *ExportJob) string {
+	names := []string{}
+	for name := range job.Filters {
+		if name != "dateFrom" && name != "dateTo" && job.Filters[name] != "" {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	parts := make([]string, 0, len(names))
+	for _, name := range names {
+		parts = append(parts, fmt.Sprintf("%s: %s", name, job.Filters[name]))
+	}
+	return strings.Join(parts, ", ")
+}
+
+func truncateRunes(text string, limit int) string {
+	runes := []rune(text)
+	if len(runes) <= limit {
+		return text
+	}
+	return string(runes[:limit])
+}
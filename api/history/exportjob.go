@@ -0,0 +1,553 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// Export job states. Only PENDING jobs pass the stream filter that starts the worker, so
+// progress updates of a RUNNING job do not start another one.
+const (
+	exportPending   = "PENDING"
+	exportRunning   = "RUNNING"
+	exportCompleted = "COMPLETED"
+	exportFailed    = "FAILED"
+)
+
+const (
+	// Upload part size; S3 requires at least 5 MiB for all parts but the last
+	exportPartSize = 8 << 20
+	// Records read per index query
+	exportPageSize = 100
+	// No further page is read with less than this left before the Lambda deadline
+	exportYieldMargin = 10 * time.Second
+	// A RUNNING job not updated for this long lost its worker and is queued again
+	exportStaleAfter = 2 * time.Minute
+	// Worker invocations after which an unfinished export is failed
+	maxExportSteps = 30
+	// Job records and export files are kept this long
+	exportRetention = 7 * 24 * time.Hour
+	// Lifetime of download URLs
+	exportURLExpiry = time.Hour
+)
+
+// Export job record. The export file is written as a multipart upload; Cursor, RecordCount
+// and Parts describe what has been uploaded, and bytes produced since the last part are kept
+// in a carry-over object when the worker yields. Dataset formats write many files under
+// ObjectKey instead, counted in FilesWritten. Step is incremented by every worker that
+// claims the job, so a duplicate stream delivery cannot run it twice.
+type ExportJob struct {
+	JobID        string            `dynamodbav:"jobId"`
+	Status       string            `dynamodbav:"status"`
+	Format       string            `dynamodbav:"format"`
+	Filters      map[string]string `dynamodbav:"filters"`
+	Options      map[string]string `dynamodbav:"options,omitempty"`
+	Step         int               `dynamodbav:"step"`
+	ObjectKey    string            `dynamodbav:"objectKey"`
+	UploadID     string            `dynamodbav:"uploadId,omitempty"`
+	Parts        []exportPart      `dynamodbav:"parts,omitempty"`
+	Cursor       string            `dynamodbav:"cursor,omitempty"`
+	RecordCount  int               `dynamodbav:"recordCount"`
+	BytesWritten int64             `dynamodbav:"bytesWritten"`
+	CarryBytes   int64             `dynamodbav:"carryBytes"`
+	FilesWritten int               `dynamodbav:"filesWritten,omitempty"`
+	Complete     bool              `dynamodbav:"complete"`
+	Error        string            `dynamodbav:"error,omitempty"`
+	CreatedAt    string            `dynamodbav:"createdAt"`
+	UpdatedAt    string            `dynamodbav:"updatedAt"`
+	ExpiresAt    int64             `dynamodbav:"expiresAt"` // Table TTL, epoch seconds
+}
+
+type exportPart struct {
+	Number int32  `dynamodbav:"number"`
+	ETag   string `dynamodbav:"etag"`
+}
+
+// Export job as returned by view=export
+type ExportJobStatus struct {
+	JobID       string            `json:"jobId"`
+	Status      string            `json:"status"`
+	Format      string            `json:"format"`
+	Options     map[string]string `json:"options,omitempty"`
+	CreatedAt   time.Time         `json:"createdAt"`
+	UpdatedAt   time.Time         `json:"updatedAt"`
+	RecordCount int               `json:"recordCount"`
+	Progress    ExportProgress    `json:"progress"`
+	Complete    bool              `json:"complete"`
+	DownloadURL string            `json:"downloadUrl,omitempty"`
+	Location    string            `json:"location,omitempty"`
+	ExpiresAt   *time.Time        `json:"expiresAt,omitempty"`
+	FileSize    string            `json:"fileSize,omitempty"`
+	Error       string            `json:"error,omitempty"`
+}
+
+type ExportProgress struct {
+	BytesWritten  int64 `json:"bytesWritten"`
+	PartsUploaded int   `json:"partsUploaded"`
+	FilesWritten  int   `json:"filesWritten,omitempty"`
+	Invocations   int   `json:"invocations"`
+}
+
+// Reported on jobs that used up their worker invocations
+var errExportUnfinished = errors.New("export did not finish")
+
+// Query parameters that are not export filters
+var exportControlParams = map[string]bool{"view": true, "format": true, "jobId": true, "cursor": true, "pageSize": true, "columns": true, "bom": true}
+
+func exportEnabled() bool {
+	return appConfig.ExportBucket != "" && appConfig.ExportJobsTable != ""
+}
+
+func errExportDisabled() error {
+	return &requestError{StatusCode: 503, Code: "EXPORT_DISABLED", Message: "Exports are not configured (AWS_EXPORT_BUCKET and EXPORT_JOBS_TABLE)"}
+}
+
+// Create a PENDING export job for the filters in queryParams. The worker is started by the
+// job table's stream.
+func createExportJob(ctx context.Context, requestID string, queryParams map[string]string) (*ExportJob, error) {
+	format := queryParams["format"]
+	if format == "" {
+		return nil, newBadRequest("INVALID_FORMAT", "The 'format' parameter is required for a new export. Valid values: %s", exportFormatNames())
+	}
+	if _, ok := exportEncoders[format]; !ok && !isDatasetFormat(format) {
+		return nil, newBadRequest("INVALID_FORMAT", "Invalid 'format' parameter: %s. Valid values: %s", format, exportFormatNames())
+	}
+
+	options := map[string]string{}
+	for _, name := range exportOptionParams {
+		if value := queryParams[name]; value != "" {
+			options[name] = value
+		}
+	}
+
+	// Reject invalid filters and options now rather than in the worker
+	if _, err := parseHistoryQuery(queryParams); err != nil {
+		return nil, err
+	}
+	if err := validateExportOptions(format, options); err != nil {
+		return nil, err
+	}
+
+	filters := map[string]string{}
+	for name, value := range queryParams {
+		if !exportControlParams[name] && value != "" {
+			filters[name] = value
+		}
+	}
+
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return nil, fmt.Errorf("failed to generate export job ID: %w", err)
+	}
+
+	now := time.Now().UTC()
+	job := &ExportJob{
+		JobID:     hex.EncodeToString(id),
+		Status:    exportPending,
+		Format:    format,
+		Filters:   filters,
+		Options:   options,
+		CreatedAt: now.Format(time.RFC3339),
+		UpdatedAt: now.Format(time.RFC3339),
+		ExpiresAt: now.Add(exportRetention).Unix(),
+	}
+	job.ObjectKey = fmt.Sprintf("exports/%s/%s", job.JobID, exportFilename(job))
+	if isDatasetFormat(format) {
+		job.ObjectKey = fmt.Sprintf("exports/%s/v%d/", job.JobID, lakeSchemaMajor)
+	}
+
+	item, err := attributevalue.MarshalMap(job)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal export job: %w", err)
+	}
+	_, err = dynamoClient.PutItem(ctx, &dynamodb.PutItemInput{
+		TableName:           aws.String(appConfig.ExportJobsTable),
+		Item:                item,
+		ConditionExpression: aws.String("attribute_not_exists(jobId)"),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create export job: %w", err)
+	}
+
+	log.Printf("RequestID: %s - Created export job %s, format: %s, filters: %v, options: %v", requestID, job.JobID, format, filters, options)
+	return job, nil
+}
+
+func getExportJob(ctx context.Context, jobID string) (*ExportJob, error) {
+	output, err := dynamoClient.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName:      aws.String(appConfig.ExportJobsTable),
+		Key:            map[string]types.AttributeValue{"jobId": &types.AttributeValueMemberS{Value: jobID}},
+		ConsistentRead: aws.Bool(true),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read export job %s: %w", jobID, err)
+	}
+	if output.Item == nil {
+		return nil, &requestError{StatusCode: 404, Code: "EXPORT_JOB_NOT_FOUND", Message: fmt.Sprintf("Export job %s not found", jobID)}
+	}
+
+	var job ExportJob
+	if err := attributevalue.UnmarshalMap(output.Item, &job); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal export job %s: %w", jobID, err)
+	}
+	return &job, nil
+}
+
+// Status of a job, with a download URL once it has completed. A RUNNING job whose worker
+// stopped updating it is queued again, or failed once it has used up its invocations.
+func exportJobStatus(ctx context.Context, requestID string, job *ExportJob) (ExportJobStatus, error) {
+	updatedAt, _ := time.Parse(time.RFC3339, job.UpdatedAt)
+	if job.Status == exportRunning && time.Since(updatedAt) > exportStaleAfter {
+		log.Printf("RequestID: %s - Export job %s has not been updated since %s", requestID, job.JobID, job.UpdatedAt)
+		var err error
+		if job.Step >= maxExportSteps {
+			err = failExportJob(ctx, job, fmt.Errorf("%w: its last worker stopped responding", errExportUnfinished))
+		} else {
+			err = requeueExportJob(ctx, job)
+		}
+		if err != nil && !isConditionFailed(err) {
+			return ExportJobStatus{}, err
+		}
+	}
+
+	createdAt, _ := time.Parse(time.RFC3339, job.CreatedAt)
+	updatedAt, _ = time.Parse(time.RFC3339, job.UpdatedAt)
+	status := ExportJobStatus{
+		JobID:       job.JobID,
+		Status:      job.Status,
+		Format:      job.Format,
+		Options:     job.Options,
+		CreatedAt:   createdAt,
+		UpdatedAt:   updatedAt,
+		RecordCount: job.RecordCount,
+		Progress: ExportProgress{
+			BytesWritten:  job.BytesWritten + job.CarryBytes,
+			PartsUploaded: len(job.Parts),
+			FilesWritten:  job.FilesWritten,
+			Invocations:   job.Step,
+		},
+		Complete: job.Complete,
+		Error:    job.Error,
+	}
+
+	if job.Status == exportCompleted {
+		// A dataset is read from its location; the download is its manifest
+		key, filename := job.ObjectKey, exportFilename(job)
+		if isDatasetFormat(job.Format) {
+			key, filename = datasetManifestKey(job), datasetManifestFilename(job)
+			status.Location = datasetLocation(job)
+		}
+		presigned, err := s3.NewPresignClient(s3Client).PresignGetObject(ctx, &s3.GetObjectInput{
+			Bucket:                     aws.String(appConfig.ExportBucket),
+			Key:                        aws.String(key),
+			ResponseContentDisposition: aws.String(fmt.Sprintf(`attachment; filename="%s"`, filename)),
+		}, s3.WithPresignExpires(exportURLExpiry))
+		if err != nil {
+			return ExportJobStatus{}, fmt.Errorf("failed to presign export %s: %w", key, err)
+		}
+		expiresAt := time.Now().Add(exportURLExpiry)
+		status.DownloadURL = presigned.URL
+		status.ExpiresAt = &expiresAt
+		status.FileSize = formatFileSize(job.BytesWritten)
+	}
+	return status, nil
+}
+
+func formatFileSize(size int64) string {
+	switch {
+	case size >= 1<<20:
+		return fmt.Sprintf("%.1f MB", float64(size)/(1<<20))
+	case size >= 1<<10:
+		return fmt.Sprintf("%.1f KB", float64(size)/(1<<10))
+	default:
+		return fmt.Sprintf("%d B", size)
+	}
+}
+
+// Run the export jobs of a job table stream batch. Failures are recorded on the job rather
+// than returned, so a broken export does not hold up the stream.
+func processExportJobs(ctx context.Context, event events.DynamoDBEvent) (int, error) {
+	processed := 0
+	for _, streamRecord := range event.Records {
+		var job ExportJob
+		item := streamImageItem(streamRecord.Change.NewImage)
+		if item == nil {
+			continue
+		}
+		if err := attributevalue.UnmarshalMap(item, &job); err != nil {
+			log.Printf("RequestID: %s - Warning: Failed to unmarshal export job: %v", streamRecord.EventID, err)
+			continue
+		}
+		if job.Status != exportPending {
+			continue
+		}
+
+		if err := runExportJob(ctx, &job); err != nil {
+			return processed, err
+		}
+		processed++
+	}
+	return processed, nil
+}
+
+func runExportJob(ctx context.Context, job *ExportJob) error {
+	requestID := "export-" + job.JobID
+
+	if err := exportIO.ClaimJob(ctx, job); err != nil {
+		if isConditionFailed(err) {
+			log.Printf("RequestID: %s - Export job step %d already claimed, skipping", requestID, job.Step)
+			return nil
+		}
+		return err
+	}
+	log.Printf("RequestID: %s - Running export job, step %d, %d records and %d parts so far", requestID, job.Step, job.RecordCount, len(job.Parts))
+
+	export := exportRecords
+	if isDatasetFormat(job.Format) {
+		export = exportDataset
+	}
+	if err := export(ctx, requestID, job); err != nil {
+		log.Printf("RequestID: %s - Export job failed: %v", requestID, err)
+		if err := failExportJob(ctx, job, err); err != nil {
+			log.Printf("RequestID: %s - Failed to record export job failure: %v", requestID, err)
+		}
+	}
+	return nil
+}
+
+// Write records into the upload until the export is done or the deadline is near, then
+// complete the upload or yield the job to the next worker
+func exportRecords(ctx context.Context, requestID string, job *ExportJob) error {
+	encoder, err := newExportEncoder(job)
+	if err != nil {
+		return err
+	}
+	query, err := parseHistoryQuery(job.Filters)
+	if err != nil {
+		return err
+	}
+
+	if job.UploadID == "" {
+		uploadID, err := exportIO.CreateUpload(ctx, job, encoder.ContentType())
+		if err != nil {
+			return err
+		}
+		job.UploadID = uploadID
+		if err := exportIO.SaveJob(ctx, job); err != nil {
+			return err
+		}
+	}
+
+	var buffer bytes.Buffer
+	switch {
+	case job.CarryBytes > 0:
+		if err := readExportCarry(ctx, job, &buffer); err != nil {
+			return err
+		}
+	case len(job.Parts) == 0:
+		buffer.Write(encoder.Header(job))
+	}
+
+	// Documents are rendered whole at the end, so their records stay in the carry-over
+	document, isDocument := encoder.(documentEncoder)
+	recordLimit := maxExportRecords
+	if isDocument {
+		recordLimit = document.MaxRecords()
+	}
+
+	for {
+		if exportIO.ShouldYield(ctx) {
+			return yieldExportJob(ctx, requestID, job, &buffer)
+		}
+
+		pageSize := exportPageSize
+		if remaining := recordLimit - job.RecordCount; remaining < pageSize {
+			pageSize = remaining
+		}
+		records, next, err := exportIO.ReadPage(ctx, requestID, query, pageSize, job.Cursor)
+		if err != nil {
+			return err
+		}
+		for _, record := range records {
+			if data, ok := encoder.Record(requestID, record, job.RecordCount); ok {
+				buffer.Write(data)
+				job.RecordCount++
+			}
+		}
+		job.Cursor = next
+
+		if next == "" || job.RecordCount >= recordLimit {
+			job.Complete = next == ""
+			buffer.Write(encoder.Footer(job, job.RecordCount))
+			if isDocument {
+				rendered, err := document.Render(requestID, job, buffer.Bytes())
+				if err != nil {
+					return err
+				}
+				buffer.Reset()
+				buffer.Write(rendered)
+			}
+			return completeExportJob(ctx, requestID, job, &buffer)
+		}
+
+		if !isDocument && buffer.Len() >= exportPartSize {
+			if err := uploadExportPart(ctx, job, buffer.Bytes()); err != nil {
+				return err
+			}
+			buffer.Reset()
+			job.CarryBytes = 0
+			if err := exportIO.SaveJob(ctx, job); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func uploadExportPart(ctx context.Context, job *ExportJob, data []byte) error {
+	number := int32(len(job.Parts) + 1)
+	etag, err := exportIO.UploadPart(ctx, job, number, data)
+	if err != nil {
+		return err
+	}
+
+	job.Parts = append(job.Parts, exportPart{Number: number, ETag: etag})
+	job.BytesWritten += int64(len(data))
+	return nil
+}
+
+func completeExportJob(ctx context.Context, requestID string, job *ExportJob, buffer *bytes.Buffer) error {
+	if buffer.Len() > 0 || len(job.Parts) == 0 {
+		if err := uploadExportPart(ctx, job, buffer.Bytes()); err != nil {
+			return err
+		}
+	}
+
+	if err := exportIO.CompleteUpload(ctx, job); err != nil {
+		return err
+	}
+	deleteExportCarry(ctx, job)
+
+	job.Status = exportCompleted
+	job.CarryBytes = 0
+	job.Cursor = ""
+	if err := exportIO.SaveJob(ctx, job); err != nil {
+		return err
+	}
+	log.Printf("RequestID: %s - Export completed: %d records, %d bytes in %d parts, complete: %t",
+		requestID, job.RecordCount, job.BytesWritten, len(job.Parts), job.Complete)
+	return nil
+}
+
+// Save the bytes produced since the last part and hand the job to the next worker
+func yieldExportJob(ctx context.Context, requestID string, job *ExportJob, buffer *bytes.Buffer) error {
+	if job.Step >= maxExportSteps {
+		return fmt.Errorf("%w within %d invocations", errExportUnfinished, maxExportSteps)
+	}
+
+	if buffer.Len() > 0 {
+		if err := exportIO.PutCarry(ctx, job, buffer.Bytes()); err != nil {
+			return err
+		}
+	}
+	job.CarryBytes = int64(buffer.Len())
+	job.Status = exportPending
+	if err := exportIO.SaveJob(ctx, job); err != nil {
+		return err
+	}
+	log.Printf("RequestID: %s - Export yielded at step %d: %d records, %d bytes carried over", requestID, job.Step, job.RecordCount, job.CarryBytes)
+	return nil
+}
+
+func exportCarryKey(job *ExportJob) string {
+	return fmt.Sprintf("exports/%s/.carry", job.JobID)
+}
+
+func readExportCarry(ctx context.Context, job *ExportJob, buffer *bytes.Buffer) error {
+	data, err := exportIO.GetCarry(ctx, job)
+	if err != nil {
+		return err
+	}
+	if int64(len(data)) != job.CarryBytes {
+		return fmt.Errorf("export carry-over has %d bytes, expected %d", len(data), job.CarryBytes)
+	}
+	buffer.Write(data)
+	return nil
+}
+
+func deleteExportCarry(ctx context.Context, job *ExportJob) {
+	if job.Step <= 1 {
+		return // Only a yielded job has a carry-over
+	}
+	if err := exportIO.DeleteCarry(ctx, job); err != nil {
+		log.Printf("RequestID: export-%s - Warning: Failed to delete export carry-over: %v", job.JobID, err)
+	}
+}
+
+// Abort the upload and mark the job FAILED. Client errors keep their message; anything
+// else is reported generically and logged.
+func failExportJob(ctx context.Context, job *ExportJob, cause error) error {
+	if job.UploadID != "" {
+		if err := exportIO.AbortUpload(ctx, job); err != nil {
+			log.Printf("RequestID: export-%s - Warning: Failed to abort upload: %v", job.JobID, err)
+		}
+	}
+	deleteExportCarry(ctx, job)
+
+	var reqErr *requestError
+	switch {
+	case errors.As(cause, &reqErr):
+		job.Error = reqErr.Message
+	case errors.Is(cause, errExportUnfinished):
+		job.Error = cause.Error()
+	default:
+		job.Error = "Internal error while generating the export"
+	}
+	job.Status = exportFailed
+	job.CarryBytes = 0
+	return exportIO.SaveJob(ctx, job)
+}
+
+// Put a RUNNING job whose worker disappeared back to PENDING, which starts a new worker
+func requeueExportJob(ctx context.Context, job *ExportJob) error {
+	now := time.Now().UTC().Format(time.RFC3339)
+	_, err := dynamoClient.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName:           aws.String(appConfig.ExportJobsTable),
+		Key:                 map[string]types.AttributeValue{"jobId": &types.AttributeValueMemberS{Value: job.JobID}},
+		UpdateExpression:    aws.String("SET #status = :pending, updatedAt = :now"),
+		ConditionExpression: aws.String("#status = :running AND #step = :step"),
+		ExpressionAttributeNames: map[string]string{
+			"#status": "status",
+			"#step":   "step",
+		},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":pending": &types.AttributeValueMemberS{Value: exportPending},
+			":running": &types.AttributeValueMemberS{Value: exportRunning},
+			":step":    &types.AttributeValueMemberN{Value: fmt.Sprint(job.Step)},
+			":now":     &types.AttributeValueMemberS{Value: now},
+		},
+	})
+	if err != nil {
+		return err
+	}
+	job.Status = exportPending
+	job.UpdatedAt = now
+	return nil
+}
+
+func isConditionFailed(err error) bool {
+	var conditionFailed *types.ConditionalCheckFailedException
+	return errors.As(err, &conditionFailed)
+}
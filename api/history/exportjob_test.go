@@ -0,0 +1,280 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// In-memory export backend: one job record, its upload and its carry-over. Each worker
+// reads yieldAfter pages before it is told to yield; 0 never yields.
+type fakeExportBackend struct {
+	records    []VerificationRecord
+	yieldAfter int
+	pagesRead  int
+
+	job     ExportJob
+	claims  int
+	refused int
+
+	uploads   int
+	parts     map[int32][]byte
+	file      []byte
+	carry     []byte
+	carryPuts int
+}
+
+func newFakeExportBackend(t *testing.T, records []VerificationRecord, yieldAfter int) *fakeExportBackend {
+	t.Helper()
+	fake := &fakeExportBackend{
+		records:    records,
+		yieldAfter: yieldAfter,
+		job: ExportJob{
+			JobID:     "0123456789abcdef",
+			Status:    exportPending,
+			Format:    "csv",
+			ObjectKey: "exports/0123456789abcdef/history-export.csv",
+			CreatedAt: "2025-06-18T12:00:00Z",
+		},
+		parts: map[int32][]byte{},
+	}
+	saved := exportIO
+	exportIO = fake
+	t.Cleanup(func() { exportIO = saved })
+	return fake
+}
+
+// Copy of a job that shares no slices with the original
+func cloneExportJob(job ExportJob) ExportJob {
+	job.Parts = append([]exportPart(nil), job.Parts...)
+	return job
+}
+
+func (f *fakeExportBackend) ReadPage(ctx context.Context, requestID string, query historyQuery, pageSize int, cursor string) ([]VerificationRecord, string, error) {
+	f.pagesRead++
+	start := 0
+	if cursor != "" {
+		start, _ = strconv.Atoi(cursor)
+	}
+	end := start + pageSize
+	if end >= len(f.records) {
+		return f.records[start:], "", nil
+	}
+	return f.records[start:end], strconv.Itoa(end), nil
+}
+
+func (f *fakeExportBackend) ShouldYield(ctx context.Context) bool {
+	return f.yieldAfter > 0 && f.pagesRead >= f.yieldAfter
+}
+
+func (f *fakeExportBackend) ClaimJob(ctx context.Context, job *ExportJob) error {
+	if f.job.Status != exportPending || f.job.Step != job.Step {
+		f.refused++
+		return &types.ConditionalCheckFailedException{}
+	}
+	f.claims++
+	f.pagesRead = 0
+	f.job.Status = exportRunning
+	f.job.Step++
+	*job = cloneExportJob(f.job)
+	return nil
+}
+
+func (f *fakeExportBackend) SaveJob(ctx context.Context, job *ExportJob) error {
+	if f.job.Step != job.Step {
+		return &types.ConditionalCheckFailedException{}
+	}
+	f.job = cloneExportJob(*job)
+	return nil
+}
+
+func (f *fakeExportBackend) CreateUpload(ctx context.Context, job *ExportJob, contentType string) (string, error) {
+	f.uploads++
+	return fmt.Sprintf("upload-%d", f.uploads), nil
+}
+
+func (f *fakeExportBackend) UploadPart(ctx context.Context, job *ExportJob, number int32, data []byte) (string, error) {
+	f.parts[number] = append([]byte(nil), data...)
+	return fmt.Sprintf("etag-%d", number), nil
+}
+
+func (f *fakeExportBackend) CompleteUpload(ctx context.Context, job *ExportJob) error {
+	f.file = nil
+	for _, part := range job.Parts {
+		f.file = append(f.file, f.parts[part.Number]...)
+	}
+	return nil
+}
+
+func (f *fakeExportBackend) AbortUpload(ctx context.Context, job *ExportJob) error {
+	return nil
+}
+
+func (f *fakeExportBackend) PutCarry(ctx context.Context, job *ExportJob, data []byte) error {
+	f.carryPuts++
+	f.carry = append([]byte(nil), data...)
+	return nil
+}
+
+func (f *fakeExportBackend) GetCarry(ctx context.Context, job *ExportJob) ([]byte, error) {
+	return f.carry, nil
+}
+
+func (f *fakeExportBackend) DeleteCarry(ctx context.Context, job *ExportJob) error {
+	f.carry = nil
+	return nil
+}
+
+// Deliver the stored job to a worker, as the job table's stream does after every save
+// that leaves it PENDING
+func (f *fakeExportBackend) deliver(t *testing.T) {
+	t.Helper()
+	job := cloneExportJob(f.job)
+	if err := runExportJob(context.Background(), &job); err != nil {
+		t.Fatalf("runExportJob() returned error: %v", err)
+	}
+	if f.job.Status == exportFailed {
+		t.Fatalf("export job failed: %s", f.job.Error)
+	}
+}
+
+func exportTestRecords(count int, productID string) []VerificationRecord {
+	records := make([]VerificationRecord, count)
+	for i := range records {
+		records[i] = xlsxTestRecord(fmt.Sprintf("id-%d", i), fmt.Sprintf("2025-06-18T12:%02d:%02d", i/60%60, i%60), "REF", 0.95)
+		records[i].ProductID = productID
+	}
+	return records
+}
+
+// The CSV file of records as a single worker would write it
+func expectedExportCSV(t *testing.T, records []VerificationRecord) []byte {
+	t.Helper()
+	encoder := mustNewCSVEncoder(t, nil)
+	var buffer bytes.Buffer
+	buffer.Write(encoder.Header(nil))
+	for i, record := range records {
+		data, ok := encoder.Record("test", record, i)
+		if !ok {
+			t.Fatalf("Record(%s) returned false", record.ID)
+		}
+		buffer.Write(data)
+	}
+	buffer.Write(encoder.Footer(nil, len(records)))
+	return buffer.Bytes()
+}
+
+func TestExportRecordsResumesFromCarry(t *testing.T) {
+	records := exportTestRecords(250, "AQR-M466XA(GB)")
+	fake := newFakeExportBackend(t, records, 1)
+
+	fake.deliver(t)
+	job := fake.job
+	if job.Status != exportPending || job.Step != 1 || job.RecordCount != 100 || job.Cursor != "100" {
+		t.Fatalf("after the first worker: status %s, step %d, %d records, cursor %q; want PENDING, 1, 100, \"100\"",
+			job.Status, job.Step, job.RecordCount, job.Cursor)
+	}
+	// Less than a part was written, so everything including the header is carried over
+	if len(job.Parts) != 0 || job.CarryBytes == 0 || job.CarryBytes != int64(len(fake.carry)) {
+		t.Fatalf("after the first worker: %d parts, CarryBytes %d, carry-over of %d bytes; want no parts and the carry-over counted",
+			len(job.Parts), job.CarryBytes, len(fake.carry))
+	}
+	if want := expectedExportCSV(t, records[:100]); !bytes.Equal(fake.carry, want) {
+		t.Errorf("carry-over = %d bytes, want the header and the first 100 records (%d bytes)", len(fake.carry), len(want))
+	}
+
+	for i := 0; fake.job.Status == exportPending; i++ {
+		if i == maxExportSteps {
+			t.Fatalf("export still PENDING after %d workers", i)
+		}
+		fake.deliver(t)
+	}
+
+	job = fake.job
+	if job.Status != exportCompleted || job.Step != 3 || job.RecordCount != 250 || !job.Complete {
+		t.Errorf("completed job: status %s, step %d, %d records, complete %t; want COMPLETED, 3, 250, true",
+			job.Status, job.Step, job.RecordCount, job.Complete)
+	}
+	if len(job.Parts) != 1 || job.CarryBytes != 0 || fake.carry != nil || fake.uploads != 1 {
+		t.Errorf("completed job: %d parts, CarryBytes %d, carry-over kept %t, %d uploads; want 1 part, no carry-over and 1 upload",
+			len(job.Parts), job.CarryBytes, fake.carry != nil, fake.uploads)
+	}
+	if want := expectedExportCSV(t, records); !bytes.Equal(fake.file, want) {
+		t.Errorf("export file = %d bytes, want %d bytes of the single-worker file", len(fake.file), len(want))
+	}
+}
+
+func TestExportRecordsPartBoundaryAtYield(t *testing.T) {
+	// A page of 100 records of 100 KiB fills a part, so the first worker cuts one and
+	// then yields with an empty buffer
+	records := exportTestRecords(150, strings.Repeat("A", 100<<10))
+	fake := newFakeExportBackend(t, records, 1)
+
+	fake.deliver(t)
+	job := fake.job
+	if job.Status != exportPending || len(job.Parts) != 1 || job.CarryBytes != 0 || fake.carryPuts != 0 {
+		t.Fatalf("after the first worker: status %s, %d parts, CarryBytes %d, %d carry-over writes; want PENDING, 1 part and no carry-over",
+			job.Status, len(job.Parts), job.CarryBytes, fake.carryPuts)
+	}
+	if job.BytesWritten != int64(len(fake.parts[1])) || len(fake.parts[1]) < exportPartSize {
+		t.Errorf("part 1 has %d bytes and BytesWritten is %d, want a full part counted", len(fake.parts[1]), job.BytesWritten)
+	}
+
+	// The next worker neither reads a carry-over nor writes the header again
+	fake.deliver(t)
+	job = fake.job
+	if job.Status != exportCompleted || len(job.Parts) != 2 || job.RecordCount != 150 {
+		t.Fatalf("after the second worker: status %s, %d parts, %d records; want COMPLETED, 2 parts, 150 records",
+			job.Status, len(job.Parts), job.RecordCount)
+	}
+	if want := expectedExportCSV(t, records); !bytes.Equal(fake.file, want) {
+		t.Errorf("export file = %d bytes, want %d bytes of the single-worker file", len(fake.file), len(want))
+	}
+	if job.BytesWritten != int64(len(fake.file)) {
+		t.Errorf("BytesWritten = %d, want %d", job.BytesWritten, len(fake.file))
+	}
+}
+
+func TestProcessExportJobsDuplicateDelivery(t *testing.T) {
+	records := exportTestRecords(10, "AQR-M466XA(GB)")
+	fake := newFakeExportBackend(t, records, 0)
+
+	image := map[string]events.DynamoDBAttributeValue{
+		"jobId":     events.NewStringAttribute(fake.job.JobID),
+		"status":    events.NewStringAttribute(exportPending),
+		"format":    events.NewStringAttribute(fake.job.Format),
+		"step":      events.NewNumberAttribute("0"),
+		"objectKey": events.NewStringAttribute(fake.job.ObjectKey),
+		"createdAt": events.NewStringAttribute(fake.job.CreatedAt),
+	}
+	record := events.DynamoDBEventRecord{EventID: "1", Change: events.DynamoDBStreamRecord{NewImage: image}}
+	event := events.DynamoDBEvent{Records: []events.DynamoDBEventRecord{record, record}}
+
+	if _, err := processExportJobs(context.Background(), event); err != nil {
+		t.Fatalf("processExportJobs() returned error: %v", err)
+	}
+	if fake.claims != 1 || fake.refused != 1 || fake.uploads != 1 {
+		t.Errorf("%d claims, %d refused and %d uploads, want the second delivery refused and one upload", fake.claims, fake.refused, fake.uploads)
+	}
+	if fake.job.Status != exportCompleted || fake.job.Step != 1 || fake.job.RecordCount != 10 {
+		t.Errorf("job: status %s, step %d, %d records; want COMPLETED, step 1, 10 records", fake.job.Status, fake.job.Step, fake.job.RecordCount)
+	}
+	if want := expectedExportCSV(t, records); !bytes.Equal(fake.file, want) {
+		t.Errorf("export file = %q, want %q", fake.file, want)
+	}
+
+	// A delivery of the completed job's image is not even claimed
+	image["status"] = events.NewStringAttribute(exportCompleted)
+	if _, err := processExportJobs(context.Background(), events.DynamoDBEvent{Records: []events.DynamoDBEventRecord{record}}); err != nil {
+		t.Fatalf("processExportJobs() returned error: %v", err)
+	}
+	if fake.claims != 1 || fake.refused != 1 {
+		t.Errorf("a COMPLETED image was claimed: %d claims, %d refused", fake.claims, fake.refused)
+	}
+}
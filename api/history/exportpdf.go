@@ -0,0 +1,384 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/go-fonts/dejavu/dejavusans"
+	"github.com/go-fonts/dejavu/dejavusansbold"
+	"github.com/go-fonts/dejavu/dejavusanscondensed"
+	"github.com/go-fonts/dejavu/dejavusanscondensedbold"
+	"github.com/go-pdf/fpdf"
+)
+
+const (
+	// A printable report stops at this many records; the table alone is about 120 pages
+	maxPDFRecords = 5000
+	// Explanations are cut to this many characters while the report is being collected
+	pdfNoteLength = 200
+	// Categories drawn in the breakdown chart, the rest are summed as "Other"
+	pdfChartCategories = 8
+)
+
+// Monthly report layout: a summary page with charts, then a paginated table of the
+// verifications. Text is set in embedded DejaVu fonts so Vietnamese diacritics render.
+type pdfEncoder struct{}
+
+func (pdfEncoder) ContentType() string {
+	return "application/pdf"
+}
+
+func (pdfEncoder) Header(*ExportJob) []byte {
+	return nil
+}
+
+func (pdfEncoder) Record(requestID string, record VerificationRecord, written int) ([]byte, bool) {
+	return compactExportRecord(requestID, record, pdfNoteLength)
+}
+
+func (pdfEncoder) Footer(*ExportJob, int) []byte {
+	return nil
+}
+
+func (pdfEncoder) MaxRecords() int {
+	return maxPDFRecords
+}
+
+func (pdfEncoder) Render(requestID string, job *ExportJob, rows []byte) ([]byte, error) {
+	records, err := readCompactRecords(rows)
+	if err != nil {
+		return nil, err
+	}
+
+	report := newPDFReport(job)
+	report.summaryPage(calculateSummaryAnalytics(requestID, records), len(records))
+	report.verificationTable(requestID, records)
+
+	var out bytes.Buffer
+	if err := report.pdf.Output(&out); err != nil {
+		return nil, fmt.Errorf("failed to render PDF report: %w", err)
+	}
+	return out.Bytes(), nil
+}
+
+// Colours of the report: results, confidence buckets and chart bars
+var (
+	pdfColorText      = [3]int{33, 37, 41}
+	pdfColorMuted     = [3]int{108, 117, 125}
+	pdfColorRule      = [3]int{222, 226, 230}
+	pdfColorHeader    = [3]int{233, 236, 239}
+	pdfColorStripe    = [3]int{248, 249, 250}
+	pdfColorCorrect   = [3]int{25, 135, 84}
+	pdfColorUncertain = [3]int{255, 193, 7}
+	pdfColorIncorrect = [3]int{220, 53, 69}
+	pdfColorBar       = [3]int{13, 110, 253}
+)
+
+type pdfReport struct {
+	pdf *fpdf.Fpdf
+	job *ExportJob
+}
+
+func newPDFReport(job *ExportJob) *pdfReport {
+	pdf := fpdf.New("L", "mm", "A4", "")
+	pdf.AddUTF8FontFromBytes("DejaVu", "", dejavusans.TTF)
+	pdf.AddUTF8FontFromBytes("DejaVu", "B", dejavusansbold.TTF)
+	pdf.AddUTF8FontFromBytes("DejaVuCondensed", "", dejavusanscondensed.TTF)
+	pdf.AddUTF8FontFromBytes("DejaVuCondensed", "B", dejavusanscondensedbold.TTF)
+	pdf.SetMargins(12, 12, 12)
+	pdf.SetAutoPageBreak(true, 14)
+	pdf.SetTitle("Verification Report", true)
+	pdf.SetProducer("history-api", true)
+	if created, err := time.Parse(time.RFC3339, job.CreatedAt); err == nil {
+		pdf.SetCreationDate(created)
+	}
+	pdf.AliasNbPages("")
+
+	report := &pdfReport{pdf: pdf, job: job}
+	pdf.SetFooterFunc(func() {
+		pdf.SetY(-10)
+		pdf.SetFont("DejaVu", "", 7)
+		report.textColor(pdfColorMuted)
+		pdf.CellFormat(0, 4, fmt.Sprintf("Export %s · Generated %s", job.JobID, job.CreatedAt), "", 0, "L", false, 0, "")
+		left, _, _, _ := pdf.GetMargins()
+		pdf.SetX(left)
+		pdf.CellFormat(0, 4, fmt.Sprintf("Page %d / {nb}", pdf.PageNo()), "", 0, "R", false, 0, "")
+	})
+	return report
+}
+
+func (r *pdfReport) textColor(color [3]int) {
+	r.pdf.SetTextColor(color[0], color[1], color[2])
+}
+
+func (r *pdfReport) fillColor(color [3]int) {
+	r.pdf.SetFillColor(color[0], color[1], color[2])
+}
+
+func (r *pdfReport) drawColor(color [3]int) {
+	r.pdf.SetDrawColor(color[0], color[1], color[2])
+}
+
+func (r *pdfReport) summaryPage(summary SummaryData, collected int) {
+	pdf := r.pdf
+	pdf.AddPage()
+
+	pdf.SetFont("DejaVu", "B", 18)
+	r.textColor(pdfColorText)
+	pdf.CellFormat(0, 9, "Verification Report · Báo cáo kiểm định", "", 1, "L", false, 0, "")
+	pdf.SetFont("DejaVu", "", 9)
+	r.textColor(pdfColorMuted)
+	pdf.CellFormat(0, 5, "Period: "+exportPeriod(r.job), "", 1, "L", false, 0, "")
+	if filters := exportFilterSummary(r.job); filters != "" {
+		pdf.CellFormat(0, 5, "Filters: "+filters, "", 1, "L", false, 0, "")
+	}
+	if !r.job.Complete {
+		r.textColor(pdfColorIncorrect)
+		pdf.CellFormat(0, 5, fmt.Sprintf("Only the newest %d verifications are included; narrow the filters for a complete report.", collected), "", 1, "L", false, 0, "")
+	}
+	pdf.Ln(4)
+
+	// Key figures
+	figures := []struct{ label, value string }{
+		{"Verifications", fmt.Sprintf("%d", summary.TotalVerifications)},
+		{"Success rate", fmt.Sprintf("%.1f%%", summary.SuccessRate)},
+		{"Average confidence", fmt.Sprintf("%.3f", summary.AverageConfidence)},
+		{"Label match rate", fmt.Sprintf("%.1f%%", summary.LabelAccuracy.SuccessRate)},
+		{"Overview match rate", fmt.Sprintf("%.1f%%", summary.OverviewAccuracy.SuccessRate)},
+	}
+	left, top := pdf.GetX(), pdf.GetY()
+	gap := 4.0
+	width := (r.contentWidth() - gap*float64(len(figures)-1)) / float64(len(figures))
+	for i, figure := range figures {
+		x := left + float64(i)*(width+gap)
+		r.fillColor(pdfColorStripe)
+		r.drawColor(pdfColorRule)
+		pdf.Rect(x, top, width, 22, "FD")
+		pdf.SetXY(x, top+3)
+		pdf.SetFont("DejaVu", "", 8)
+		r.textColor(pdfColorMuted)
+		pdf.CellFormat(width, 5, figure.label, "", 2, "C", false, 0, "")
+		pdf.SetFont("DejaVu", "B", 16)
+		r.textColor(pdfColorText)
+		pdf.CellFormat(width, 10, figure.value, "", 0, "C", false, 0, "")
+	}
+	pdf.SetXY(left, top+30)
+
+	chartTop := pdf.GetY()
+	half := (r.contentWidth() - 10) / 2
+
+	distribution := summary.ConfidenceDistribution
+	r.barChart(left, chartTop, half, "Confidence distribution", []pdfBar{
+		{fmt.Sprintf("High (%s)", distribution.High.Range), float64(distribution.High.Count), fmt.Sprintf("%d · %.1f%%", distribution.High.Count, distribution.High.Percentage), pdfColorCorrect},
+		{fmt.Sprintf("Medium (%s)", distribution.Medium.Range), float64(distribution.Medium.Count), fmt.Sprintf("%d · %.1f%%", distribution.Medium.Count, distribution.Medium.Percentage), pdfColorUncertain},
+		{fmt.Sprintf("Low (%s)", distribution.Low.Range), float64(distribution.Low.Count), fmt.Sprintf("%d · %.1f%%", distribution.Low.Count, distribution.Low.Percentage), pdfColorIncorrect},
+	})
+	r.barChart(left+half+10, chartTop, half, "Verifications by category", categoryBars(summary.CategoryBreakdown))
+}
+
+type pdfBar struct {
+	label string
+	value float64
+	note  string
+	color [3]int
+}
+
+// Categories by count, with success rates, folding the smallest into "Other"
+func categoryBars(breakdown map[string]CategoryStats) []pdfBar {
+	categories := make([]string, 0, len(breakdown))
+	for category := range breakdown {
+		categories = append(categories, category)
+	}
+	sort.Slice(categories, func(i, j int) bool {
+		a, b := breakdown[categories[i]], breakdown[categories[j]]
+		if a.Count != b.Count {
+			return a.Count > b.Count
+		}
+		return categories[i] < categories[j]
+	})
+
+	bars := []pdfBar{}
+	var other, otherCorrect float64
+	for i, category := range categories {
+		stats := breakdown[category]
+		if i >= pdfChartCategories-1 && len(categories) > pdfChartCategories {
+			other += float64(stats.Count)
+			otherCorrect += float64(stats.Count) * stats.SuccessRate / 100
+			continue
+		}
+		label := category
+		if label == "" {
+			label = "(none)"
+		}
+		bars = append(bars, pdfBar{label, float64(stats.Count), fmt.Sprintf("%d · %.0f%% correct", stats.Count, stats.SuccessRate), pdfColorBar})
+	}
+	if other > 0 {
+		bars = append(bars, pdfBar{"Other", other, fmt.Sprintf("%.0f · %.0f%% correct", other, otherCorrect/other*100), pdfColorMuted})
+	}
+	return bars
+}
+
+// Horizontal bar chart scaled to the largest value
+func (r *pdfReport) barChart(x, y, width float64, title string, bars []pdfBar) {
+	pdf := r.pdf
+	pdf.SetXY(x, y)
+	pdf.SetFont("DejaVu", "B", 11)
+	r.textColor(pdfColorText)
+	pdf.CellFormat(width, 7, title, "", 2, "L", false, 0, "")
+
+	if len(bars) == 0 {
+		pdf.SetFont("DejaVu", "", 9)
+		r.textColor(pdfColorMuted)
+		pdf.CellFormat(width, 6, "No verifications", "", 2, "L", false, 0, "")
+		return
+	}
+
+	largest := 0.0
+	for _, bar := range bars {
+		if bar.value > largest {
+			largest = bar.value
+		}
+	}
+
+	labelWidth, noteWidth := width*0.3, width*0.25
+	barWidth := width - labelWidth - noteWidth - 4
+	rowHeight := 9.0
+	top := pdf.GetY() + 2
+	for i, bar := range bars {
+		rowY := top + float64(i)*rowHeight
+		pdf.SetXY(x, rowY)
+		pdf.SetFont("DejaVuCondensed", "", 8)
+		r.textColor(pdfColorText)
+		pdf.CellFormat(labelWidth, 6, fitText(pdf, bar.label, labelWidth-1), "", 0, "L", false, 0, "")
+
+		r.fillColor(pdfColorHeader)
+		pdf.Rect(x+labelWidth, rowY+0.5, barWidth, 5, "F")
+		if largest > 0 && bar.value > 0 {
+			r.fillColor(bar.color)
+			pdf.Rect(x+labelWidth, rowY+0.5, barWidth*bar.value/largest, 5, "F")
+		}
+
+		pdf.SetXY(x+labelWidth+barWidth+4, rowY)
+		r.textColor(pdfColorMuted)
+		pdf.CellFormat(noteWidth, 6, bar.note, "", 0, "L", false, 0, "")
+	}
+	pdf.SetXY(x, top+float64(len(bars))*rowHeight)
+}
+
+type pdfColumn struct {
+	title string
+	width float64
+	align string
+}
+
+var pdfTableColumns = []pdfColumn{
+	{"Date", 27, "L"},
+	{"Product", 32, "L"},
+	{"Category", 28, "L"},
+	{"Result", 21, "L"},
+	{"Confidence", 17, "R"},
+	{"Label", 13, "C"},
+	{"Overview", 15, "C"},
+	{"Model", 40, "L"},
+	{"Label notes", 80, "L"},
+}
+
+func (r *pdfReport) tableHeader() {
+	pdf := r.pdf
+	pdf.SetFont("DejaVuCondensed", "B", 8)
+	r.textColor(pdfColorText)
+	r.fillColor(pdfColorHeader)
+	r.drawColor(pdfColorRule)
+	for _, column := range pdfTableColumns {
+		pdf.CellFormat(column.width, 7, column.title, "B", 0, column.align, true, 0, "")
+	}
+	pdf.Ln(-1)
+	pdf.SetFont("DejaVuCondensed", "", 7.5)
+}
+
+// One row per verification, newest first, repeating the header on every page
+func (r *pdfReport) verificationTable(requestID string, records []VerificationRecord) {
+	pdf := r.pdf
+	pdf.AddPage()
+	pdf.SetFont("DejaVu", "B", 13)
+	r.textColor(pdfColorText)
+	pdf.CellFormat(0, 8, fmt.Sprintf("Verifications (%d)", len(records)), "", 1, "L", false, 0, "")
+	r.tableHeader()
+
+	_, pageHeight := pdf.GetPageSize()
+	_, _, _, bottom := pdf.GetMargins()
+	rowHeight := 6.0
+	for i, record := range records {
+		results, err := parseVerificationResults(requestID, record.BedrockResponse)
+		if err != nil {
+			continue
+		}
+		if pdf.GetY()+rowHeight > pageHeight-bottom {
+			pdf.AddPage()
+			r.tableHeader()
+		}
+
+		overallConfidence := (results.MatchLabelConfidence + results.MatchOverviewConfidence) / 2
+		result := determineVerificationResult(results, overallConfidence)
+		date := record.Timestamp
+		if ts, err := parseResultTimestamp(record.Timestamp); err == nil {
+			date = ts.Format("2006-01-02 15:04")
+		}
+
+		cells := []string{
+			date,
+			record.ProductID,
+			record.ProductCategory,
+			result,
+			fmt.Sprintf("%.3f", overallConfidence),
+			results.MatchLabelToReference,
+			results.MatchOverviewToReference,
+			record.BedrockResponse.Model,
+			results.LabelExplanation,
+		}
+
+		fill := i%2 == 1
+		r.fillColor(pdfColorStripe)
+		for c, column := range pdfTableColumns {
+			r.textColor(pdfColorText)
+			if c == 3 {
+				r.textColor(resultColor(result))
+			}
+			pdf.CellFormat(column.width, rowHeight, fitText(pdf, cells[c], column.width-2), "", 0, column.align, fill, 0, "")
+		}
+		pdf.Ln(-1)
+	}
+}
+
+func resultColor(result string) [3]int {
+	switch result {
+	case "CORRECT":
+		return pdfColorCorrect
+	case "INCORRECT":
+		return pdfColorIncorrect
+	default:
+		return [3]int{176, 132, 0}
+	}
+}
+
+func (r *pdfReport) contentWidth() float64 {
+	pageWidth, _ := r.pdf.GetPageSize()
+	left, _, right, _ := r.pdf.GetMargins()
+	return pageWidth - left - right
+}
+
+// Cut text to fit a cell in the current font, ending it with an ellipsis
+func fitText(pdf *fpdf.Fpdf, text string, width float64) string {
+	text = strings.Join(strings.Fields(text), " ")
+	if pdf.GetStringWidth(text) <= width {
+		return text
+	}
+	runes := []rune(text)
+	fits := sort.Search(len(runes), func(n int) bool {
+		return pdf.GetStringWidth(string(runes[:n+1])+"…") > width
+	})
+	return strings.TrimSpace(string(runes[:fits])) + "…"
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"bytes"
+	"compress/zlib"
+	"io"
+	"regexp"
+	"testing"
+	"unicode/utf16"
+)
+
+var pdfStreamPattern = regexp.MustCompile(`(?s)stream\r?\n(.*?)endstream`)
+
+// Decompressed contents of the Flate-encoded streams of a PDF file
+func pdfStreams(t *testing.T, document []byte) [][]byte {
+	t.Helper()
+	var streams [][]byte
+	for _, match := range pdfStreamPattern.FindAllSubmatch(document, -1) {
+		reader, err := zlib.NewReader(bytes.NewReader(match[1]))
+		if err != nil {
+			continue // Not compressed
+		}
+		data, err := io.ReadAll(reader)
+		if err != nil {
+			t.Fatalf("failed to decompress PDF stream: %v", err)
+		}
+		streams = append(streams, data)
+	}
+	return streams
+}
+
+// Text as it appears in a content stream set in an Identity-H font
+func utf16BE(text string) []byte {
+	var out []byte
+	for _, unit := range utf16.Encode([]rune(text)) {
+		out = append(out, byte(unit>>8), byte(unit))
+	}
+	return out
+}
+
+func TestPDFEncoderRenderVietnamese(t *testing.T) {
+	records := []VerificationRecord{
+		xlsxTestRecord("id-1", "2025-06-18T12:00:00", "REF", 0.95),
+		xlsxTestRecord("id-2", "2025-06-18T06:00:00", "WM", 0.2),
+	}
+	records[1].ProductID = "Máy giặt cửa trước"
+
+	var rows []byte
+	encoder := pdfEncoder{}
+	for i, record := range records {
+		if line, ok := encoder.Record("test", record, i); ok {
+			rows = append(rows, line...)
+		}
+	}
+
+	job := &ExportJob{
+		JobID:     "0123456789abcdef",
+		Format:    "pdf",
+		Filters:   map[string]string{"dateFrom": "2025-06-01"},
+		Complete:  true,
+		CreatedAt: "2025-06-19T01:02:03Z",
+	}
+	document, err := encoder.Render("test", job, rows)
+	if err != nil {
+		t.Fatalf("Render returned error: %v", err)
+	}
+
+	if !bytes.HasPrefix(document, []byte("%PDF-")) {
+		t.Errorf("document starts with %q, want a PDF header", document[:min(len(document), 8)])
+	}
+	if !bytes.HasSuffix(bytes.TrimSpace(document), []byte("%%EOF")) {
+		t.Errorf("document does not end with %%%%EOF")
+	}
+	// A summary page and one page of the table
+	if !bytes.Contains(document, []byte("/Type /Pages")) || !bytes.Contains(document, []byte("/Count 2")) {
+		t.Errorf("document does not have 2 pages")
+	}
+
+	// The DejaVu fonts are embedded rather than referenced
+	for _, font := range []string{"/BaseFont /utf8dejavu", "/FontFile2", "/Subtype /CIDFontType2"} {
+		if !bytes.Contains(document, []byte(font)) {
+			t.Errorf("document has no %s", font)
+		}
+	}
+
+	// Text with diacritics is set as is, in the embedded font's code points
+	streams := pdfStreams(t, document)
+	for _, text := range []string{"Nhãn năng lượng khớp", "Máy giặt cửa trước"} {
+		found := false
+		for _, stream := range streams {
+			if bytes.Contains(stream, utf16BE(text)) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			t.Errorf("no page content contains %q", text)
+		}
+	}
+}
@@ -0,0 +1,415 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// The whole workbook is built at the end of the export, so it holds fewer records than
+	// a CSV or JSON file
+	maxXLSXRecords = 50000
+	// Longest text Excel keeps in a cell
+	xlsxCellLength = 32767
+)
+
+// Cell styles, as indexes into cellXfs of xlsxStyles
+const (
+	xlsxStyleDefault = iota
+	xlsxStyleHeader
+	xlsxStyleDate
+	xlsxStylePercent
+	xlsxStyleInteger
+	xlsxStyleCurrency
+	xlsxStyleTitle
+)
+
+// Office Open XML workbook with a "History" sheet of typed columns, frozen header row and
+// autofilter, and a "Summary" sheet of the analytics of the exported records. Written
+// with inline strings, so Excel reads Vietnamese text without an import step.
+type xlsxEncoder struct{}
+
+func (xlsxEncoder) ContentType() string {
+	return "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"
+}
+
+func (xlsxEncoder) Header(*ExportJob) []byte {
+	return nil
+}
+
+func (xlsxEncoder) Record(requestID string, record VerificationRecord, written int) ([]byte, bool) {
+	return compactExportRecord(requestID, record, xlsxCellLength)
+}
+
+func (xlsxEncoder) Footer(*ExportJob, int) []byte {
+	return nil
+}
+
+func (xlsxEncoder) MaxRecords() int {
+	return maxXLSXRecords
+}
+
+func (xlsxEncoder) Render(requestID string, job *ExportJob, rows []byte) ([]byte, error) {
+	records, err := readCompactRecords(rows)
+	if err != nil {
+		return nil, err
+	}
+
+	history := historySheet(requestID, records)
+	summary := summarySheet(job, calculateSummaryAnalytics(requestID, records))
+
+	var out bytes.Buffer
+	archive := zip.NewWriter(&out)
+	parts := []struct {
+		name string
+		data []byte
+	}{
+		{"[Content_Types].xml", []byte(xlsxContentTypes)},
+		{"_rels/.rels", []byte(xlsxRootRels)},
+		{"docProps/core.xml", xlsxCoreProperties(job)},
+		{"xl/workbook.xml", xlsxWorkbook(len(records))},
+		{"xl/_rels/workbook.xml.rels", []byte(xlsxWorkbookRels)},
+		{"xl/styles.xml", []byte(xlsxStyles)},
+		{"xl/worksheets/sheet1.xml", history},
+		{"xl/worksheets/sheet2.xml", summary},
+	}
+	for _, part := range parts {
+		w, err := archive.Create(part.name)
+		if err != nil {
+			return nil, fmt.Errorf("failed to add %s to workbook: %w", part.name, err)
+		}
+		if _, err := w.Write(part.data); err != nil {
+			return nil, fmt.Errorf("failed to write %s to workbook: %w", part.name, err)
+		}
+	}
+	if err := archive.Close(); err != nil {
+		return nil, fmt.Errorf("failed to finish workbook: %w", err)
+	}
+	return out.Bytes(), nil
+}
+
+type xlsxColumn struct {
+	title string
+	width float64
+}
+
+var xlsxHistoryColumns = []xlsxColumn{
+	{"ID", 38},
+	{"Timestamp (UTC)", 20},
+	{"Product ID", 22},
+	{"Category", 12},
+	{"Verification Result", 18},
+	{"Overall Confidence", 18},
+	{"Label Match", 12},
+	{"Label Confidence", 16},
+	{"Overview Match", 15},
+	{"Overview Confidence", 19},
+	{"AI Model", 30},
+	{"Input Tokens", 13},
+	{"Output Tokens", 14},
+	{"Label Explanation", 60},
+	{"Overview Explanation", 60},
+}
+
+func historySheet(requestID string, records []VerificationRecord) []byte {
+	sheet := &xlsxSheet{}
+	sheet.header(xlsxHistoryColumns)
+	for _, record := range records {
+		results, err := parseVerificationResults(requestID, record.BedrockResponse)
+		if err != nil {
+			continue
+		}
+		overallConfidence := (results.MatchLabelConfidence + results.MatchOverviewConfidence) / 2
+
+		sheet.row(
+			xlsxText(record.ID),
+			xlsxTimestamp(record.Timestamp),
+			xlsxText(record.ProductID),
+			xlsxText(record.ProductCategory),
+			xlsxText(determineVerificationResult(results, overallConfidence)),
+			xlsxNumber(overallConfidence, xlsxStylePercent),
+			xlsxText(results.MatchLabelToReference),
+			xlsxNumber(results.MatchLabelConfidence, xlsxStylePercent),
+			xlsxText(results.MatchOverviewToReference),
+			xlsxNumber(results.MatchOverviewConfidence, xlsxStylePercent),
+			xlsxText(record.BedrockResponse.Model),
+			xlsxNumber(float64(record.BedrockResponse.Usage.InputTokens), xlsxStyleInteger),
+			xlsxNumber(float64(record.BedrockResponse.Usage.OutputTokens), xlsxStyleInteger),
+			xlsxText(results.LabelExplanation),
+			xlsxText(results.OverviewExplanation),
+		)
+	}
+	return sheet.document(xlsxHistoryColumns, true)
+}
+
+var xlsxSummaryColumns = []xlsxColumn{
+	{"", 28},
+	{"", 18},
+	{"", 16},
+	{"", 16},
+	{"", 16},
+}
+
+// Rates in SummaryData are percentages, the sheet stores them as fractions for the
+// percentage format
+func summarySheet(job *ExportJob, summary SummaryData) []byte {
+	sheet := &xlsxSheet{}
+	sheet.row(xlsxCell{text: "Verification Summary", style: xlsxStyleTitle})
+	sheet.row(xlsxText("Period"), xlsxText(exportPeriod(job)))
+	if filters := exportFilterSummary(job); filters != "" {
+		sheet.row(xlsxText("Filters"), xlsxText(filters))
+	}
+	sheet.row(xlsxText("Generated (UTC)"), xlsxTimestamp(job.CreatedAt))
+	if !job.Complete {
+		sheet.row(xlsxText("Note"), xlsxText(fmt.Sprintf("Only the newest %d verifications are included", summary.TotalVerifications)))
+	}
+	sheet.row()
+
+	sheet.row(xlsxHeader("Overview"), xlsxHeader("Value"))
+	sheet.row(xlsxText("Total Verifications"), xlsxNumber(float64(summary.TotalVerifications), xlsxStyleInteger))
+	sheet.row(xlsxText("Success Rate"), xlsxNumber(summary.SuccessRate/100, xlsxStylePercent))
+	sheet.row(xlsxText("Average Confidence"), xlsxNumber(summary.AverageConfidence, xlsxStylePercent))
+	sheet.row()
+
+	sheet.row(xlsxHeader("Accuracy"), xlsxHeader("Match Rate"), xlsxHeader("Avg Confidence"))
+	sheet.row(xlsxText("Label"), xlsxNumber(summary.LabelAccuracy.SuccessRate/100, xlsxStylePercent), xlsxNumber(summary.LabelAccuracy.AverageConfidence, xlsxStylePercent))
+	sheet.row(xlsxText("Overview"), xlsxNumber(summary.OverviewAccuracy.SuccessRate/100, xlsxStylePercent), xlsxNumber(summary.OverviewAccuracy.AverageConfidence, xlsxStylePercent))
+	sheet.row()
+
+	distribution := summary.ConfidenceDistribution
+	sheet.row(xlsxHeader("Confidence"), xlsxHeader("Range"), xlsxHeader("Count"), xlsxHeader("Share"))
+	for _, bucket := range []struct {
+		name string
+		ConfidenceBucket
+	}{{"High", distribution.High}, {"Medium", distribution.Medium}, {"Low", distribution.Low}} {
+		sheet.row(xlsxText(bucket.name), xlsxText(bucket.Range), xlsxNumber(float64(bucket.Count), xlsxStyleInteger), xlsxNumber(bucket.Percentage/100, xlsxStylePercent))
+	}
+	sheet.row()
+
+	sheet.row(xlsxHeader("Category"), xlsxHeader("Count"), xlsxHeader("Success Rate"), xlsxHeader("Avg Confidence"))
+	categories := make([]string, 0, len(summary.CategoryBreakdown))
+	for category := range summary.CategoryBreakdown {
+		categories = append(categories, category)
+	}
+	sort.Strings(categories)
+	for _, category := range categories {
+		stats := summary.CategoryBreakdown[category]
+		sheet.row(xlsxText(category), xlsxNumber(float64(stats.Count), xlsxStyleInteger), xlsxNumber(stats.SuccessRate/100, xlsxStylePercent), xlsxNumber(stats.AvgConfidence, xlsxStylePercent))
+	}
+	sheet.row()
+
+	sheet.row(xlsxHeader("AI Model"), xlsxHeader("Verifications"), xlsxHeader("Avg Input Tokens"), xlsxHeader("Avg Output Tokens"), xlsxHeader("Est. Cost"))
+	models := make([]string, 0, len(summary.AIModelUsage))
+	for model := range summary.AIModelUsage {
+		models = append(models, model)
+	}
+	sort.Strings(models)
+	for _, model := range models {
+		stats := summary.AIModelUsage[model]
+		sheet.row(xlsxText(model), xlsxNumber(float64(stats.Verifications), xlsxStyleInteger), xlsxNumber(float64(stats.AvgInputTokens), xlsxStyleInteger), xlsxNumber(float64(stats.AvgOutputTokens), xlsxStyleInteger), xlsxNumber(stats.TotalCost, xlsxStyleCurrency))
+	}
+	return sheet.document(xlsxSummaryColumns, false)
+}
+
+type xlsxCell struct {
+	text    string
+	number  float64
+	numeric bool
+	style   int
+}
+
+func xlsxText(text string) xlsxCell {
+	return xlsxCell{text: text}
+}
+
+func xlsxHeader(text string) xlsxCell {
+	return xlsxCell{text: text, style: xlsxStyleHeader}
+}
+
+func xlsxNumber(value float64, style int) xlsxCell {
+	return xlsxCell{number: value, numeric: true, style: style}
+}
+
+// Excel counts days from 1899-12-30; timestamps that do not parse stay text
+var xlsxEpoch = time.Date(1899, 12, 30, 0, 0, 0, 0, time.UTC)
+
+func xlsxTimestamp(value string) xlsxCell {
+	ts, err := parseResultTimestamp(value)
+	if err != nil {
+		return xlsxText(value)
+	}
+	return xlsxNumber(ts.Sub(xlsxEpoch).Hours()/24, xlsxStyleDate)
+}
+
+type xlsxSheet struct {
+	data    bytes.Buffer
+	rows    int
+	maxCols int
+}
+
+func (s *xlsxSheet) header(columns []xlsxColumn) {
+	cells := make([]xlsxCell, 0, len(columns))
+	for _, column := range columns {
+		cells = append(cells, xlsxHeader(column.title))
+	}
+	s.row(cells...)
+}
+
+// Append a row; no cells leaves it blank
+func (s *xlsxSheet) row(cells ...xlsxCell) {
+	s.rows++
+	if len(cells) == 0 {
+		return
+	}
+	if len(cells) > s.maxCols {
+		s.maxCols = len(cells)
+	}
+	fmt.Fprintf(&s.data, `<row r="%d">`, s.rows)
+	for i, cell := range cells {
+		ref := xlsxColumnName(i) + strconv.Itoa(s.rows)
+		style := ""
+		if cell.style != xlsxStyleDefault {
+			style = fmt.Sprintf(` s="%d"`, cell.style)
+		}
+		if cell.numeric {
+			fmt.Fprintf(&s.data, `<c r="%s"%s><v>%s</v></c>`, ref, style, strconv.FormatFloat(cell.number, 'g', -1, 64))
+			continue
+		}
+		if cell.text == "" {
+			if style != "" {
+				fmt.Fprintf(&s.data, `<c r="%s"%s/>`, ref, style)
+			}
+			continue
+		}
+		fmt.Fprintf(&s.data, `<c r="%s"%s t="inlineStr"><is><t xml:space="preserve">`, ref, style)
+		xml.EscapeText(&s.data, []byte(truncateRunes(cell.text, xlsxCellLength)))
+		s.data.WriteString(`</t></is></c>`)
+	}
+	s.data.WriteString(`</row>`)
+}
+
+// Worksheet part; a table sheet freezes and filters its header row
+func (s *xlsxSheet) document(columns []xlsxColumn, table bool) []byte {
+	var doc bytes.Buffer
+	doc.WriteString(xml.Header)
+	doc.WriteString(`<worksheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main" xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships">`)
+
+	lastCol := s.maxCols
+	if lastCol < 1 {
+		lastCol = 1
+	}
+	lastRow := s.rows
+	if lastRow < 1 {
+		lastRow = 1
+	}
+	extent := fmt.Sprintf("A1:%s%d", xlsxColumnName(lastCol-1), lastRow)
+	fmt.Fprintf(&doc, `<dimension ref="%s"/>`, extent)
+
+	if table {
+		doc.WriteString(`<sheetViews><sheetView tabSelected="1" workbookViewId="0"><pane ySplit="1" topLeftCell="A2" activePane="bottomLeft" state="frozen"/><selection pane="bottomLeft" activeCell="A2" sqref="A2"/></sheetView></sheetViews>`)
+	} else {
+		doc.WriteString(`<sheetViews><sheetView workbookViewId="0"/></sheetViews>`)
+	}
+
+	doc.WriteString(`<cols>`)
+	for i, column := range columns {
+		fmt.Fprintf(&doc, `<col min="%d" max="%d" width="%g" customWidth="1"/>`, i+1, i+1, column.width)
+	}
+	doc.WriteString(`</cols>`)
+
+	doc.WriteString(`<sheetData>`)
+	doc.Write(s.data.Bytes())
+	doc.WriteString(`</sheetData>`)
+	if table {
+		fmt.Fprintf(&doc, `<autoFilter ref="%s"/>`, extent)
+	}
+	doc.WriteString(`</worksheet>`)
+	return doc.Bytes()
+}
+
+// Column letters of a zero-based index: 0 is "A", 26 is "AA"
+func xlsxColumnName(index int) string {
+	name := ""
+	for index >= 0 {
+		name = string(rune('A'+index%26)) + name
+		index = index/26 - 1
+	}
+	return name
+}
+
+// The History autofilter is also declared as the hidden _FilterDatabase name, which
+// Excel expects alongside the sheet's autoFilter
+func xlsxWorkbook(records int) []byte {
+	lastRow := records + 1
+	return []byte(xml.Header + `<workbook xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main" xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships">` +
+		`<sheets><sheet name="History" sheetId="1" r:id="rId1"/><sheet name="Summary" sheetId="2" r:id="rId2"/></sheets>` +
+		fmt.Sprintf(`<definedNames><definedName name="_xlnm._FilterDatabase" localSheetId="0" hidden="1">History!$A$1:$%s$%d</definedName></definedNames>`,
+			xlsxColumnName(len(xlsxHistoryColumns)-1), lastRow) +
+		`</workbook>`)
+}
+
+func xlsxCoreProperties(job *ExportJob) []byte {
+	var created strings.Builder
+	xml.EscapeText(&created, []byte(job.CreatedAt))
+	return []byte(xml.Header + `<cp:coreProperties xmlns:cp="http://schemas.openxmlformats.org/package/2006/metadata/core-properties" xmlns:dc="http://purl.org/dc/elements/1.1/" xmlns:dcterms="http://purl.org/dc/terms/" xmlns:xsi="http://www.w3.org/2001/XMLSchema-instance">` +
+		`<dc:title>Verification History</dc:title><dc:creator>history-api</dc:creator>` +
+		`<dcterms:created xsi:type="dcterms:W3CDTF">` + created.String() + `</dcterms:created>` +
+		`</cp:coreProperties>`)
+}
+
+const xlsxContentTypes = xml.Header + `<Types xmlns="http://schemas.openxmlformats.org/package/2006/content-types">` +
+	`<Default Extension="rels" ContentType="application/vnd.openxmlformats-package.relationships+xml"/>` +
+	`<Default Extension="xml" ContentType="application/xml"/>` +
+	`<Override PartName="/xl/workbook.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.sheet.main+xml"/>` +
+	`<Override PartName="/xl/worksheets/sheet1.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.worksheet+xml"/>` +
+	`<Override PartName="/xl/worksheets/sheet2.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.worksheet+xml"/>` +
+	`<Override PartName="/xl/styles.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.styles+xml"/>` +
+	`<Override PartName="/docProps/core.xml" ContentType="application/vnd.openxmlformats-package.core-properties+xml"/>` +
+	`</Types>`
+
+const xlsxRootRels = xml.Header + `<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">` +
+	`<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/officeDocument" Target="xl/workbook.xml"/>` +
+	`<Relationship Id="rId2" Type="http://schemas.openxmlformats.org/package/2006/relationships/metadata/core-properties" Target="docProps/core.xml"/>` +
+	`</Relationships>`
+
+const xlsxWorkbookRels = xml.Header + `<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">` +
+	`<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/worksheet" Target="worksheets/sheet1.xml"/>` +
+	`<Relationship Id="rId2" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/worksheet" Target="worksheets/sheet2.xml"/>` +
+	`<Relationship Id="rId3" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/styles" Target="styles.xml"/>` +
+	`</Relationships>`
+
+// cellXfs are in the order of the xlsxStyle constants
+const xlsxStyles = xml.Header + `<styleSheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main">` +
+	`<numFmts count="2">` +
+	`<numFmt numFmtId="164" formatCode="yyyy-mm-dd hh:mm:ss"/>` +
+	`<numFmt numFmtId="165" formatCode="&quot;$&quot;#,##0.0000"/>` +
+	`</numFmts>` +
+	`<fonts count="3">` +
+	`<font><sz val="11"/><name val="Calibri"/><family val="2"/></font>` +
+	`<font><b/><sz val="11"/><name val="Calibri"/><family val="2"/></font>` +
+	`<font><b/><sz val="14"/><name val="Calibri"/><family val="2"/></font>` +
+	`</fonts>` +
+	`<fills count="3">` +
+	`<fill><patternFill patternType="none"/></fill>` +
+	`<fill><patternFill patternType="gray125"/></fill>` +
+	`<fill><patternFill patternType="solid"><fgColor rgb="FFD9E1F2"/><bgColor indexed="64"/></patternFill></fill>` +
+	`</fills>` +
+	`<borders count="1"><border><left/><right/><top/><bottom/><diagonal/></border></borders>` +
+	`<cellStyleXfs count="1"><xf numFmtId="0" fontId="0" fillId="0" borderId="0"/></cellStyleXfs>` +
+	`<cellXfs count="7">` +
+	`<xf numFmtId="0" fontId="0" fillId="0" borderId="0" xfId="0"/>` +
+	`<xf numFmtId="0" fontId="1" fillId="2" borderId="0" xfId="0" applyFont="1" applyFill="1"/>` +
+	`<xf numFmtId="164" fontId="0" fillId="0" borderId="0" xfId="0" applyNumberFormat="1"/>` +
+	`<xf numFmtId="10" fontId="0" fillId="0" borderId="0" xfId="0" applyNumberFormat="1"/>` +
+	`<xf numFmtId="3" fontId="0" fillId="0" borderId="0" xfId="0" applyNumberFormat="1"/>` +
+	`<xf numFmtId="165" fontId="0" fillId="0" borderId="0" xfId="0" applyNumberFormat="1"/>` +
+	`<xf numFmtId="0" fontId="2" fillId="0" borderId="0" xfId="0" applyFont="1"/>` +
+	`</cellXfs>` +
+	`<cellStyles count="1"><cellStyle name="Normal" xfId="0" builtinId="0"/></cellStyles>` +
+	`</styleSheet>`
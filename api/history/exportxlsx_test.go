@@ -0,0 +1,365 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestXLSXColumnName(t *testing.T) {
+	tests := []struct {
+		index int
+		want  string
+	}{
+		{0, "A"},
+		{14, "O"},
+		{25, "Z"},
+		{26, "AA"},
+		{51, "AZ"},
+		{52, "BA"},
+		{701, "ZZ"},
+		{702, "AAA"},
+		{16383, "XFD"}, // Last column Excel allows
+	}
+
+	for _, tt := range tests {
+		if got := xlsxColumnName(tt.index); got != tt.want {
+			t.Errorf("xlsxColumnName(%d) = %s, want %s", tt.index, got, tt.want)
+		}
+	}
+}
+
+func TestXLSXTimestamp(t *testing.T) {
+	tests := []struct {
+		value string
+		want  xlsxCell
+	}{
+		{"2025-06-18T12:00:00", xlsxNumber(45826.5, xlsxStyleDate)},
+		{"2025-06-18T00:00:00.000000", xlsxNumber(45826, xlsxStyleDate)},
+		{"2025-06-18T19:00:00+07:00", xlsxNumber(45826.5, xlsxStyleDate)},
+		{"1900-03-01T06:00:00Z", xlsxNumber(61.25, xlsxStyleDate)},
+		{"", xlsxText("")},
+		{"n/a", xlsxText("n/a")},
+	}
+
+	for _, tt := range tests {
+		if got := xlsxTimestamp(tt.value); got != tt.want {
+			t.Errorf("xlsxTimestamp(%q) = %+v, want %+v", tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestXLSXSheetRow(t *testing.T) {
+	tests := []struct {
+		name  string
+		cells []xlsxCell
+		want  string
+	}{
+		{"blank row", nil, ``},
+		{
+			"text is inline and escaped",
+			[]xlsxCell{xlsxText(`<Nhãn> & "ĐỎ"`)},
+			`<row r="1"><c r="A1" t="inlineStr"><is><t xml:space="preserve">&lt;Nhãn&gt; &amp; &#34;ĐỎ&#34;</t></is></c></row>`,
+		},
+		{
+			"numbers carry their style",
+			[]xlsxCell{xlsxNumber(0.875, xlsxStylePercent), xlsxNumber(10024, xlsxStyleInteger), xlsxNumber(1e-7, xlsxStyleDefault)},
+			`<row r="1"><c r="A1" s="3"><v>0.875</v></c><c r="B1" s="4"><v>10024</v></c><c r="C1"><v>1e-07</v></c></row>`,
+		},
+		{
+			"empty cells are left out unless styled",
+			[]xlsxCell{xlsxText(""), xlsxHeader(""), xlsxText("x")},
+			`<row r="1"><c r="B1" s="1"/><c r="C1" t="inlineStr"><is><t xml:space="preserve">x</t></is></c></row>`,
+		},
+		{
+			"control characters XML cannot hold are replaced",
+			[]xlsxCell{xlsxText("a\x01b\tc")},
+			`<row r="1"><c r="A1" t="inlineStr"><is><t xml:space="preserve">a` + "�" + `b&#x9;c</t></is></c></row>`,
+		},
+	}
+
+	for _, tt := range tests {
+		sheet := &xlsxSheet{}
+		sheet.row(tt.cells...)
+		if got := sheet.data.String(); got != tt.want {
+			t.Errorf("%s: row = %s, want %s", tt.name, got, tt.want)
+		}
+		if sheet.rows != 1 {
+			t.Errorf("%s: sheet has %d rows, want 1", tt.name, sheet.rows)
+		}
+	}
+}
+
+func TestXLSXSheetRowTruncatesLongText(t *testing.T) {
+	sheet := &xlsxSheet{}
+	sheet.row(xlsxText(strings.Repeat("ạ", xlsxCellLength+10)))
+
+	var row struct {
+		Text string `xml:"c>is>t"`
+	}
+	if err := xml.Unmarshal(sheet.data.Bytes(), &row); err != nil {
+		t.Fatalf("row is not XML: %v", err)
+	}
+	if got := len([]rune(row.Text)); got > xlsxCellLength {
+		t.Errorf("cell holds %d characters, Excel keeps at most %d", got, xlsxCellLength)
+	}
+}
+
+// Worksheet XML, as much of it as the tests check
+type xlsxTestSheet struct {
+	Dimension struct {
+		Ref string `xml:"ref,attr"`
+	} `xml:"dimension"`
+	Pane *struct {
+		YSplit string `xml:"ySplit,attr"`
+		State  string `xml:"state,attr"`
+	} `xml:"sheetViews>sheetView>pane"`
+	Cols []struct {
+		Min   int     `xml:"min,attr"`
+		Width float64 `xml:"width,attr"`
+	} `xml:"cols>col"`
+	Rows []struct {
+		R     int `xml:"r,attr"`
+		Cells []struct {
+			R      string `xml:"r,attr"`
+			S      int    `xml:"s,attr"`
+			T      string `xml:"t,attr"`
+			V      string `xml:"v"`
+			Inline string `xml:"is>t"`
+		} `xml:"c"`
+	} `xml:"sheetData>row"`
+	AutoFilter *struct {
+		Ref string `xml:"ref,attr"`
+	} `xml:"autoFilter"`
+}
+
+func xlsxTestRecord(id, timestamp, category string, labelConfidence float64) VerificationRecord {
+	return VerificationRecord{
+		ID:              id,
+		Timestamp:       timestamp,
+		ProductID:       "AQR-M466XA(GB)",
+		ProductCategory: category,
+		BedrockResponse: BedrockResponse{
+			Model: "claude-sonnet-4-20250514",
+			Usage: TokenUsage{InputTokens: 10024, OutputTokens: 443},
+			Content: []ContentItem{{Type: "text", Text: map[string]interface{}{
+				"matchLabelToReference":               "yes",
+				"matchLabelToReference_confidence":    labelConfidence,
+				"label_explanation":                   "Nhãn năng lượng khớp & rõ ràng",
+				"matchOverviewToReference":            "yes",
+				"matchOverviewToReference_confidence": 0.9,
+				"overview_explanation":                "Overview <matches>",
+			}}},
+		},
+	}
+}
+
+func TestXLSXEncoderRender(t *testing.T) {
+	records := []VerificationRecord{
+		xlsxTestRecord("id-1", "2025-06-18T12:00:00.000000", "REF", 0.95),
+		xlsxTestRecord("id-2", "2025-06-18T06:00:00", "WM", 0.2),
+		{ID: "unparsable", Timestamp: "2025-06-18T00:00:00"},
+	}
+
+	var rows []byte
+	encoder := xlsxEncoder{}
+	for i, record := range records {
+		line, ok := encoder.Record("test", record, i)
+		if ok {
+			rows = append(rows, line...)
+		}
+	}
+
+	job := &ExportJob{
+		Format:    "xlsx",
+		Filters:   map[string]string{"dateFrom": "2025-06-01", "category": "REF"},
+		Complete:  true,
+		CreatedAt: "2025-06-19T01:02:03Z",
+	}
+	workbook, err := encoder.Render("test", job, rows)
+	if err != nil {
+		t.Fatalf("Render returned error: %v", err)
+	}
+
+	archive, err := zip.NewReader(bytes.NewReader(workbook), int64(len(workbook)))
+	if err != nil {
+		t.Fatalf("workbook is not a zip archive: %v", err)
+	}
+	parts := map[string][]byte{}
+	for _, file := range archive.File {
+		reader, err := file.Open()
+		if err != nil {
+			t.Fatalf("failed to open %s: %v", file.Name, err)
+		}
+		data, err := io.ReadAll(reader)
+		reader.Close()
+		if err != nil {
+			t.Fatalf("failed to read %s: %v", file.Name, err)
+		}
+		parts[file.Name] = data
+
+		// Every part must be well-formed XML
+		decoder := xml.NewDecoder(bytes.NewReader(data))
+		for {
+			if _, err := decoder.Token(); err == io.EOF {
+				break
+			} else if err != nil {
+				t.Fatalf("%s is not well-formed XML: %v", file.Name, err)
+			}
+		}
+	}
+
+	if name := archive.File[0].Name; name != "[Content_Types].xml" {
+		t.Errorf("first part is %s, want [Content_Types].xml", name)
+	}
+	checkXLSXRelationships(t, parts)
+
+	var history xlsxTestSheet
+	if err := xml.Unmarshal(parts["xl/worksheets/sheet1.xml"], &history); err != nil {
+		t.Fatalf("failed to parse the History sheet: %v", err)
+	}
+	if history.Dimension.Ref != "A1:O3" {
+		t.Errorf("History dimension = %s, want A1:O3", history.Dimension.Ref)
+	}
+	if history.AutoFilter == nil || history.AutoFilter.Ref != "A1:O3" {
+		t.Errorf("History autofilter = %+v, want A1:O3", history.AutoFilter)
+	}
+	if history.Pane == nil || history.Pane.YSplit != "1" || history.Pane.State != "frozen" {
+		t.Errorf("History header row is not frozen: %+v", history.Pane)
+	}
+	if len(history.Cols) != len(xlsxHistoryColumns) {
+		t.Errorf("History has %d column widths, want %d", len(history.Cols), len(xlsxHistoryColumns))
+	}
+
+	// The record without parsable results is left out
+	if len(history.Rows) != 3 {
+		t.Fatalf("History has %d rows, want a header and 2 records", len(history.Rows))
+	}
+	for i, cell := range history.Rows[0].Cells {
+		if cell.Inline != xlsxHistoryColumns[i].title || cell.S != xlsxStyleHeader {
+			t.Errorf("header cell %s = %q style %d, want %q style %d", cell.R, cell.Inline, cell.S, xlsxHistoryColumns[i].title, xlsxStyleHeader)
+		}
+	}
+
+	first := history.Rows[1].Cells
+	checks := []struct {
+		column int
+		ref    string
+		style  int
+		inline string
+		value  string
+	}{
+		{0, "A2", xlsxStyleDefault, "id-1", ""},
+		{1, "B2", xlsxStyleDate, "", "45826.5"},
+		{2, "C2", xlsxStyleDefault, "AQR-M466XA(GB)", ""},
+		{4, "E2", xlsxStyleDefault, "CORRECT", ""},
+		{5, "F2", xlsxStylePercent, "", "0.925"},
+		{11, "L2", xlsxStyleInteger, "", "10024"},
+		{13, "N2", xlsxStyleDefault, "Nhãn năng lượng khớp & rõ ràng", ""},
+		{14, "O2", xlsxStyleDefault, "Overview <matches>", ""},
+	}
+	for _, check := range checks {
+		cell := first[check.column]
+		if cell.R != check.ref || cell.S != check.style || cell.Inline != check.inline || cell.V != check.value {
+			t.Errorf("cell %s = %+v, want style %d, text %q, value %q", check.ref, cell, check.style, check.inline, check.value)
+		}
+		if check.inline != "" && cell.T != "inlineStr" {
+			t.Errorf("cell %s type = %q, want inlineStr", check.ref, cell.T)
+		}
+	}
+	if second := history.Rows[2].Cells; second[1].V != "45826.25" || second[4].Inline != "INCORRECT" {
+		t.Errorf("second record = %s %s, want 45826.25 INCORRECT", second[1].V, second[4].Inline)
+	}
+
+	var summary xlsxTestSheet
+	if err := xml.Unmarshal(parts["xl/worksheets/sheet2.xml"], &summary); err != nil {
+		t.Fatalf("failed to parse the Summary sheet: %v", err)
+	}
+	if summary.AutoFilter != nil || summary.Pane != nil {
+		t.Error("Summary sheet has an autofilter or frozen pane")
+	}
+	found := map[string]string{}
+	for _, row := range summary.Rows {
+		if len(row.Cells) >= 2 {
+			value := row.Cells[1].V
+			if value == "" {
+				value = row.Cells[1].Inline
+			}
+			found[row.Cells[0].Inline] = value
+		}
+	}
+	for label, want := range map[string]string{
+		"Period":              "from 2025-06-01",
+		"Filters":             "category: REF",
+		"Generated (UTC)":     "45827.04309027778",
+		"Total Verifications": "2",
+		"Success Rate":        "0.5",
+	} {
+		if found[label] != want {
+			t.Errorf("Summary %s = %q, want %q", label, found[label], want)
+		}
+	}
+
+	if !bytes.Contains(parts["xl/workbook.xml"], []byte(`History!$A$1:$O$3`)) {
+		t.Error("workbook does not declare the History filter range")
+	}
+	if !bytes.Contains(parts["docProps/core.xml"], []byte(`>2025-06-19T01:02:03Z</dcterms:created>`)) {
+		t.Error("core properties do not hold the job creation time")
+	}
+}
+
+// Every part must have a content type, and every relationship must point at a part
+func checkXLSXRelationships(t *testing.T, parts map[string][]byte) {
+	t.Helper()
+
+	var types struct {
+		Defaults []struct {
+			Extension string `xml:"Extension,attr"`
+		} `xml:"Default"`
+		Overrides []struct {
+			PartName string `xml:"PartName,attr"`
+		} `xml:"Override"`
+	}
+	if err := xml.Unmarshal(parts["[Content_Types].xml"], &types); err != nil {
+		t.Fatalf("failed to parse [Content_Types].xml: %v", err)
+	}
+	typed := map[string]bool{}
+	for _, override := range types.Overrides {
+		typed[strings.TrimPrefix(override.PartName, "/")] = true
+		if parts[strings.TrimPrefix(override.PartName, "/")] == nil {
+			t.Errorf("content type declared for missing part %s", override.PartName)
+		}
+	}
+	extensions := map[string]bool{}
+	for _, def := range types.Defaults {
+		extensions[def.Extension] = true
+	}
+	for name := range parts {
+		if name == "[Content_Types].xml" {
+			continue
+		}
+		extension := name[strings.LastIndex(name, ".")+1:]
+		if !typed[name] && !extensions[extension] {
+			t.Errorf("part %s has no content type", name)
+		}
+	}
+
+	for rels, base := range map[string]string{"_rels/.rels": "", "xl/_rels/workbook.xml.rels": "xl/"} {
+		var relationships struct {
+			Relationship []struct {
+				Target string `xml:"Target,attr"`
+			} `xml:"Relationship"`
+		}
+		if err := xml.Unmarshal(parts[rels], &relationships); err != nil {
+			t.Fatalf("failed to parse %s: %v", rels, err)
+		}
+		for _, relationship := range relationships.Relationship {
+			if parts[base+relationship.Target] == nil {
+				t.Errorf("%s points at missing part %s", rels, base+relationship.Target)
+			}
+		}
+	}
+}
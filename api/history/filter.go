@@ -0,0 +1,702 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// Kinds of values a query field holds
+const (
+	fieldText      = "text"
+	fieldNumber    = "number"
+	fieldTimestamp = "timestamp"
+	fieldEnum      = "enum"
+)
+
+// Field that can appear in a 'q' expression. Stored fields map to a record attribute and can
+// be filtered by DynamoDB; derived fields are computed from the model response in memory.
+type queryField struct {
+	Name      string
+	Kind      string
+	Attribute []string // Attribute path of a stored field, nil for derived fields
+	Values    []string // Accepted values of an enum field
+	text      func(*recordFields) string
+	number    func(*recordFields) float64
+}
+
+func (f *queryField) stored() bool {
+	return f.Attribute != nil
+}
+
+var queryFields = []*queryField{
+	{Name: "id", Kind: fieldText, Attribute: []string{"id"},
+		text: func(r *recordFields) string { return r.Record.ID }},
+	{Name: "productId", Kind: fieldText, Attribute: []string{"productId"},
+		text: func(r *recordFields) string { return r.Record.ProductID }},
+	{Name: "category", Kind: fieldText, Attribute: []string{"productCategory"},
+		text: func(r *recordFields) string { return r.Record.ProductCategory }},
+	{Name: "model", Kind: fieldText, Attribute: []string{"bedrockResponse", "model"},
+		text: func(r *recordFields) string { return r.Record.BedrockResponse.Model }},
+	{Name: "timestamp", Kind: fieldTimestamp, Attribute: []string{"timestamp"},
+		text: func(r *recordFields) string { return r.Record.Timestamp }},
+	{Name: "inputTokens", Kind: fieldNumber, Attribute: []string{"bedrockResponse", "usage", "input_tokens"},
+		number: func(r *recordFields) float64 { return float64(r.Record.BedrockResponse.Usage.InputTokens) }},
+	{Name: "outputTokens", Kind: fieldNumber, Attribute: []string{"bedrockResponse", "usage", "output_tokens"},
+		number: func(r *recordFields) float64 { return float64(r.Record.BedrockResponse.Usage.OutputTokens) }},
+	{Name: "result", Kind: fieldEnum, Values: []string{"CORRECT", "INCORRECT", "UNCERTAIN"},
+		text: func(r *recordFields) string { return r.derive().Result }},
+	{Name: "confidence", Kind: fieldNumber,
+		number: func(r *recordFields) float64 { return r.derive().Confidence }},
+	{Name: "labelConfidence", Kind: fieldNumber,
+		number: func(r *recordFields) float64 { return r.derive().Results.MatchLabelConfidence }},
+	{Name: "overviewConfidence", Kind: fieldNumber,
+		number: func(r *recordFields) float64 { return r.derive().Results.MatchOverviewConfidence }},
+	{Name: "labelMatch", Kind: fieldEnum, Values: []string{"YES", "NO"},
+		text: func(r *recordFields) string { return strings.ToUpper(r.derive().Results.MatchLabelToReference) }},
+	{Name: "overviewMatch", Kind: fieldEnum, Values: []string{"YES", "NO"},
+		text: func(r *recordFields) string { return strings.ToUpper(r.derive().Results.MatchOverviewToReference) }},
+}
+
+func lookupQueryField(name string) *queryField {
+	for _, field := range queryFields {
+		if strings.EqualFold(field.Name, name) {
+			return field
+		}
+	}
+	return nil
+}
+
+func queryFieldNames() string {
+	names := make([]string, len(queryFields))
+	for i, field := range queryFields {
+		names[i] = field.Name
+	}
+	return strings.Join(names, ", ")
+}
+
+// A record with its derived verification fields, parsed from the model response on first use
+type recordFields struct {
+	RequestID string
+	Record    VerificationRecord
+	derived   *derivedFields
+}
+
+type derivedFields struct {
+	Results    VerificationResults
+	Confidence float64
+	Result     string
+}
+
+func (r *recordFields) derive() *derivedFields {
+	if r.derived == nil {
+		r.derived = &derivedFields{Result: "UNCERTAIN"}
+		// Unparsable responses count as UNCERTAIN with zero confidence, as in the list view
+		if results, err := parseVerificationResults(r.RequestID, r.Record.BedrockResponse); err == nil {
+			r.derived.Results = results
+			r.derived.Confidence = (results.MatchLabelConfidence + results.MatchOverviewConfidence) / 2
+			r.derived.Result = determineVerificationResult(results, r.derived.Confidence)
+		}
+	}
+	return r.derived
+}
+
+// Node of a parsed filter expression
+type filterNode interface {
+	matches(r *recordFields) bool
+	// Whether DynamoDB can evaluate the node without touching the excluded attributes
+	pushable(excluded map[string]bool) bool
+	expression(b *expressionBuilder) string
+	String() string
+}
+
+type andNode struct{ Children []filterNode }
+type orNode struct{ Children []filterNode }
+type notNode struct{ Child filterNode }
+
+type predicateNode struct {
+	Field    *queryField
+	Operator string // One of = != < <= > >=; ':' is read as '='
+	Value    string // Text value; for timestamps the lower bound
+	Upper    string // Upper bound of a timestamp value
+	Number   float64
+	Prefix   bool // Trailing '*' on a text value
+}
+
+func (n *andNode) matches(r *recordFields) bool {
+	for _, child := range n.Children {
+		if !child.matches(r) {
+			return false
+		}
+	}
+	return true
+}
+
+func (n *orNode) matches(r *recordFields) bool {
+	for _, child := range n.Children {
+		if child.matches(r) {
+			return true
+		}
+	}
+	return false
+}
+
+func (n *notNode) matches(r *recordFields) bool {
+	return !n.Child.matches(r)
+}
+
+func (n *predicateNode) matches(r *recordFields) bool {
+	switch n.Field.Kind {
+	case fieldNumber:
+		return compareOrdered(n.Field.number(r), n.Number, n.Operator)
+	case fieldTimestamp:
+		value := n.Field.text(r)
+		switch n.Operator {
+		case "=":
+			return value >= n.Value && value <= n.Upper
+		case "!=":
+			return value < n.Value || value > n.Upper
+		case "<", ">=":
+			return compareOrdered(value, n.Value, n.Operator)
+		default:
+			return compareOrdered(value, n.Upper, n.Operator)
+		}
+	default:
+		value := n.Field.text(r)
+		equal := value == n.Value
+		if n.Prefix {
+			equal = strings.HasPrefix(value, n.Value)
+		}
+		if n.Operator == "!=" {
+			return !equal
+		}
+		return equal
+	}
+}
+
+func compareOrdered[T string | float64](a, b T, operator string) bool {
+	switch operator {
+	case "=":
+		return a == b
+	case "!=":
+		return a != b
+	case "<":
+		return a < b
+	case "<=":
+		return a <= b
+	case ">":
+		return a > b
+	default:
+		return a >= b
+	}
+}
+
+func (n *andNode) pushable(excluded map[string]bool) bool {
+	return allPushable(n.Children, excluded)
+}
+
+func (n *orNode) pushable(excluded map[string]bool) bool {
+	return allPushable(n.Children, excluded)
+}
+
+func (n *notNode) pushable(excluded map[string]bool) bool {
+	return n.Child.pushable(excluded)
+}
+
+func (n *predicateNode) pushable(excluded map[string]bool) bool {
+	return n.Field.stored() && !excluded[n.Field.Attribute[0]]
+}
+
+func allPushable(nodes []filterNode, excluded map[string]bool) bool {
+	for _, node := range nodes {
+		if !node.pushable(excluded) {
+			return false
+		}
+	}
+	return true
+}
+
+func (n *andNode) expression(b *expressionBuilder) string {
+	return joinExpressions(n.Children, " AND ", b)
+}
+
+func (n *orNode) expression(b *expressionBuilder) string {
+	return joinExpressions(n.Children, " OR ", b)
+}
+
+func (n *notNode) expression(b *expressionBuilder) string {
+	return "NOT (" + n.Child.expression(b) + ")"
+}
+
+func (n *predicateNode) expression(b *expressionBuilder) string {
+	path := b.path(n.Field.Attribute)
+
+	switch n.Field.Kind {
+	case fieldNumber:
+		return fmt.Sprintf("%s %s %s", path, dynamoOperator(n.Operator), b.number(n.Number))
+	case fieldTimestamp:
+		switch n.Operator {
+		case "=":
+			return fmt.Sprintf("%s BETWEEN %s AND %s", path, b.text(n.Value), b.text(n.Upper))
+		case "!=":
+			return fmt.Sprintf("NOT (%s BETWEEN %s AND %s)", path, b.text(n.Value), b.text(n.Upper))
+		case "<", ">=":
+			return fmt.Sprintf("%s %s %s", path, n.Operator, b.text(n.Value))
+		default:
+			return fmt.Sprintf("%s %s %s", path, n.Operator, b.text(n.Upper))
+		}
+	default:
+		if n.Prefix {
+			condition := fmt.Sprintf("begins_with(%s, %s)", path, b.text(n.Value))
+			if n.Operator == "!=" {
+				return "NOT " + condition
+			}
+			return condition
+		}
+		return fmt.Sprintf("%s %s %s", path, dynamoOperator(n.Operator), b.text(n.Value))
+	}
+}
+
+func dynamoOperator(operator string) string {
+	if operator == "!=" {
+		return "<>"
+	}
+	return operator
+}
+
+func joinExpressions(nodes []filterNode, separator string, b *expressionBuilder) string {
+	parts := make([]string, len(nodes))
+	for i, node := range nodes {
+		parts[i] = "(" + node.expression(b) + ")"
+	}
+	return strings.Join(parts, separator)
+}
+
+func (n *andNode) String() string {
+	return joinStrings(n.Children, " AND ")
+}
+
+func (n *orNode) String() string {
+	return joinStrings(n.Children, " OR ")
+}
+
+func (n *notNode) String() string {
+	return "NOT " + n.Child.String()
+}
+
+func (n *predicateNode) String() string {
+	value := n.Value
+	switch n.Field.Kind {
+	case fieldNumber:
+		value = strconv.FormatFloat(n.Number, 'g', -1, 64)
+	case fieldTimestamp:
+		value = n.Value + ".." + n.Upper
+	}
+	if n.Prefix {
+		value += "*"
+	}
+	return n.Field.Name + n.Operator + strconv.Quote(value)
+}
+
+func joinStrings(nodes []filterNode, separator string) string {
+	parts := make([]string, len(nodes))
+	for i, node := range nodes {
+		parts[i] = "(" + node.String() + ")"
+	}
+	return strings.Join(parts, separator)
+}
+
+// Collects the placeholder names and values of a DynamoDB expression
+type expressionBuilder struct {
+	Names        map[string]string
+	Values       map[string]types.AttributeValue
+	placeholders map[string]string // Attribute name to its placeholder
+}
+
+func newExpressionBuilder() *expressionBuilder {
+	return &expressionBuilder{
+		Names:        map[string]string{},
+		Values:       map[string]types.AttributeValue{},
+		placeholders: map[string]string{},
+	}
+}
+
+func (b *expressionBuilder) path(attribute []string) string {
+	segments := make([]string, len(attribute))
+	for i, name := range attribute {
+		placeholder, ok := b.placeholders[name]
+		if !ok {
+			placeholder = fmt.Sprintf("#q%d", len(b.Names))
+			b.Names[placeholder] = name
+			b.placeholders[name] = placeholder
+		}
+		segments[i] = placeholder
+	}
+	return strings.Join(segments, ".")
+}
+
+func (b *expressionBuilder) text(value string) string {
+	placeholder := fmt.Sprintf(":q%d", len(b.Values))
+	b.Values[placeholder] = &types.AttributeValueMemberS{Value: value}
+	return placeholder
+}
+
+func (b *expressionBuilder) number(value float64) string {
+	placeholder := fmt.Sprintf(":q%d", len(b.Values))
+	b.Values[placeholder] = &types.AttributeValueMemberN{Value: strconv.FormatFloat(value, 'f', -1, 64)}
+	return placeholder
+}
+
+// Top-level AND terms of a filter
+func conjuncts(node filterNode) []filterNode {
+	if node == nil {
+		return nil
+	}
+	if and, ok := node.(*andNode); ok {
+		return and.Children
+	}
+	return []filterNode{node}
+}
+
+// Split filter terms into a DynamoDB filter expression and the terms left for memory.
+// Terms on the excluded attributes (the keys of the index read) always stay in memory.
+func splitFilter(terms []filterNode, excluded map[string]bool, b *expressionBuilder) (string, []filterNode) {
+	var pushed []string
+	var residual []filterNode
+	for _, term := range terms {
+		if term.pushable(excluded) {
+			pushed = append(pushed, "("+term.expression(b)+")")
+		} else {
+			residual = append(residual, term)
+		}
+	}
+	return strings.Join(pushed, " AND "), residual
+}
+
+func matchesAll(terms []filterNode, r *recordFields) bool {
+	for _, term := range terms {
+		if !term.matches(r) {
+			return false
+		}
+	}
+	return true
+}
+
+// Parse the 'q' parameter and the shorthand filter parameters into one filter, nil when there is none
+func parseFilterParams(queryParams map[string]string) (filterNode, error) {
+	var terms []filterNode
+
+	if q := strings.TrimSpace(queryParams["q"]); q != "" {
+		node, err := parseFilter(q)
+		if err != nil {
+			return nil, newBadRequest("INVALID_QUERY", "Invalid 'q' parameter %v", err)
+		}
+		terms = append(terms, conjuncts(node)...)
+	}
+
+	// Shorthands for common terms, e.g. result=INCORRECT is q=result:INCORRECT
+	shorthands := []struct{ param, format string }{
+		{"result", "result:%s"},
+		{"aiModel", "model:%s"},
+		{"minConfidence", "confidence>=%s"},
+		{"minLabelConfidence", "labelConfidence>=%s"},
+		{"minOverviewConfidence", "overviewConfidence>=%s"},
+	}
+	for _, shorthand := range shorthands {
+		value := queryParams[shorthand.param]
+		if value == "" {
+			continue
+		}
+		node, err := parseFilter(fmt.Sprintf(shorthand.format, strconv.Quote(value)))
+		var syntaxErr *filterSyntaxError
+		if errors.As(err, &syntaxErr) {
+			return nil, newBadRequest("INVALID_QUERY", "Invalid '%s' parameter: %s", shorthand.param, syntaxErr.Message)
+		}
+		terms = append(terms, node)
+	}
+
+	switch len(terms) {
+	case 0:
+		return nil, nil
+	case 1:
+		return terms[0], nil
+	default:
+		return &andNode{Children: terms}, nil
+	}
+}
+
+type filterSyntaxError struct {
+	Position int // 1-based rune position in the expression
+	Message  string
+}
+
+func (e *filterSyntaxError) Error() string {
+	return fmt.Sprintf("at position %d: %s", e.Position, e.Message)
+}
+
+// Recursive descent parser over
+//
+//	expr      = term { OR term }
+//	term      = factor { AND factor }
+//	factor    = NOT factor | "(" expr ")" | predicate
+//	predicate = field operator value
+//
+// Keywords are case-insensitive. Values are bare words or double-quoted strings;
+// a bare word may contain balanced parentheses, e.g. AQR-M466XA(GB).
+type filterParser struct {
+	input []rune
+	pos   int
+}
+
+func parseFilter(input string) (filterNode, error) {
+	parser := &filterParser{input: []rune(input)}
+	node, err := parser.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	parser.skipSpace()
+	if parser.pos < len(parser.input) {
+		if parser.input[parser.pos] == ')' {
+			return nil, parser.errorf("unexpected ')'")
+		}
+		return nil, parser.errorf("expected AND or OR before %q", parser.peekWord())
+	}
+	return node, nil
+}
+
+func (p *filterParser) errorf(format string, args ...interface{}) error {
+	return &filterSyntaxError{Position: p.pos + 1, Message: fmt.Sprintf(format, args...)}
+}
+
+func (p *filterParser) skipSpace() {
+	for p.pos < len(p.input) && unicode.IsSpace(p.input[p.pos]) {
+		p.pos++
+	}
+}
+
+// Consume a keyword when it is next, followed by a space or parenthesis
+func (p *filterParser) acceptKeyword(keyword string) bool {
+	p.skipSpace()
+	end := p.pos + len(keyword)
+	if end > len(p.input) || !strings.EqualFold(string(p.input[p.pos:end]), keyword) {
+		return false
+	}
+	if end < len(p.input) && !unicode.IsSpace(p.input[end]) && p.input[end] != '(' {
+		return false
+	}
+	p.pos = end
+	return true
+}
+
+func (p *filterParser) peekWord() string {
+	end := p.pos
+	for end < len(p.input) && !unicode.IsSpace(p.input[end]) {
+		end++
+	}
+	return string(p.input[p.pos:end])
+}
+
+func (p *filterParser) parseOr() (filterNode, error) {
+	node, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	children := []filterNode{node}
+	for p.acceptKeyword("OR") {
+		node, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		children = append(children, node)
+	}
+	if len(children) == 1 {
+		return children[0], nil
+	}
+	return &orNode{Children: children}, nil
+}
+
+func (p *filterParser) parseAnd() (filterNode, error) {
+	node, err := p.parseFactor()
+	if err != nil {
+		return nil, err
+	}
+	children := []filterNode{node}
+	for p.acceptKeyword("AND") {
+		node, err := p.parseFactor()
+		if err != nil {
+			return nil, err
+		}
+		// Flatten nested ANDs so their terms can be pushed down one by one
+		children = append(children, conjuncts(node)...)
+	}
+	if len(children) == 1 {
+		return children[0], nil
+	}
+	return &andNode{Children: children}, nil
+}
+
+func (p *filterParser) parseFactor() (filterNode, error) {
+	if p.acceptKeyword("NOT") {
+		child, err := p.parseFactor()
+		if err != nil {
+			return nil, err
+		}
+		return &notNode{Child: child}, nil
+	}
+
+	p.skipSpace()
+	if p.pos >= len(p.input) {
+		return nil, p.errorf("unexpected end of query, expected a field name")
+	}
+	if p.input[p.pos] == '(' {
+		p.pos++
+		node, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		p.skipSpace()
+		if p.pos >= len(p.input) || p.input[p.pos] != ')' {
+			return nil, p.errorf("expected ')'")
+		}
+		p.pos++
+		return node, nil
+	}
+	return p.parsePredicate()
+}
+
+func (p *filterParser) parsePredicate() (filterNode, error) {
+	start := p.pos
+	for p.pos < len(p.input) && (unicode.IsLetter(p.input[p.pos]) || unicode.IsDigit(p.input[p.pos]) || p.input[p.pos] == '_') {
+		p.pos++
+	}
+	name := string(p.input[start:p.pos])
+	if name == "" {
+		return nil, p.errorf("expected a field name, found %q", p.peekWord())
+	}
+	field := lookupQueryField(name)
+	if field == nil {
+		p.pos = start
+		return nil, p.errorf("unknown field %q. Valid fields: %s", name, queryFieldNames())
+	}
+
+	operatorPos := p.pos
+	operator := p.parseOperator()
+	if operator == "" {
+		return nil, p.errorf("expected an operator (: = != < <= > >=) after %q", name)
+	}
+	if operator == ":" {
+		operator = "="
+	}
+
+	valuePos := p.pos
+	value, quoted, err := p.parseValue()
+	if err != nil {
+		return nil, err
+	}
+	if value == "" && !quoted {
+		return nil, p.errorf("expected a value after %s%s", name, operator)
+	}
+
+	predicate := &predicateNode{Field: field, Operator: operator, Value: value}
+	fail := func(format string, args ...interface{}) error {
+		return &filterSyntaxError{Position: valuePos + 1, Message: fmt.Sprintf(format, args...)}
+	}
+
+	ordered := operator != "=" && operator != "!="
+	switch field.Kind {
+	case fieldNumber:
+		number, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return nil, fail("%s needs a number, found %q", field.Name, value)
+		}
+		predicate.Number = number
+	case fieldTimestamp:
+		lower, err := parseDateBound(field.Name, value, false)
+		if err != nil {
+			return nil, fail("%s needs an RFC 3339 time or a YYYY-MM-DD date, found %q", field.Name, value)
+		}
+		upper, _ := parseDateBound(field.Name, value, true)
+		predicate.Value, predicate.Upper = lower, upper
+	case fieldEnum:
+		if ordered {
+			return nil, &filterSyntaxError{Position: operatorPos + 1, Message: fmt.Sprintf("%s only supports : = and !=", field.Name)}
+		}
+		predicate.Value = strings.ToUpper(value)
+		valid := false
+		for _, allowed := range field.Values {
+			valid = valid || predicate.Value == allowed
+		}
+		if !valid {
+			return nil, fail("invalid %s %q. Valid values: %s", field.Name, value, strings.Join(field.Values, ", "))
+		}
+	case fieldText:
+		if ordered {
+			return nil, &filterSyntaxError{Position: operatorPos + 1, Message: fmt.Sprintf("%s only supports : = and !=", field.Name)}
+		}
+		if !quoted && strings.HasSuffix(value, "*") {
+			predicate.Prefix = true
+			predicate.Value = strings.TrimSuffix(value, "*")
+		}
+		if strings.Contains(predicate.Value, "*") && !quoted {
+			return nil, fail("'*' is only supported at the end of a value")
+		}
+	}
+
+	return predicate, nil
+}
+
+func (p *filterParser) parseOperator() string {
+	p.skipSpace()
+	for _, operator := range []string{"!=", "<=", ">=", ":", "=", "<", ">"} {
+		end := p.pos + len(operator)
+		if end <= len(p.input) && string(p.input[p.pos:end]) == operator {
+			p.pos = end
+			return operator
+		}
+	}
+	return ""
+}
+
+// Read a double-quoted string or a bare word ending at a space or an unbalanced ')'
+func (p *filterParser) parseValue() (string, bool, error) {
+	p.skipSpace()
+	if p.pos < len(p.input) && p.input[p.pos] == '"' {
+		start := p.pos
+		var value strings.Builder
+		for p.pos++; p.pos < len(p.input); p.pos++ {
+			switch p.input[p.pos] {
+			case '\\':
+				if p.pos+1 < len(p.input) {
+					p.pos++
+					value.WriteRune(p.input[p.pos])
+				}
+			case '"':
+				p.pos++
+				return value.String(), true, nil
+			default:
+				value.WriteRune(p.input[p.pos])
+			}
+		}
+		p.pos = start
+		return "", false, p.errorf("unterminated quoted value")
+	}
+
+	start := p.pos
+	depth := 0
+	for p.pos < len(p.input) && !unicode.IsSpace(p.input[p.pos]) {
+		if p.input[p.pos] == '(' {
+			depth++
+		} else if p.input[p.pos] == ')' {
+			if depth == 0 {
+				break
+			}
+			depth--
+		}
+		p.pos++
+	}
+	return string(p.input[start:p.pos]), false, nil
+}
@@ -0,0 +1,247 @@
+package main
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+func TestParseFilter(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{`result:correct`, `result="CORRECT"`},
+		{`Result = incorrect`, `result="INCORRECT"`},
+		{`productId:AQR-M466XA(GB)`, `productId="AQR-M466XA(GB)"`},
+		{`productId:"a \"quoted\" id"`, `productId="a \"quoted\" id"`},
+		{`model:claude*`, `model="claude*"`},
+		{`model:"claude*"`, `model="claude*"`},
+		{`inputTokens>=1e3`, `inputTokens>="1000"`},
+		{`timestamp:2025-06-18`, `timestamp="2025-06-18T00:00:00..2025-06-18T23:59:59.999999"`},
+		{`timestamp<2025-06-18T08:00:00+07:00`, `timestamp<"2025-06-18T01:00:00..2025-06-18T01:00:00.999999"`},
+		{`result:CORRECT category:REF`, ``},
+		{`result:CORRECT and category:REF`, `(result="CORRECT") AND (category="REF")`},
+		{`a:1 OR b:2`, ``},
+		{`labelMatch:yes or not overviewMatch:no`, `(labelMatch="YES") OR (NOT overviewMatch="NO")`},
+		// AND binds tighter than OR, and nested ANDs are flattened
+		{`category:REF AND (result:CORRECT AND confidence>0.9) OR productId:P1`, `((category="REF") AND (result="CORRECT") AND (confidence>"0.9")) OR (productId="P1")`},
+	}
+
+	for _, tt := range tests {
+		node, err := parseFilter(tt.input)
+		if tt.want == "" {
+			if err == nil {
+				t.Errorf("parseFilter(%q) = %s, want an error", tt.input, node)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("parseFilter(%q) returned error: %v", tt.input, err)
+			continue
+		}
+		if got := node.String(); got != tt.want {
+			t.Errorf("parseFilter(%q) = %s, want %s", tt.input, got, tt.want)
+		}
+	}
+}
+
+func TestParseFilterErrors(t *testing.T) {
+	tests := []struct {
+		input    string
+		position int
+		message  string
+	}{
+		{``, 1, "unexpected end of query"},
+		{`result:CORRECT AND`, 19, "unexpected end of query"},
+		{`colour:red`, 1, `unknown field "colour"`},
+		{`result:CORRECT AND shade:red`, 20, `unknown field "shade"`},
+		{`productId`, 10, "expected an operator"},
+		{`productId:`, 11, "expected a value after productId="},
+		{`result:MAYBE`, 8, `invalid result "MAYBE"`},
+		{`confidence>=high`, 13, `confidence needs a number, found "high"`},
+		{`timestamp>yesterday`, 11, "timestamp needs an RFC 3339 time"},
+		{`model<claude`, 6, "model only supports : = and !="},
+		{`result>=CORRECT`, 7, "result only supports : = and !="},
+		{`model:cl*ude`, 7, "'*' is only supported at the end of a value"},
+		{`productId:"P1`, 11, "unterminated quoted value"},
+		{`(result:CORRECT`, 16, "expected ')'"},
+		{`result:CORRECT)`, 15, "unexpected ')'"},
+		{`result:CORRECT model:x`, 16, `expected AND or OR before "model:x"`},
+		// Positions count runes, not bytes
+		{`productId:ĐỎ x`, 14, `expected AND or OR before "x"`},
+		{`productId:"CHÍNH DIỆN" AND màu:đỏ`, 28, `unknown field "màu"`},
+	}
+
+	for _, tt := range tests {
+		_, err := parseFilter(tt.input)
+		var syntaxErr *filterSyntaxError
+		if !errors.As(err, &syntaxErr) {
+			t.Errorf("parseFilter(%q) error = %v, want a syntax error", tt.input, err)
+			continue
+		}
+		if syntaxErr.Position != tt.position || !strings.Contains(syntaxErr.Message, tt.message) {
+			t.Errorf("parseFilter(%q) error = %v, want position %d and %q", tt.input, err, tt.position, tt.message)
+		}
+	}
+}
+
+func TestParseFilterParams(t *testing.T) {
+	tests := []struct {
+		params map[string]string
+		want   string
+		err    string
+	}{
+		{map[string]string{}, "", ""},
+		{map[string]string{"q": "  "}, "", ""},
+		{map[string]string{"result": "correct"}, `result="CORRECT"`, ""},
+		{map[string]string{"q": "category:REF AND model:x", "minConfidence": "0.8"}, `(category="REF") AND (model="x") AND (confidence>="0.8")`, ""},
+		{map[string]string{"aiModel": "a b"}, `model="a b"`, ""},
+		{map[string]string{"q": "result:"}, "", "Invalid 'q' parameter at position 8"},
+		{map[string]string{"minConfidence": "high"}, "", "Invalid 'minConfidence' parameter: confidence needs a number"},
+		{map[string]string{"result": "MAYBE"}, "", "Invalid 'result' parameter: invalid result"},
+	}
+
+	for _, tt := range tests {
+		node, err := parseFilterParams(tt.params)
+		if tt.err != "" {
+			var reqErr *requestError
+			if !errors.As(err, &reqErr) || reqErr.Code != "INVALID_QUERY" || !strings.Contains(reqErr.Message, tt.err) {
+				t.Errorf("parseFilterParams(%v) error = %v, want INVALID_QUERY containing %q", tt.params, err, tt.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("parseFilterParams(%v) returned error: %v", tt.params, err)
+			continue
+		}
+		got := ""
+		if node != nil {
+			got = node.String()
+		}
+		if got != tt.want {
+			t.Errorf("parseFilterParams(%v) = %s, want %s", tt.params, got, tt.want)
+		}
+	}
+}
+
+func TestSplitFilter(t *testing.T) {
+	categoryIndexKeys := map[string]bool{"productCategory": true, "timestamp": true}
+	dayIndexKeys := map[string]bool{"day": true, "timestamp": true}
+
+	tests := []struct {
+		name     string
+		q        string
+		excluded map[string]bool
+		pushed   string
+		names    map[string]string
+		values   map[string]string
+		residual []string
+	}{
+		{
+			name:     "stored fields are pushed",
+			q:        `model:claude* AND inputTokens>=100 AND productId!=P1`,
+			excluded: categoryIndexKeys,
+			pushed:   `(begins_with(#q0.#q1, :q0)) AND (#q0.#q2.#q3 >= :q1) AND (#q4 <> :q2)`,
+			names:    map[string]string{"#q0": "bedrockResponse", "#q1": "model", "#q2": "usage", "#q3": "input_tokens", "#q4": "productId"},
+			values:   map[string]string{":q0": "claude", ":q1": "100", ":q2": "P1"},
+		},
+		{
+			name:     "derived fields stay in memory",
+			q:        `result:INCORRECT AND confidence<0.6 AND outputTokens>10`,
+			excluded: categoryIndexKeys,
+			pushed:   `(#q0.#q1.#q2 > :q0)`,
+			names:    map[string]string{"#q0": "bedrockResponse", "#q1": "usage", "#q2": "output_tokens"},
+			values:   map[string]string{":q0": "10"},
+			residual: []string{`result="INCORRECT"`, `confidence<"0.6"`},
+		},
+		{
+			name:     "index keys stay in memory",
+			q:        `category:REF AND timestamp>=2025-06-01 AND id:abc`,
+			excluded: categoryIndexKeys,
+			pushed:   `(#q0 = :q0)`,
+			names:    map[string]string{"#q0": "id"},
+			values:   map[string]string{":q0": "abc"},
+			residual: []string{`category="REF"`, `timestamp>="2025-06-01T00:00:00..2025-06-01T23:59:59.999999"`},
+		},
+		{
+			name:     "category is pushed when it is not the partition key",
+			q:        `category:REF AND productId!=P1*`,
+			excluded: dayIndexKeys,
+			pushed:   `(#q0 = :q0) AND (NOT begins_with(#q1, :q1))`,
+			names:    map[string]string{"#q0": "productCategory", "#q1": "productId"},
+			values:   map[string]string{":q0": "REF", ":q1": "P1"},
+		},
+		{
+			name:     "timestamp ranges",
+			q:        `timestamp:2025-06-18 AND NOT timestamp!=2025-06-18T08:00:00Z`,
+			excluded: map[string]bool{"productId": true},
+			pushed:   `(#q0 BETWEEN :q0 AND :q1) AND (NOT (NOT (#q0 BETWEEN :q2 AND :q3)))`,
+			names:    map[string]string{"#q0": "timestamp"},
+			values: map[string]string{
+				":q0": "2025-06-18T00:00:00", ":q1": "2025-06-18T23:59:59.999999",
+				":q2": "2025-06-18T08:00:00", ":q3": "2025-06-18T08:00:00.999999",
+			},
+		},
+		{
+			name:     "an OR with a derived field stays in memory whole",
+			q:        `(model:x OR result:CORRECT) AND category:REF`,
+			excluded: dayIndexKeys,
+			pushed:   `(#q0 = :q0)`,
+			names:    map[string]string{"#q0": "productCategory"},
+			values:   map[string]string{":q0": "REF"},
+			residual: []string{`(model="x") OR (result="CORRECT")`},
+		},
+		{
+			name:     "an OR of stored fields is pushed",
+			q:        `model:x OR NOT category:REF`,
+			excluded: dayIndexKeys,
+			pushed:   `((#q0.#q1 = :q0) OR (NOT (#q2 = :q1)))`,
+			names:    map[string]string{"#q0": "bedrockResponse", "#q1": "model", "#q2": "productCategory"},
+			values:   map[string]string{":q0": "x", ":q1": "REF"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			node, err := parseFilter(tt.q)
+			if err != nil {
+				t.Fatalf("parseFilter(%q) returned error: %v", tt.q, err)
+			}
+
+			builder := newExpressionBuilder()
+			pushed, residual := splitFilter(conjuncts(node), tt.excluded, builder)
+
+			if pushed != tt.pushed {
+				t.Errorf("pushed = %s, want %s", pushed, tt.pushed)
+			}
+			if !reflect.DeepEqual(builder.Names, tt.names) && (len(builder.Names) > 0 || len(tt.names) > 0) {
+				t.Errorf("names = %v, want %v", builder.Names, tt.names)
+			}
+
+			values := map[string]string{}
+			for placeholder, value := range builder.Values {
+				switch v := value.(type) {
+				case *types.AttributeValueMemberS:
+					values[placeholder] = v.Value
+				case *types.AttributeValueMemberN:
+					values[placeholder] = v.Value
+				}
+			}
+			if !reflect.DeepEqual(values, tt.values) && (len(values) > 0 || len(tt.values) > 0) {
+				t.Errorf("values = %v, want %v", values, tt.values)
+			}
+
+			var residualStrings []string
+			for _, term := range residual {
+				residualStrings = append(residualStrings, term.String())
+			}
+			if !reflect.DeepEqual(residualStrings, tt.residual) {
+				t.Errorf("residual = %q, want %q", residualStrings, tt.residual)
+			}
+		})
+	}
+}
@@ -3,10 +3,10 @@ package main
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"os"
-	"sort"
 	"strconv"
 	"strings"
 	"time"
@@ -15,7 +15,6 @@ import (
 	"github.com/aws/aws-lambda-go/lambda"
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/config"
-	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
 	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
 	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
@@ -25,6 +24,8 @@ import (
 type Config struct {
 	ResultTable         string
 	ExportBucket       string
+	SearchIndexTable   string
+	ExportJobsTable    string
 	Region             string
 	LogLevel           string
 }
@@ -100,13 +101,11 @@ type MatchResult struct {
 	Explanation string  `json:"explanation"`
 }
 
+// Cursor-based pagination: pass nextCursor back as 'cursor' to read the following page
 type Pagination struct {
-	CurrentPage     int  `json:"currentPage"`
-	PageSize        int  `json:"pageSize"`
-	TotalRecords    int  `json:"totalRecords"`
-	TotalPages      int  `json:"totalPages"`
-	HasNextPage     bool `json:"hasNextPage"`
-	HasPreviousPage bool `json:"hasPreviousPage"`
+	PageSize    int    `json:"pageSize"`
+	NextCursor  string `json:"nextCursor,omitempty"`
+	HasNextPage bool   `json:"hasNextPage"`
 }
 
 type SummaryData struct {
@@ -150,14 +149,6 @@ type AccuracyStats struct {
 	SuccessRate      float64 `json:"successRate"`
 }
 
-type ExportData struct {
-	DownloadURL  string    `json:"downloadUrl"`
-	ExpiresAt    time.Time `json:"expiresAt"`
-	Format       string    `json:"format"`
-	RecordCount  int       `json:"recordCount"`
-	FileSize     string    `json:"fileSize"`
-}
-
 type ErrorResponse struct {
 	Error struct {
 		Code      string    `json:"code"`
@@ -181,6 +172,8 @@ func init() {
 	appConfig = &Config{
 		ResultTable:  os.Getenv("AWS_RESULT_TABLE"),
 		ExportBucket: os.Getenv("AWS_EXPORT_BUCKET"),
+		SearchIndexTable: os.Getenv("SEARCH_INDEX_TABLE"),
+		ExportJobsTable:  os.Getenv("EXPORT_JOBS_TABLE"),
 		Region:       os.Getenv("AWS_REGION"),
 		LogLevel:     os.Getenv("LOG_LEVEL"),
 	}
@@ -204,6 +197,50 @@ func init() {
 	s3Client = s3.NewFromConfig(cfg)
 
 	log.Println("AWS DynamoDB and S3 clients initialized successfully")
+	if searchEnabled() {
+		log.Printf("Search index enabled: table=%s", appConfig.SearchIndexTable)
+	}
+	if exportEnabled() {
+		log.Printf("Export jobs enabled: table=%s, bucket=%s", appConfig.ExportJobsTable, appConfig.ExportBucket)
+	}
+}
+
+// Lambda entry point, routing stream batches of the results and export job tables and API Gateway requests
+func dispatch(ctx context.Context, payload json.RawMessage) (interface{}, error) {
+	var streamEvent events.DynamoDBEvent
+	if err := json.Unmarshal(payload, &streamEvent); err == nil && len(streamEvent.Records) > 0 && streamEvent.Records[0].EventSource == "aws:dynamodb" {
+		if appConfig.ExportJobsTable != "" && streamTableName(streamEvent.Records[0].EventSourceArn) == appConfig.ExportJobsTable {
+			processed, err := processExportJobs(ctx, streamEvent)
+			if err != nil {
+				return nil, err
+			}
+			log.Printf("Processed %d export jobs from %d stream records", processed, len(streamEvent.Records))
+			return map[string]int{"processed": processed}, nil
+		}
+
+		written, err := indexSearchStream(ctx, streamEvent)
+		if err != nil {
+			return nil, err
+		}
+		log.Printf("Wrote %d search index changes from %d stream records", written, len(streamEvent.Records))
+		return map[string]int{"written": written}, nil
+	}
+
+	var request events.APIGatewayProxyRequest
+	if err := json.Unmarshal(payload, &request); err != nil {
+		return nil, fmt.Errorf("unsupported event payload: %w", err)
+	}
+
+	return handler(ctx, request)
+}
+
+// Table name in a stream ARN, e.g. "arn:aws:dynamodb:<region>:<account>:table/<name>/stream/<label>"
+func streamTableName(arn string) string {
+	parts := strings.Split(arn, "/")
+	if len(parts) < 2 {
+		return ""
+	}
+	return parts[1]
 }
 
 // Main Lambda handler function
@@ -235,14 +272,18 @@ func handler(ctx context.Context, request events.APIGatewayProxyRequest) (events
 		response, err = handleSummaryView(ctx, requestID, queryParams)
 	case "export":
 		response, err = handleExportView(ctx, requestID, queryParams)
+	case "search":
+		response, err = handleSearchView(ctx, requestID, queryParams)
+	case "trends":
+		response, err = handleTrendsView(ctx, requestID, queryParams)
 	default:
 		log.Printf("RequestID: %s - Invalid view type: %s", requestID, viewType)
-		return createErrorResponse(400, "INVALID_VIEW", fmt.Sprintf("Invalid 'view' parameter: %s. Valid values: list, summary, export", viewType))
+		return createErrorResponse(400, "INVALID_VIEW", fmt.Sprintf("Invalid 'view' parameter: %s. Valid values: list, summary, trends, export, search", viewType))
 	}
 
 	if err != nil {
 		log.Printf("RequestID: %s - Operation failed: %v", requestID, err)
-		return createErrorResponse(500, "OPERATION_FAILED", "Internal server error during history operation")
+		return createRequestErrorResponse(err)
 	}
 
 	// Serialize response to JSON
@@ -271,29 +312,28 @@ func handleHistoryList(ctx context.Context, requestID string, queryParams map[st
 	log.Printf("RequestID: %s - Starting history list operation", requestID)
 
 	// Parse pagination parameters
-	page, _ := strconv.Atoi(queryParams["page"])
-	if page < 1 {
-		page = 1
-	}
-
 	pageSize, _ := strconv.Atoi(queryParams["pageSize"])
-	if pageSize < 1 || pageSize > 100 {
-		pageSize = 20
+	if pageSize < 1 || pageSize > maxPageSize {
+		pageSize = defaultPageSize
 	}
+	cursor := queryParams["cursor"]
 
-	log.Printf("RequestID: %s - Pagination: page=%d, pageSize=%d", requestID, page, pageSize)
+	log.Printf("RequestID: %s - Pagination: pageSize=%d, cursor=%t", requestID, pageSize, cursor != "")
 
-	// Build filter conditions
-	filterExpression, filterValues, attributeNames := buildFilterConditions(queryParams)
-	log.Printf("RequestID: %s - Applied filters: %s", requestID, filterExpression)
+	query, err := parseHistoryQuery(queryParams)
+	if err != nil {
+		return nil, err
+	}
+	plan := planHistoryQuery(query)
+	log.Printf("RequestID: %s - Listing from index %s", requestID, plan.Index)
 
-	// Query DynamoDB with filters
-	records, totalCount, err := queryVerificationRecords(ctx, requestID, filterExpression, filterValues, attributeNames, page, pageSize)
+	// Query the index serving these filters
+	records, nextCursor, err := queryHistoryPage(ctx, requestID, query, pageSize, cursor)
 	if err != nil {
 		return nil, fmt.Errorf("failed to query verification records: %w", err)
 	}
 
-	log.Printf("RequestID: %s - Retrieved %d records out of %d total", requestID, len(records), totalCount)
+	log.Printf("RequestID: %s - Retrieved %d records, more: %t", requestID, len(records), nextCursor != "")
 
 	// Convert to API format
 	historyItems := make([]HistoryItem, 0, len(records))
@@ -306,15 +346,10 @@ func handleHistoryList(ctx context.Context, requestID string, queryParams map[st
 		historyItems = append(historyItems, item)
 	}
 
-	// Calculate pagination
-	totalPages := (totalCount + pageSize - 1) / pageSize
 	pagination := &Pagination{
-		CurrentPage:     page,
-		PageSize:        pageSize,
-		TotalRecords:    totalCount,
-		TotalPages:      totalPages,
-		HasNextPage:     page < totalPages,
-		HasPreviousPage: page > 1,
+		PageSize:    pageSize,
+		NextCursor:  nextCursor,
+		HasNextPage: nextCursor != "",
 	}
 
 	response := &HistoryResponse{
@@ -324,6 +359,7 @@ func handleHistoryList(ctx context.Context, requestID string, queryParams map[st
 		Metadata: map[string]interface{}{
 			"scannedAt":      time.Now(),
 			"appliedFilters": getAppliedFilters(queryParams),
+			"index":          plan.Index,
 		},
 	}
 
@@ -331,6 +367,48 @@ func handleHistoryList(ctx context.Context, requestID string, queryParams map[st
 	return response, nil
 }
 
+// Handle full-text search over the AI explanations
+func handleSearchView(ctx context.Context, requestID string, queryParams map[string]string) (*HistoryResponse, error) {
+	if !searchEnabled() {
+		return nil, &requestError{StatusCode: 503, Code: "SEARCH_DISABLED", Message: "Search is not configured (SEARCH_INDEX_TABLE is not set)"}
+	}
+
+	pageSize, _ := strconv.Atoi(queryParams["pageSize"])
+	if pageSize < 1 || pageSize > maxPageSize {
+		pageSize = defaultPageSize
+	}
+
+	query, err := parseSearchQuery(queryParams)
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("RequestID: %s - Searching for terms %v, %d phrases, fields %v", requestID, query.Terms, len(query.Phrases), query.Fields)
+
+	hits, nextCursor, err := searchPage(ctx, requestID, query, pageSize, queryParams["cursor"])
+	if err != nil {
+		return nil, fmt.Errorf("failed to search verification records: %w", err)
+	}
+
+	log.Printf("RequestID: %s - Search completed: %d hits, more: %t", requestID, len(hits), nextCursor != "")
+
+	return &HistoryResponse{
+		View: "search",
+		Data: hits,
+		Pagination: &Pagination{
+			PageSize:    pageSize,
+			NextCursor:  nextCursor,
+			HasNextPage: nextCursor != "",
+		},
+		Metadata: map[string]interface{}{
+			"scannedAt": time.Now(),
+			"text":      query.Text,
+			"terms":     query.Terms,
+			"fields":    query.Fields,
+			"category":  query.Category,
+		},
+	}, nil
+}
+
 // Handle summary analytics view
 func handleSummaryView(ctx context.Context, requestID string, queryParams map[string]string) (*HistoryResponse, error) {
 	log.Printf("RequestID: %s - Starting summary analytics operation", requestID)
@@ -339,11 +417,17 @@ func handleSummaryView(ctx context.Context, requestID string, queryParams map[st
 	dateFrom, dateTo := parseDateRange(queryParams)
 	log.Printf("RequestID: %s - Summary date range: %s to %s", requestID, dateFrom.Format(time.RFC3339), dateTo.Format(time.RFC3339))
 
+	filter, err := parseFilterParams(queryParams)
+	if err != nil {
+		return nil, err
+	}
+
 	// Query all records in date range
-	records, err := queryAllRecordsInRange(ctx, requestID, dateFrom, dateTo)
+	scan, err := queryAllRecordsInRange(ctx, requestID, dateFrom, dateTo, conjuncts(filter))
 	if err != nil {
 		return nil, fmt.Errorf("failed to query records for summary: %w", err)
 	}
+	records := scan.Records
 
 	log.Printf("RequestID: %s - Processing %d records for analytics", requestID, len(records))
 
@@ -358,7 +442,9 @@ func handleSummaryView(ctx context.Context, requestID string, queryParams map[st
 				"from": dateFrom,
 				"to":   dateTo,
 			},
-			"scannedAt": time.Now(),
+			"scannedAt":    time.Now(),
+			"complete":     scan.Complete,
+			"scannedItems": scan.ScannedItems,
 		},
 	}
 
@@ -366,132 +452,44 @@ func handleSummaryView(ctx context.Context, requestID string, queryParams map[st
 	return response, nil
 }
 
-// Handle export functionality
+// Handle export jobs: start one for the filters, or report on one with jobId
 func handleExportView(ctx context.Context, requestID string, queryParams map[string]string) (*HistoryResponse, error) {
-	format := queryParams["format"]
-	if format == "" {
-		return nil, fmt.Errorf("missing required 'format' parameter")
+	if !exportEnabled() {
+		return nil, errExportDisabled()
 	}
 
-	log.Printf("RequestID: %s - Starting export operation, format: %s", requestID, format)
-
-	// Query records with same filters as list view
-	filterExpression, filterValues, attributeNames := buildFilterConditions(queryParams)
-	records, _, err := queryVerificationRecords(ctx, requestID, filterExpression, filterValues, attributeNames, 1, 10000) // Export all matching records
+	var job *ExportJob
+	var err error
+	if jobID := queryParams["jobId"]; jobID != "" {
+		log.Printf("RequestID: %s - Reading export job %s", requestID, jobID)
+		job, err = getExportJob(ctx, jobID)
+	} else {
+		log.Printf("RequestID: %s - Starting export job, format: %s", requestID, queryParams["format"])
+		job, err = createExportJob(ctx, requestID, queryParams)
+	}
 	if err != nil {
-		return nil, fmt.Errorf("failed to query records for export: %w", err)
+		return nil, err
 	}
 
-	log.Printf("RequestID: %s - Exporting %d records", requestID, len(records))
-
-	// Generate export file
-	exportData, err := generateExportFile(ctx, requestID, records, format)
+	status, err := exportJobStatus(ctx, requestID, job)
 	if err != nil {
-		return nil, fmt.Errorf("failed to generate export file: %w", err)
+		return nil, err
 	}
 
 	response := &HistoryResponse{
 		View: "export",
-		Data: exportData,
+		Data: status,
 		Metadata: map[string]interface{}{
-			"exportedAt":     time.Now(),
-			"appliedFilters": getAppliedFilters(queryParams),
+			"checkedAt":      time.Now(),
+			"appliedFilters": getAppliedFilters(job.Filters),
+			"statusQuery":    "view=export&jobId=" + job.JobID,
 		},
 	}
 
-	log.Printf("RequestID: %s - Export completed: %s", requestID, exportData.DownloadURL)
+	log.Printf("RequestID: %s - Export job %s is %s: %d records", requestID, job.JobID, status.Status, status.RecordCount)
 	return response, nil
 }
 
-// Build DynamoDB filter conditions based on query parameters
-func buildFilterConditions(queryParams map[string]string) (string, map[string]types.AttributeValue, map[string]string) {
-	var conditions []string
-	filterValues := make(map[string]types.AttributeValue)
-	attributeNames := make(map[string]string)
-
-	if productID := queryParams["productId"]; productID != "" {
-		conditions = append(conditions, "productId = :productId")
-		filterValues[":productId"] = &types.AttributeValueMemberS{Value: productID}
-	}
-
-	if category := queryParams["category"]; category != "" {
-		conditions = append(conditions, "productCategory = :category")
-		filterValues[":category"] = &types.AttributeValueMemberS{Value: category}
-	}
-
-	if dateFrom := queryParams["dateFrom"]; dateFrom != "" {
-		conditions = append(conditions, "#ts >= :dateFrom")
-		filterValues[":dateFrom"] = &types.AttributeValueMemberS{Value: dateFrom}
-		attributeNames["#ts"] = "timestamp" // Only add when used
-	}
-
-	if dateTo := queryParams["dateTo"]; dateTo != "" {
-		conditions = append(conditions, "#ts <= :dateTo")
-		filterValues[":dateTo"] = &types.AttributeValueMemberS{Value: dateTo}
-		attributeNames["#ts"] = "timestamp" // Only add when used
-	}
-
-	filterExpression := strings.Join(conditions, " AND ")
-	return filterExpression, filterValues, attributeNames
-}
-
-// Query verification records from DynamoDB
-func queryVerificationRecords(ctx context.Context, requestID, filterExpression string, filterValues map[string]types.AttributeValue, attributeNames map[string]string, page, pageSize int) ([]VerificationRecord, int, error) {
-	log.Printf("RequestID: %s - Querying DynamoDB with filter: %s", requestID, filterExpression)
-
-	input := &dynamodb.ScanInput{
-		TableName: aws.String(appConfig.ResultTable),
-	}
-
-	if filterExpression != "" {
-		input.FilterExpression = aws.String(filterExpression)
-		input.ExpressionAttributeValues = filterValues
-		// Only set ExpressionAttributeNames if we have any
-		if len(attributeNames) > 0 {
-			input.ExpressionAttributeNames = attributeNames
-		}
-	}
-
-	result, err := dynamoClient.Scan(ctx, input)
-	if err != nil {
-		return nil, 0, fmt.Errorf("DynamoDB scan failed: %w", err)
-	}
-
-	log.Printf("RequestID: %s - DynamoDB scan returned %d items", requestID, len(result.Items))
-
-	// Unmarshal records
-	var records []VerificationRecord
-	for _, item := range result.Items {
-		var record VerificationRecord
-		err := attributevalue.UnmarshalMap(item, &record)
-		if err != nil {
-			log.Printf("RequestID: %s - Warning: Failed to unmarshal record: %v", requestID, err)
-			continue
-		}
-		records = append(records, record)
-	}
-
-	// Sort by timestamp (newest first)
-	sort.Slice(records, func(i, j int) bool {
-		return records[i].Timestamp > records[j].Timestamp
-	})
-
-	totalCount := len(records)
-
-	// Apply pagination
-	start := (page - 1) * pageSize
-	end := start + pageSize
-	if start >= len(records) {
-		records = []VerificationRecord{}
-	} else if end > len(records) {
-		records = records[start:]
-	} else {
-		records = records[start:end]
-	}
-
-	return records, totalCount, nil
-}
-
 // Convert DynamoDB record to API history item format
 func convertToHistoryItem(requestID string, record VerificationRecord) (HistoryItem, error) {
 	// Parse timestamp
@@ -626,54 +624,77 @@ func parseVerificationResults(requestID string, bedrockResponse BedrockResponse)
 	return results, nil
 }
 
+// Overall confidence thresholds of a verification result. A result is CORRECT when both
+// images match at correctConfidenceThreshold or above, INCORRECT below
+// incorrectConfidenceThreshold, and UNCERTAIN otherwise. The catalog API (verification.go)
+// and the transaction API (main.go) classify with copies of these values, which the catalog
+// API's TestVerificationThresholdsMatch keeps in step.
+const (
+	correctConfidenceThreshold   = 0.85
+	incorrectConfidenceThreshold = 0.60
+)
+
 // Determine verification result based on AI analysis
 func determineVerificationResult(results VerificationResults, overallConfidence float64) string {
 	labelMatch := results.MatchLabelToReference == "yes"
 	overviewMatch := results.MatchOverviewToReference == "yes"
 
-	if labelMatch && overviewMatch && overallConfidence >= 0.85 {
+	if labelMatch && overviewMatch && overallConfidence >= correctConfidenceThreshold {
 		return "CORRECT"
-	} else if overallConfidence < 0.60 {
+	} else if overallConfidence < incorrectConfidenceThreshold {
 		return "INCORRECT"
 	} else {
 		return "UNCERTAIN"
 	}
 }
 
-// Query all records within date range for analytics
-func queryAllRecordsInRange(ctx context.Context, requestID string, dateFrom, dateTo time.Time) ([]VerificationRecord, error) {
+// Query all records within date range for analytics, scanning the table in parallel segments.
+// Filter terms on stored attributes are applied by DynamoDB, the rest in memory.
+func queryAllRecordsInRange(ctx context.Context, requestID string, dateFrom, dateTo time.Time, filter []filterNode) (*scanResult, error) {
 	log.Printf("RequestID: %s - Querying all records from %s to %s", requestID, dateFrom.Format(time.RFC3339), dateTo.Format(time.RFC3339))
 
-	input := &dynamodb.ScanInput{
+	input := dynamodb.ScanInput{
 		TableName:        aws.String(appConfig.ResultTable),
 		FilterExpression: aws.String("#ts BETWEEN :dateFrom AND :dateTo"),
 		ExpressionAttributeNames: map[string]string{
 			"#ts": "timestamp",
 		},
 		ExpressionAttributeValues: map[string]types.AttributeValue{
-			":dateFrom": &types.AttributeValueMemberS{Value: dateFrom.Format(time.RFC3339)},
-			":dateTo":   &types.AttributeValueMemberS{Value: dateTo.Format(time.RFC3339)},
+			// Stored timestamps are naive UTC; the upper bound covers the whole second
+			":dateFrom": &types.AttributeValueMemberS{Value: dateFrom.UTC().Format(resultTimestampLayout)},
+			":dateTo":   &types.AttributeValueMemberS{Value: dateTo.UTC().Format(resultTimestampLayout) + ".999999"},
 		},
 	}
 
-	result, err := dynamoClient.Scan(ctx, input)
+	builder := newExpressionBuilder()
+	pushed, residual := splitFilter(filter, nil, builder)
+	if pushed != "" {
+		input.FilterExpression = aws.String(*input.FilterExpression + " AND " + pushed)
+		for name, value := range builder.Names {
+			input.ExpressionAttributeNames[name] = value
+		}
+		for name, value := range builder.Values {
+			input.ExpressionAttributeValues[name] = value
+		}
+	}
+
+	result, err := parallelScan(ctx, requestID, dynamoClient, input)
 	if err != nil {
 		return nil, fmt.Errorf("failed to scan records for analytics: %w", err)
 	}
 
-	var records []VerificationRecord
-	for _, item := range result.Items {
-		var record VerificationRecord
-		err := attributevalue.UnmarshalMap(item, &record)
-		if err != nil {
-			log.Printf("RequestID: %s - Warning: Failed to unmarshal analytics record: %v", requestID, err)
-			continue
+	if len(residual) > 0 {
+		matched := []VerificationRecord{}
+		for _, record := range result.Records {
+			if matchesAll(residual, &recordFields{RequestID: requestID, Record: record}) {
+				matched = append(matched, record)
+			}
 		}
-		records = append(records, record)
+		result.Records = matched
 	}
 
-	log.Printf("RequestID: %s - Retrieved %d records for analytics", requestID, len(records))
-	return records, nil
+	log.Printf("RequestID: %s - Retrieved %d records for analytics, complete: %t", requestID, len(result.Records), result.Complete)
+	return result, nil
 }
 
 // Calculate comprehensive analytics from verification records
@@ -818,102 +839,6 @@ func calculateSummaryAnalytics(requestID string, records []VerificationRecord) S
 	return summaryData
 }
 
-// Generate export file and upload to S3
-func generateExportFile(ctx context.Context, requestID string, records []VerificationRecord, format string) (ExportData, error) {
-	log.Printf("RequestID: %s - Generating export file in format: %s", requestID, format)
-
-	if format != "csv" && format != "json" && format != "pdf" {
-		return ExportData{}, fmt.Errorf("unsupported export format: %s", format)
-	}
-
-	// Convert records to export format
-	var exportContent string
-
-	switch format {
-	case "csv":
-		exportContent = generateCSV(requestID, records)
-	case "json":
-		exportContent = generateJSON(requestID, records)
-	case "pdf":
-		return ExportData{}, fmt.Errorf("PDF export not implemented yet")
-	}
-
-	// Generate filename
-	filename := fmt.Sprintf("history-export-%s.%s", time.Now().Format("20060102-150405"), format)
-	
-	// Mock S3 upload for this example (in production, upload to actual S3)
-	downloadURL := fmt.Sprintf("https://s3.amazonaws.com/%s/exports/%s", appConfig.ExportBucket, filename)
-	
-	exportData := ExportData{
-		DownloadURL: downloadURL,
-		ExpiresAt:   time.Now().Add(24 * time.Hour),
-		Format:      format,
-		RecordCount: len(records),
-		FileSize:    fmt.Sprintf("%.1fKB", float64(len(exportContent))/1024),
-	}
-
-	log.Printf("RequestID: %s - Export file generated: %s, size: %s", requestID, filename, exportData.FileSize)
-	return exportData, nil
-}
-
-// Generate CSV export content
-func generateCSV(requestID string, records []VerificationRecord) string {
-	log.Printf("RequestID: %s - Generating CSV export for %d records", requestID, len(records))
-
-	var lines []string
-	lines = append(lines, "ID,Timestamp,Product ID,Category,Verification Result,Overall Confidence,Label Match,Label Confidence,Overview Match,Overview Confidence,AI Model")
-
-	for _, record := range records {
-		verificationResults, err := parseVerificationResults(requestID, record.BedrockResponse)
-		if err != nil {
-			continue
-		}
-
-		overallConfidence := (verificationResults.MatchLabelConfidence + verificationResults.MatchOverviewConfidence) / 2
-		verificationResult := determineVerificationResult(verificationResults, overallConfidence)
-
-		line := fmt.Sprintf("%s,%s,%s,%s,%s,%.3f,%s,%.3f,%s,%.3f,%s",
-			record.ID,
-			record.Timestamp,
-			record.ProductID,
-			record.ProductCategory,
-			verificationResult,
-			overallConfidence,
-			verificationResults.MatchLabelToReference,
-			verificationResults.MatchLabelConfidence,
-			verificationResults.MatchOverviewToReference,
-			verificationResults.MatchOverviewConfidence,
-			record.BedrockResponse.Model,
-		)
-		lines = append(lines, line)
-	}
-
-	return strings.Join(lines, "\n")
-}
-
-// Generate JSON export content
-func generateJSON(requestID string, records []VerificationRecord) string {
-	log.Printf("RequestID: %s - Generating JSON export for %d records", requestID, len(records))
-
-	var historyItems []HistoryItem
-	for _, record := range records {
-		item, err := convertToHistoryItem(requestID, record)
-		if err != nil {
-			continue
-		}
-		historyItems = append(historyItems, item)
-	}
-
-	exportData := map[string]interface{}{
-		"exportedAt": time.Now(),
-		"totalRecords": len(historyItems),
-		"records": historyItems,
-	}
-
-	jsonBytes, _ := json.MarshalIndent(exportData, "", "  ")
-	return string(jsonBytes)
-}
-
 // Parse date range from query parameters
 func parseDateRange(queryParams map[string]string) (time.Time, time.Time) {
 	now := time.Now()
@@ -963,6 +888,9 @@ func getAppliedFilters(queryParams map[string]string) map[string]interface{} {
 	if result := queryParams["result"]; result != "" {
 		filters["result"] = result
 	}
+	if q := queryParams["q"]; q != "" {
+		filters["q"] = q
+	}
 	if dateFrom := queryParams["dateFrom"]; dateFrom != "" {
 		filters["dateFrom"] = dateFrom
 	}
@@ -973,6 +901,16 @@ func getAppliedFilters(queryParams map[string]string) map[string]interface{} {
 	return filters
 }
 
+// Map an operation error to a response: 4xx for invalid input, 500 otherwise
+func createRequestErrorResponse(err error) (events.APIGatewayProxyResponse, error) {
+	var reqErr *requestError
+	if errors.As(err, &reqErr) {
+		return createErrorResponse(reqErr.StatusCode, reqErr.Code, reqErr.Message)
+	}
+
+	return createErrorResponse(500, "OPERATION_FAILED", "Internal server error during history operation")
+}
+
 // Create standardized error response
 func createErrorResponse(statusCode int, errorCode, message string) (events.APIGatewayProxyResponse, error) {
 	errorResp := ErrorResponse{}
@@ -1002,5 +940,5 @@ func createErrorResponse(statusCode int, errorCode, message string) (events.APIG
 // Main function to start Lambda
 func main() {
 	log.Println("Starting Aqua History API Lambda function")
-	lambda.Start(handler)
+	lambda.Start(dispatch)
 }
@@ -0,0 +1,393 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"reflect"
+	"time"
+)
+
+// Minimal Apache Parquet writer for flat records: one row group per file, PLAIN-encoded
+// data pages compressed with GZIP, which Athena, Spark and pandas all read. Columns come
+// from the parquet tags of the struct fields, in field order. Pointer fields are optional
+// columns; strings, float64, int64, bool and time.Time (as a UTC millisecond timestamp)
+// are supported.
+
+const (
+	// Rows per data page, which keeps pages around a megabyte with long explanations
+	parquetPageRows  = 2000
+	parquetCreatedBy = "history-api"
+)
+
+// Parquet physical types, repetitions, encodings and codecs (parquet.thrift)
+const (
+	parquetBoolean   = 0
+	parquetInt64     = 2
+	parquetDouble    = 5
+	parquetByteArray = 6
+
+	parquetRequired = 0
+	parquetOptional = 1
+
+	parquetPlain = 0
+	parquetRLE   = 3
+
+	parquetGzip = 2
+
+	parquetDataPage = 0
+
+	parquetConvertedUTF8            = 0
+	parquetConvertedTimestampMillis = 9
+)
+
+type parquetColumn struct {
+	name      string
+	field     int
+	physical  int32
+	optional  bool
+	timestamp bool
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+func parquetColumns(rowType reflect.Type) ([]parquetColumn, error) {
+	columns := []parquetColumn{}
+	for i := 0; i < rowType.NumField(); i++ {
+		field := rowType.Field(i)
+		name := field.Tag.Get("parquet")
+		if name == "" || name == "-" {
+			continue
+		}
+
+		column := parquetColumn{name: name, field: i}
+		fieldType := field.Type
+		if fieldType.Kind() == reflect.Ptr {
+			column.optional = true
+			fieldType = fieldType.Elem()
+		}
+		switch {
+		case fieldType == timeType:
+			column.physical, column.timestamp = parquetInt64, true
+		case fieldType.Kind() == reflect.String:
+			column.physical = parquetByteArray
+		case fieldType.Kind() == reflect.Float64:
+			column.physical = parquetDouble
+		case fieldType.Kind() == reflect.Int64:
+			column.physical = parquetInt64
+		case fieldType.Kind() == reflect.Bool:
+			column.physical = parquetBoolean
+		default:
+			return nil, fmt.Errorf("unsupported parquet column type %s of %s", field.Type, field.Name)
+		}
+		columns = append(columns, column)
+	}
+	return columns, nil
+}
+
+// Encode a slice of structs as a Parquet file
+func writeParquet(rows interface{}) ([]byte, error) {
+	slice := reflect.ValueOf(rows)
+	if slice.Kind() != reflect.Slice || slice.Type().Elem().Kind() != reflect.Struct {
+		return nil, fmt.Errorf("parquet rows must be a slice of structs, got %T", rows)
+	}
+	columns, err := parquetColumns(slice.Type().Elem())
+	if err != nil {
+		return nil, err
+	}
+
+	var file bytes.Buffer
+	file.WriteString("PAR1")
+
+	chunks := make([]parquetChunk, 0, len(columns))
+	var totalSize int64
+	for _, column := range columns {
+		chunk := parquetChunk{column: column, offset: int64(file.Len())}
+		for start := 0; start < slice.Len() || start == 0; start += parquetPageRows {
+			end := start + parquetPageRows
+			if end > slice.Len() {
+				end = slice.Len()
+			}
+			uncompressed, compressed, err := writeParquetPage(&file, column, slice, start, end)
+			if err != nil {
+				return nil, err
+			}
+			chunk.uncompressed += uncompressed
+			chunk.compressed += compressed
+		}
+		chunk.values = int64(slice.Len())
+		totalSize += chunk.uncompressed
+		chunks = append(chunks, chunk)
+	}
+
+	footer := parquetFileMetadata(columns, chunks, int64(slice.Len()), totalSize)
+	file.Write(footer)
+	binary.Write(&file, binary.LittleEndian, uint32(len(footer)))
+	file.WriteString("PAR1")
+	return file.Bytes(), nil
+}
+
+type parquetChunk struct {
+	column       parquetColumn
+	offset       int64
+	values       int64
+	uncompressed int64
+	compressed   int64
+}
+
+// Write the rows [start, end) of a column as one data page. Returns the page sizes
+// including its header, before and after compression.
+func writeParquetPage(file *bytes.Buffer, column parquetColumn, rows reflect.Value, start, end int) (int64, int64, error) {
+	var body bytes.Buffer
+	var present []bool
+	if column.optional {
+		present = make([]bool, 0, end-start)
+	}
+
+	var bits []bool
+	for i := start; i < end; i++ {
+		value := rows.Index(i).Field(column.field)
+		if column.optional {
+			present = append(present, !value.IsNil())
+			if value.IsNil() {
+				continue
+			}
+			value = value.Elem()
+		}
+
+		switch {
+		case column.timestamp:
+			binary.Write(&body, binary.LittleEndian, value.Interface().(time.Time).UnixMilli())
+		case column.physical == parquetByteArray:
+			binary.Write(&body, binary.LittleEndian, uint32(value.Len()))
+			body.WriteString(value.String())
+		case column.physical == parquetDouble:
+			binary.Write(&body, binary.LittleEndian, math.Float64bits(value.Float()))
+		case column.physical == parquetInt64:
+			binary.Write(&body, binary.LittleEndian, value.Int())
+		case column.physical == parquetBoolean:
+			bits = append(bits, value.Bool())
+		}
+	}
+	if column.physical == parquetBoolean {
+		body.Write(packBits(bits))
+	}
+
+	// Definition levels come first: 0 for null, 1 for a value, as one bit-packed run
+	var page bytes.Buffer
+	if column.optional {
+		levels := append(uvarint(uint64(len(present)+7)/8<<1|1), packBits(present)...)
+		binary.Write(&page, binary.LittleEndian, uint32(len(levels)))
+		page.Write(levels)
+	}
+	page.Write(body.Bytes())
+
+	var compressed bytes.Buffer
+	writer := gzip.NewWriter(&compressed)
+	if _, err := writer.Write(page.Bytes()); err != nil {
+		return 0, 0, fmt.Errorf("failed to compress parquet page of %s: %w", column.name, err)
+	}
+	if err := writer.Close(); err != nil {
+		return 0, 0, fmt.Errorf("failed to compress parquet page of %s: %w", column.name, err)
+	}
+
+	var header thriftWriter
+	header.i32(1, parquetDataPage)
+	header.i32(2, int32(page.Len()))
+	header.i32(3, int32(compressed.Len()))
+	header.beginStruct(5)
+	header.i32(1, int32(end-start))
+	header.i32(2, parquetPlain)
+	header.i32(3, parquetRLE)
+	header.i32(4, parquetRLE)
+	header.endStruct()
+	header.stop()
+
+	file.Write(header.Bytes())
+	file.Write(compressed.Bytes())
+	return int64(header.Len() + page.Len()), int64(header.Len() + compressed.Len()), nil
+}
+
+func parquetFileMetadata(columns []parquetColumn, chunks []parquetChunk, numRows, totalSize int64) []byte {
+	var meta thriftWriter
+	meta.i32(1, 1)
+
+	meta.beginList(2, thriftStruct, len(columns)+1)
+	meta.beginElement()
+	meta.str(4, "schema")
+	meta.i32(5, int32(len(columns)))
+	meta.endElement()
+	for _, column := range columns {
+		meta.beginElement()
+		meta.i32(1, column.physical)
+		repetition := int32(parquetRequired)
+		if column.optional {
+			repetition = parquetOptional
+		}
+		meta.i32(3, repetition)
+		meta.str(4, column.name)
+		switch {
+		case column.timestamp:
+			meta.i32(6, parquetConvertedTimestampMillis)
+			meta.beginStruct(10) // LogicalType
+			meta.beginStruct(8)  // TIMESTAMP
+			meta.boolean(1, true)
+			meta.beginStruct(2) // unit
+			meta.beginStruct(1) // MILLIS
+			meta.endStruct()
+			meta.endStruct()
+			meta.endStruct()
+			meta.endStruct()
+		case column.physical == parquetByteArray:
+			meta.i32(6, parquetConvertedUTF8)
+			meta.beginStruct(10) // LogicalType
+			meta.beginStruct(1)  // STRING
+			meta.endStruct()
+			meta.endStruct()
+		}
+		meta.endElement()
+	}
+
+	meta.i64(3, numRows)
+
+	meta.beginList(4, thriftStruct, 1)
+	meta.beginElement()
+	meta.beginList(1, thriftStruct, len(chunks))
+	for _, chunk := range chunks {
+		meta.beginElement()
+		meta.i64(2, chunk.offset)
+		meta.beginStruct(3)
+		meta.i32(1, chunk.column.physical)
+		meta.beginList(2, thriftI32, 2)
+		meta.listI32(parquetPlain)
+		meta.listI32(parquetRLE)
+		meta.beginList(3, thriftBinary, 1)
+		meta.listStr(chunk.column.name)
+		meta.i32(4, parquetGzip)
+		meta.i64(5, chunk.values)
+		meta.i64(6, chunk.uncompressed)
+		meta.i64(7, chunk.compressed)
+		meta.i64(9, chunk.offset)
+		meta.endStruct()
+		meta.endElement()
+	}
+	meta.i64(2, totalSize)
+	meta.i64(3, numRows)
+	meta.endElement()
+
+	meta.str(6, parquetCreatedBy)
+	meta.stop()
+	return meta.Bytes()
+}
+
+// LSB-first bit packing, padded to whole bytes
+func packBits(bits []bool) []byte {
+	packed := make([]byte, (len(bits)+7)/8)
+	for i, bit := range bits {
+		if bit {
+			packed[i/8] |= 1 << (i % 8)
+		}
+	}
+	return packed
+}
+
+func uvarint(value uint64) []byte {
+	return binary.AppendUvarint(nil, value)
+}
+
+// Thrift compact protocol, only as much as Parquet metadata needs
+const (
+	thriftTrue   = 1
+	thriftFalse  = 2
+	thriftI32    = 5
+	thriftI64    = 6
+	thriftBinary = 8
+	thriftList   = 9
+	thriftStruct = 12
+)
+
+type thriftWriter struct {
+	bytes.Buffer
+	lastField int16
+	fieldIDs  []int16
+}
+
+func (w *thriftWriter) fieldHeader(id int16, fieldType byte) {
+	if delta := id - w.lastField; delta > 0 && delta <= 15 {
+		w.WriteByte(byte(delta)<<4 | fieldType)
+	} else {
+		w.WriteByte(fieldType)
+		w.Write(binary.AppendVarint(nil, int64(id)))
+	}
+	w.lastField = id
+}
+
+func (w *thriftWriter) i32(id int16, value int32) {
+	w.fieldHeader(id, thriftI32)
+	w.Write(binary.AppendVarint(nil, int64(value)))
+}
+
+func (w *thriftWriter) i64(id int16, value int64) {
+	w.fieldHeader(id, thriftI64)
+	w.Write(binary.AppendVarint(nil, value))
+}
+
+func (w *thriftWriter) boolean(id int16, value bool) {
+	if value {
+		w.fieldHeader(id, thriftTrue)
+	} else {
+		w.fieldHeader(id, thriftFalse)
+	}
+}
+
+func (w *thriftWriter) str(id int16, value string) {
+	w.fieldHeader(id, thriftBinary)
+	w.Write(uvarint(uint64(len(value))))
+	w.WriteString(value)
+}
+
+func (w *thriftWriter) beginStruct(id int16) {
+	w.fieldHeader(id, thriftStruct)
+	w.beginElement()
+}
+
+func (w *thriftWriter) endStruct() {
+	w.endElement()
+}
+
+// Struct elements of a list have no field header
+func (w *thriftWriter) beginElement() {
+	w.fieldIDs = append(w.fieldIDs, w.lastField)
+	w.lastField = 0
+}
+
+func (w *thriftWriter) endElement() {
+	w.stop()
+	w.lastField = w.fieldIDs[len(w.fieldIDs)-1]
+	w.fieldIDs = w.fieldIDs[:len(w.fieldIDs)-1]
+}
+
+func (w *thriftWriter) stop() {
+	w.WriteByte(0)
+}
+
+func (w *thriftWriter) beginList(id int16, elementType byte, size int) {
+	w.fieldHeader(id, thriftList)
+	if size < 15 {
+		w.WriteByte(byte(size)<<4 | elementType)
+	} else {
+		w.WriteByte(0xf0 | elementType)
+		w.Write(uvarint(uint64(size)))
+	}
+}
+
+func (w *thriftWriter) listI32(value int32) {
+	w.Write(binary.AppendVarint(nil, int64(value)))
+}
+
+func (w *thriftWriter) listStr(value string) {
+	w.Write(uvarint(uint64(len(value))))
+	w.WriteString(value)
+}
@@ -0,0 +1,428 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Thrift compact protocol reader for checking the metadata the writer produces. Structs
+// decode to their fields by ID: integers as int64, binaries as string, structs as
+// thriftFields and lists as []interface{}.
+type thriftFields map[int16]interface{}
+
+type thriftReader struct {
+	data []byte
+	pos  int
+}
+
+func (r *thriftReader) byte() byte {
+	if r.pos >= len(r.data) {
+		panic("thrift: unexpected end of data")
+	}
+	b := r.data[r.pos]
+	r.pos++
+	return b
+}
+
+func (r *thriftReader) varint() int64 {
+	value, n := binary.Varint(r.data[r.pos:])
+	if n <= 0 {
+		panic("thrift: bad varint")
+	}
+	r.pos += n
+	return value
+}
+
+func (r *thriftReader) uvarint() uint64 {
+	value, n := binary.Uvarint(r.data[r.pos:])
+	if n <= 0 {
+		panic("thrift: bad uvarint")
+	}
+	r.pos += n
+	return value
+}
+
+func (r *thriftReader) readStruct() thriftFields {
+	fields := thriftFields{}
+	var lastID int16
+	for {
+		header := r.byte()
+		if header == 0 {
+			return fields
+		}
+		fieldType := header & 0x0f
+		id := lastID + int16(header>>4)
+		if header>>4 == 0 {
+			id = int16(r.varint())
+		}
+		lastID = id
+
+		switch fieldType {
+		case thriftTrue:
+			fields[id] = true
+		case thriftFalse:
+			fields[id] = false
+		default:
+			fields[id] = r.readValue(fieldType)
+		}
+	}
+}
+
+func (r *thriftReader) readValue(valueType byte) interface{} {
+	switch valueType {
+	case thriftI32, thriftI64:
+		return r.varint()
+	case thriftBinary:
+		length := int(r.uvarint())
+		value := string(r.data[r.pos : r.pos+length])
+		r.pos += length
+		return value
+	case thriftStruct:
+		return r.readStruct()
+	case thriftList:
+		header := r.byte()
+		size := int(header >> 4)
+		if size == 15 {
+			size = int(r.uvarint())
+		}
+		list := make([]interface{}, size)
+		for i := range list {
+			list[i] = r.readValue(header & 0x0f)
+		}
+		return list
+	}
+	panic(fmt.Sprintf("thrift: unsupported type %d", valueType))
+}
+
+type parquetTestRow struct {
+	Name     string     `parquet:"name"`
+	Note     *string    `parquet:"note"`
+	Score    float64    `parquet:"score"`
+	Ratio    *float64   `parquet:"ratio"`
+	Count    int64      `parquet:"count"`
+	Flag     bool       `parquet:"flag"`
+	At       time.Time  `parquet:"at"`
+	Seen     *time.Time `parquet:"seen"`
+	Internal string
+	Skipped  string `parquet:"-"`
+}
+
+func parquetTestRows(n int) []parquetTestRow {
+	base := time.Date(2025, 6, 18, 8, 1, 13, 680325000, time.UTC)
+	rows := make([]parquetTestRow, n)
+	for i := range rows {
+		row := parquetTestRow{
+			Name:     fmt.Sprintf("row-%d-%s", i, strings.Repeat("Nhãn", i%3)),
+			Score:    float64(i) / 3,
+			Count:    int64(i) * 1000003,
+			Flag:     i%3 == 0,
+			At:       base.Add(time.Duration(i) * time.Minute),
+			Internal: "not written",
+		}
+		if i%2 == 0 {
+			note := fmt.Sprintf("note %d", i)
+			row.Note = &note
+		}
+		if i%5 != 0 {
+			ratio := -float64(i)
+			row.Ratio = &ratio
+		}
+		if i%7 == 0 {
+			seen := base.Add(-time.Duration(i) * time.Hour)
+			row.Seen = &seen
+		}
+		rows[i] = row
+	}
+	return rows
+}
+
+// Column schema expected in the footer: name, physical type, optional, converted type (-1 for none)
+var parquetTestSchema = []struct {
+	name      string
+	physical  int64
+	optional  bool
+	converted int64
+}{
+	{"name", parquetByteArray, false, parquetConvertedUTF8},
+	{"note", parquetByteArray, true, parquetConvertedUTF8},
+	{"score", parquetDouble, false, -1},
+	{"ratio", parquetDouble, true, -1},
+	{"count", parquetInt64, false, -1},
+	{"flag", parquetBoolean, false, -1},
+	{"at", parquetInt64, false, parquetConvertedTimestampMillis},
+	{"seen", parquetInt64, true, parquetConvertedTimestampMillis},
+}
+
+func TestWriteParquet(t *testing.T) {
+	for _, rowCount := range []int{0, 1, 9, parquetPageRows, parquetPageRows*2 + 17} {
+		t.Run(fmt.Sprintf("%d rows", rowCount), func(t *testing.T) {
+			rows := parquetTestRows(rowCount)
+			file, err := writeParquet(rows)
+			if err != nil {
+				t.Fatalf("writeParquet returned error: %v", err)
+			}
+
+			if !bytes.HasPrefix(file, []byte("PAR1")) || !bytes.HasSuffix(file, []byte("PAR1")) {
+				t.Fatal("file does not start and end with the PAR1 magic")
+			}
+			footerLength := int(binary.LittleEndian.Uint32(file[len(file)-8:]))
+			footerStart := len(file) - 8 - footerLength
+			if footerStart < 4 {
+				t.Fatalf("footer length %d does not fit in a %d byte file", footerLength, len(file))
+			}
+
+			reader := &thriftReader{data: file[footerStart : len(file)-8]}
+			meta := reader.readStruct()
+			if reader.pos != footerLength {
+				t.Fatalf("footer metadata is %d bytes, footer length says %d", reader.pos, footerLength)
+			}
+			checkParquetFileMetadata(t, meta, int64(rowCount))
+
+			rowGroup := meta[4].([]interface{})[0].(thriftFields)
+			for i, chunk := range rowGroup[1].([]interface{}) {
+				checkParquetColumnChunk(t, file, chunk.(thriftFields), i, rows)
+			}
+		})
+	}
+}
+
+func checkParquetFileMetadata(t *testing.T, meta thriftFields, rowCount int64) {
+	t.Helper()
+	if meta[1] != int64(1) {
+		t.Errorf("version = %v, want 1", meta[1])
+	}
+	if meta[3] != rowCount {
+		t.Errorf("num_rows = %v, want %d", meta[3], rowCount)
+	}
+	if meta[6] != parquetCreatedBy {
+		t.Errorf("created_by = %v, want %s", meta[6], parquetCreatedBy)
+	}
+
+	schema := meta[2].([]interface{})
+	if len(schema) != len(parquetTestSchema)+1 {
+		t.Fatalf("schema has %d elements, want %d", len(schema), len(parquetTestSchema)+1)
+	}
+	root := schema[0].(thriftFields)
+	if root[4] != "schema" || root[5] != int64(len(parquetTestSchema)) {
+		t.Errorf("schema root = %v, want %d children", root, len(parquetTestSchema))
+	}
+	for i, want := range parquetTestSchema {
+		element := schema[i+1].(thriftFields)
+		repetition := int64(parquetRequired)
+		if want.optional {
+			repetition = parquetOptional
+		}
+		if element[4] != want.name || element[1] != want.physical || element[3] != repetition {
+			t.Errorf("schema element %d = %v, want %s of type %d, repetition %d", i+1, element, want.name, want.physical, repetition)
+		}
+		converted, ok := element[6]
+		if (want.converted < 0 && ok) || (want.converted >= 0 && converted != want.converted) {
+			t.Errorf("schema element %s converted type = %v, want %d", want.name, converted, want.converted)
+		}
+		if want.converted == parquetConvertedTimestampMillis {
+			timestamp := element[10].(thriftFields)[8].(thriftFields)
+			if timestamp[1] != true {
+				t.Errorf("timestamp %s is not adjusted to UTC", want.name)
+			}
+			if _, ok := timestamp[2].(thriftFields)[1]; !ok {
+				t.Errorf("timestamp %s unit is not MILLIS", want.name)
+			}
+		}
+	}
+
+	rowGroups := meta[4].([]interface{})
+	if len(rowGroups) != 1 {
+		t.Fatalf("file has %d row groups, want 1", len(rowGroups))
+	}
+	if rowGroup := rowGroups[0].(thriftFields); rowGroup[3] != rowCount {
+		t.Errorf("row group num_rows = %v, want %d", rowGroup[3], rowCount)
+	}
+}
+
+// Walk the pages of a column chunk, checking their headers and sizes against the chunk
+// metadata and their values against the rows written
+func checkParquetColumnChunk(t *testing.T, file []byte, chunk thriftFields, index int, rows []parquetTestRow) {
+	t.Helper()
+	column := parquetTestSchema[index]
+	meta := chunk[3].(thriftFields)
+
+	if path := meta[3].([]interface{}); len(path) != 1 || path[0] != column.name {
+		t.Errorf("column %d path = %v, want [%s]", index, path, column.name)
+	}
+	if meta[1] != column.physical || meta[4] != int64(parquetGzip) || meta[5] != int64(len(rows)) {
+		t.Errorf("column %s metadata = %v", column.name, meta)
+	}
+	if meta[9] != chunk[2] {
+		t.Errorf("column %s data_page_offset %v differs from file_offset %v", column.name, meta[9], chunk[2])
+	}
+
+	pos := int(meta[9].(int64))
+	end := pos + int(meta[7].(int64))
+	var uncompressedTotal int64
+	var values []interface{}
+	for pages := 0; pos < end; pages++ {
+		reader := &thriftReader{data: file[pos:]}
+		header := reader.readStruct()
+		pos += reader.pos
+
+		dataHeader := header[5].(thriftFields)
+		if header[1] != int64(parquetDataPage) || dataHeader[2] != int64(parquetPlain) ||
+			dataHeader[3] != int64(parquetRLE) || dataHeader[4] != int64(parquetRLE) {
+			t.Fatalf("column %s page %d header = %v", column.name, pages, header)
+		}
+		rowsInPage := int(dataHeader[1].(int64))
+		if wantRows := min(parquetPageRows, len(rows)-pages*parquetPageRows); rowsInPage != wantRows {
+			t.Errorf("column %s page %d has %d values, want %d", column.name, pages, rowsInPage, wantRows)
+		}
+
+		compressedSize := int(header[3].(int64))
+		gz, err := gzip.NewReader(bytes.NewReader(file[pos : pos+compressedSize]))
+		if err != nil {
+			t.Fatalf("column %s page %d is not gzip: %v", column.name, pages, err)
+		}
+		page, err := io.ReadAll(gz)
+		if err != nil {
+			t.Fatalf("column %s page %d does not decompress: %v", column.name, pages, err)
+		}
+		if int64(len(page)) != header[2] {
+			t.Errorf("column %s page %d is %d bytes, header says %v", column.name, pages, len(page), header[2])
+		}
+		pos += compressedSize
+		uncompressedTotal += int64(reader.pos + len(page))
+
+		values = append(values, decodeParquetTestPage(t, column.name, column.physical, column.optional, page, rowsInPage)...)
+	}
+	if pos != end {
+		t.Errorf("column %s pages end at %d, total_compressed_size says %d", column.name, pos, end)
+	}
+	if meta[6] != uncompressedTotal {
+		t.Errorf("column %s total_uncompressed_size = %v, pages add up to %d", column.name, meta[6], uncompressedTotal)
+	}
+
+	for i, row := range rows {
+		want := parquetTestValue(reflect.ValueOf(row).FieldByName(reflect.TypeOf(row).Field(index).Name))
+		if !reflect.DeepEqual(values[i], want) {
+			t.Fatalf("column %s row %d = %v, want %v", column.name, i, values[i], want)
+		}
+	}
+}
+
+// Decode the definition levels and PLAIN values of a page; nulls are nil
+func decodeParquetTestPage(t *testing.T, name string, physical int64, optional bool, page []byte, count int) []interface{} {
+	t.Helper()
+	present := make([]bool, count)
+	for i := range present {
+		present[i] = true
+	}
+	if optional {
+		length := int(binary.LittleEndian.Uint32(page))
+		levels := page[4 : 4+length]
+		page = page[4+length:]
+
+		runHeader, n := binary.Uvarint(levels)
+		if runHeader&1 != 1 || int(runHeader>>1) != (count+7)/8 {
+			t.Fatalf("column %s definition levels header = %d, want one bit-packed run of %d groups", name, runHeader, (count+7)/8)
+		}
+		for i := range present {
+			present[i] = levels[n+i/8]&(1<<(i%8)) != 0
+		}
+	}
+
+	values := make([]interface{}, count)
+	reader := bytes.NewReader(page)
+	bit := 0
+	for i := range values {
+		if !present[i] {
+			continue
+		}
+		switch physical {
+		case parquetByteArray:
+			var length uint32
+			binary.Read(reader, binary.LittleEndian, &length)
+			value := make([]byte, length)
+			io.ReadFull(reader, value)
+			values[i] = string(value)
+		case parquetDouble:
+			var bits uint64
+			binary.Read(reader, binary.LittleEndian, &bits)
+			values[i] = math.Float64frombits(bits)
+		case parquetInt64:
+			var value int64
+			binary.Read(reader, binary.LittleEndian, &value)
+			values[i] = value
+		case parquetBoolean:
+			values[i] = page[bit/8]&(1<<(bit%8)) != 0
+			bit++
+		}
+	}
+	if physical == parquetBoolean {
+		if len(page) != (bit+7)/8 {
+			t.Errorf("column %s has %d bytes of booleans, want %d", name, len(page), (bit+7)/8)
+		}
+	} else if reader.Len() != 0 {
+		t.Errorf("column %s page has %d bytes left over", name, reader.Len())
+	}
+	return values
+}
+
+// Value a row field is expected to decode to: times as UTC milliseconds, nil for null pointers
+func parquetTestValue(value reflect.Value) interface{} {
+	if value.Kind() == reflect.Ptr {
+		if value.IsNil() {
+			return nil
+		}
+		value = value.Elem()
+	}
+	if ts, ok := value.Interface().(time.Time); ok {
+		return ts.UnixMilli()
+	}
+	if value.Kind() == reflect.Int64 {
+		return value.Int()
+	}
+	return value.Interface()
+}
+
+func TestWriteParquetErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		rows interface{}
+	}{
+		{"not a slice", parquetTestRow{}},
+		{"slice of pointers", []*parquetTestRow{}},
+		{"unsupported column type", []struct {
+			Count int `parquet:"count"`
+		}{}},
+	}
+
+	for _, tt := range tests {
+		if _, err := writeParquet(tt.rows); err == nil {
+			t.Errorf("writeParquet(%s) returned no error", tt.name)
+		}
+	}
+}
+
+func TestWriteParquetLakeRecords(t *testing.T) {
+	row, ok := lakeRow("test", VerificationRecord{ID: "abc", Timestamp: "2025-06-18T08:01:13.680325", ProductCategory: "REF"})
+	if !ok {
+		t.Fatal("lakeRow rejected a stored timestamp")
+	}
+	file, err := writeParquet([]lakeRecord{row})
+	if err != nil {
+		t.Fatalf("writeParquet returned error: %v", err)
+	}
+
+	footerLength := int(binary.LittleEndian.Uint32(file[len(file)-8:]))
+	meta := (&thriftReader{data: file[len(file)-8-footerLength : len(file)-8]}).readStruct()
+	schema := meta[2].([]interface{})
+	if got, want := len(schema)-1, reflect.TypeOf(lakeRecord{}).NumField(); got != want {
+		t.Errorf("lake schema has %d columns, want %d", got, want)
+	}
+}
@@ -0,0 +1,443 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// Results table indexes, each sorted by timestamp
+const (
+	productIndex  = "product-index"  // Partitioned by productId
+	categoryIndex = "category-index" // Partitioned by productCategory
+	dayIndex      = "day-index"      // Partitioned by day (YYYY-MM-DD), for unfiltered listing
+)
+
+const (
+	// Result timestamps are written by the validate function as naive UTC, e.g. "2025-06-18T08:01:13.680325"
+	resultTimestampLayout = "2006-01-02T15:04:05"
+	dayLayout             = "2006-01-02"
+
+	defaultPageSize  = 20
+	maxPageSize      = 100
+	maxExportRecords = 100000
+
+	// Without dateFrom, an unfiltered listing walks back at most this many daily buckets
+	listLookbackDays = 366
+	// Partitions one page may read without finding a record before it returns a cursor,
+	// so a sparse or unmatched range is walked across several requests
+	maxEmptyPartitions = 31
+)
+
+// requestError is returned for invalid client input and mapped to a 4xx response
+type requestError struct {
+	StatusCode int
+	Code       string
+	Message    string
+}
+
+func (e *requestError) Error() string {
+	return e.Message
+}
+
+func newBadRequest(code, format string, args ...interface{}) error {
+	return &requestError{StatusCode: 400, Code: code, Message: fmt.Sprintf(format, args...)}
+}
+
+// Filters of a history listing. From and To are inclusive bounds in the stored timestamp format.
+// Filter holds the 'q' terms that are not served by the index key.
+type historyQuery struct {
+	ProductID string
+	Category  string
+	From      string
+	To        string
+	Filter    []filterNode
+}
+
+// Index and partitions a query reads, newest partition first
+type queryPlan struct {
+	Index         string
+	PartitionAttr string
+	Partitions    []string
+	Filter        string // Applied after the key condition, e.g. category when reading by product
+}
+
+// Position after the last record of a page. Encoded as opaque base64 for clients.
+type listCursor struct {
+	Partition string            `json:"p"`
+	Key       map[string]string `json:"k,omitempty"` // Start of the partition when empty
+	Query     string            `json:"q"`           // Fingerprint of the filters the cursor belongs to
+}
+
+// Parse list filters from query parameters
+func parseHistoryQuery(queryParams map[string]string) (historyQuery, error) {
+	query := historyQuery{
+		ProductID: queryParams["productId"],
+		Category:  queryParams["category"],
+	}
+
+	if value := queryParams["dateFrom"]; value != "" {
+		from, err := parseDateBound("dateFrom", value, false)
+		if err != nil {
+			return query, err
+		}
+		query.From = from
+	}
+	if value := queryParams["dateTo"]; value != "" {
+		to, err := parseDateBound("dateTo", value, true)
+		if err != nil {
+			return query, err
+		}
+		query.To = to
+	}
+	if query.From != "" && query.To != "" && query.From > query.To {
+		return query, newBadRequest("INVALID_DATE_RANGE", "'dateFrom' must not be after 'dateTo'")
+	}
+
+	filter, err := parseFilterParams(queryParams)
+	if err != nil {
+		return query, err
+	}
+
+	// Top-level product, category and time terms narrow the index read instead of filtering it
+	for _, term := range conjuncts(filter) {
+		predicate, ok := term.(*predicateNode)
+		if !ok || predicate.Operator == "!=" || predicate.Prefix {
+			query.Filter = append(query.Filter, term)
+			continue
+		}
+
+		switch {
+		case predicate.Field.Name == "productId" && predicate.Operator == "=" && query.ProductID == "":
+			query.ProductID = predicate.Value
+		case predicate.Field.Name == "category" && predicate.Operator == "=" && query.Category == "":
+			query.Category = predicate.Value
+		case predicate.Field.Kind == fieldTimestamp:
+			lower, upper := "", ""
+			switch predicate.Operator {
+			case "=":
+				lower, upper = predicate.Value, predicate.Upper
+			case ">=":
+				lower = predicate.Value
+			case ">":
+				lower = predicate.Upper
+			case "<=":
+				upper = predicate.Upper
+			case "<":
+				upper = predicate.Value
+			}
+			if predicate.Operator == "<" || predicate.Operator == ">" {
+				// Key bounds are inclusive, so strict bounds are also checked in memory
+				query.Filter = append(query.Filter, term)
+			}
+			if lower > query.From {
+				query.From = lower
+			}
+			if upper != "" && (query.To == "" || upper < query.To) {
+				query.To = upper
+			}
+		default:
+			query.Filter = append(query.Filter, term)
+		}
+	}
+
+	return query, nil
+}
+
+// Convert an RFC 3339 time or a YYYY-MM-DD date to a bound comparable with stored timestamps.
+// Upper bounds cover the whole second, or the whole day for a date.
+func parseDateBound(param, value string, upper bool) (string, error) {
+	if day, err := time.Parse(dayLayout, value); err == nil {
+		if upper {
+			return day.Format(dayLayout) + "T23:59:59.999999", nil
+		}
+		return day.Format(resultTimestampLayout), nil
+	}
+
+	parsed, err := time.Parse(time.RFC3339Nano, value)
+	if err != nil {
+		return "", newBadRequest("INVALID_DATE_RANGE", "Invalid '%s' parameter: %s. Use RFC 3339 (2025-06-01T00:00:00Z) or a date (2025-06-01)", param, value)
+	}
+	bound := parsed.UTC().Format(resultTimestampLayout)
+	if upper {
+		bound += ".999999"
+	}
+	return bound, nil
+}
+
+// Parse a stored result timestamp. Results hold naive UTC with optional microseconds; RFC 3339
+// times, such as those of export jobs, are accepted as well.
+func parseResultTimestamp(value string) (time.Time, error) {
+	if ts, err := time.Parse(resultTimestampLayout+".999999999", value); err == nil {
+		return ts, nil
+	}
+	ts, err := time.Parse(time.RFC3339Nano, value)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return ts.UTC(), nil
+}
+
+// Pick the index for a query: product, then category, then daily buckets
+func planHistoryQuery(query historyQuery) queryPlan {
+	if query.From != "" && query.To != "" && query.From > query.To {
+		// Contradictory time terms in 'q'; nothing to read
+		return queryPlan{Index: dayIndex, PartitionAttr: "day"}
+	}
+
+	switch {
+	case query.ProductID != "":
+		plan := queryPlan{Index: productIndex, PartitionAttr: "productId", Partitions: []string{query.ProductID}}
+		if query.Category != "" {
+			plan.Filter = "productCategory = :category"
+		}
+		return plan
+	case query.Category != "":
+		return queryPlan{Index: categoryIndex, PartitionAttr: "productCategory", Partitions: []string{query.Category}}
+	}
+
+	latest := time.Now().UTC()
+	if query.To != "" {
+		latest, _ = time.Parse(dayLayout, query.To[:len(dayLayout)])
+	}
+	earliest := latest.AddDate(0, 0, -(listLookbackDays - 1))
+	if query.From != "" {
+		earliest, _ = time.Parse(dayLayout, query.From[:len(dayLayout)])
+	}
+
+	plan := queryPlan{Index: dayIndex, PartitionAttr: "day"}
+	for day := latest; !day.Before(earliest); day = day.AddDate(0, 0, -1) {
+		plan.Partitions = append(plan.Partitions, day.Format(dayLayout))
+	}
+	return plan
+}
+
+// Identifies the filters a cursor was issued for, so it cannot be replayed against another listing
+func (q historyQuery) fingerprint() string {
+	parts := []string{q.ProductID, q.Category, q.From, q.To}
+	for _, term := range q.Filter {
+		parts = append(parts, term.String())
+	}
+	sum := sha256.Sum256([]byte(strings.Join(parts, "\x00")))
+	return hex.EncodeToString(sum[:8])
+}
+
+// Read up to pageSize records, newest first, starting at the cursor. Returns the cursor of
+// the next page, empty when there are no more records. Reading stops early at the Lambda
+// deadline or after maxEmptyPartitions partitions without a record; the page is then short and
+// the cursor continues where it stopped.
+func queryHistoryPage(ctx context.Context, requestID string, query historyQuery, pageSize int, cursor string) ([]VerificationRecord, string, error) {
+	plan := planHistoryQuery(query)
+
+	partition := 0
+	var startKey map[string]types.AttributeValue
+	if cursor != "" {
+		decoded, err := decodeListCursor(cursor, query)
+		if err != nil {
+			return nil, "", err
+		}
+		partition = -1
+		for i, value := range plan.Partitions {
+			if value == decoded.Partition {
+				partition = i
+				break
+			}
+		}
+		if partition < 0 {
+			return nil, "", newBadRequest("INVALID_CURSOR", "The 'cursor' parameter does not belong to this query")
+		}
+		startKey = decoded.startKey()
+	}
+
+	log.Printf("RequestID: %s - Querying %s from partition %d of %d", requestID, plan.Index, partition, len(plan.Partitions))
+
+	// Index keys cannot appear in a filter expression, so terms on them are checked in memory
+	builder := newExpressionBuilder()
+	pushed, residual := splitFilter(query.Filter, map[string]bool{plan.PartitionAttr: true, "timestamp": true}, builder)
+	if len(query.Filter) > 0 {
+		log.Printf("RequestID: %s - Filter pushed to DynamoDB: %q, %d terms checked in memory", requestID, pushed, len(residual))
+	}
+
+	workCtx, cancel := withDeadlineMargin(ctx)
+	defer cancel()
+
+	records := []VerificationRecord{}
+	queries := 0
+	emptyPartitions := 0
+	stopped := false
+
+	for partition < len(plan.Partitions) && !stopped {
+		input := buildPartitionQuery(plan, plan.Partitions[partition], query, pushed, builder)
+		found := len(records)
+
+		for {
+			// The limit counts evaluated items, so a page never reads past the records it returns
+			input.Limit = aws.Int32(int32(pageSize - len(records)))
+			input.ExclusiveStartKey = startKey
+
+			output, err := dynamoClient.Query(workCtx, input)
+			if err != nil && stoppedAtDeadline(ctx, workCtx, err) {
+				stopped = true
+				break
+			}
+			if err != nil {
+				return nil, "", fmt.Errorf("DynamoDB query on %s failed: %w", plan.Index, err)
+			}
+			queries++
+
+			for _, item := range output.Items {
+				var record VerificationRecord
+				if err := attributevalue.UnmarshalMap(item, &record); err != nil {
+					log.Printf("RequestID: %s - Warning: Failed to unmarshal record: %v", requestID, err)
+					continue
+				}
+				if len(residual) > 0 && !matchesAll(residual, &recordFields{RequestID: requestID, Record: record}) {
+					continue
+				}
+				records = append(records, record)
+			}
+
+			startKey = output.LastEvaluatedKey
+			if startKey == nil || len(records) >= pageSize {
+				break
+			}
+		}
+
+		if startKey == nil && !stopped {
+			partition++
+		}
+		if len(records) >= pageSize {
+			break
+		}
+		if len(records) == found && startKey == nil {
+			if emptyPartitions++; emptyPartitions >= maxEmptyPartitions {
+				break
+			}
+		}
+	}
+
+	log.Printf("RequestID: %s - %s returned %d records in %d queries, %d empty partitions, stopped at deadline: %t",
+		requestID, plan.Index, len(records), queries, emptyPartitions, stopped)
+
+	if partition >= len(plan.Partitions) {
+		return records, "", nil
+	}
+
+	next, err := encodeListCursor(plan.Partitions[partition], startKey, query)
+	if err != nil {
+		return nil, "", err
+	}
+	return records, next, nil
+}
+
+// Newest-first query of one index partition, bounded by the date filters and
+// filtered by the plan's filter and the pushed-down 'q' terms
+func buildPartitionQuery(plan queryPlan, partition string, query historyQuery, pushed string, builder *expressionBuilder) *dynamodb.QueryInput {
+	condition := "#pk = :partition"
+	names := map[string]string{"#pk": plan.PartitionAttr}
+	values := map[string]types.AttributeValue{
+		":partition": &types.AttributeValueMemberS{Value: partition},
+	}
+
+	switch {
+	case query.From != "" && query.To != "":
+		condition += " AND #ts BETWEEN :dateFrom AND :dateTo"
+	case query.From != "":
+		condition += " AND #ts >= :dateFrom"
+	case query.To != "":
+		condition += " AND #ts <= :dateTo"
+	}
+	if query.From != "" {
+		names["#ts"] = "timestamp"
+		values[":dateFrom"] = &types.AttributeValueMemberS{Value: query.From}
+	}
+	if query.To != "" {
+		names["#ts"] = "timestamp"
+		values[":dateTo"] = &types.AttributeValueMemberS{Value: query.To}
+	}
+
+	input := &dynamodb.QueryInput{
+		TableName:                 aws.String(appConfig.ResultTable),
+		IndexName:                 aws.String(plan.Index),
+		KeyConditionExpression:    aws.String(condition),
+		ExpressionAttributeNames:  names,
+		ExpressionAttributeValues: values,
+		ScanIndexForward:          aws.Bool(false),
+	}
+	var filters []string
+	if plan.Filter != "" {
+		filters = append(filters, plan.Filter)
+		values[":category"] = &types.AttributeValueMemberS{Value: query.Category}
+	}
+	if pushed != "" {
+		filters = append(filters, pushed)
+		for name, value := range builder.Names {
+			names[name] = value
+		}
+		for name, value := range builder.Values {
+			values[name] = value
+		}
+	}
+	if len(filters) > 0 {
+		input.FilterExpression = aws.String(strings.Join(filters, " AND "))
+	}
+	return input
+}
+
+func encodeListCursor(partition string, startKey map[string]types.AttributeValue, query historyQuery) (string, error) {
+	cursor := listCursor{Partition: partition, Query: query.fingerprint()}
+	if len(startKey) > 0 {
+		cursor.Key = make(map[string]string, len(startKey))
+		for name, value := range startKey {
+			s, ok := value.(*types.AttributeValueMemberS)
+			if !ok {
+				return "", fmt.Errorf("unexpected non-string key attribute %s", name)
+			}
+			cursor.Key[name] = s.Value
+		}
+	}
+
+	data, err := json.Marshal(cursor)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(data), nil
+}
+
+func decodeListCursor(encoded string, query historyQuery) (*listCursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, newBadRequest("INVALID_CURSOR", "The 'cursor' parameter is not a valid cursor")
+	}
+
+	var cursor listCursor
+	if err := json.Unmarshal(data, &cursor); err != nil || cursor.Partition == "" {
+		return nil, newBadRequest("INVALID_CURSOR", "The 'cursor' parameter is not a valid cursor")
+	}
+	if cursor.Query != query.fingerprint() {
+		return nil, newBadRequest("INVALID_CURSOR", "The 'cursor' parameter does not belong to this query")
+	}
+	return &cursor, nil
+}
+
+func (c *listCursor) startKey() map[string]types.AttributeValue {
+	if len(c.Key) == 0 {
+		return nil
+	}
+	key := make(map[string]types.AttributeValue, len(c.Key))
+	for name, value := range c.Key {
+		key[name] = &types.AttributeValueMemberS{Value: value}
+	}
+	return key
+}
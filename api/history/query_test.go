@@ -0,0 +1,177 @@
+package main
+
+import (
+	"encoding/base64"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+func mustParseHistoryQuery(t *testing.T, queryParams map[string]string) historyQuery {
+	t.Helper()
+	query, err := parseHistoryQuery(queryParams)
+	if err != nil {
+		t.Fatalf("parseHistoryQuery(%v) returned error: %v", queryParams, err)
+	}
+	return query
+}
+
+func assertInvalidCursor(t *testing.T, err error, message string) {
+	t.Helper()
+	var reqErr *requestError
+	if !errors.As(err, &reqErr) || reqErr.StatusCode != 400 || reqErr.Code != "INVALID_CURSOR" {
+		t.Fatalf("error = %v, want a 400 INVALID_CURSOR", err)
+	}
+	if !strings.Contains(reqErr.Message, message) {
+		t.Fatalf("error = %q, want it to contain %q", reqErr.Message, message)
+	}
+}
+
+func TestListCursorRoundTrip(t *testing.T) {
+	tests := []struct {
+		name      string
+		params    map[string]string
+		partition string
+		startKey  map[string]types.AttributeValue
+	}{
+		{
+			name:      "start of a day partition",
+			params:    map[string]string{},
+			partition: "2025-06-18",
+		},
+		{
+			name:      "inside a day partition",
+			params:    map[string]string{"dateFrom": "2025-06-01", "q": "result:INCORRECT"},
+			partition: "2025-06-18",
+			startKey: map[string]types.AttributeValue{
+				"id":        &types.AttributeValueMemberS{Value: "5f0c7a52-0d0e-4d7c-9d55-0c1f3c6a3e11"},
+				"day":       &types.AttributeValueMemberS{Value: "2025-06-18"},
+				"timestamp": &types.AttributeValueMemberS{Value: "2025-06-18T08:01:13.680325"},
+			},
+		},
+		{
+			name:      "product partition with a non-ASCII ID",
+			params:    map[string]string{"productId": "MÁY-GIẶT/01", "category": "WM"},
+			partition: "MÁY-GIẶT/01",
+			startKey: map[string]types.AttributeValue{
+				"id":        &types.AttributeValueMemberS{Value: "abc"},
+				"productId": &types.AttributeValueMemberS{Value: "MÁY-GIẶT/01"},
+				"timestamp": &types.AttributeValueMemberS{Value: "2025-06-18T08:01:13"},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			query := mustParseHistoryQuery(t, tt.params)
+
+			encoded, err := encodeListCursor(tt.partition, tt.startKey, query)
+			if err != nil {
+				t.Fatalf("encodeListCursor returned error: %v", err)
+			}
+			// Cursors travel in query strings, so they must need no escaping
+			if strings.ContainsAny(encoded, "+/=") {
+				t.Errorf("cursor %q is not URL safe", encoded)
+			}
+
+			decoded, err := decodeListCursor(encoded, mustParseHistoryQuery(t, tt.params))
+			if err != nil {
+				t.Fatalf("decodeListCursor returned error: %v", err)
+			}
+			if decoded.Partition != tt.partition {
+				t.Errorf("partition = %q, want %q", decoded.Partition, tt.partition)
+			}
+			if got := decoded.startKey(); !reflect.DeepEqual(got, tt.startKey) {
+				t.Errorf("start key = %v, want %v", got, tt.startKey)
+			}
+		})
+	}
+}
+
+func TestEncodeListCursorRejectsNonStringKeys(t *testing.T) {
+	startKey := map[string]types.AttributeValue{
+		"id":        &types.AttributeValueMemberS{Value: "abc"},
+		"timestamp": &types.AttributeValueMemberN{Value: "1718697673"},
+	}
+	if _, err := encodeListCursor("2025-06-18", startKey, historyQuery{}); err == nil {
+		t.Fatal("encodeListCursor accepted a number key attribute")
+	}
+}
+
+func TestDecodeListCursorErrors(t *testing.T) {
+	issued := map[string]string{"category": "REF", "dateFrom": "2025-06-01", "q": "result:INCORRECT"}
+	cursor, err := encodeListCursor("REF", nil, mustParseHistoryQuery(t, issued))
+	if err != nil {
+		t.Fatalf("encodeListCursor returned error: %v", err)
+	}
+
+	encode := func(data string) string {
+		return base64.RawURLEncoding.EncodeToString([]byte(data))
+	}
+
+	tests := []struct {
+		name    string
+		cursor  string
+		params  map[string]string
+		message string
+	}{
+		{"not base64", "not a cursor!", issued, "is not a valid cursor"},
+		{"padded base64", base64.URLEncoding.EncodeToString([]byte(`{"p":"REF"}`)), issued, "is not a valid cursor"},
+		{"not JSON", encode("REF"), issued, "is not a valid cursor"},
+		{"no partition", encode(`{"q":"0000000000000000"}`), issued, "is not a valid cursor"},
+		{"other category", cursor, map[string]string{"category": "WM", "dateFrom": "2025-06-01", "q": "result:INCORRECT"}, "does not belong to this query"},
+		{"other date range", cursor, map[string]string{"category": "REF", "dateFrom": "2025-06-02", "q": "result:INCORRECT"}, "does not belong to this query"},
+		{"other filter", cursor, map[string]string{"category": "REF", "dateFrom": "2025-06-01", "q": "result:CORRECT"}, "does not belong to this query"},
+		{"filter dropped", cursor, map[string]string{"category": "REF", "dateFrom": "2025-06-01"}, "does not belong to this query"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := decodeListCursor(tt.cursor, mustParseHistoryQuery(t, tt.params))
+			assertInvalidCursor(t, err, tt.message)
+		})
+	}
+}
+
+func TestHistoryQueryFingerprint(t *testing.T) {
+	// The same filters, however they are written, continue the same listing
+	same := []map[string]string{
+		{"category": "REF", "result": "incorrect", "dateFrom": "2025-06-01"},
+		{"q": "category:REF AND result:INCORRECT AND timestamp>=2025-06-01"},
+		{"q": "Category = REF and Result = Incorrect", "dateFrom": "2025-06-01T00:00:00Z"},
+	}
+
+	want := mustParseHistoryQuery(t, same[0]).fingerprint()
+	for _, params := range same[1:] {
+		if got := mustParseHistoryQuery(t, params).fingerprint(); got != want {
+			t.Errorf("fingerprint of %v = %s, want %s", params, got, want)
+		}
+	}
+}
+
+func TestPlanHistoryQuery(t *testing.T) {
+	tests := []struct {
+		params     map[string]string
+		index      string
+		partitions []string
+		filter     string
+	}{
+		{map[string]string{"productId": "P1"}, productIndex, []string{"P1"}, ""},
+		{map[string]string{"q": "productId:P1 AND category:REF"}, productIndex, []string{"P1"}, "productCategory = :category"},
+		{map[string]string{"category": "REF", "dateFrom": "2025-06-01"}, categoryIndex, []string{"REF"}, ""},
+		{map[string]string{"dateFrom": "2025-06-17", "dateTo": "2025-06-19"}, dayIndex, []string{"2025-06-19", "2025-06-18", "2025-06-17"}, ""},
+		{map[string]string{"q": "timestamp:2025-06-18"}, dayIndex, []string{"2025-06-18"}, ""},
+		// Contradictory time terms read nothing
+		{map[string]string{"q": "timestamp>=2025-06-19 AND timestamp<=2025-06-18"}, dayIndex, nil, ""},
+	}
+
+	for _, tt := range tests {
+		plan := planHistoryQuery(mustParseHistoryQuery(t, tt.params))
+		if plan.Index != tt.index || !reflect.DeepEqual(plan.Partitions, tt.partitions) || plan.Filter != tt.filter {
+			t.Errorf("planHistoryQuery(%v) = %s %v %q, want %s %v %q", tt.params, plan.Index, plan.Partitions, plan.Filter, tt.index, tt.partitions, tt.filter)
+		}
+	}
+}
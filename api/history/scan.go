@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+)
+
+const (
+	// Segments read concurrently by a full-table scan
+	scanSegments = 8
+	// Time kept back from the Lambda deadline to build and send the response
+	deadlineMargin = 5 * time.Second
+)
+
+// Outcome of a full-table scan. Complete is false when the deadline stopped some segment early.
+type scanResult struct {
+	Records      []VerificationRecord
+	Complete     bool
+	ScannedItems int
+}
+
+// Context that expires deadlineMargin before the Lambda deadline, if ctx has one
+func withDeadlineMargin(ctx context.Context) (context.Context, context.CancelFunc) {
+	if deadline, ok := ctx.Deadline(); ok {
+		return context.WithDeadline(ctx, deadline.Add(-deadlineMargin))
+	}
+	return context.WithCancel(ctx)
+}
+
+// Whether err only means the work context ran out of time while the request itself is still live
+func stoppedAtDeadline(ctx, workCtx context.Context, err error) bool {
+	return ctx.Err() == nil && workCtx.Err() != nil &&
+		(errors.Is(err, context.DeadlineExceeded) || errors.Is(err, context.Canceled))
+}
+
+// The part of the DynamoDB client a full-table scan uses
+type tableScanner interface {
+	Scan(ctx context.Context, params *dynamodb.ScanInput, optFns ...func(*dynamodb.Options)) (*dynamodb.ScanOutput, error)
+}
+
+// Scan the whole table with parallel segments, following LastEvaluatedKey in each.
+// Segments stop at the deadline and the records read so far are returned as incomplete.
+func parallelScan(ctx context.Context, requestID string, client tableScanner, input dynamodb.ScanInput) (*scanResult, error) {
+	workCtx, cancel := withDeadlineMargin(ctx)
+	defer cancel()
+
+	var (
+		mutex    sync.Mutex
+		wg       sync.WaitGroup
+		firstErr error
+	)
+	result := &scanResult{Records: []VerificationRecord{}, Complete: true}
+	started := time.Now()
+
+	for segment := 0; segment < scanSegments; segment++ {
+		wg.Add(1)
+		go func(segment int) {
+			defer wg.Done()
+
+			segmentInput := input
+			segmentInput.Segment = aws.Int32(int32(segment))
+			segmentInput.TotalSegments = aws.Int32(scanSegments)
+
+			for {
+				output, err := client.Scan(workCtx, &segmentInput)
+				if err != nil {
+					mutex.Lock()
+					if stoppedAtDeadline(ctx, workCtx, err) {
+						result.Complete = false
+					} else if firstErr == nil {
+						firstErr = fmt.Errorf("scan of segment %d failed: %w", segment, err)
+						cancel()
+					}
+					mutex.Unlock()
+					return
+				}
+
+				records := make([]VerificationRecord, 0, len(output.Items))
+				for _, item := range output.Items {
+					var record VerificationRecord
+					if err := attributevalue.UnmarshalMap(item, &record); err != nil {
+						log.Printf("RequestID: %s - Warning: Failed to unmarshal record: %v", requestID, err)
+						continue
+					}
+					records = append(records, record)
+				}
+
+				mutex.Lock()
+				result.Records = append(result.Records, records...)
+				result.ScannedItems += int(output.ScannedCount)
+				mutex.Unlock()
+
+				if output.LastEvaluatedKey == nil {
+					return
+				}
+				segmentInput.ExclusiveStartKey = output.LastEvaluatedKey
+			}
+		}(segment)
+	}
+	wg.Wait()
+
+	if firstErr != nil {
+		return nil, firstErr
+	}
+
+	log.Printf("RequestID: %s - Scanned %d items in %d segments in %v, %d matched, complete: %t",
+		requestID, result.ScannedItems, scanSegments, time.Since(started).Round(time.Millisecond), len(result.Records), result.Complete)
+	return result, nil
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+func TestStoppedAtDeadline(t *testing.T) {
+	live := context.Background()
+	expired, cancel := context.WithDeadline(live, time.Now().Add(-time.Second))
+	defer cancel()
+	cancelled, cancelWork := context.WithCancel(live)
+	cancelWork()
+
+	tests := []struct {
+		name    string
+		ctx     context.Context
+		workCtx context.Context
+		err     error
+		want    bool
+	}{
+		{"work context expired", live, expired, fmt.Errorf("operation error DynamoDB: Scan: %w", context.DeadlineExceeded), true},
+		{"work context cancelled", live, cancelled, context.Canceled, true},
+		{"request expired too", expired, expired, context.DeadlineExceeded, false},
+		{"work context still live", live, live, context.DeadlineExceeded, false},
+		{"other error after the deadline", live, expired, errors.New("throttled"), false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := stoppedAtDeadline(tt.ctx, tt.workCtx, tt.err); got != tt.want {
+				t.Errorf("stoppedAtDeadline() = %t, want %t", got, tt.want)
+			}
+		})
+	}
+}
+
+// Scanner that returns one record per segment, and for the segments in stall a second
+// page whose successor never arrives
+type stubScanner struct {
+	stall map[int32]bool
+	err   error
+}
+
+func (s stubScanner) Scan(ctx context.Context, params *dynamodb.ScanInput, optFns ...func(*dynamodb.Options)) (*dynamodb.ScanOutput, error) {
+	segment := aws.ToInt32(params.Segment)
+	if s.err != nil && segment == 0 {
+		return nil, s.err
+	}
+	if params.ExclusiveStartKey != nil {
+		<-ctx.Done()
+		return nil, fmt.Errorf("operation error DynamoDB: Scan: %w", ctx.Err())
+	}
+
+	item, err := attributevalue.MarshalMap(VerificationRecord{ID: fmt.Sprintf("segment-%d", segment)})
+	if err != nil {
+		return nil, err
+	}
+	output := &dynamodb.ScanOutput{Items: []map[string]types.AttributeValue{item}, ScannedCount: 1}
+	if s.stall[segment] {
+		output.LastEvaluatedKey = item
+	}
+	return output, nil
+}
+
+func TestParallelScan(t *testing.T) {
+	result, err := parallelScan(context.Background(), "test", stubScanner{}, dynamodb.ScanInput{})
+	if err != nil {
+		t.Fatalf("parallelScan() returned error: %v", err)
+	}
+	if !result.Complete || len(result.Records) != scanSegments || result.ScannedItems != scanSegments {
+		t.Errorf("parallelScan() = complete %t, %d records, %d scanned; want complete with one record per segment",
+			result.Complete, len(result.Records), result.ScannedItems)
+	}
+}
+
+func TestParallelScanDeadline(t *testing.T) {
+	// The work context runs out shortly, well before the request's own deadline
+	ctx, cancel := context.WithTimeout(context.Background(), deadlineMargin+200*time.Millisecond)
+	defer cancel()
+
+	result, err := parallelScan(ctx, "test", stubScanner{stall: map[int32]bool{2: true, 5: true}}, dynamodb.ScanInput{})
+	if err != nil {
+		t.Fatalf("parallelScan() returned error %v, want an incomplete result", err)
+	}
+	if result.Complete {
+		t.Errorf("parallelScan() is complete, want incomplete")
+	}
+	if len(result.Records) != scanSegments {
+		t.Errorf("parallelScan() returned %d records, want the %d read before the deadline", len(result.Records), scanSegments)
+	}
+}
+
+func TestParallelScanError(t *testing.T) {
+	// A failed segment fails the scan, although the segment it cancels looks stopped at the deadline
+	scanner := stubScanner{stall: map[int32]bool{1: true}, err: errors.New("throttled")}
+	if _, err := parallelScan(context.Background(), "test", scanner, dynamodb.ScanInput{}); err == nil {
+		t.Errorf("parallelScan() returned no error for a failed segment")
+	}
+}
@@ -0,0 +1,528 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"html"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+const (
+	// Index terms a search may combine
+	maxSearchTerms = 8
+	// Postings read per term in one round of intersection
+	searchPostingsPerRound = 1000
+	// Maximum number of keys in one BatchGetItem call
+	batchGetSize = 100
+	// Words of context kept before the first highlight, and words shown in total
+	snippetLeadingWords = 8
+	snippetWords        = 32
+)
+
+// Parsed 'text' search. Every term must occur in the result; every phrase must occur,
+// word for word, within one explanation.
+type searchQuery struct {
+	Text     string
+	Terms    []string
+	Phrases  [][]textToken
+	Fields   []string
+	Category string
+}
+
+// History item with its highlighted explanation snippets, keyed by field
+type SearchHit struct {
+	HistoryItem
+	Snippets map[string]string `json:"snippets"`
+}
+
+// Position after the last posting a page covered
+type searchCursor struct {
+	After string `json:"a"`
+	Query string `json:"q"`
+}
+
+// Parse a search from query parameters: 'text' with optional "quoted phrases", 'field'
+// (label, overview or all) and 'category'
+func parseSearchQuery(queryParams map[string]string) (searchQuery, error) {
+	query := searchQuery{Text: strings.TrimSpace(queryParams["text"]), Category: queryParams["category"]}
+	if query.Text == "" {
+		return query, newBadRequest("INVALID_SEARCH", "The 'text' parameter is required for view=search")
+	}
+
+	switch field := queryParams["field"]; field {
+	case "", "all":
+		query.Fields = []string{searchFieldLabel, searchFieldOverview}
+	case searchFieldLabel, searchFieldOverview:
+		query.Fields = []string{field}
+	default:
+		return query, newBadRequest("INVALID_SEARCH", "Invalid 'field' parameter: %s. Valid values: label, overview, all", field)
+	}
+
+	// Text between double quotes is a phrase; an unterminated quote runs to the end
+	terms := map[string]bool{}
+	for i, part := range strings.Split(query.Text, `"`) {
+		tokens := tokenizeText(part)
+		if i%2 == 1 && len(tokens) > 1 {
+			query.Phrases = append(query.Phrases, tokens)
+		}
+		for _, token := range tokens {
+			if token.Term != "" {
+				terms[token.Term] = true
+			}
+		}
+	}
+	for term := range terms {
+		query.Terms = append(query.Terms, term)
+	}
+	sort.Strings(query.Terms)
+
+	if len(query.Terms) == 0 {
+		return query, newBadRequest("INVALID_SEARCH", "The 'text' parameter has no searchable words")
+	}
+	if len(query.Terms) > maxSearchTerms {
+		return query, newBadRequest("INVALID_SEARCH", "The 'text' parameter has %d distinct words, at most %d are allowed", len(query.Terms), maxSearchTerms)
+	}
+	return query, nil
+}
+
+// Identifies the search a cursor was issued for
+func (q searchQuery) fingerprint() string {
+	parts := append([]string{q.Category, strings.Join(q.Fields, ",")}, q.Terms...)
+	for _, phrase := range q.Phrases {
+		words := make([]string, len(phrase))
+		for i, token := range phrase {
+			words[i] = token.Word
+		}
+		parts = append(parts, `"`+strings.Join(words, " ")+`"`)
+	}
+	sum := sha256.Sum256([]byte(strings.Join(parts, "\x00")))
+	return hex.EncodeToString(sum[:8])
+}
+
+// Postings of one term read in a round, newest first. Complete is set when the term has
+// no postings older than the last one read; otherwise Last is the oldest posting read.
+type termPostings struct {
+	Results  map[string]string // Posting key to result ID
+	Last     string
+	Complete bool
+}
+
+// Read up to pageSize matching results, newest first, after the cursor. Each round reads a
+// window of postings per term and intersects them; only postings newer than the oldest one
+// read for an incomplete term are certain to be in every term's window, so the next round
+// continues from there. At the Lambda deadline the page is returned short with a cursor.
+func searchPage(ctx context.Context, requestID string, query searchQuery, pageSize int, cursor string) ([]SearchHit, string, error) {
+	after := ""
+	if cursor != "" {
+		decoded, err := decodeSearchCursor(cursor, query)
+		if err != nil {
+			return nil, "", err
+		}
+		after = decoded.After
+	}
+
+	workCtx, cancel := withDeadlineMargin(ctx)
+	defer cancel()
+
+	hits := []SearchHit{}
+	rounds := 0
+	for {
+		postings, stopped, err := readTermPostings(ctx, workCtx, query, after)
+		if err != nil {
+			return nil, "", err
+		}
+		if stopped {
+			log.Printf("RequestID: %s - Search stopped at deadline after %d rounds with %d hits", requestID, rounds, len(hits))
+			return hits, encodeSearchCursor(after, query), nil
+		}
+		rounds++
+
+		candidates, bound := intersectPostings(postings)
+		log.Printf("RequestID: %s - Search round %d after %q: %d candidates, bound %q", requestID, rounds, after, len(candidates), bound)
+
+		for start := 0; start < len(candidates); {
+			end := start + pageSize - len(hits)
+			if end > len(candidates) {
+				end = len(candidates)
+			}
+			ids := make([]string, 0, end-start)
+			for _, candidate := range candidates[start:end] {
+				ids = append(ids, postings[0].Results[candidate])
+			}
+			records, err := batchGetResults(workCtx, ids)
+			if err != nil && stoppedAtDeadline(ctx, workCtx, err) {
+				return hits, encodeSearchCursor(after, query), nil
+			}
+			if err != nil {
+				return nil, "", err
+			}
+
+			for _, candidate := range candidates[start:end] {
+				record, ok := records[postings[0].Results[candidate]]
+				// A missing result or one that no longer matches is a posting the stream has not caught up with yet
+				if !ok || postingKey(record) != candidate {
+					continue
+				}
+				if hit, ok := matchSearchHit(requestID, query, record); ok {
+					hits = append(hits, hit)
+				}
+			}
+			start = end
+			after = candidates[end-1]
+
+			if len(hits) >= pageSize {
+				if end == len(candidates) && bound == "" {
+					return hits, "", nil
+				}
+				return hits, encodeSearchCursor(after, query), nil
+			}
+		}
+
+		if bound == "" {
+			return hits, "", nil
+		}
+		after = bound
+	}
+}
+
+// Read the next window of postings of every term concurrently. stopped reports that the
+// deadline interrupted a read.
+func readTermPostings(ctx, workCtx context.Context, query searchQuery, after string) ([]termPostings, bool, error) {
+	var (
+		wg       sync.WaitGroup
+		mutex    sync.Mutex
+		firstErr error
+		stopped  bool
+	)
+	postings := make([]termPostings, len(query.Terms))
+
+	for i, term := range query.Terms {
+		wg.Add(1)
+		go func(i int, term string) {
+			defer wg.Done()
+
+			result, err := readPostingWindow(workCtx, query, term, after)
+			mutex.Lock()
+			defer mutex.Unlock()
+			switch {
+			case err != nil && stoppedAtDeadline(ctx, workCtx, err):
+				stopped = true
+			case err != nil && firstErr == nil:
+				firstErr = err
+			default:
+				postings[i] = result
+			}
+		}(i, term)
+	}
+	wg.Wait()
+
+	return postings, stopped, firstErr
+}
+
+func readPostingWindow(ctx context.Context, query searchQuery, term, after string) (termPostings, error) {
+	condition := "#term = :term"
+	names := map[string]string{"#term": "term"}
+	values := map[string]types.AttributeValue{":term": &types.AttributeValueMemberS{Value: term}}
+	if after != "" {
+		condition += " AND #posting < :after"
+		names["#posting"] = "posting"
+		values[":after"] = &types.AttributeValueMemberS{Value: after}
+	}
+
+	var filters []string
+	if len(query.Fields) == 1 {
+		filters = append(filters, "contains(#fields, :field)")
+		names["#fields"] = "fields"
+		values[":field"] = &types.AttributeValueMemberS{Value: query.Fields[0]}
+	}
+	if query.Category != "" {
+		filters = append(filters, "#category = :category")
+		names["#category"] = "productCategory"
+		values[":category"] = &types.AttributeValueMemberS{Value: query.Category}
+	}
+
+	input := &dynamodb.QueryInput{
+		TableName:                 aws.String(appConfig.SearchIndexTable),
+		KeyConditionExpression:    aws.String(condition),
+		ExpressionAttributeNames:  names,
+		ExpressionAttributeValues: values,
+		ProjectionExpression:      aws.String("posting, resultId"),
+		ScanIndexForward:          aws.Bool(false),
+	}
+	if len(filters) > 0 {
+		input.FilterExpression = aws.String(strings.Join(filters, " AND "))
+	}
+
+	result := termPostings{Results: map[string]string{}}
+	read := 0
+	for {
+		// The limit counts evaluated postings, so Last bounds exactly what was read
+		input.Limit = aws.Int32(int32(searchPostingsPerRound - read))
+		output, err := dynamoClient.Query(ctx, input)
+		if err != nil {
+			return result, fmt.Errorf("search index query for term %q failed: %w", term, err)
+		}
+		read += int(output.ScannedCount)
+
+		for _, item := range output.Items {
+			var posting searchPosting
+			if err := attributevalue.UnmarshalMap(item, &posting); err != nil {
+				continue
+			}
+			result.Results[posting.Posting] = posting.ResultID
+		}
+
+		if output.LastEvaluatedKey == nil {
+			result.Complete = true
+			return result, nil
+		}
+		input.ExclusiveStartKey = output.LastEvaluatedKey
+		if read >= searchPostingsPerRound {
+			last, _ := output.LastEvaluatedKey["posting"].(*types.AttributeValueMemberS)
+			if last == nil {
+				return result, fmt.Errorf("search index query for term %q returned no posting key", term)
+			}
+			result.Last = last.Value
+			return result, nil
+		}
+	}
+}
+
+// Postings present for every term, newest first, limited to those no incomplete term may
+// still be missing. bound is where the next round starts, empty when every term is complete.
+func intersectPostings(postings []termPostings) ([]string, string) {
+	bound := ""
+	for _, term := range postings {
+		if !term.Complete && term.Last > bound {
+			bound = term.Last
+		}
+	}
+
+	var candidates []string
+	for posting := range postings[0].Results {
+		if posting < bound {
+			continue
+		}
+		inAll := true
+		for _, term := range postings[1:] {
+			if _, ok := term.Results[posting]; !ok {
+				inAll = false
+				break
+			}
+		}
+		if inAll {
+			candidates = append(candidates, posting)
+		}
+	}
+	sort.Sort(sort.Reverse(sort.StringSlice(candidates)))
+	return candidates, bound
+}
+
+// Fetch results by ID, retrying unprocessed keys
+func batchGetResults(ctx context.Context, ids []string) (map[string]VerificationRecord, error) {
+	records := make(map[string]VerificationRecord, len(ids))
+	for start := 0; start < len(ids); start += batchGetSize {
+		end := start + batchGetSize
+		if end > len(ids) {
+			end = len(ids)
+		}
+
+		keys := make([]map[string]types.AttributeValue, 0, end-start)
+		for _, id := range ids[start:end] {
+			keys = append(keys, map[string]types.AttributeValue{"id": &types.AttributeValueMemberS{Value: id}})
+		}
+		request := map[string]types.KeysAndAttributes{appConfig.ResultTable: {Keys: keys}}
+
+		backoff := 50 * time.Millisecond
+		for len(request) > 0 {
+			output, err := dynamoClient.BatchGetItem(ctx, &dynamodb.BatchGetItemInput{RequestItems: request})
+			if err != nil {
+				return nil, fmt.Errorf("batch get of %d results failed: %w", len(keys), err)
+			}
+			for _, item := range output.Responses[appConfig.ResultTable] {
+				var record VerificationRecord
+				if err := attributevalue.UnmarshalMap(item, &record); err != nil {
+					continue
+				}
+				records[record.ID] = record
+			}
+
+			request = output.UnprocessedKeys
+			if len(request) > 0 {
+				select {
+				case <-ctx.Done():
+					return nil, ctx.Err()
+				case <-time.After(backoff):
+				}
+				backoff *= 2
+			}
+		}
+	}
+	return records, nil
+}
+
+// Check a result against the search and highlight its matching explanations
+func matchSearchHit(requestID string, query searchQuery, record VerificationRecord) (SearchHit, bool) {
+	results, err := parseVerificationResults(requestID, record.BedrockResponse)
+	if err != nil {
+		return SearchHit{}, false
+	}
+
+	texts := map[string]string{
+		searchFieldLabel:    results.LabelExplanation,
+		searchFieldOverview: results.OverviewExplanation,
+	}
+	wanted := make(map[string]bool, len(query.Terms))
+	for _, term := range query.Terms {
+		wanted[term] = true
+	}
+
+	found := map[string]bool{}
+	phrasesFound := make([]bool, len(query.Phrases))
+	snippets := map[string]string{}
+	for _, field := range query.Fields {
+		tokens := tokenizeText(texts[field])
+		highlighted := make([]bool, len(tokens))
+		for i, token := range tokens {
+			if wanted[token.Term] {
+				found[token.Term] = true
+				highlighted[i] = true
+			}
+		}
+		for p, phrase := range query.Phrases {
+			for i := 0; i+len(phrase) <= len(tokens); i++ {
+				if phraseAt(tokens[i:], phrase) {
+					phrasesFound[p] = true
+					for j := range phrase {
+						highlighted[i+j] = true
+					}
+				}
+			}
+		}
+		if snippet := buildSnippet(texts[field], tokens, highlighted); snippet != "" {
+			snippets[field] = snippet
+		}
+	}
+
+	if len(found) < len(wanted) {
+		return SearchHit{}, false
+	}
+	for _, ok := range phrasesFound {
+		if !ok {
+			return SearchHit{}, false
+		}
+	}
+
+	item, err := convertToHistoryItem(requestID, record)
+	if err != nil {
+		return SearchHit{}, false
+	}
+	return SearchHit{HistoryItem: item, Snippets: snippets}, true
+}
+
+// Whether tokens start with the phrase. Stop words are compared by their folded word.
+func phraseAt(tokens []textToken, phrase []textToken) bool {
+	for i, word := range phrase {
+		if word.Term != "" && tokens[i].Term != word.Term {
+			return false
+		}
+		if word.Term == "" && tokens[i].Word != word.Word {
+			return false
+		}
+	}
+	return true
+}
+
+// Excerpt of text around its first highlighted word, HTML-escaped, with highlighted words
+// wrapped in <mark>. Neighbouring highlights separated only by spaces share one mark.
+// Returns "" when nothing is highlighted.
+func buildSnippet(text string, tokens []textToken, highlighted []bool) string {
+	first := -1
+	for i, on := range highlighted {
+		if on {
+			first = i
+			break
+		}
+	}
+	if first < 0 {
+		return ""
+	}
+
+	from := first - snippetLeadingWords
+	if from < 0 {
+		from = 0
+	}
+	to := from + snippetWords
+	if to > len(tokens) {
+		to = len(tokens)
+	}
+
+	begin := tokens[from].Start
+	if from == 0 {
+		begin = 0
+	}
+	end := len(text)
+	if to < len(tokens) {
+		end = tokens[to].Start
+	}
+
+	var b strings.Builder
+	if begin > 0 {
+		b.WriteString("…")
+	}
+	position := begin
+	for i := from; i < to; i++ {
+		if !highlighted[i] {
+			continue
+		}
+		b.WriteString(html.EscapeString(text[position:tokens[i].Start]))
+		last := i
+		for last+1 < to && highlighted[last+1] && strings.TrimSpace(text[tokens[last].End:tokens[last+1].Start]) == "" {
+			last++
+		}
+		b.WriteString("<mark>")
+		b.WriteString(html.EscapeString(text[tokens[i].Start:tokens[last].End]))
+		b.WriteString("</mark>")
+		position = tokens[last].End
+		i = last
+	}
+	b.WriteString(html.EscapeString(strings.TrimRight(text[position:end], " \t\r\n")))
+	if end < len(text) {
+		b.WriteString("…")
+	}
+	return b.String()
+}
+
+func encodeSearchCursor(after string, query searchQuery) string {
+	data, _ := json.Marshal(searchCursor{After: after, Query: query.fingerprint()})
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeSearchCursor(encoded string, query searchQuery) (*searchCursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, newBadRequest("INVALID_CURSOR", "The 'cursor' parameter is not a valid cursor")
+	}
+
+	var cursor searchCursor
+	if err := json.Unmarshal(data, &cursor); err != nil {
+		return nil, newBadRequest("INVALID_CURSOR", "The 'cursor' parameter is not a valid cursor")
+	}
+	if cursor.Query != query.fingerprint() {
+		return nil, newBadRequest("INVALID_CURSOR", "The 'cursor' parameter does not belong to this search")
+	}
+	return &cursor, nil
+}
@@ -0,0 +1,227 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// Explanation fields covered by the search index
+const (
+	searchFieldLabel    = "label"
+	searchFieldOverview = "overview"
+)
+
+const (
+	// Maximum number of requests in one BatchWriteItem call
+	batchWriteSize = 25
+	// Attempts at writing back unprocessed items before the stream batch is failed and retried
+	batchWriteAttempts = 5
+)
+
+// Inverted index entry: one per term and result. Posting is "<timestamp>#<id>", so the
+// postings of a term sort newest first like the history listing.
+type searchPosting struct {
+	Term            string   `dynamodbav:"term"`
+	Posting         string   `dynamodbav:"posting"`
+	ResultID        string   `dynamodbav:"resultId"`
+	Fields          []string `dynamodbav:"fields,stringset"`
+	ProductCategory string   `dynamodbav:"productCategory,omitempty"`
+}
+
+func searchEnabled() bool {
+	return appConfig.SearchIndexTable != ""
+}
+
+func postingKey(record VerificationRecord) string {
+	return record.Timestamp + "#" + record.ID
+}
+
+// Postings of a result, keyed by term. Results whose response cannot be parsed have none.
+func resultPostings(requestID string, record VerificationRecord) map[string]searchPosting {
+	results, err := parseVerificationResults(requestID, record.BedrockResponse)
+	if err != nil {
+		return nil
+	}
+
+	fields := map[string][]string{}
+	for term := range textTerms(results.LabelExplanation) {
+		fields[term] = append(fields[term], searchFieldLabel)
+	}
+	for term := range textTerms(results.OverviewExplanation) {
+		fields[term] = append(fields[term], searchFieldOverview)
+	}
+
+	postings := make(map[string]searchPosting, len(fields))
+	for term, termFields := range fields {
+		postings[term] = searchPosting{
+			Term:            term,
+			Posting:         postingKey(record),
+			ResultID:        record.ID,
+			Fields:          termFields,
+			ProductCategory: record.ProductCategory,
+		}
+	}
+	return postings
+}
+
+// Keep the search index in step with the results table stream. Postings of the new image
+// are always written, so touching a result re-indexes it; postings only the old image had
+// are deleted. An error fails the batch, which Lambda then retries.
+func indexSearchStream(ctx context.Context, event events.DynamoDBEvent) (int, error) {
+	if !searchEnabled() {
+		log.Printf("Search index not configured (SEARCH_INDEX_TABLE), skipping %d stream records", len(event.Records))
+		return 0, nil
+	}
+
+	// One write per posting: a batch may not hold two requests for the same key, and a
+	// later stream record of the same result supersedes an earlier one
+	pending := map[string]types.WriteRequest{}
+	for _, streamRecord := range event.Records {
+		requestID := streamRecord.EventID
+
+		var oldPostings, newPostings map[string]searchPosting
+		if record, ok := streamImageRecord(requestID, streamRecord.Change.OldImage); ok {
+			oldPostings = resultPostings(requestID, record)
+		}
+		if record, ok := streamImageRecord(requestID, streamRecord.Change.NewImage); ok {
+			newPostings = resultPostings(requestID, record)
+		}
+
+		for term, posting := range oldPostings {
+			if current, ok := newPostings[term]; ok && current.Posting == posting.Posting {
+				continue
+			}
+			pending[posting.Term+"\x00"+posting.Posting] = types.WriteRequest{DeleteRequest: &types.DeleteRequest{
+				Key: map[string]types.AttributeValue{
+					"term":    &types.AttributeValueMemberS{Value: posting.Term},
+					"posting": &types.AttributeValueMemberS{Value: posting.Posting},
+				},
+			}}
+		}
+		for _, posting := range newPostings {
+			item, err := attributevalue.MarshalMap(posting)
+			if err != nil {
+				return 0, fmt.Errorf("failed to marshal posting %s of %s: %w", posting.Term, posting.ResultID, err)
+			}
+			pending[posting.Term+"\x00"+posting.Posting] = types.WriteRequest{PutRequest: &types.PutRequest{Item: item}}
+		}
+	}
+
+	keys := make([]string, 0, len(pending))
+	for key := range pending {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	writes := make([]types.WriteRequest, 0, len(keys))
+	for _, key := range keys {
+		writes = append(writes, pending[key])
+	}
+
+	for start := 0; start < len(writes); start += batchWriteSize {
+		end := start + batchWriteSize
+		if end > len(writes) {
+			end = len(writes)
+		}
+		if err := batchWritePostings(ctx, writes[start:end]); err != nil {
+			return 0, err
+		}
+	}
+	return len(writes), nil
+}
+
+// Write one batch, retrying unprocessed items with exponential backoff
+func batchWritePostings(ctx context.Context, writes []types.WriteRequest) error {
+	backoff := 100 * time.Millisecond
+	for attempt := 1; ; attempt++ {
+		output, err := dynamoClient.BatchWriteItem(ctx, &dynamodb.BatchWriteItemInput{
+			RequestItems: map[string][]types.WriteRequest{appConfig.SearchIndexTable: writes},
+		})
+		if err != nil {
+			return fmt.Errorf("search index batch write failed: %w", err)
+		}
+
+		writes = output.UnprocessedItems[appConfig.SearchIndexTable]
+		if len(writes) == 0 {
+			return nil
+		}
+		if attempt == batchWriteAttempts {
+			return fmt.Errorf("search index batch write left %d unprocessed items after %d attempts", len(writes), attempt)
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+// Decode a stream image into a result. Returns false for a missing image (inserts have no
+// old image, removals no new one) or one that does not decode.
+func streamImageRecord(requestID string, image map[string]events.DynamoDBAttributeValue) (VerificationRecord, bool) {
+	var record VerificationRecord
+	item := streamImageItem(image)
+	if item == nil {
+		return record, false
+	}
+	if err := attributevalue.UnmarshalMap(item, &record); err != nil {
+		log.Printf("RequestID: %s - Warning: Failed to unmarshal stream image: %v", requestID, err)
+		return record, false
+	}
+	return record, record.ID != "" && record.Timestamp != ""
+}
+
+// Convert a stream image into an SDK item, nil when there is no image
+func streamImageItem(image map[string]events.DynamoDBAttributeValue) map[string]types.AttributeValue {
+	if len(image) == 0 {
+		return nil
+	}
+	item := make(map[string]types.AttributeValue, len(image))
+	for name, value := range image {
+		item[name] = streamAttributeValue(value)
+	}
+	return item
+}
+
+// Convert a Lambda stream attribute value into its SDK form
+func streamAttributeValue(value events.DynamoDBAttributeValue) types.AttributeValue {
+	switch value.DataType() {
+	case events.DataTypeString:
+		return &types.AttributeValueMemberS{Value: value.String()}
+	case events.DataTypeNumber:
+		return &types.AttributeValueMemberN{Value: value.Number()}
+	case events.DataTypeBinary:
+		return &types.AttributeValueMemberB{Value: value.Binary()}
+	case events.DataTypeBoolean:
+		return &types.AttributeValueMemberBOOL{Value: value.Boolean()}
+	case events.DataTypeStringSet:
+		return &types.AttributeValueMemberSS{Value: value.StringSet()}
+	case events.DataTypeNumberSet:
+		return &types.AttributeValueMemberNS{Value: value.NumberSet()}
+	case events.DataTypeBinarySet:
+		return &types.AttributeValueMemberBS{Value: value.BinarySet()}
+	case events.DataTypeList:
+		list := make([]types.AttributeValue, 0, len(value.List()))
+		for _, element := range value.List() {
+			list = append(list, streamAttributeValue(element))
+		}
+		return &types.AttributeValueMemberL{Value: list}
+	case events.DataTypeMap:
+		members := make(map[string]types.AttributeValue, len(value.Map()))
+		for name, member := range value.Map() {
+			members[name] = streamAttributeValue(member)
+		}
+		return &types.AttributeValueMemberM{Value: members}
+	default:
+		return &types.AttributeValueMemberNULL{Value: true}
+	}
+}
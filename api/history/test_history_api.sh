@@ -1,7 +1,7 @@
 #!/bin/bash
 
 # History API Test Script
-# Tests all view types: list, summary, and export operations
+# Tests all view types: list, summary, trends, and export operations
 
 set -e  # Exit on any error
 
@@ -36,12 +36,22 @@ echo "✓ Default list view test completed"
 echo ""
 echo "🔍 Test 2: History List with Pagination"
 echo "-------------------------------------------"
-curl -X GET \
-  "${API_GATEWAY_ENDPOINT}?view=list&page=1&pageSize=10" \
+FIRST_PAGE=$(curl -s -X GET \
+  "${API_GATEWAY_ENDPOINT}?view=list&pageSize=10" \
   -H 'Content-Type: application/json' \
-  -H "x-api-key: $API_KEY" \
-  -w "\nHTTP Status: %{http_code}\nResponse Time: %{time_total}s\n" \
-  | jq '.' 2>/dev/null || echo "Response received"
+  -H "x-api-key: $API_KEY")
+echo "$FIRST_PAGE" | jq '.pagination' 2>/dev/null || echo "Response received"
+
+NEXT_CURSOR=$(echo "$FIRST_PAGE" | jq -r '.pagination.nextCursor // empty' 2>/dev/null)
+if [ -n "$NEXT_CURSOR" ]; then
+  echo "Fetching the next page with the returned cursor"
+  curl -X GET \
+    "${API_GATEWAY_ENDPOINT}?view=list&pageSize=10&cursor=${NEXT_CURSOR}" \
+    -H 'Content-Type: application/json' \
+    -H "x-api-key: $API_KEY" \
+    -w "\nHTTP Status: %{http_code}\nResponse Time: %{time_total}s\n" \
+    | jq '.pagination' 2>/dev/null || echo "Response received"
+fi
 
 echo ""
 echo "✓ Pagination test completed"
@@ -148,12 +158,28 @@ echo "✓ Summary with date range test completed"
 echo ""
 echo "📥 Test 10: Export History as CSV"
 echo "-------------------------------------------"
-curl -X GET \
+EXPORT_JOB=$(curl -s -X GET \
   "${API_GATEWAY_ENDPOINT}?view=export&format=csv" \
   -H 'Content-Type: application/json' \
-  -H "x-api-key: $API_KEY" \
-  -w "\nHTTP Status: %{http_code}\nResponse Time: %{time_total}s\n" \
-  | jq '.' 2>/dev/null || echo "Response received"
+  -H "x-api-key: $API_KEY")
+echo "$EXPORT_JOB" | jq '.' 2>/dev/null || echo "$EXPORT_JOB"
+
+# Exports run asynchronously; poll the job until it finishes
+JOB_ID=$(echo "$EXPORT_JOB" | jq -r '.data.jobId // empty' 2>/dev/null)
+if [ -n "$JOB_ID" ]; then
+  for attempt in $(seq 1 30); do
+    sleep 5
+    JOB_STATUS=$(curl -s -X GET \
+      "${API_GATEWAY_ENDPOINT}?view=export&jobId=${JOB_ID}" \
+      -H "x-api-key: $API_KEY")
+    STATUS=$(echo "$JOB_STATUS" | jq -r '.data.status' 2>/dev/null)
+    echo "Export job ${JOB_ID}: ${STATUS}, $(echo "$JOB_STATUS" | jq -r '.data.recordCount' 2>/dev/null) records"
+    if [ "$STATUS" = "COMPLETED" ] || [ "$STATUS" = "FAILED" ]; then
+      echo "$JOB_STATUS" | jq '.' 2>/dev/null || echo "$JOB_STATUS"
+      break
+    fi
+  done
+fi
 
 echo ""
 echo "✓ CSV export test completed"
@@ -186,6 +212,20 @@ curl -X GET \
 echo ""
 echo "✓ Combined filters test completed"
 
+# Test 13: Trends by Category
+echo ""
+echo "📈 Test 13: Daily Trends by Category"
+echo "-------------------------------------------"
+curl -X GET \
+  "${API_GATEWAY_ENDPOINT}?view=trends&interval=day&breakdown=category&dateRange=week" \
+  -H 'Content-Type: application/json' \
+  -H "x-api-key: $API_KEY" \
+  -w "\nHTTP Status: %{http_code}\nResponse Time: %{time_total}s\n" \
+  | jq '.' 2>/dev/null || echo "Response received"
+
+echo ""
+echo "✓ Trends test completed"
+
 echo ""
 echo "==========================================="
 echo "🎉 All History API tests completed!"
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"strings"
+	"unicode"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// Word of an explanation: its search term and the byte range of the original text, for highlighting.
+// Term is empty for stop words, which are kept so phrases can still be matched word by word.
+type textToken struct {
+	Term  string
+	Word  string
+	Start int
+	End   int
+}
+
+// Words too common in explanations to be worth a posting. Vietnamese entries are in folded form
+// and leave out words whose folded form collides with a meaningful one, e.g. "do" (đỏ, red),
+// "ma" (mã, code), "trong" (trống, empty) and "voi" (vòi, tap).
+var stopWords = map[string]bool{
+	"a": true, "an": true, "and": true, "are": true, "as": true, "at": true, "be": true, "been": true,
+	"but": true, "by": true, "for": true, "from": true, "has": true, "have": true, "in": true,
+	"is": true, "it": true, "its": true, "of": true, "on": true, "or": true, "that": true, "the": true,
+	"there": true, "this": true, "to": true, "was": true, "were": true, "which": true, "with": true,
+	"cac": true, "cua": true, "duoc": true, "mot": true, "nay": true, "nhung": true, "thi": true, "va": true,
+}
+
+// British spellings indexed under their American form, so "colour" also finds "color"
+var spellingVariants = map[string]string{
+	"colour":   "color",
+	"coloured": "colored",
+	"grey":     "gray",
+	"centre":   "center",
+	"labelled": "labeled",
+	"metre":    "meter",
+	"litre":    "liter",
+}
+
+// Split text into words: runs of letters, digits and combining marks. Everything else,
+// including hyphens and apostrophes, separates words, so "AQR-M466XA" gives "aqr" and "m466xa".
+func tokenizeText(text string) []textToken {
+	var tokens []textToken
+	start := -1
+	for i, r := range text {
+		inWord := unicode.IsLetter(r) || unicode.IsDigit(r) || unicode.Is(unicode.Mn, r)
+		if inWord && start < 0 {
+			start = i
+		} else if !inWord && start >= 0 {
+			tokens = appendToken(tokens, text, start, i)
+			start = -1
+		}
+	}
+	if start >= 0 {
+		tokens = appendToken(tokens, text, start, len(text))
+	}
+	return tokens
+}
+
+func appendToken(tokens []textToken, text string, start, end int) []textToken {
+	word := foldWord(text[start:end])
+	// Single letters carry no meaning on their own; single digits do, e.g. "2 doors"
+	if word == "" || (len(word) == 1 && !unicode.IsDigit(rune(word[0]))) {
+		return tokens
+	}
+	return append(tokens, textToken{Term: searchTerm(word), Word: word, Start: start, End: end})
+}
+
+// Lowercase and strip diacritics: decompose, drop the combining marks, and map đ, which has
+// no decomposition, to d. "Nhãn bị che khuất" folds to "nhan bi che khuat".
+func foldWord(word string) string {
+	var b strings.Builder
+	for _, r := range norm.NFD.String(word) {
+		if unicode.Is(unicode.Mn, r) {
+			continue
+		}
+		if r == 'đ' || r == 'Đ' {
+			r = 'd'
+		}
+		b.WriteRune(unicode.ToLower(r))
+	}
+	return b.String()
+}
+
+// Index term of a folded word, or "" for a stop word. English plurals are reduced to the
+// singular; Vietnamese syllables never end in "s", so they pass through unchanged.
+func searchTerm(word string) string {
+	if stopWords[word] {
+		return ""
+	}
+	if len(word) > 4 && strings.HasSuffix(word, "ies") {
+		word = word[:len(word)-3] + "y"
+	} else if len(word) > 3 && strings.HasSuffix(word, "s") &&
+		!strings.HasSuffix(word, "ss") && !strings.HasSuffix(word, "us") && !strings.HasSuffix(word, "is") {
+		word = word[:len(word)-1]
+	}
+	if variant, ok := spellingVariants[word]; ok {
+		return variant
+	}
+	return word
+}
+
+// Distinct index terms of a text
+func textTerms(text string) map[string]bool {
+	terms := map[string]bool{}
+	for _, token := range tokenizeText(text) {
+		if token.Term != "" {
+			terms[token.Term] = true
+		}
+	}
+	return terms
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+func TestFoldWord(t *testing.T) {
+	tests := []struct {
+		word string
+		want string
+	}{
+		{"Nhãn", "nhan"},
+		{"bị", "bi"},
+		{"khuất", "khuat"},
+		{"ĐỎ", "do"},
+		{"đường", "duong"},
+		{"Mã", "ma"},
+		{"trống", "trong"},
+		{"vòi", "voi"},
+		{"ƯỚC", "uoc"},
+		{"ơn", "on"},
+		{"ÀÁẢÃẠ", "aaaaa"},
+		{"ẰẮẲẴẶ", "aaaaa"},
+		{"Ỷ", "y"},
+		{norm.NFD.String("CHÍNH DIỆN"), "chinh dien"},
+		{"M466XA", "m466xa"},
+		{"Colour", "colour"},
+	}
+
+	for _, tt := range tests {
+		if got := foldWord(tt.word); got != tt.want {
+			t.Errorf("foldWord(%q) = %q, want %q", tt.word, got, tt.want)
+		}
+		// Composed and decomposed input fold alike
+		if got := foldWord(norm.NFC.String(tt.word)); got != tt.want {
+			t.Errorf("foldWord(NFC %q) = %q, want %q", tt.word, got, tt.want)
+		}
+	}
+}
+
+func TestTokenizeText(t *testing.T) {
+	tests := []struct {
+		text  string
+		terms []string // Term of each token, "" for stop words
+	}{
+		{"Nhãn bị che khuất", []string{"nhan", "bi", "che", "khuat"}},
+		{"Màu ĐỎ và các vòi", []string{"mau", "do", "", "", "voi"}},
+		{norm.NFD.String("Nhãn bị che khuất"), []string{"nhan", "bi", "che", "khuat"}},
+		{"AQR-M466XA(GB)", []string{"aqr", "m466xa", "gb"}},
+		{"The label's colour is grey", []string{"", "label", "color", "", "gray"}},
+		{"a 2 doors, 3 batteries, status", []string{"2", "door", "3", "battery", "status"}},
+		{"  --  ", nil},
+	}
+
+	for _, tt := range tests {
+		tokens := tokenizeText(tt.text)
+
+		var terms []string
+		for _, token := range tokens {
+			terms = append(terms, token.Term)
+			// The byte range must cover the original word, for highlighting
+			if got := foldWord(tt.text[token.Start:token.End]); got != token.Word {
+				t.Errorf("tokenizeText(%q): range %d-%d holds %q, want %q", tt.text, token.Start, token.End, tt.text[token.Start:token.End], token.Word)
+			}
+		}
+		if !reflect.DeepEqual(terms, tt.terms) {
+			t.Errorf("tokenizeText(%q) terms = %q, want %q", tt.text, terms, tt.terms)
+		}
+	}
+}
+
+func TestTokenizeTextRanges(t *testing.T) {
+	text := "Nhãn bị che khuất"
+	want := []textToken{
+		{Term: "nhan", Word: "nhan", Start: 0, End: 5},
+		{Term: "bi", Word: "bi", Start: 6, End: 10},
+		{Term: "che", Word: "che", Start: 11, End: 14},
+		{Term: "khuat", Word: "khuat", Start: 15, End: 22},
+	}
+	if got := tokenizeText(text); !reflect.DeepEqual(got, want) {
+		t.Errorf("tokenizeText(%q) = %+v, want %+v", text, got, want)
+	}
+}
+
+func TestTextTerms(t *testing.T) {
+	got := textTerms("Nhãn bị che khuất, màu đỏ; the label is NOT visible. Nhan bi che")
+	want := map[string]bool{
+		"nhan": true, "bi": true, "che": true, "khuat": true, "mau": true, "do": true,
+		"label": true, "not": true, "visible": true,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("textTerms = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,266 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"time"
+)
+
+// Most buckets one trends request may produce, e.g. a month of hours
+const maxTrendBuckets = 1000
+
+// Figures of one bucket, or of one category or model within it. Rates and averages cover
+// the records whose model response could be parsed; token counts cover all of them.
+type TrendStats struct {
+	Verifications         int     `json:"verifications"`
+	ParseFailures         int     `json:"parseFailures"`
+	SuccessRate           float64 `json:"successRate"`
+	AvgLabelConfidence    float64 `json:"avgLabelConfidence"`
+	AvgOverviewConfidence float64 `json:"avgOverviewConfidence"`
+	InputTokens           int     `json:"inputTokens"`
+	OutputTokens          int     `json:"outputTokens"`
+}
+
+// Interval [Start, End). Breakdown has an entry for every key of the series, zero when
+// the key has no records in the bucket.
+type TrendBucket struct {
+	Start time.Time `json:"start"`
+	End   time.Time `json:"end"`
+	TrendStats
+	Breakdown map[string]TrendStats `json:"breakdown,omitempty"`
+}
+
+type TrendsData struct {
+	Interval  string        `json:"interval"`
+	Breakdown string        `json:"breakdown,omitempty"`
+	Keys      []string      `json:"keys,omitempty"`
+	Buckets   []TrendBucket `json:"buckets"`
+}
+
+// Bucket size: the start of the bucket holding a time, and the start of the bucket after one
+type trendInterval struct {
+	truncate func(time.Time) time.Time
+	next     func(time.Time) time.Time
+}
+
+// Bucket sizes by name. Weeks start on Monday; all buckets are aligned in UTC.
+var trendIntervals = map[string]trendInterval{
+	"hour": {
+		truncate: func(t time.Time) time.Time { return t.Truncate(time.Hour) },
+		next:     func(t time.Time) time.Time { return t.Add(time.Hour) },
+	},
+	"day": {
+		truncate: truncateDay,
+		next:     func(t time.Time) time.Time { return t.AddDate(0, 0, 1) },
+	},
+	"week": {
+		truncate: func(t time.Time) time.Time {
+			day := truncateDay(t)
+			return day.AddDate(0, 0, -(int(day.Weekday())+6)%7)
+		},
+		next: func(t time.Time) time.Time { return t.AddDate(0, 0, 7) },
+	},
+	"month": {
+		truncate: func(t time.Time) time.Time { return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC) },
+		next:     func(t time.Time) time.Time { return t.AddDate(0, 1, 0) },
+	},
+}
+
+// Record attribute a breakdown groups by
+var trendBreakdowns = map[string]func(VerificationRecord) string{
+	"category": func(r VerificationRecord) string { return r.ProductCategory },
+	"model":    func(r VerificationRecord) string { return r.BedrockResponse.Model },
+}
+
+func truncateDay(t time.Time) time.Time {
+	t = t.UTC()
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+}
+
+// Sums of a bucket while records are added
+type trendAccumulator struct {
+	records, parsed, correct            int
+	labelConfidence, overviewConfidence float64
+	inputTokens, outputTokens           int
+}
+
+// Add a record; results is nil when its model response could not be parsed
+func (a *trendAccumulator) add(record VerificationRecord, results *VerificationResults) {
+	a.records++
+	a.inputTokens += record.BedrockResponse.Usage.InputTokens
+	a.outputTokens += record.BedrockResponse.Usage.OutputTokens
+
+	if results == nil {
+		return
+	}
+	a.parsed++
+	a.labelConfidence += results.MatchLabelConfidence
+	a.overviewConfidence += results.MatchOverviewConfidence
+	overallConfidence := (results.MatchLabelConfidence + results.MatchOverviewConfidence) / 2
+	if determineVerificationResult(*results, overallConfidence) == "CORRECT" {
+		a.correct++
+	}
+}
+
+func (a *trendAccumulator) stats() TrendStats {
+	stats := TrendStats{
+		Verifications: a.records,
+		ParseFailures: a.records - a.parsed,
+		InputTokens:   a.inputTokens,
+		OutputTokens:  a.outputTokens,
+	}
+	if a.parsed > 0 {
+		stats.SuccessRate = float64(a.correct) / float64(a.parsed) * 100
+		stats.AvgLabelConfidence = a.labelConfidence / float64(a.parsed)
+		stats.AvgOverviewConfidence = a.overviewConfidence / float64(a.parsed)
+	}
+	return stats
+}
+
+// Handle time-series trends: the records of the date range in buckets of an interval
+func handleTrendsView(ctx context.Context, requestID string, queryParams map[string]string) (*HistoryResponse, error) {
+	log.Printf("RequestID: %s - Starting trends operation", requestID)
+
+	intervalName := queryParams["interval"]
+	if intervalName == "" {
+		intervalName = "day"
+	}
+
+	breakdownName := queryParams["breakdown"]
+	if _, ok := trendBreakdowns[breakdownName]; breakdownName != "" && !ok {
+		return nil, newBadRequest("INVALID_BREAKDOWN", "Invalid 'breakdown' parameter: %s. Valid values: category, model", breakdownName)
+	}
+
+	dateFrom, dateTo := parseDateRange(queryParams)
+	if dateFrom.After(dateTo) {
+		return nil, newBadRequest("INVALID_DATE_RANGE", "'dateFrom' must not be after 'dateTo'")
+	}
+
+	starts, err := trendBucketStarts(intervalName, dateFrom, dateTo)
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("RequestID: %s - Trends from %s to %s in %d %s buckets, breakdown: %s",
+		requestID, dateFrom.Format(time.RFC3339), dateTo.Format(time.RFC3339), len(starts), intervalName, breakdownName)
+
+	filter, err := parseFilterParams(queryParams)
+	if err != nil {
+		return nil, err
+	}
+
+	scan, err := queryAllRecordsInRange(ctx, requestID, dateFrom, dateTo, conjuncts(filter))
+	if err != nil {
+		return nil, fmt.Errorf("failed to query records for trends: %w", err)
+	}
+
+	data := bucketTrendRecords(requestID, intervalName, breakdownName, starts, scan.Records)
+
+	response := &HistoryResponse{
+		View: "trends",
+		Data: data,
+		Metadata: map[string]interface{}{
+			"dateRange": map[string]interface{}{
+				"from": dateFrom,
+				"to":   dateTo,
+			},
+			"scannedAt":      time.Now(),
+			"appliedFilters": getAppliedFilters(queryParams),
+			"complete":       scan.Complete,
+			"scannedItems":   scan.ScannedItems,
+		},
+	}
+
+	log.Printf("RequestID: %s - Trends completed: %d records in %d buckets", requestID, len(scan.Records), len(starts))
+	return response, nil
+}
+
+// Bucket starts from the interval containing dateFrom up to dateTo, so every interval of
+// the range is reported even without records
+func trendBucketStarts(intervalName string, dateFrom, dateTo time.Time) ([]time.Time, error) {
+	interval, ok := trendIntervals[intervalName]
+	if !ok {
+		return nil, newBadRequest("INVALID_INTERVAL", "Invalid 'interval' parameter: %s. Valid values: hour, day, week, month", intervalName)
+	}
+
+	starts := []time.Time{}
+	for start := interval.truncate(dateFrom.UTC()); !start.After(dateTo); start = interval.next(start) {
+		if len(starts) == maxTrendBuckets {
+			return nil, newBadRequest("INVALID_INTERVAL", "The date range has more than %d '%s' buckets. Use a longer interval or a shorter range", maxTrendBuckets, intervalName)
+		}
+		starts = append(starts, start)
+	}
+	return starts, nil
+}
+
+// Sort records into the buckets starting at starts. Records before the first bucket are skipped.
+// Buckets and breakdown entries without records are filled with zeroes.
+func bucketTrendRecords(requestID, intervalName, breakdownName string, starts []time.Time, records []VerificationRecord) TrendsData {
+	interval := trendIntervals[intervalName]
+	breakdownKey := trendBreakdowns[breakdownName]
+
+	totals := make([]trendAccumulator, len(starts))
+	groups := make([]map[string]*trendAccumulator, len(starts))
+	keys := map[string]bool{}
+	for _, record := range records {
+		ts, err := parseResultTimestamp(record.Timestamp)
+		if err != nil {
+			log.Printf("RequestID: %s - Warning: Skipping record %s with timestamp %s: %v", requestID, record.ID, record.Timestamp, err)
+			continue
+		}
+
+		// Starts are ascending, so the bucket is the last one starting at or before ts
+		i := sort.Search(len(starts), func(i int) bool { return starts[i].After(ts) }) - 1
+		if i < 0 {
+			continue
+		}
+		var results *VerificationResults
+		if parsed, err := parseVerificationResults(requestID, record.BedrockResponse); err == nil {
+			results = &parsed
+		}
+		totals[i].add(record, results)
+
+		if breakdownKey != nil {
+			key := breakdownKey(record)
+			keys[key] = true
+			if groups[i] == nil {
+				groups[i] = map[string]*trendAccumulator{}
+			}
+			if groups[i][key] == nil {
+				groups[i][key] = &trendAccumulator{}
+			}
+			groups[i][key].add(record, results)
+		}
+	}
+
+	data := TrendsData{
+		Interval:  intervalName,
+		Breakdown: breakdownName,
+		Buckets:   make([]TrendBucket, len(starts)),
+	}
+	if breakdownKey != nil {
+		data.Keys = make([]string, 0, len(keys))
+		for key := range keys {
+			data.Keys = append(data.Keys, key)
+		}
+		sort.Strings(data.Keys)
+	}
+
+	for i, start := range starts {
+		bucket := TrendBucket{Start: start, End: interval.next(start), TrendStats: totals[i].stats()}
+		if breakdownKey != nil {
+			bucket.Breakdown = make(map[string]TrendStats, len(data.Keys))
+			for _, key := range data.Keys {
+				var stats TrendStats
+				if group := groups[i][key]; group != nil {
+					stats = group.stats()
+				}
+				bucket.Breakdown[key] = stats
+			}
+		}
+		data.Buckets[i] = bucket
+	}
+
+	return data
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func utcTime(value string) time.Time {
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		panic(err)
+	}
+	return t.UTC()
+}
+
+func TestTrendBucketStarts(t *testing.T) {
+	tests := []struct {
+		name     string
+		interval string
+		from, to string
+		want     []string
+	}{
+		{
+			name:     "weeks start on Monday",
+			interval: "week",
+			from:     "2026-10-14T15:00:00Z", // Wednesday
+			to:       "2026-10-25T23:00:00Z", // Sunday
+			want:     []string{"2026-10-12T00:00:00Z", "2026-10-19T00:00:00Z"},
+		},
+		{
+			name:     "a Sunday belongs to the week of the Monday before",
+			interval: "week",
+			from:     "2026-10-18T10:00:00Z",
+			to:       "2026-10-19T00:00:00Z",
+			want:     []string{"2026-10-12T00:00:00Z", "2026-10-19T00:00:00Z"},
+		},
+		{
+			name:     "months roll over the year and short months",
+			interval: "month",
+			from:     "2025-12-31T23:00:00Z",
+			to:       "2026-03-01T00:00:00Z",
+			want:     []string{"2025-12-01T00:00:00Z", "2026-01-01T00:00:00Z", "2026-02-01T00:00:00Z", "2026-03-01T00:00:00Z"},
+		},
+		{
+			name:     "days are aligned in UTC",
+			interval: "day",
+			from:     "2026-10-17T23:30:00+07:00",
+			to:       "2026-10-18T05:00:00+07:00",
+			want:     []string{"2026-10-17T00:00:00Z"},
+		},
+		{
+			name:     "hours",
+			interval: "hour",
+			from:     "2026-10-17T08:59:59Z",
+			to:       "2026-10-17T10:00:00Z",
+			want:     []string{"2026-10-17T08:00:00Z", "2026-10-17T09:00:00Z", "2026-10-17T10:00:00Z"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			starts, err := trendBucketStarts(tt.interval, utcTime(tt.from), utcTime(tt.to))
+			if err != nil {
+				t.Fatalf("trendBucketStarts() error = %v", err)
+			}
+			got := make([]string, len(starts))
+			for i, start := range starts {
+				got[i] = start.Format(time.RFC3339)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("trendBucketStarts(%q, %s, %s) = %v, want %v", tt.interval, tt.from, tt.to, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTrendBucketStartsRejected(t *testing.T) {
+	tests := []struct {
+		name     string
+		interval string
+		from, to string
+	}{
+		{"unknown interval", "fortnight", "2026-10-01T00:00:00Z", "2026-10-18T00:00:00Z"},
+		{"one bucket over the limit", "hour", "2026-10-01T00:00:00Z", "2026-11-11T16:00:00Z"},
+		{"years of hours", "hour", "2024-01-01T00:00:00Z", "2026-10-18T00:00:00Z"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := trendBucketStarts(tt.interval, utcTime(tt.from), utcTime(tt.to))
+			var reqErr *requestError
+			if !errors.As(err, &reqErr) || reqErr.StatusCode != 400 || reqErr.Code != "INVALID_INTERVAL" {
+				t.Errorf("trendBucketStarts(%q, %s, %s) error = %v, want a 400 INVALID_INTERVAL", tt.interval, tt.from, tt.to, err)
+			}
+		})
+	}
+
+	// Exactly maxTrendBuckets hours fit
+	starts, err := trendBucketStarts("hour", utcTime("2026-10-01T00:00:00Z"), utcTime("2026-11-11T15:00:00Z"))
+	if err != nil || len(starts) != maxTrendBuckets {
+		t.Errorf("trendBucketStarts() for %d hours = %d starts, %v", maxTrendBuckets, len(starts), err)
+	}
+}
+
+func TestBucketTrendRecords(t *testing.T) {
+	starts, err := trendBucketStarts("day", utcTime("2026-10-15T00:00:00Z"), utcTime("2026-10-18T00:00:00Z"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	record := func(timestamp, category string, inputTokens int) VerificationRecord {
+		return VerificationRecord{
+			ID:              timestamp,
+			Timestamp:       timestamp,
+			ProductCategory: category,
+			BedrockResponse: BedrockResponse{Usage: TokenUsage{InputTokens: inputTokens}},
+		}
+	}
+	records := []VerificationRecord{
+		record("2026-10-15T08:00:00.123456", "REF", 10),
+		record("2026-10-15T23:59:59.999999", "WM", 20),
+		record("2026-10-17T00:00:00", "REF", 40),
+		record("2026-10-14T23:59:59", "REF", 80), // Before the first bucket
+		record("not a timestamp", "REF", 160),
+	}
+
+	data := bucketTrendRecords("test", "day", "category", starts, records)
+
+	if want := []string{"REF", "WM"}; !reflect.DeepEqual(data.Keys, want) {
+		t.Errorf("Keys = %v, want %v", data.Keys, want)
+	}
+	if len(data.Buckets) != 4 {
+		t.Fatalf("got %d buckets, want 4", len(data.Buckets))
+	}
+
+	want := []struct {
+		verifications int
+		inputTokens   int
+		breakdown     map[string]int // Verifications per key
+	}{
+		{2, 30, map[string]int{"REF": 1, "WM": 1}},
+		{0, 0, map[string]int{"REF": 0, "WM": 0}},
+		{1, 40, map[string]int{"REF": 1, "WM": 0}},
+		{0, 0, map[string]int{"REF": 0, "WM": 0}},
+	}
+	for i, bucket := range data.Buckets {
+		if !bucket.End.Equal(bucket.Start.AddDate(0, 0, 1)) {
+			t.Errorf("bucket %d spans %v to %v, want one day", i, bucket.Start, bucket.End)
+		}
+		if bucket.Verifications != want[i].verifications || bucket.ParseFailures != want[i].verifications || bucket.InputTokens != want[i].inputTokens {
+			t.Errorf("bucket %d = %+v, want %d verifications and %d input tokens", i, bucket.TrendStats, want[i].verifications, want[i].inputTokens)
+		}
+		got := make(map[string]int, len(bucket.Breakdown))
+		for key, stats := range bucket.Breakdown {
+			got[key] = stats.Verifications
+		}
+		if !reflect.DeepEqual(got, want[i].breakdown) {
+			t.Errorf("bucket %d breakdown = %v, want %v", i, got, want[i].breakdown)
+		}
+	}
+
+	// Without a breakdown there are no keys or per-key entries
+	data = bucketTrendRecords("test", "day", "", starts, records)
+	if data.Keys != nil || data.Buckets[0].Breakdown != nil {
+		t.Errorf("bucketTrendRecords() without breakdown = keys %v, breakdown %v", data.Keys, data.Buckets[0].Breakdown)
+	}
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// Maximum length of a single key segment, of a full S3 key and of a version ID, in bytes
+const (
+	maxKeyComponentBytes = 255
+	maxObjectKeyBytes    = 1024
+	maxVersionIDBytes    = 1024
+)
+
+// Suffix pinning a stored key to one object version: "<key>?versionId=<url-encoded id>"
+const versionIDSuffix = "?versionId="
+
+// Canonicalise one key segment to NFC and reject anything that could escape its prefix.
+// Empty segments, as in "a//b", are valid S3 keys and are kept.
+func canonicalKeyComponent(segment string) (string, error) {
+	if !utf8.ValidString(segment) {
+		return "", fmt.Errorf("key segment is not valid UTF-8")
+	}
+
+	canonical := norm.NFC.String(segment)
+
+	if len(canonical) > maxKeyComponentBytes {
+		return "", fmt.Errorf("key segment exceeds %d bytes", maxKeyComponentBytes)
+	}
+	if canonical == "." || canonical == ".." {
+		return "", fmt.Errorf("key contains a relative path segment %q", canonical)
+	}
+	if strings.Contains(canonical, "\\") {
+		return "", fmt.Errorf("key segment contains a backslash")
+	}
+	for _, r := range canonical {
+		if unicode.IsControl(r) || unicode.Is(unicode.Cf, r) || r == utf8.RuneError {
+			return "", fmt.Errorf("key contains a control or formatting character (U+%04X)", r)
+		}
+	}
+
+	return canonical, nil
+}
+
+// Validate a full object key segment by segment and return its canonical form
+func canonicalObjectKey(key string) (string, error) {
+	if len(key) > maxObjectKeyBytes {
+		return "", fmt.Errorf("key exceeds %d bytes", maxObjectKeyBytes)
+	}
+	if strings.HasPrefix(key, "/") {
+		return "", fmt.Errorf("key must not start with '/'")
+	}
+
+	segments := strings.Split(key, "/")
+	for i, segment := range segments {
+		canonical, err := canonicalKeyComponent(segment)
+		if err != nil {
+			return "", err
+		}
+		segments[i] = canonical
+	}
+
+	return strings.Join(segments, "/"), nil
+}
+
+// Split a possibly version-pinned key into the key and its version ID
+func splitVersionedKey(key string) (string, string, error) {
+	index := strings.LastIndex(key, versionIDSuffix)
+	if index < 0 {
+		return key, "", nil
+	}
+
+	versionID, err := url.QueryUnescape(key[index+len(versionIDSuffix):])
+	if err != nil {
+		return "", "", fmt.Errorf("version ID is not URL-encoded correctly: %w", err)
+	}
+	if versionID == "" || len(versionID) > maxVersionIDBytes || !utf8.ValidString(versionID) {
+		return "", "", fmt.Errorf("invalid version ID")
+	}
+	for _, r := range versionID {
+		if unicode.IsControl(r) {
+			return "", "", fmt.Errorf("version ID contains a control character")
+		}
+	}
+
+	return key[:index], versionID, nil
+}
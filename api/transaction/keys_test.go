@@ -0,0 +1,186 @@
+package main
+
+import (
+	"io"
+	"log"
+	"os"
+	"strings"
+	"testing"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// Inputs an attacker would try, and stored names the API must accept
+var keySegmentSeeds = []string{
+	"P1",
+	"a.jpg",
+	"CHÍNH DIỆN",
+	norm.NFD.String("CHÍNH DIỆN"),
+	"",
+	".",
+	"..",
+	`a\b`,
+	"a\x00b",
+	"\x00",
+	"a\nb",
+	"\x7f",
+	"\u202e",
+	"\ufeffa.jpg",
+	"\xff",
+	strings.Repeat("a", maxKeyComponentBytes+1),
+}
+
+var objectKeySeeds = []string{
+	"dataset/REF/P1/TEM NL/a.jpg",
+	"uploads/2025/06/18/label.jpg",
+	"dataset/REF/" + norm.NFD.String("CHÍNH DIỆN") + "/a.jpg",
+	"dataset//REF/a.jpg",
+	"dataset/../secrets",
+	"../../etc/passwd",
+	"dataset/./REF",
+	"/dataset/REF",
+	"dataset/REF/a\x00.jpg",
+	"dataset/REF/a\n.jpg",
+	`dataset\REF`,
+	"dataset%2F..%2Fsecrets",
+	"dataset/REF/a.jpg?versionId=abc",
+	strings.Repeat("a/", maxObjectKeyBytes/2),
+}
+
+// What every accepted segment must satisfy; empty segments are valid S3 keys
+func assertSafeSegment(t *testing.T, segment string) {
+	t.Helper()
+	if segment == "." || segment == ".." {
+		t.Fatalf("accepted relative segment %q", segment)
+	}
+	if strings.Contains(segment, "\\") {
+		t.Fatalf("accepted segment %q with a backslash", segment)
+	}
+	if !utf8.ValidString(segment) {
+		t.Fatalf("accepted segment %q that is not UTF-8", segment)
+	}
+	for _, r := range segment {
+		if unicode.IsControl(r) || unicode.Is(unicode.Cf, r) {
+			t.Fatalf("accepted segment %q with control or formatting character U+%04X", segment, r)
+		}
+	}
+}
+
+func assertSafeKey(t *testing.T, key string) {
+	t.Helper()
+	if strings.HasPrefix(key, "/") {
+		t.Fatalf("accepted key %q starting with '/'", key)
+	}
+	for _, segment := range strings.Split(key, "/") {
+		assertSafeSegment(t, segment)
+	}
+}
+
+// Whether a value has something that must be rejected in any normalisation form
+func hasForbiddenRune(value string) bool {
+	for _, r := range norm.NFC.String(value) {
+		if r == 0 || unicode.IsControl(r) || unicode.Is(unicode.Cf, r) {
+			return true
+		}
+	}
+	return false
+}
+
+func FuzzCanonicalKeyComponent(f *testing.F) {
+	for _, seed := range keySegmentSeeds {
+		f.Add(seed)
+	}
+
+	f.Fuzz(func(t *testing.T, segment string) {
+		canonical, err := canonicalKeyComponent(segment)
+		if err != nil {
+			return
+		}
+
+		assertSafeSegment(t, canonical)
+		if hasForbiddenRune(segment) || norm.NFC.String(segment) == ".." {
+			t.Fatalf("accepted %q", segment)
+		}
+		if !norm.NFC.IsNormalString(canonical) {
+			t.Fatalf("result %q is not NFC", canonical)
+		}
+
+		again, err := canonicalKeyComponent(canonical)
+		if err != nil || again != canonical {
+			t.Fatalf("not idempotent: %q -> %q -> %q (%v)", segment, canonical, again, err)
+		}
+	})
+}
+
+func FuzzCanonicalObjectKey(f *testing.F) {
+	for _, seed := range objectKeySeeds {
+		f.Add(seed)
+	}
+	for _, seed := range keySegmentSeeds {
+		f.Add("dataset/REF/" + seed + "/a.jpg")
+	}
+
+	f.Fuzz(func(t *testing.T, key string) {
+		canonical, err := canonicalObjectKey(key)
+		if err != nil {
+			return
+		}
+
+		assertSafeKey(t, canonical)
+		if hasForbiddenRune(key) {
+			t.Fatalf("accepted %q", key)
+		}
+		if strings.Count(canonical, "/") != strings.Count(key, "/") {
+			t.Fatalf("segments changed: %q -> %q", key, canonical)
+		}
+
+		again, err := canonicalObjectKey(canonical)
+		if err != nil || again != canonical {
+			t.Fatalf("not idempotent: %q -> %q -> %q (%v)", key, canonical, again, err)
+		}
+	})
+}
+
+// normalizeS3Key is where keys from stored records enter: URL decoding must not
+// smuggle in what canonicalObjectKey rejects
+func FuzzNormalizeS3Key(f *testing.F) {
+	for _, seed := range objectKeySeeds {
+		f.Add(seed)
+	}
+	f.Add("dataset/REF/P1/CH%C3%8DNH%20DI%E1%BB%86N/a.jpg")
+	f.Add("dataset/REF/%2e%2e/secrets")
+	f.Add("dataset/REF/a%00.jpg")
+	f.Add("dataset/REF/a%0A.jpg")
+
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	f.Fuzz(func(t *testing.T, key string) {
+		canonical, stored, err := normalizeS3Key(key)
+		if err != nil {
+			return
+		}
+
+		assertSafeKey(t, canonical)
+		if canonical == "" {
+			t.Fatalf("accepted %q as an empty key", key)
+		}
+		if stored != "" {
+			if stored == canonical || norm.NFC.String(stored) != canonical {
+				t.Fatalf("stored form %q is not another normalisation of %q", stored, canonical)
+			}
+			assertSafeKey(t, stored)
+		}
+
+		// Decoding again legitimately changes a key with a literal '%' or '+'
+		if strings.ContainsAny(canonical, "%+") {
+			return
+		}
+		again, _, err := normalizeS3Key(canonical)
+		if err != nil || again != canonical {
+			t.Fatalf("not idempotent: %q -> %q -> %q (%v)", key, canonical, again, err)
+		}
+	})
+}
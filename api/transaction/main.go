@@ -111,6 +111,7 @@ type ImageAccessData struct {
 
 type ImageAccess struct {
 	Key          string    `json:"key"`
+	VersionID    string    `json:"versionId,omitempty"`
 	PresignedURL string    `json:"presignedUrl"`
 	ExpiresAt    time.Time `json:"expiresAt"`
 }
@@ -210,7 +211,7 @@ func handler(ctx context.Context, request events.APIGatewayProxyRequest) (events
 		return createErrorResponse(400, "MISSING_TRANSACTION_ID", "Transaction ID is required in path")
 	}
 
-	// Validate UUID format
+	// Validate UUID format. The ID is looked up as sent: stored IDs are compared byte for byte.
 	if _, err := uuid.Parse(transactionID); err != nil {
 		log.Printf("RequestID: %s - Invalid UUID format for transactionId: %s", requestID, transactionID)
 		return createErrorResponse(400, "INVALID_TRANSACTION_ID", "Transaction ID must be a valid UUID")
@@ -425,14 +426,21 @@ func parseVerificationResults(requestID string, bedrockResponse BedrockResponse)
 	return results, nil
 }
 
+// Confidence thresholds of a verification result, copied from the history API's
+// determineVerificationResult (history/main.go), which documents them
+const (
+	correctConfidenceThreshold   = 0.85
+	incorrectConfidenceThreshold = 0.60
+)
+
 // Determine verification result based on AI analysis
 func determineVerificationResult(results *VerificationResults, overallConfidence float64) string {
 	labelMatch := results.MatchLabelToReference == "yes"
 	overviewMatch := results.MatchOverviewToReference == "yes"
 
-	if labelMatch && overviewMatch && overallConfidence >= 0.85 {
+	if labelMatch && overviewMatch && overallConfidence >= correctConfidenceThreshold {
 		return "CORRECT"
-	} else if overallConfidence < 0.60 {
+	} else if overallConfidence < incorrectConfidenceThreshold {
 		return "INCORRECT"
 	} else {
 		return "UNCERTAIN"
@@ -453,7 +461,7 @@ func generateImageAccessURLs(ctx context.Context, requestID string, record *Tran
 			return nil
 		}
 
-		url, processedKey, err := generatePresignedURL(ctx, requestID, key)
+		url, processedKey, versionID, err := generatePresignedURL(ctx, requestID, key)
 		if err != nil {
 			errorMsg := fmt.Sprintf("Failed to generate %s URL: %v", imageType, err)
 			errors = append(errors, errorMsg)
@@ -463,6 +471,7 @@ func generateImageAccessURLs(ctx context.Context, requestID string, record *Tran
 
 		return &ImageAccess{
 			Key:          processedKey,
+			VersionID:    versionID,
 			PresignedURL: url,
 			ExpiresAt:    expiresAt,
 		}
@@ -494,8 +503,10 @@ func generateImageAccessURLs(ctx context.Context, requestID string, record *Tran
 	return imageAccess, nil
 }
 
-// Normalize S3 key to handle URL-encoded characters and special characters
-func normalizeS3Key(key string) (string, error) {
+// Normalize S3 key to handle URL-encoded characters and special characters.
+// Returns the NFC key and, when it differs, the key as stored in the record, which
+// generatePresignedURL falls back to: S3 keys are byte-exact and may be stored in NFD.
+func normalizeS3Key(key string) (string, string, error) {
 	log.Printf("Normalizing S3 key: original='%s'", key)
 
 	// URL decode the key to handle encoded characters like %28, %29, %C3%8CNH
@@ -510,30 +521,51 @@ func normalizeS3Key(key string) (string, error) {
 	normalizedKey = strings.TrimSpace(normalizedKey)
 
 	if normalizedKey == "" {
-		return "", fmt.Errorf("empty key after normalization")
+		return "", "", fmt.Errorf("empty key after normalization")
+	}
+
+	// Reject traversal segments and control characters, canonicalise to NFC
+	canonicalKey, err := canonicalObjectKey(normalizedKey)
+	if err != nil {
+		return "", "", fmt.Errorf("invalid S3 key '%s': %w", key, err)
 	}
 
-	log.Printf("Normalized S3 key: original='%s', decoded='%s', normalized='%s'", key, decodedKey, normalizedKey)
-	return normalizedKey, nil
+	log.Printf("Normalized S3 key: original='%s', decoded='%s', normalized='%s'", key, decodedKey, canonicalKey)
+	if canonicalKey == normalizedKey {
+		return canonicalKey, "", nil
+	}
+	return canonicalKey, normalizedKey, nil
 }
 
-// Check if S3 object exists before generating presigned URL
-func checkS3ObjectExists(ctx context.Context, bucket, key string) error {
-	_, err := s3Client.HeadObject(ctx, &s3.HeadObjectInput{
+// Check if S3 object (or the given version of it) exists before generating presigned URL
+func checkS3ObjectExists(ctx context.Context, bucket, key, versionID string) error {
+	input := &s3.HeadObjectInput{
 		Bucket: aws.String(bucket),
 		Key:    aws.String(key),
-	})
+	}
+	if versionID != "" {
+		input.VersionId = aws.String(versionID)
+	}
+
+	_, err := s3Client.HeadObject(ctx, input)
 	return err
 }
 
-// Generate presigned URL for S3 object with proper URL decoding and error handling
-func generatePresignedURL(ctx context.Context, requestID, key string) (string, string, error) {
+// Generate presigned URL for S3 object with proper URL decoding and error handling.
+// Keys pinned with "?versionId=" resolve to that version rather than the current object.
+func generatePresignedURL(ctx context.Context, requestID, key string) (string, string, string, error) {
 	log.Printf("RequestID: %s - Generating presigned URL for key: '%s'", requestID, key)
 
+	// Split off the version before decoding, the version ID carries its own encoding
+	unversionedKey, versionID, err := splitVersionedKey(key)
+	if err != nil {
+		return "", "", "", fmt.Errorf("invalid version-pinned key '%s': %w", key, err)
+	}
+
 	// Normalize the key to handle URL-encoded characters
-	normalizedKey, err := normalizeS3Key(key)
+	normalizedKey, storedKey, err := normalizeS3Key(unversionedKey)
 	if err != nil {
-		return "", "", fmt.Errorf("failed to normalize S3 key: %w", err)
+		return "", "", "", fmt.Errorf("failed to normalize S3 key: %w", err)
 	}
 
 	// Determine bucket based on key prefix
@@ -544,18 +576,23 @@ func generatePresignedURL(ctx context.Context, requestID, key string) (string, s
 		bucket = appConfig.ValidationBucket
 	}
 
-	log.Printf("RequestID: %s - Using bucket: %s for key: %s", requestID, bucket, normalizedKey)
+	log.Printf("RequestID: %s - Using bucket: %s for key: %s, version: %s", requestID, bucket, normalizedKey, versionID)
 
 	// Validate bucket configuration
 	if bucket == "" {
-		return "", "", fmt.Errorf("bucket not configured for key prefix")
+		return "", "", "", fmt.Errorf("bucket not configured for key prefix")
 	}
 
-	// Check if object exists before generating presigned URL
-	if err := checkS3ObjectExists(ctx, bucket, normalizedKey); err != nil {
-		log.Printf("RequestID: %s - S3 object check failed: bucket=%s, key=%s, error=%v",
-			requestID, bucket, normalizedKey, err)
-		return "", "", fmt.Errorf("S3 object not accessible: %w", err)
+	// Check if object exists before generating presigned URL, as NFC and then as stored
+	err = checkS3ObjectExists(ctx, bucket, normalizedKey, versionID)
+	if err != nil && storedKey != "" && checkS3ObjectExists(ctx, bucket, storedKey, versionID) == nil {
+		log.Printf("RequestID: %s - Key %s is stored in another normalisation form than NFC", requestID, storedKey)
+		normalizedKey, err = storedKey, nil
+	}
+	if err != nil {
+		log.Printf("RequestID: %s - S3 object check failed: bucket=%s, key=%s, version=%s, error=%v",
+			requestID, bucket, normalizedKey, versionID, err)
+		return "", "", "", fmt.Errorf("S3 object not accessible: %w", err)
 	}
 
 	// Generate presigned URL using a fresh presign client
@@ -567,19 +604,24 @@ func generatePresignedURL(ctx context.Context, requestID, key string) (string, s
 				})
 		})
 
-	request, err := presignClient.PresignGetObject(ctx, &s3.GetObjectInput{
+	input := &s3.GetObjectInput{
 		Bucket: aws.String(bucket),
 		Key:    aws.String(normalizedKey),
-	}, func(opts *s3.PresignOptions) {
+	}
+	if versionID != "" {
+		input.VersionId = aws.String(versionID)
+	}
+
+	request, err := presignClient.PresignGetObject(ctx, input, func(opts *s3.PresignOptions) {
 		opts.Expires = appConfig.PresignedURLExpiry
 	})
 
 	if err != nil {
-		return "", "", fmt.Errorf("failed to generate presigned URL for bucket %s, key %s: %w", bucket, normalizedKey, err)
+		return "", "", "", fmt.Errorf("failed to generate presigned URL for bucket %s, key %s: %w", bucket, normalizedKey, err)
 	}
 
 	log.Printf("RequestID: %s - Presigned URL generated successfully", requestID)
-	return request.URL, normalizedKey, nil
+	return request.URL, normalizedKey, versionID, nil
 }
 
 // Calculate estimated cost based on token usage
@@ -0,0 +1,20 @@
+package main
+
+import "os"
+
+// init in main.go reads its configuration from the environment and stops without it.
+// Package variables are initialised before any init function, so tests can supply
+// placeholders here; variables already set are left alone.
+var _ = func() bool {
+	for name, value := range map[string]string{
+		"AWS_RESULT_TABLE":                "test-result-table",
+		"AWS_DATASET_BUCKET":              "test-dataset-bucket",
+		"AWS_IMPUT_IMG_VALIDATION_BUCKET": "test-validation-bucket",
+		"AWS_REGION":                      "us-east-1",
+	} {
+		if os.Getenv(name) == "" {
+			os.Setenv(name, value)
+		}
+	}
+	return true
+}()
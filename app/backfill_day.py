@@ -0,0 +1,64 @@
+"""
+Backfill the "day" attribute on verification results written before it existed.
+
+The history API lists unfiltered results from the table's day-index, which only
+contains items that carry "day" (the UTC date of "timestamp", YYYY-MM-DD).
+Run once after deploying the index:
+
+    python backfill_day.py --table aqua-genai-validate-result-ncwy [--profile dev] [--dry-run]
+"""
+import argparse
+
+import boto3
+
+
+def backfill(table_name, aws_profile=None, dry_run=False):
+    session = boto3.Session(profile_name=aws_profile) if aws_profile else boto3.Session()
+    dynamodb_client = session.client("dynamodb")
+
+    paginator = dynamodb_client.get_paginator("scan")
+    pages = paginator.paginate(
+        TableName=table_name,
+        ProjectionExpression="id, #ts",
+        FilterExpression="attribute_not_exists(#day)",
+        ExpressionAttributeNames={"#ts": "timestamp", "#day": "day"},
+    )
+
+    updated = skipped = 0
+    for page in pages:
+        for item in page.get("Items", []):
+            timestamp = item.get("timestamp", {}).get("S", "")
+            if len(timestamp) < 10:
+                print(f"Skipping {item['id']['S']}: no usable timestamp")
+                skipped += 1
+                continue
+
+            day = timestamp[:10]
+            if dry_run:
+                print(f"Would set day={day} on {item['id']['S']}")
+            else:
+                try:
+                    dynamodb_client.update_item(
+                        TableName=table_name,
+                        Key={"id": item["id"]},
+                        UpdateExpression="SET #day = :day",
+                        ConditionExpression="attribute_exists(id) AND attribute_not_exists(#day)",
+                        ExpressionAttributeNames={"#day": "day"},
+                        ExpressionAttributeValues={":day": {"S": day}},
+                    )
+                except dynamodb_client.exceptions.ConditionalCheckFailedException:
+                    skipped += 1
+                    continue
+            updated += 1
+
+    print(f"{'Would update' if dry_run else 'Updated'} {updated} items, skipped {skipped}")
+
+
+if __name__ == "__main__":
+    parser = argparse.ArgumentParser(description="Backfill the day attribute of verification results")
+    parser.add_argument("--table", required=True, help="Verification results table name")
+    parser.add_argument("--profile", help="AWS profile to use")
+    parser.add_argument("--dry-run", action="store_true", help="Only report the items that would be updated")
+    args = parser.parse_args()
+
+    backfill(args.table, args.profile, args.dry_run)
@@ -0,0 +1,63 @@
+"""
+Re-index verification results in the history API's full-text search index.
+
+The search index is maintained by the history function from the results table's
+stream, so it only covers results written or changed after the stream was enabled.
+This script touches every result by setting "searchIndexVersion", which sends it
+through the stream again. Run once after deploying the search index, and again with
+a higher --version after changing how explanations are tokenised:
+
+    python reindex_search.py --table aqua-genai-validate-result-ncwy [--version 1] [--profile dev] [--dry-run]
+
+Results already at --version are skipped, so an interrupted run can be restarted.
+"""
+import argparse
+
+import boto3
+
+
+def reindex(table_name, version, aws_profile=None, dry_run=False):
+    session = boto3.Session(profile_name=aws_profile) if aws_profile else boto3.Session()
+    dynamodb_client = session.client("dynamodb")
+
+    paginator = dynamodb_client.get_paginator("scan")
+    pages = paginator.paginate(
+        TableName=table_name,
+        ProjectionExpression="id",
+        FilterExpression="attribute_not_exists(#version) OR #version < :version",
+        ExpressionAttributeNames={"#version": "searchIndexVersion"},
+        ExpressionAttributeValues={":version": {"N": str(version)}},
+    )
+
+    touched = skipped = 0
+    for page in pages:
+        for item in page.get("Items", []):
+            if dry_run:
+                print(f"Would re-index {item['id']['S']}")
+            else:
+                try:
+                    dynamodb_client.update_item(
+                        TableName=table_name,
+                        Key={"id": item["id"]},
+                        UpdateExpression="SET #version = :version",
+                        ConditionExpression="attribute_exists(id) AND (attribute_not_exists(#version) OR #version < :version)",
+                        ExpressionAttributeNames={"#version": "searchIndexVersion"},
+                        ExpressionAttributeValues={":version": {"N": str(version)}},
+                    )
+                except dynamodb_client.exceptions.ConditionalCheckFailedException:
+                    skipped += 1
+                    continue
+            touched += 1
+
+    print(f"{'Would re-index' if dry_run else 'Re-indexed'} {touched} items, skipped {skipped}")
+
+
+if __name__ == "__main__":
+    parser = argparse.ArgumentParser(description="Send verification results through the search index stream again")
+    parser.add_argument("--table", required=True, help="Verification results table name")
+    parser.add_argument("--version", type=int, default=1, help="Search index version to bring results up to")
+    parser.add_argument("--profile", help="AWS profile to use")
+    parser.add_argument("--dry-run", action="store_true", help="Only report the items that would be re-indexed")
+    args = parser.parse_args()
+
+    reindex(args.table, args.version, args.profile, args.dry_run)
@@ -171,6 +171,7 @@ def lambda_handler(event, context):
         dynamo_item = {
             "id": item_id,
             "timestamp": timestamp,
+            "day": timestamp[:10],  # UTC date bucket for the history API's day-index
             "productId": product_id,
             "productCategory": product_category,
             "uploadedLabelImageKey": uploaded_label_image_key or "direct_base64_data",
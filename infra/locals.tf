@@ -5,8 +5,14 @@ locals {
   # S3 bucket name
   s3_bucket_name = "${var.project_name}-dataset-${data.aws_caller_identity.current.account_id}-${data.aws_region.current.name}-${local.name_suffix}"
 
+  # Existing bucket holding the product image dataset served by the catalog
+  catalog_dataset_bucket_name = "aqua-genai-dataset-879654127886-ap-southeast-1"
+
   # DynamoDB table name
   dynamodb_table_name = "${var.project_name}-validate-result-${local.name_suffix}"
+  catalog_journal_table_name = "${var.project_name}-catalog-journal-${local.name_suffix}"
+  search_index_table_name    = "${var.project_name}-search-index-${local.name_suffix}"
+  export_jobs_table_name     = "${var.project_name}-export-jobs-${local.name_suffix}"
 
   # Lambda functions and ECR repositories
   lambda_functions = {
@@ -31,13 +37,19 @@ locals {
     catalog = {
       name        = "${var.project_name}-catalog-function-${local.name_suffix}"
       description = "Handles catalog-related requests"
-      memory_size = 512
-      timeout     = 30
+      # Sized for the image batch jobs (qualityScoring, labelCodes, similarityIndex), which
+      # decode several images at once and run until shortly before the timeout. API requests
+      # are still cut off by API Gateway after 29 seconds.
+      memory_size = 1536
+      timeout     = 300
       environment = {
         LOG_LEVEL        = var.function_log_level
-        AWS_DATASET_BUCKET              = "aqua-genai-dataset-879654127886-ap-southeast-1"
+        AWS_DATASET_BUCKET              = local.catalog_dataset_bucket_name
         AWS_IMPUT_IMG_VALIDATION_BUCKET = "aqua-genai-dataset-879654127886-ap-southeast-1"
         AWS_RESULT_TABLE = module.dynamodb_table.table_name # Example, adjust as needed
+        MAX_REFERENCE_LABEL_IMAGES      = var.max_reference_label_images
+        MAX_REFERENCE_OVERVIEW_IMAGES   = var.max_reference_overview_images
+        CATALOG_JOURNAL_TABLE           = module.catalog_journal_table.table_name
       }
     }
     transaction_by_id = {
@@ -62,7 +74,9 @@ locals {
         AWS_RESULT_TABLE = module.dynamodb_table.table_name
         AWS_DATASET_BUCKET              = "aqua-genai-dataset-879654127886-ap-southeast-1"
         AWS_IMPUT_IMG_VALIDATION_BUCKET = "aqua-genai-dataset-879654127886-ap-southeast-1"
-        EXPORT_BUCKET    = local.s3_bucket_name
+        AWS_EXPORT_BUCKET               = local.s3_bucket_name
+        EXPORT_JOBS_TABLE               = module.export_jobs_table.table_name
+        SEARCH_INDEX_TABLE              = module.search_index_table.table_name
       }
     }
         health_check = {
@@ -101,6 +115,26 @@ locals {
     }
   }
 
+  # Scheduled catalog jobs, each invoking the catalog function with {"job": <key>}
+  catalog_jobs = {
+    statsSnapshot = {
+      description = "Weekly snapshot of per-category dataset statistics"
+      schedule    = "cron(0 1 ? * MON *)"
+    }
+    qualityScoring = {
+      description = "Incremental quality scoring of catalog images"
+      schedule    = "rate(1 hour)"
+    }
+    similarityIndex = {
+      description = "Incremental perceptual hash and colour histogram indexing of reference images"
+      schedule    = "rate(1 hour)"
+    }
+    labelCodes = {
+      description = "Incremental QR code and barcode decoding of label images"
+      schedule    = "rate(1 hour)"
+    }
+  }
+
   # API Gateway name
   api_gateway_name = "${var.project_name}-api-${local.name_suffix}"
   
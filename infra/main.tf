@@ -11,6 +11,70 @@ module "dynamodb_table" {
   source = "./modules/dynamodb"
 
   table_name = local.dynamodb_table_name
+  attributes = [
+    { name = "id", type = "S" },
+    { name = "productId", type = "S" },
+    { name = "timestamp", type = "S" },
+    { name = "productCategory", type = "S" },
+    { name = "day", type = "S" },
+  ]
+  global_secondary_indexes = [
+    # Product history listing and the latest result of a product in catalog verification statistics
+    { name = "product-index", hash_key = "productId", range_key = "timestamp" },
+    # History listing by category and catalog verification statistics, and unfiltered listing by UTC day (YYYY-MM-DD)
+    { name = "category-index", hash_key = "productCategory", range_key = "timestamp" },
+    { name = "day-index", hash_key = "day", range_key = "timestamp" },
+  ]
+  # Feeds the search index maintained by the history function
+  stream_view_type = "NEW_AND_OLD_IMAGES"
+  common_tags      = local.common_tags
+}
+
+# Full-text search index over result explanations, one item per term and result
+module "search_index_table" {
+  source = "./modules/dynamodb"
+
+  table_name = local.search_index_table_name
+  hash_key   = "term"
+  range_key  = "posting"
+  attributes = [
+    { name = "term", type = "S" },
+    { name = "posting", type = "S" },
+  ]
+  common_tags = local.common_tags
+}
+
+# History export jobs; PENDING jobs are picked up from the stream by the history function
+module "export_jobs_table" {
+  source = "./modules/dynamodb"
+
+  table_name       = local.export_jobs_table_name
+  hash_key         = "jobId"
+  attributes       = [{ name = "jobId", type = "S" }]
+  stream_view_type = "NEW_IMAGE"
+  ttl_attribute    = "expiresAt"
+  common_tags      = local.common_tags
+}
+
+# Catalog change journal
+module "catalog_journal_table" {
+  source = "./modules/dynamodb"
+
+  table_name = local.catalog_journal_table_name
+  hash_key   = "productKey"
+  range_key  = "entryId"
+  attributes = [
+    { name = "productKey", type = "S" },
+    { name = "entryId", type = "S" },
+    { name = "actor", type = "S" },
+    { name = "objectKey", type = "S" },
+    { name = "day", type = "S" },
+  ]
+  global_secondary_indexes = [
+    { name = "actor-index", hash_key = "actor", range_key = "entryId" },
+    { name = "object-index", hash_key = "objectKey", range_key = "entryId" },
+    { name = "day-index", hash_key = "day", range_key = "entryId" },
+  ]
   common_tags = local.common_tags
 }
 
@@ -45,6 +109,8 @@ module "lambda_iam" {
 
   s3_bucket_arn = module.s3_bucket.bucket_arn
   dynamodb_table_arn = module.dynamodb_table.table_arn
+  additional_dynamodb_table_arns = [module.catalog_journal_table.table_arn, module.search_index_table.table_arn, module.export_jobs_table.table_arn]
+  dynamodb_stream_arns           = [module.dynamodb_table.stream_arn, module.export_jobs_table.stream_arn]
   ecr_repository_arns = {
     for k, v in module.ecr_repositories : k => v.repository_arn
   }
@@ -71,6 +137,102 @@ module "lambda" {
   common_tags = local.common_tags
 }
 
+# Search index maintenance from the results table stream
+resource "aws_lambda_event_source_mapping" "search_index" {
+  event_source_arn  = module.dynamodb_table.stream_arn
+  function_name     = module.lambda["history"].function_arn
+  starting_position = "TRIM_HORIZON"
+  batch_size        = 100
+
+  maximum_batching_window_in_seconds = 5
+  maximum_retry_attempts             = 10
+  bisect_batch_on_function_error     = true
+}
+
+# History export workers, one job per invocation. Only PENDING jobs start a worker: new
+# jobs, and jobs a worker handed on before its deadline.
+resource "aws_lambda_event_source_mapping" "export_jobs" {
+  event_source_arn       = module.export_jobs_table.stream_arn
+  function_name          = module.lambda["history"].function_arn
+  starting_position      = "LATEST"
+  batch_size             = 1
+  parallelization_factor = 10
+  maximum_retry_attempts = 2
+
+  filter_criteria {
+    filter {
+      pattern = jsonencode({ dynamodb = { NewImage = { status = { S = ["PENDING"] } } } })
+    }
+  }
+}
+
+# History exports expire with their job records; abandoned multipart uploads are cleaned up
+resource "aws_s3_bucket_lifecycle_configuration" "exports" {
+  bucket = module.s3_bucket.bucket_name
+
+  rule {
+    id     = "history-exports"
+    status = "Enabled"
+
+    filter {
+      prefix = "exports/"
+    }
+
+    expiration {
+      days = 7
+    }
+
+    noncurrent_version_expiration {
+      noncurrent_days = 1
+    }
+
+    abort_incomplete_multipart_upload {
+      days_after_initiation = 1
+    }
+  }
+}
+
+# Scheduled Catalog Jobs
+resource "aws_cloudwatch_event_rule" "catalog_jobs" {
+  for_each = local.catalog_jobs
+
+  name                = "${var.project_name}-catalog-${lower(each.key)}-${local.name_suffix}"
+  description         = each.value.description
+  schedule_expression = each.value.schedule
+
+  tags = local.common_tags
+}
+
+resource "aws_cloudwatch_event_target" "catalog_jobs" {
+  for_each = local.catalog_jobs
+
+  rule  = aws_cloudwatch_event_rule.catalog_jobs[each.key].name
+  arn   = module.lambda["catalog"].function_arn
+  input = jsonencode({ job = each.key })
+}
+
+resource "aws_lambda_permission" "catalog_jobs" {
+  for_each = local.catalog_jobs
+
+  statement_id  = "AllowEventBridge-${each.key}"
+  action        = "lambda:InvokeFunction"
+  function_name = module.lambda["catalog"].function_name
+  principal     = "events.amazonaws.com"
+  source_arn    = aws_cloudwatch_event_rule.catalog_jobs[each.key].arn
+}
+
+# Dataset changes reach the catalog function as S3 event notifications, which reconcile the
+# inventory listing and feed the change journal. The dataset bucket is shared, so the notification
+# itself is merged into its configuration by hand (see README.md); only the permission is managed here.
+resource "aws_lambda_permission" "catalog_dataset_events" {
+  statement_id   = "AllowS3DatasetEvents"
+  action         = "lambda:InvokeFunction"
+  function_name  = module.lambda["catalog"].function_name
+  principal      = "s3.amazonaws.com"
+  source_arn     = "arn:aws:s3:::${local.catalog_dataset_bucket_name}"
+  source_account = data.aws_caller_identity.current.account_id
+}
+
 # API Gateway
 module "api_gateway" {
   source = "./modules/api_gateway"
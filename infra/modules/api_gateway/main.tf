@@ -98,6 +98,24 @@ resource "aws_api_gateway_integration" "catalog_get" {
   uri                     = var.lambda_function_invoke_arns["catalog"]
 }
 
+# Mutating catalog operations (e.g. type=restoreVersion)
+resource "aws_api_gateway_method" "catalog_post" {
+  rest_api_id   = aws_api_gateway_rest_api.this.id
+  resource_id   = aws_api_gateway_resource.catalog.id
+  http_method   = "POST"
+  authorization = "NONE"
+  api_key_required = true
+}
+
+resource "aws_api_gateway_integration" "catalog_post" {
+  rest_api_id             = aws_api_gateway_rest_api.this.id
+  resource_id             = aws_api_gateway_resource.catalog.id
+  http_method             = aws_api_gateway_method.catalog_post.http_method
+  integration_http_method = "POST"
+  type                    = "AWS_PROXY"
+  uri                     = var.lambda_function_invoke_arns["catalog"]
+}
+
 resource "aws_api_gateway_method" "catalog_options" {
   rest_api_id   = aws_api_gateway_rest_api.this.id
   resource_id   = aws_api_gateway_resource.catalog.id
@@ -137,7 +155,7 @@ resource "aws_api_gateway_integration_response" "catalog_options" {
 
   response_parameters = {
     "method.response.header.Access-Control-Allow-Headers" = "'Content-Type,X-Amz-Date,Authorization,X-Api-Key,X-Amz-Security-Token,X-Amz-User-Agent'"
-    "method.response.header.Access-Control-Allow-Methods" = "'OPTIONS,GET'"
+    "method.response.header.Access-Control-Allow-Methods" = "'OPTIONS,GET,POST'"
     "method.response.header.Access-Control-Allow-Origin"  = "'*'"
   }
 }
@@ -360,6 +378,7 @@ resource "aws_api_gateway_deployment" "this" {
     aws_api_gateway_integration.validate_post,
     aws_api_gateway_integration.validate_options,
     aws_api_gateway_integration.catalog_get,
+    aws_api_gateway_integration.catalog_post,
     aws_api_gateway_integration.catalog_options,
     aws_api_gateway_integration.transaction_id_get,
     aws_api_gateway_integration.transaction_id_options,
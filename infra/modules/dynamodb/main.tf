@@ -1,11 +1,37 @@
 resource "aws_dynamodb_table" "this" {
   name           = var.table_name
   billing_mode   = "PAY_PER_REQUEST"
-  hash_key       = "id"
-  attribute {
-    name = "id"
-    type = "S"
+  hash_key       = var.hash_key
+  range_key      = var.range_key
+
+  stream_enabled   = var.stream_view_type != null
+  stream_view_type = var.stream_view_type
+
+  dynamic "ttl" {
+    for_each = var.ttl_attribute != null ? [var.ttl_attribute] : []
+    content {
+      attribute_name = ttl.value
+      enabled        = true
+    }
+  }
+
+  dynamic "attribute" {
+    for_each = var.attributes
+    content {
+      name = attribute.value.name
+      type = attribute.value.type
+    }
+  }
+
+  dynamic "global_secondary_index" {
+    for_each = var.global_secondary_indexes
+    content {
+      name            = global_secondary_index.value.name
+      hash_key        = global_secondary_index.value.hash_key
+      range_key       = global_secondary_index.value.range_key
+      projection_type = "ALL"
+    }
   }
 
   tags = var.common_tags
-} 
\ No newline at end of file
+} 
@@ -6,4 +6,9 @@ output "table_name" {
 output "table_arn" {
   description = "The ARN of the DynamoDB table"
   value       = aws_dynamodb_table.this.arn
-} 
\ No newline at end of file
+} 
+
+output "stream_arn" {
+  description = "The ARN of the table's stream, if enabled"
+  value       = aws_dynamodb_table.this.stream_arn
+}
@@ -7,4 +7,47 @@ variable "common_tags" {
   description = "Common tags to be applied to all resources"
   type        = map(string)
   default     = {}
-} 
\ No newline at end of file
+}
+
+variable "hash_key" {
+  description = "Partition key attribute name"
+  type        = string
+  default     = "id"
+}
+
+variable "range_key" {
+  description = "Sort key attribute name, if any"
+  type        = string
+  default     = null
+}
+
+variable "attributes" {
+  description = "Key attributes of the table and its indexes"
+  type = list(object({
+    name = string
+    type = string
+  }))
+  default = [{ name = "id", type = "S" }]
+}
+
+variable "global_secondary_indexes" {
+  description = "Global secondary indexes, projecting all attributes"
+  type = list(object({
+    name      = string
+    hash_key  = string
+    range_key = string
+  }))
+  default = []
+}
+
+variable "stream_view_type" {
+  description = "DynamoDB stream view type (e.g. NEW_AND_OLD_IMAGES), or null for no stream"
+  type        = string
+  default     = null
+}
+
+variable "ttl_attribute" {
+  description = "Attribute holding the item expiry time in epoch seconds, or null for no TTL"
+  type        = string
+  default     = null
+}
@@ -34,7 +34,8 @@ resource "aws_iam_role_policy" "s3_access" {
     {
       "Effect": "Allow",
       "Action": [
-        "s3:ListBucket"
+        "s3:ListBucket",
+        "s3:ListBucketVersions"
       ],
       "Resource": [
         "${var.s3_bucket_arn}",
@@ -45,9 +46,11 @@ resource "aws_iam_role_policy" "s3_access" {
       "Effect": "Allow",
       "Action": [
         "s3:GetObject",
+        "s3:GetObjectVersion",
         "s3:PutObject",
         "s3:HeadObject",
-        "s3:DeleteObject"
+        "s3:DeleteObject",
+        "s3:AbortMultipartUpload"
       ],
       "Resource": [
         "${var.s3_bucket_arn}/*",
@@ -105,12 +108,36 @@ resource "aws_iam_role_policy" "dynamodb_access" {
           "dynamodb:Query",
           "dynamodb:Scan",
           "dynamodb:UpdateItem",
-          "dynamodb:DeleteItem"
+          "dynamodb:DeleteItem",
+          "dynamodb:BatchGetItem",
+          "dynamodb:BatchWriteItem"
         ]
-        Resource = [
-          var.dynamodb_table_arn,
-          "${var.dynamodb_table_arn}/index/*"
+        Resource = concat(
+          [var.dynamodb_table_arn, "${var.dynamodb_table_arn}/index/*"],
+          flatten([for arn in var.additional_dynamodb_table_arns : [arn, "${arn}/index/*"]])
+        )
+      }
+    ]
+  })
+}
+
+resource "aws_iam_role_policy" "dynamodb_stream_access" {
+  count = length(var.dynamodb_stream_arns) > 0 ? 1 : 0
+  name  = "DynamoDBStreamAccessPolicy"
+  role  = aws_iam_role.lambda_execution.id
+
+  policy = jsonencode({
+    Version = "2012-10-17"
+    Statement = [
+      {
+        Effect = "Allow"
+        Action = [
+          "dynamodb:DescribeStream",
+          "dynamodb:GetRecords",
+          "dynamodb:GetShardIterator",
+          "dynamodb:ListStreams"
         ]
+        Resource = var.dynamodb_stream_arns
       }
     ]
   })
@@ -23,6 +23,18 @@ variable "dynamodb_table_arn" {
   type        = string
 }
 
+variable "additional_dynamodb_table_arns" {
+  description = "ARNs of further DynamoDB tables the functions may access"
+  type        = list(string)
+  default     = []
+}
+
+variable "dynamodb_stream_arns" {
+  description = "ARNs of DynamoDB streams that trigger the functions"
+  type        = list(string)
+  default     = []
+}
+
 variable "ecr_repository_arns" {
   description = "A map of ECR repository ARNs, where keys are logical names (e.g., 'validate', 'catalog')"
   type        = map(string)
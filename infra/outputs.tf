@@ -13,6 +13,16 @@ output "dynamodb_table_name" {
   value       = module.dynamodb_table.table_name
 }
 
+output "search_index_table_name" {
+  description = "The name of the DynamoDB table holding the search index over result explanations"
+  value       = module.search_index_table.table_name
+}
+
+output "export_jobs_table_name" {
+  description = "The name of the DynamoDB table tracking history export jobs"
+  value       = module.export_jobs_table.table_name
+}
+
 output "api_key" {
   description = "The API Key for accessing the API Gateway endpoints"
   value       = module.api_gateway.api_key
@@ -24,6 +34,11 @@ output "lambda_function_names" {
   value       = { for k, v in module.lambda : k => v.function_name }
 }
 
+output "catalog_function_arn" {
+  description = "The ARN of the catalog Lambda function, the target of the dataset bucket notification"
+  value       = module.lambda["catalog"].function_arn
+}
+
 output "ecr_repository_urls" {
   description = "The URLs of the ECR repositories"
   value       = { for k, v in module.ecr_repositories : k => v.repository_url }